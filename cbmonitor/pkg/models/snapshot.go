@@ -16,16 +16,24 @@ type Cluster struct {
 
 // SnapshotMetadata represents the snapshot metadata structure from Couchbase
 type SnapshotMetadata struct {
-	SnapshotID   string               `json:"snapshotId" couchbase:"id"`
-	Services     []string             `json:"services" couchbase:"services"`
-	Clusters     []Cluster            `json:"clusters,omitempty" couchbase:"clusters"`
-	Version      string               `json:"version" couchbase:"server"`
-	TSStart      string               `json:"ts_start" couchbase:"ts_start"`
-	TSEnd        string               `json:"ts_end" couchbase:"ts_end"`
-	Phases       []Phase              `json:"phases,omitempty"`
-	Label        string               `json:"label,omitempty"`
-	CustomPanels []CustomPanelsConfig `json:"custom_panels,omitempty"`
-	Products     []string             `json:"products,omitempty"`
+	// SchemaVersion is config-manager's metadata document version; 0 for
+	// documents written before it was recorded.
+	SchemaVersion int                  `json:"schema_version"`
+	SnapshotID    string               `json:"snapshotId" couchbase:"id"`
+	Services      []string             `json:"services" couchbase:"services"`
+	Clusters      []Cluster            `json:"clusters,omitempty" couchbase:"clusters"`
+	Version       string               `json:"version" couchbase:"server"`
+	TSStart       string               `json:"ts_start" couchbase:"ts_start"`
+	TSEnd         string               `json:"ts_end" couchbase:"ts_end"`
+	Phases        []Phase              `json:"phases,omitempty"`
+	Label         string               `json:"label,omitempty"`
+	Tags          map[string]string    `json:"tags,omitempty"`
+	CustomPanels  []CustomPanelsConfig `json:"custom_panels,omitempty"`
+	Products      []string             `json:"products,omitempty"`
+	// ClonedFrom and RestoredFrom link a run to the snapshot it was
+	// cloned or restored from in config-manager.
+	ClonedFrom   string `json:"cloned_from,omitempty"`
+	RestoredFrom string `json:"restored_from,omitempty"`
 }
 
 // CustomPanelOverride lets a snapshot tweak how a single discovered
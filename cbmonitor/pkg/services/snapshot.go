@@ -104,7 +104,97 @@ func (ss *SnapshotService) GetSnapshotByID(ctx context.Context, snapshotID strin
 		return nil, fmt.Errorf("failed to parse snapshot document: %w", err)
 	}
 
-	// Parse metadata from raw data
+	metadata := parseSnapshotMetadata(snapshotID, rawData)
+	applyMetadataSchema(&metadata, rawData)
+
+	// Create a copy of rawData without metadata fields to avoid duplication
+	dataWithoutMetadata := make(map[string]interface{})
+	metadataFields := map[string]bool{
+		"id":             true,
+		"schema_version": true,
+		"services":       true,
+		"server":         true,
+		"version":        true,
+		"ts_start":       true,
+		"ts_end":         true,
+		"phases":         true,
+		"label":          true,
+		"tags":           true,
+		"clusters":       true,
+		"custom_panels":  true,
+		"products":       true,
+		"cloned_from":    true,
+		"restored_from":  true,
+	}
+	for k, v := range rawData {
+		if !metadataFields[k] {
+			dataWithoutMetadata[k] = v
+		}
+	}
+
+	// Determine which dashboards to show based on services
+	dashboards := ss.determineDashboards(metadata.Services)
+
+	// Create snapshot data structure
+	snapshotData := &models.SnapshotData{
+		Metadata:   metadata,
+		Data:       dataWithoutMetadata,
+		Dashboards: dashboards,
+	}
+
+	log.Printf("Successfully fetched snapshot: %s with %d services.", snapshotID, len(metadata.Services))
+
+	ss.cache.set(snapshotID, snapshotData)
+	return snapshotData, nil
+}
+
+// InvalidateCache evicts snapshotID's cached document, if any, so the next
+// GetSnapshotByID call is a guaranteed live fetch. Used by the "Refresh
+// metadata" flow, which must bypass the short-TTL cache.
+func (ss *SnapshotService) InvalidateCache(snapshotID string) {
+	ss.cache.delete(snapshotID)
+}
+
+// determineDashboards returns a list of dashboard IDs based on the services in the snapshot
+func (ss *SnapshotService) determineDashboards(services []string) []string {
+	dashboardMap := map[string]string{
+		"kv":              "kv_basic",
+		"index":           "index_basic",
+		"query":           "query_basic",
+		"fts":             "fts_basic",
+		"eventing":        "eventing_basic",
+		"analytics":       "analytics_basic",
+		"cbas":            "analytics_basic",
+		"n1ql":            "query_basic",
+		"data":            "kv_basic",
+		"xdcr":            "xdcr_basic",
+		"cluster_manager": "cluster_manager_basic",
+	}
+
+	dashboards := []string{}
+	seenDashboards := make(map[string]bool)
+
+	// Add dashboards based on services
+	for _, service := range services {
+		if dashboardID, ok := dashboardMap[service]; ok {
+			if !seenDashboards[dashboardID] {
+				dashboards = append(dashboards, dashboardID)
+				seenDashboards[dashboardID] = true
+			}
+		}
+	}
+
+	// Always include system dashboard
+	if !seenDashboards["system_basic"] {
+		dashboards = append(dashboards, "system_basic")
+	}
+
+	return dashboards
+}
+
+// parseSnapshotMetadata reads the metadata fields of a Couchbase document
+// into the shape the frontend expects.
+func parseSnapshotMetadata(snapshotID string, rawData map[string]interface{}) models.SnapshotMetadata {
 	metadata := models.SnapshotMetadata{
 		SnapshotID: snapshotID,
 	}
@@ -157,6 +247,25 @@ func (ss *SnapshotService) GetSnapshotByID(ctx context.Context, snapshotID strin
 		metadata.Label = label
 	}
 
+	// Extract lineage: the snapshot this run was cloned or restored from.
+	if clonedFrom, ok := rawData["cloned_from"].(string); ok {
+		metadata.ClonedFrom = clonedFrom
+	}
+	if restoredFrom, ok := rawData["restored_from"].(string); ok {
+		metadata.RestoredFrom = restoredFrom
+	}
+
+	// Extract tags (structured key/value labels such as build or owner).
+	// Non-string values are skipped rather than stringified.
+	if tags, ok := rawData["tags"].(map[string]interface{}); ok {
+		metadata.Tags = make(map[string]string, len(tags))
+		for k, v := range tags {
+			if tagStr, ok := v.(string); ok {
+				metadata.Tags[k] = tagStr
+			}
+		}
+	}
+
 	// Extract products (the distinct set this snapshot scrapes, e.g.
 	// ["couchbase"] or ["kafka"]). Drives the frontend's Couchbase-baseline
 	// tab decision.
@@ -216,85 +325,34 @@ func (ss *SnapshotService) GetSnapshotByID(ctx context.Context, snapshotID strin
 		}
 	}
 
-	// Create a copy of rawData without metadata fields to avoid duplication
-	dataWithoutMetadata := make(map[string]interface{})
-	metadataFields := map[string]bool{
-		"id":            true,
-		"services":      true,
-		"server":        true,
-		"version":       true,
-		"ts_start":      true,
-		"ts_end":        true,
-		"phases":        true,
-		"label":         true,
-		"clusters":      true,
-		"custom_panels": true,
-		"products":      true,
-	}
-	for k, v := range rawData {
-		if !metadataFields[k] {
-			dataWithoutMetadata[k] = v
-		}
-	}
-
-	// Determine which dashboards to show based on services
-	dashboards := ss.determineDashboards(metadata.Services)
-
-	// Create snapshot data structure
-	snapshotData := &models.SnapshotData{
-		Metadata:   metadata,
-		Data:       dataWithoutMetadata,
-		Dashboards: dashboards,
-	}
-
-	log.Printf("Successfully fetched snapshot: %s with %d services.", snapshotID, len(metadata.Services))
-
-	ss.cache.set(snapshotID, snapshotData)
-	return snapshotData, nil
+	return metadata
 }
 
-// InvalidateCache evicts snapshotID's cached document, if any, so the next
-// GetSnapshotByID call is a guaranteed live fetch. Used by the "Refresh
-// metadata" flow, which must bypass the short-TTL cache.
-func (ss *SnapshotService) InvalidateCache(snapshotID string) {
-	ss.cache.delete(snapshotID)
-}
-
-// determineDashboards returns a list of dashboard IDs based on the services in the snapshot
-func (ss *SnapshotService) determineDashboards(services []string) []string {
-	dashboardMap := map[string]string{
-		"kv":              "kv_basic",
-		"index":           "index_basic",
-		"query":           "query_basic",
-		"fts":             "fts_basic",
-		"eventing":        "eventing_basic",
-		"analytics":       "analytics_basic",
-		"cbas":            "analytics_basic",
-		"n1ql":            "query_basic",
-		"data":            "kv_basic",
-		"xdcr":            "xdcr_basic",
-		"cluster_manager": "cluster_manager_basic",
+// metadataSchemaVersion is the newest metadata document schema_version
+// config-manager writes that this parser knows about.
+const metadataSchemaVersion = 1
+
+// applyMetadataSchema records the document's schema_version in metadata
+// and fills in what unversioned documents may lack. Those predate ts_end
+// for running snapshots and the products field, and were all Couchbase
+// snapshots. config-manager migrates them on read and with `migrate`, so
+// the defaults only matter until the backfill has run.
+func applyMetadataSchema(metadata *models.SnapshotMetadata, rawData map[string]interface{}) {
+	if v, ok := rawData["schema_version"].(float64); ok {
+		metadata.SchemaVersion = int(v)
 	}
-
-	dashboards := []string{}
-	seenDashboards := make(map[string]bool)
-
-	// Add dashboards based on services
-	for _, service := range services {
-		if dashboardID, ok := dashboardMap[service]; ok {
-			if !seenDashboards[dashboardID] {
-				dashboards = append(dashboards, dashboardID)
-				seenDashboards[dashboardID] = true
-			}
-		}
+	if metadata.SchemaVersion > metadataSchemaVersion {
+		log.Printf("Snapshot %s has metadata schema version %d; this build knows up to %d.",
+			metadata.SnapshotID, metadata.SchemaVersion, metadataSchemaVersion)
 	}
-
-	// Always include system dashboard
-	if !seenDashboards["system_basic"] {
-		dashboards = append(dashboards, "system_basic")
+	if metadata.SchemaVersion == 0 {
+		if metadata.TSEnd == "" {
+			metadata.TSEnd = "now"
+		}
+		if _, ok := rawData["products"]; !ok {
+			metadata.Products = []string{"couchbase"}
+		}
 	}
-
-	return dashboards
 }
 
 // parseCustomPanelsConfig pulls a single CustomPanelsConfig out of a raw
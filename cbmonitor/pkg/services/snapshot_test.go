@@ -0,0 +1,47 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/cbmonitor/pkg/models"
+)
+
+func TestParseSnapshotMetadata_versionedAndLegacy(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want models.SnapshotMetadata
+	}{
+		{
+			name: "versioned",
+			doc:  `{"schema_version":1,"id":"a","services":["kv"],"ts_end":"now","custom_panels":[{"match":"kv_.*"}],"products":[],"extras":{}}`,
+			want: models.SnapshotMetadata{
+				SchemaVersion: 1, SnapshotID: "a", Services: []string{"kv"}, TSEnd: "now",
+				CustomPanels: []models.CustomPanelsConfig{{Match: "kv_.*"}}, Products: []string{},
+			},
+		},
+		{
+			name: "legacy",
+			doc:  `{"id":"b","services":["kv"],"custom_panels":{"match":"kv_.*"}}`,
+			want: models.SnapshotMetadata{
+				SnapshotID: "b", Services: []string{"kv"}, TSEnd: "now",
+				CustomPanels: []models.CustomPanelsConfig{{Match: "kv_.*"}}, Products: []string{"couchbase"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.doc), &raw); err != nil {
+				t.Fatal(err)
+			}
+			got := parseSnapshotMetadata(tt.want.SnapshotID, raw)
+			applyMetadataSchema(&got, raw)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSnapshotMetadata = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import { SnapshotMetadata } from 'types/snapshot';
 import { nodeDrilldownUrl } from '../../pages/nodeDrilldownPage';
 import { snapshotService } from '../../services/snapshotService';
 import { normaliseProductList } from '../../config/products';
+import { prefixRoute, ROUTE_PATHS } from '../../utils/utils.routing';
 
 interface SnapshotDetailsDrawerProps {
     metadata: SnapshotMetadata;
@@ -79,6 +80,15 @@ export function SnapshotDetailsDrawer({ metadata, onClose }: SnapshotDetailsDraw
     const label = metadata.label;
     const labelIsUrl = isValidURL(label);
 
+    // A clone is a repeat of its predecessor, so it's the natural run to
+    // compare against. A restore continues an ended run.
+    const predecessor = metadata.cloned_from || metadata.restored_from;
+    const predecessorLabel = metadata.cloned_from ? 'Cloned from' : 'Restored from';
+    const openSnapshot = (path: string) => {
+        locationService.push(prefixRoute(path));
+        onClose();
+    };
+
     return (
         <Drawer title="Snapshot details" onClose={onClose} size="sm">
             <div className={styles.body}>
@@ -136,6 +146,32 @@ export function SnapshotDetailsDrawer({ metadata, onClose }: SnapshotDetailsDraw
                     </Section>
                 )}
 
+                {predecessor && (
+                    <Section label={predecessorLabel}>
+                        <div className={styles.idRow}>
+                            <a
+                                className={styles.link}
+                                href={prefixRoute(ROUTE_PATHS.snapshotView(predecessor))}
+                                onClick={(e) => {
+                                    e.preventDefault();
+                                    openSnapshot(ROUTE_PATHS.snapshotView(predecessor));
+                                }}
+                                title={`Open snapshot ${predecessor}`}
+                            >
+                                {predecessor}
+                            </a>
+                            <Button
+                                icon="code-branch"
+                                variant="secondary"
+                                size="sm"
+                                onClick={() => openSnapshot(ROUTE_PATHS.compareSnapshots([predecessor, metadata.snapshotId]))}
+                            >
+                                Compare
+                            </Button>
+                        </div>
+                    </Section>
+                )}
+
                 <Section label="Time range">
                     <div className={styles.kv}>
                         <span className={styles.kvKey}>Start:</span>
@@ -91,6 +91,12 @@ function SnapshotOverviewRenderer({ model }: SceneComponentProps<SnapshotOvervie
                         <dd>{metadata.label}</dd>
                     </>
                 )}
+                {metadata.tags && Object.keys(metadata.tags).length > 0 && (
+                    <>
+                        <dt>Tags</dt>
+                        <dd>{formatTags(metadata.tags)}</dd>
+                    </>
+                )}
                 <dt>Snapshot ID</dt>
                 <dd>
                     <code>{snapshotId}</code>
@@ -143,6 +149,13 @@ function formatPhases(phases: Phase[]): string {
     return phases.map((p) => p.label).filter(Boolean).join(', ');
 }
 
+function formatTags(tags: Record<string, string>): string {
+    return Object.keys(tags)
+        .sort()
+        .map((key) => `${key}=${tags[key]}`)
+        .join(', ');
+}
+
 const getStyles = (theme: GrafanaTheme2) => ({
     container: css`
         padding: ${theme.spacing(3)};
@@ -53,6 +53,9 @@ export interface CustomPanelsConfig {
 }
 
 export interface SnapshotMetadata {
+  // config-manager's metadata document version; 0 for documents written
+  // before it was recorded.
+  schema_version?: number;
   snapshotId: string;
   services: string[];
   clusters?: Cluster[];
@@ -61,8 +64,12 @@ export interface SnapshotMetadata {
   ts_end: string;
   phases?: Phase[];
   label?: string;
+  tags?: Record<string, string>;
   custom_panels?: CustomPanelsConfig[];
   products?: string[];
+  // Lineage recorded by config-manager's clone and restore endpoints.
+  cloned_from?: string;
+  restored_from?: string;
 }
 
 export interface SnapshotData {
@@ -0,0 +1,570 @@
+// Package client is a typed Go client for the config-manager REST API.
+//
+//	c, err := client.New("http://config-manager:8080", client.WithBearerToken(token))
+//	resp, err := c.CreateSnapshot(ctx, &client.SnapshotRequest{...})
+//	err = c.WithPhase(ctx, resp.ID, "load", func() error { return runLoad() })
+//	err = c.DeleteSnapshot(ctx, resp.ID)
+//
+// Calls that are safe to repeat (reads, deletes, keep-alives, services
+// and target updates) are retried with exponential backoff on network
+// errors and 429/5xx responses. Creating a snapshot and starting or
+// ending a phase are sent once; CreateSnapshotIdempotent makes a create
+// safe to retry with an Idempotency-Key.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/google/uuid"
+)
+
+// Client talks to one config-manager instance. It is safe for
+// concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	authHeader string
+	retry      RetryPolicy
+	userAgent  string
+}
+
+// RetryPolicy controls retries of idempotent calls.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry; it doubles for each
+	// following attempt, with up to 50% jitter, capped at MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is used unless WithRetry overrides it.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: 200 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient replaces the default http.Client (30s timeout).
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithBearerToken authenticates every request with a static token.
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.authHeader = "Bearer " + token }
+}
+
+// WithBasicAuth authenticates every request as a basic-auth user.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		c.authHeader = req.Header.Get("Authorization")
+	}
+}
+
+// WithRetry replaces DefaultRetryPolicy. MaxAttempts <= 1 disables retries.
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// WithUserAgent sets the User-Agent header, e.g. "perfrunner/1.2".
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// New creates a client for the config-manager at baseURL, for example
+// "http://localhost:8080". A path prefix such as "/cm" (when behind the
+// cbmonitor reverse proxy) is kept.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		retry:      DefaultRetryPolicy,
+		userAgent:  "config-manager-client",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// APIError is a non-2xx response. Code, Field and Message come from the
+// server's error envelope; Code is empty when the body wasn't one (for
+// example an error page from a proxy).
+type APIError struct {
+	StatusCode int
+	Code       string
+	Field      string
+	Message    string
+	// Limit is set on quota_exceeded and limit_exceeded errors.
+	Limit *Limit
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Field != "" {
+		return fmt.Sprintf("config-manager: %d %s (%s): %s", e.StatusCode, e.Code, e.Field, msg)
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("config-manager: %d %s: %s", e.StatusCode, e.Code, msg)
+	}
+	return fmt.Sprintf("config-manager: %d: %s", e.StatusCode, msg)
+}
+
+// IsNotFound reports whether err is a 404 from config-manager.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// CreateSnapshot registers a new snapshot. It is not retried.
+func (c *Client) CreateSnapshot(ctx context.Context, req *SnapshotRequest) (*SnapshotResponse, error) {
+	var out SnapshotResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/snapshot", req, &out, false); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateSnapshotIdempotent registers a new snapshot under an
+// Idempotency-Key and retries like the idempotent calls: a retry of a
+// create that got through returns the snapshot it made instead of a
+// duplicate. An empty key generates one for this call.
+func (c *Client) CreateSnapshotIdempotent(ctx context.Context, req *SnapshotRequest, key string) (*SnapshotResponse, error) {
+	if key == "" {
+		key = uuid.NewString()
+	}
+	var out SnapshotResponse
+	header := http.Header{"Idempotency-Key": []string{key}}
+	if err := c.doHeader(ctx, http.MethodPost, "/api/v1/snapshot", header, req, &out, true); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RenderSnapshot validates req and returns the scrape config YAML it
+// would produce, without creating anything.
+func (c *Client) RenderSnapshot(ctx context.Context, req *SnapshotRequest) ([]byte, error) {
+	var out []byte
+	if err := c.do(ctx, http.MethodPost, "/api/v1/snapshot?dry_run=true", req, &out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListSnapshots returns every active snapshot with its label and tags.
+// Each tag filter is "key=value" to match a tag exactly or "key" to
+// match any snapshot with that key; all filters must match.
+func (c *Client) ListSnapshots(ctx context.Context, tags ...string) ([]DisplaySnapshot, error) {
+	path := "/api/v1/snapshots"
+	if len(tags) > 0 {
+		path += "?" + url.Values{"tag": tags}.Encode()
+	}
+	var out []DisplaySnapshot
+	if err := c.do(ctx, http.MethodGet, path, nil, &out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListPresets returns the custom-panel presets a SnapshotRequest can
+// name in Presets.
+func (c *Client) ListPresets(ctx context.Context) ([]Preset, error) {
+	var out []Preset
+	if err := c.do(ctx, http.MethodGet, "/api/v1/presets", nil, &out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListAudit returns the most recent audit entries, oldest first. An
+// empty snapshotID returns entries for every snapshot. It needs the
+// admin role.
+func (c *Client) ListAudit(ctx context.Context, snapshotID string) ([]AuditEntry, error) {
+	path := "/api/v1/audit"
+	if snapshotID != "" {
+		path += "?" + url.Values{"snapshot": {snapshotID}}.Encode()
+	}
+	var out []AuditEntry
+	if err := c.do(ctx, http.MethodGet, path, nil, &out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// QuerySnapshots returns the metadata of the snapshots, active or ended,
+// matching every condition of q, newest first.
+func (c *Client) QuerySnapshots(ctx context.Context, q *MetadataQuery) (*QueryResponse, error) {
+	var out QueryResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/snapshots/query", q, &out, true); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ServiceDiscovery returns the targets of every active snapshot as
+// Prometheus HTTP SD target groups, labelled with job and product. Named
+// shards limit it to the snapshots on them.
+func (c *Client) ServiceDiscovery(ctx context.Context, shards ...string) ([]TargetGroup, error) {
+	path := "/api/v1/sd"
+	if len(shards) > 0 {
+		path += "?" + url.Values{"shard": shards}.Encode()
+	}
+	var out []TargetGroup
+	if err := c.do(ctx, http.MethodGet, path, nil, &out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetQuota returns the admission limits and how much of them the
+// active snapshots use.
+func (c *Client) GetQuota(ctx context.Context) (*Quota, error) {
+	var out Quota
+	if err := c.do(ctx, http.MethodGet, "/api/v1/quota", nil, &out, true); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetSnapshot returns the scrape targets of an active snapshot.
+func (c *Client) GetSnapshot(ctx context.Context, id string) (*DisplaySnapshot, error) {
+	var out DisplaySnapshot
+	if err := c.do(ctx, http.MethodGet, snapshotURL(id), nil, &out, true); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// KeepAlive refreshes the snapshot so the manager loop doesn't expire it.
+func (c *Client) KeepAlive(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPatch, snapshotURL(id), nil, nil, true)
+}
+
+// StartPhase opens a phase on the snapshot. It is not retried: a
+// duplicate would record the phase twice.
+func (c *Client) StartPhase(ctx context.Context, id, phase string) error {
+	return c.do(ctx, http.MethodPatch, snapshotURL(id), &SnapshotPatchRequest{Phase: phase, Mode: "start"}, nil, false)
+}
+
+// EndPhase closes the most recent phase on the snapshot. It is not retried.
+func (c *Client) EndPhase(ctx context.Context, id, phase string) error {
+	return c.do(ctx, http.MethodPatch, snapshotURL(id), &SnapshotPatchRequest{Phase: phase, Mode: "end"}, nil, false)
+}
+
+// UpdateServices adds services to the snapshot's metadata. Services are
+// merged server-side, so the call is safe to retry.
+func (c *Client) UpdateServices(ctx context.Context, id string, services []string) error {
+	return c.do(ctx, http.MethodPatch, snapshotURL(id), &SnapshotPatchRequest{Services: services}, nil, true)
+}
+
+// SetTags merges tags into the snapshot's tags. An empty value removes
+// the key.
+func (c *Client) SetTags(ctx context.Context, id string, tags map[string]string) error {
+	return c.do(ctx, http.MethodPatch, snapshotURL(id), &SnapshotPatchRequest{Tags: tags}, nil, true)
+}
+
+// PatchTargets edits the target list of the snapshot's file-type configs
+// and returns the resulting list.
+func (c *Client) PatchTargets(ctx context.Context, id string, patch *TargetsPatchRequest) (*TargetsResponse, error) {
+	var out TargetsResponse
+	if err := c.do(ctx, http.MethodPatch, snapshotURL(id)+"/targets", patch, &out, true); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateCustomPanels adds presets and inline panels to a running
+// snapshot, replacing any panel with the same title, and returns the
+// snapshot's resulting panels.
+func (c *Client) UpdateCustomPanels(ctx context.Context, id string, patch *CustomPanelsPatchRequest) ([]CustomPanelsConfig, error) {
+	var out CustomPanelsResponse
+	if err := c.do(ctx, http.MethodPatch, snapshotURL(id)+"/custom_panels", patch, &out, true); err != nil {
+		return nil, err
+	}
+	return out.CustomPanels, nil
+}
+
+// DeleteSnapshot ends the snapshot and removes its scrape config. A 404
+// on a retry is treated as success: the earlier attempt got through.
+func (c *Client) DeleteSnapshot(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, snapshotURL(id), nil, nil, true)
+}
+
+// GetArchive returns what was kept of a deleted or expired snapshot.
+func (c *Client) GetArchive(ctx context.Context, id string) (*Archive, error) {
+	var out Archive
+	if err := c.do(ctx, http.MethodGet, snapshotURL(id)+"/archive", nil, &out, true); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RestoreSnapshot starts a new snapshot from the archive of id and
+// returns its id. req supplies the secrets archives don't keep.
+func (c *Client) RestoreSnapshot(ctx context.Context, id string, req *RestoreRequest) (*SnapshotResponse, error) {
+	var out SnapshotResponse
+	if err := c.do(ctx, http.MethodPost, snapshotURL(id)+"/restore", req, &out, false); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CloneSnapshot starts a new snapshot from the configuration of id,
+// which may be active or archived, and returns its id.
+func (c *Client) CloneSnapshot(ctx context.Context, id string, req *CloneRequest) (*SnapshotResponse, error) {
+	var out SnapshotResponse
+	if err := c.do(ctx, http.MethodPost, snapshotURL(id)+"/clone", req, &out, false); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetOverlaps returns the other active snapshots sharing targets or
+// clusters with the active snapshot id.
+func (c *Client) GetOverlaps(ctx context.Context, id string) (*OverlapReport, error) {
+	var out OverlapReport
+	if err := c.do(ctx, http.MethodGet, snapshotURL(id)+"/overlaps", nil, &out, true); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListTemplates returns the snapshot templates, ordered by name, with
+// their secrets redacted.
+func (c *Client) ListTemplates(ctx context.Context) ([]Template, error) {
+	var out []Template
+	if err := c.do(ctx, http.MethodGet, "/api/v1/templates", nil, &out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetTemplate returns the template name with its secrets redacted.
+func (c *Client) GetTemplate(ctx context.Context, name string) (*Template, error) {
+	var out Template
+	if err := c.do(ctx, http.MethodGet, templateURL(name), nil, &out, true); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateTemplate stores t as version 1 of a new template. Create a
+// snapshot from it by setting SnapshotRequest.Template.
+func (c *Client) CreateTemplate(ctx context.Context, t *Template) (*Template, error) {
+	var out Template
+	if err := c.do(ctx, http.MethodPost, "/api/v1/templates", t, &out, false); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateTemplate replaces the template t.Name and returns it with its
+// new version. A non-zero t.Version must match the stored version.
+func (c *Client) UpdateTemplate(ctx context.Context, t *Template) (*Template, error) {
+	var out Template
+	if err := c.do(ctx, http.MethodPut, templateURL(t.Name), t, &out, false); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DeleteTemplate removes the template name. Snapshots created from it
+// are unaffected.
+func (c *Client) DeleteTemplate(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, templateURL(name), nil, nil, true)
+}
+
+// OpenAPI returns the server's OpenAPI document.
+func (c *Client) OpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var out json.RawMessage
+	if err := c.do(ctx, http.MethodGet, "/api/v1/openapi.json", nil, &out, true); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WithPhase starts phase on the snapshot, runs fn, and ends the phase
+// whether or not fn succeeded. fn's error takes precedence over an error
+// ending the phase. The phase is not started (and fn not run) if the
+// start call fails.
+func (c *Client) WithPhase(ctx context.Context, id, phase string, fn func() error) (err error) {
+	if err := c.StartPhase(ctx, id, phase); err != nil {
+		return fmt.Errorf("start phase %q: %w", phase, err)
+	}
+	defer func() {
+		// End the phase even if ctx was cancelled while fn ran, so the
+		// metadata isn't left with an open phase.
+		endCtx := ctx
+		if ctx.Err() != nil {
+			var cancel context.CancelFunc
+			endCtx, cancel = context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+			defer cancel()
+		}
+		if endErr := c.EndPhase(endCtx, id, phase); endErr != nil && err == nil {
+			err = fmt.Errorf("end phase %q: %w", phase, endErr)
+		}
+	}()
+	return fn()
+}
+
+func snapshotURL(id string) string {
+	return "/api/v1/snapshot/" + url.PathEscape(id)
+}
+
+func templateURL(name string) string {
+	return "/api/v1/templates/" + url.PathEscape(name)
+}
+
+// do sends one request, retrying when idempotent is set, and decodes a
+// JSON response into out when out is non-nil.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}, idempotent bool) error {
+	return c.doHeader(ctx, method, path, nil, in, out, idempotent)
+}
+
+// doHeader is do with extra request headers.
+func (c *Client) doHeader(ctx context.Context, method, path string, header http.Header, in, out interface{}, idempotent bool) error {
+	var payload []byte
+	if in != nil {
+		var err error
+		payload, err = json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+	}
+
+	attempts := 1
+	if idempotent && c.retry.MaxAttempts > 1 {
+		attempts = c.retry.MaxAttempts
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := sleepCtx(ctx, c.backoff(attempt)); err != nil {
+				return lastErr
+			}
+		}
+
+		retryable, err := c.once(ctx, method, path, header, payload, out)
+		if err == nil {
+			return nil
+		}
+		if attempt > 0 && method == http.MethodDelete && IsNotFound(err) {
+			return nil
+		}
+		lastErr = err
+		if !retryable || ctx.Err() != nil {
+			return err
+		}
+	}
+	return lastErr
+}
+
+func (c *Client) once(ctx context.Context, method, path string, header http.Header, payload []byte, out interface{}) (retryable bool, err error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL.String()+path, body)
+	if err != nil {
+		return false, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if c.authHeader != "" {
+		req.Header.Set("Authorization", c.authHeader)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, fmt.Errorf("read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var env apierror.Response
+		if json.Unmarshal(respBody, &env) == nil && env.Error.Code != "" {
+			apiErr.Code = env.Error.Code
+			apiErr.Field = env.Error.Field
+			apiErr.Limit = env.Error.Limit
+			apiErr.Message = env.Error.Message
+		} else {
+			apiErr.Message = strings.TrimSpace(string(respBody))
+		}
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, apiErr
+	}
+
+	if out == nil || len(respBody) == 0 {
+		return false, nil
+	}
+	switch raw := out.(type) {
+	case *json.RawMessage:
+		*raw = append((*raw)[:0], respBody...)
+		return false, nil
+	case *[]byte:
+		*raw = append((*raw)[:0], respBody...)
+		return false, nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return false, fmt.Errorf("decode response: %w", err)
+	}
+	return false, nil
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.retry.BaseDelay << (attempt - 1)
+	if c.retry.MaxDelay > 0 && (d > c.retry.MaxDelay || d <= 0) {
+		d = c.retry.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,477 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/api"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/query"
+	"github.com/couchbase/config-manager/internal/storage"
+	"github.com/couchbase/config-manager/internal/templates"
+)
+
+// memMetadata is an in-memory storage.MetadataStorage so tests can see
+// what the handler recorded.
+type memMetadata struct {
+	mu   sync.Mutex
+	docs map[string]*models.SnapshotMetadata
+}
+
+func newMemMetadata() *memMetadata {
+	return &memMetadata{docs: map[string]*models.SnapshotMetadata{}}
+}
+
+func (m *memMetadata) SaveMetadata(md *models.SnapshotMetadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *md
+	m.docs[md.SnapshotID] = &cp
+	return nil
+}
+
+func (m *memMetadata) GetMetadata(id string) (*models.SnapshotMetadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	md, ok := m.docs[id]
+	if !ok {
+		return nil, storage.ErrMetadataNotFound
+	}
+	cp := *md
+	return &cp, nil
+}
+
+func (m *memMetadata) UpdatePhase(id, phase, mode string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	md, ok := m.docs[id]
+	if !ok {
+		return storage.ErrMetadataNotFound
+	}
+	if mode == "start" {
+		md.Phases = append(md.Phases, models.Phase{Label: phase, TsStart: time.Now()})
+	} else if len(md.Phases) > 0 {
+		md.Phases[len(md.Phases)-1].TsEnd = time.Now().Format(time.RFC3339Nano)
+	}
+	return nil
+}
+
+func (m *memMetadata) UpdateServices(id string, services []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	md, ok := m.docs[id]
+	if !ok {
+		return storage.ErrMetadataNotFound
+	}
+	md.Services = append(md.Services, services...)
+	return nil
+}
+
+func (m *memMetadata) UpdateTags(id string, tags map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	md, ok := m.docs[id]
+	if !ok {
+		return storage.ErrMetadataNotFound
+	}
+	if md.Tags == nil {
+		md.Tags = map[string]string{}
+	}
+	for k, v := range tags {
+		if v == "" {
+			delete(md.Tags, k)
+			continue
+		}
+		md.Tags[k] = v
+	}
+	return nil
+}
+
+func (m *memMetadata) UpdateCustomPanels(id string, panels []models.CustomPanelsConfig) ([]models.CustomPanelsConfig, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	md, ok := m.docs[id]
+	if !ok {
+		return nil, storage.ErrMetadataNotFound
+	}
+	md.CustomPanels = append(md.CustomPanels, panels...)
+	return md.CustomPanels, nil
+}
+
+func (m *memMetadata) EoLSnapshot(id, endedBy string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	md, ok := m.docs[id]
+	if !ok {
+		return storage.ErrMetadataNotFound
+	}
+	md.TsEnd = time.Now().Format(time.RFC3339Nano)
+	md.EndedBy = endedBy
+	return nil
+}
+
+func (m *memMetadata) QueryMetadata(q models.MetadataQuery) ([]models.SnapshotMetadata, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	docs := make([]models.SnapshotMetadata, 0, len(m.docs))
+	for _, md := range m.docs {
+		docs = append(docs, *md)
+	}
+	out, truncated := query.Filter(q, docs, time.Now())
+	return out, truncated, nil
+}
+
+func (m *memMetadata) Close() error { return nil }
+func (m *memMetadata) Type() string { return "memory" }
+
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler) (*httptest.Server, *memMetadata) {
+	t.Helper()
+	md := newMemMetadata()
+	h := api.NewHandler(storage.SingleShard(storage.NewFileStorage(t.TempDir(), "")), md, "vmagent")
+	h.SetIdempotencyWindow(time.Hour)
+	tmpls, err := templates.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetTemplates(tmpls)
+	var handler http.Handler = api.NewRouter(h, api.RouterOptions{PublicMetrics: true})
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv, md
+}
+
+func testRequest() *SnapshotRequest {
+	return &SnapshotRequest{
+		Configs: []ConfigObject{
+			{Hostnames: []string{"node1", "node2"}, Port: 9100, Type: ConfigTypeFile},
+		},
+		Credentials: Credentials{Username: "u", Password: "p"},
+		Label:       "client test",
+	}
+}
+
+func TestSnapshotLifecycle(t *testing.T) {
+	srv, md := newTestServer(t, nil)
+	c, err := New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	created, err := c.CreateSnapshot(ctx, testRequest())
+	if err != nil {
+		t.Fatalf("CreateSnapshot: %v", err)
+	}
+
+	snap, err := c.GetSnapshot(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetSnapshot: %v", err)
+	}
+	if snap.Name != created.ID || len(snap.Targets) != 2 {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+
+	ran := false
+	if err := c.WithPhase(ctx, created.ID, "load", func() error { ran = true; return nil }); err != nil {
+		t.Fatalf("WithPhase: %v", err)
+	}
+	if !ran {
+		t.Fatal("WithPhase did not run fn")
+	}
+
+	if err := c.UpdateServices(ctx, created.ID, []string{"xdcr"}); err != nil {
+		t.Fatalf("UpdateServices: %v", err)
+	}
+	targets, err := c.PatchTargets(ctx, created.ID, &TargetsPatchRequest{Remove: []string{"node1:9100"}})
+	if err != nil {
+		t.Fatalf("PatchTargets: %v", err)
+	}
+	if len(targets.Targets) != 1 || targets.Targets[0] != "node2:9100" {
+		t.Fatalf("unexpected targets: %v", targets.Targets)
+	}
+	panels, err := c.UpdateCustomPanels(ctx, created.ID, &CustomPanelsPatchRequest{Presets: []string{"cbagent"}})
+	if err != nil {
+		t.Fatalf("UpdateCustomPanels: %v", err)
+	}
+	if len(panels) != 1 || panels[0].Title != "cbagent" {
+		t.Fatalf("unexpected panels: %+v", panels)
+	}
+	if err := c.SetTags(ctx, created.ID, map[string]string{"build": "7.6.2-3721", "owner": "perf-team"}); err != nil {
+		t.Fatalf("SetTags: %v", err)
+	}
+	if err := c.SetTags(ctx, created.ID, map[string]string{"owner": ""}); err != nil {
+		t.Fatalf("SetTags remove: %v", err)
+	}
+	listed, err := c.ListSnapshots(ctx, "build=7.6.2-3721")
+	if err != nil {
+		t.Fatalf("ListSnapshots: %v", err)
+	}
+	if len(listed) != 1 || listed[0].Label != "client test" || !reflect.DeepEqual(listed[0].Tags, map[string]string{"build": "7.6.2-3721"}) {
+		t.Fatalf("unexpected listing: %+v", listed)
+	}
+	if listed, err := c.ListSnapshots(ctx, "owner"); err != nil || len(listed) != 0 {
+		t.Fatalf("removed tag still matches: %+v, %v", listed, err)
+	}
+	if err := c.KeepAlive(ctx, created.ID); err != nil {
+		t.Fatalf("KeepAlive: %v", err)
+	}
+
+	doc, err := md.GetMetadata(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Phases) != 1 || doc.Phases[0].Label != "load" || doc.Phases[0].TsEnd == "" {
+		t.Fatalf("phase not recorded: %+v", doc.Phases)
+	}
+
+	if err := c.DeleteSnapshot(ctx, created.ID); err != nil {
+		t.Fatalf("DeleteSnapshot: %v", err)
+	}
+	if _, err := c.GetSnapshot(ctx, created.ID); !IsNotFound(err) {
+		t.Fatalf("GetSnapshot after delete: got %v, want not found", err)
+	}
+}
+
+func TestWithPhaseEndsPhaseOnError(t *testing.T) {
+	srv, md := newTestServer(t, nil)
+	c, _ := New(srv.URL)
+	ctx := context.Background()
+
+	created, err := c.CreateSnapshot(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	boom := errors.New("boom")
+	if err := c.WithPhase(ctx, created.ID, "access", func() error { return boom }); !errors.Is(err, boom) {
+		t.Fatalf("WithPhase error = %v, want %v", err, boom)
+	}
+	doc, _ := md.GetMetadata(created.ID)
+	if len(doc.Phases) != 1 || doc.Phases[0].TsEnd == "" {
+		t.Fatalf("phase not ended after fn failed: %+v", doc.Phases)
+	}
+}
+
+func TestValidationErrorIsTyped(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	c, _ := New(srv.URL)
+
+	req := testRequest()
+	req.Configs[0].Port = 0
+	_, err := c.CreateSnapshot(context.Background(), req)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %T (%v), want *APIError", err, err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != CodeValidationFailed || apiErr.Field != "configs.port" {
+		t.Fatalf("unexpected error: %+v", apiErr)
+	}
+}
+
+func TestIdempotentCallsRetry(t *testing.T) {
+	var failures atomic.Int32
+	failures.Store(2)
+	flaky := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && failures.Add(-1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	srv, _ := newTestServer(t, flaky)
+	c, _ := New(srv.URL, WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	ctx := context.Background()
+
+	created, err := c.CreateSnapshot(ctx, testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetSnapshot(ctx, created.ID); err != nil {
+		t.Fatalf("GetSnapshot should have succeeded after retries: %v", err)
+	}
+}
+
+func TestNonIdempotentCallsDoNotRetry(t *testing.T) {
+	var posts atomic.Int32
+	failing := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				posts.Add(1)
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	srv, _ := newTestServer(t, failing)
+	c, _ := New(srv.URL, WithRetry(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond}))
+
+	if _, err := c.CreateSnapshot(context.Background(), testRequest()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := posts.Load(); n != 1 {
+		t.Fatalf("CreateSnapshot sent %d requests, want 1", n)
+	}
+}
+
+func TestIdempotentCreateRetry(t *testing.T) {
+	var posts atomic.Int32
+	// The first create gets through but its response is lost.
+	lossy := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost && posts.Add(1) == 1 {
+				next.ServeHTTP(httptest.NewRecorder(), r)
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	srv, _ := newTestServer(t, lossy)
+	c, _ := New(srv.URL, WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	ctx := context.Background()
+
+	created, err := c.CreateSnapshotIdempotent(ctx, testRequest(), "run-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := posts.Load(); n != 2 {
+		t.Fatalf("sent %d creates, want 2", n)
+	}
+	snapshots, err := c.ListSnapshots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name != created.ID {
+		t.Fatalf("retried create left %d snapshots, want only %s", len(snapshots), created.ID)
+	}
+
+	other := testRequest()
+	other.Label = "another run"
+	_, err = c.CreateSnapshotIdempotent(ctx, other, "run-42")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		t.Fatalf("reusing the key for another request = %v, want 409", err)
+	}
+}
+
+func TestQuerySnapshots(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	c, _ := New(srv.URL)
+	ctx := context.Background()
+
+	req := testRequest()
+	req.Label = "kv_rebalance"
+	created, err := c.CreateSnapshot(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StartPhase(ctx, created.ID, "access"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateSnapshot(ctx, testRequest()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.QuerySnapshots(ctx, &MetadataQuery{Where: []QueryCondition{
+		{Field: "label", Op: QueryPrefix, Value: "kv_"},
+		{Field: "phases", Op: QueryEq, Value: "access"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Snapshots) != 1 || got.Snapshots[0].SnapshotID != created.ID {
+		t.Errorf("QuerySnapshots = %+v, want only %s", got.Snapshots, created.ID)
+	}
+
+	_, err = c.QuerySnapshots(ctx, &MetadataQuery{Where: []QueryCondition{{Field: "label", Op: "like"}}})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Field != "where[0].op" {
+		t.Errorf("bad operator = %v, want a validation error on where[0].op", err)
+	}
+}
+
+func TestServiceDiscovery(t *testing.T) {
+	srv, _ := newTestServer(t, nil)
+	c, _ := New(srv.URL)
+	ctx := context.Background()
+
+	req := testRequest()
+	req.Configs[0].Product = "node"
+	created, err := c.CreateSnapshot(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := c.ServiceDiscovery(ctx, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TargetGroup{{Targets: []string{"node1:9100", "node2:9100"}, Labels: map[string]string{"job": created.ID, "product": "node"}}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("ServiceDiscovery = %+v, want %+v", groups, want)
+	}
+
+	_, err = c.ServiceDiscovery(ctx, "nope")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Field != "shard" {
+		t.Errorf("unknown shard = %v, want a validation error on shard", err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	srv, md := newTestServer(t, nil)
+	c, _ := New(srv.URL)
+	ctx := context.Background()
+
+	req := testRequest()
+	req.Credentials.Password = ""
+	tmpl, err := c.CreateTemplate(ctx, &Template{Name: "lab-a-kv", Request: req})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Description = "KV lab A"
+	tmpl.Request.Credentials.Password = ""
+	if tmpl, err = c.UpdateTemplate(ctx, tmpl); err != nil || tmpl.Version != 2 {
+		t.Fatalf("UpdateTemplate = %+v, %v; want version 2", tmpl, err)
+	}
+	var apiErr *APIError
+	tmpl.Version = 1
+	if _, err := c.UpdateTemplate(ctx, tmpl); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		t.Errorf("stale UpdateTemplate = %v, want 409", err)
+	}
+	if list, err := c.ListTemplates(ctx); err != nil || len(list) != 1 || list[0].Description != "KV lab A" {
+		t.Errorf("ListTemplates = %+v, %v", list, err)
+	}
+
+	created, err := c.CreateSnapshot(ctx, &SnapshotRequest{
+		Template:  "lab-a-kv",
+		Label:     "run 42",
+		Overrides: json.RawMessage(`{"credentials":{"password":"p"}}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := md.docs[created.ID]; m == nil || m.Template != "lab-a-kv" || m.TemplateVersion != 2 || m.Label != "run 42" {
+		t.Errorf("metadata = %+v, want template lab-a-kv v2 labelled run 42", m)
+	}
+
+	if err := c.DeleteTemplate(ctx, "lab-a-kv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetTemplate(ctx, "lab-a-kv"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("GetTemplate after delete = %v, want 404", err)
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/archive"
+	"github.com/couchbase/config-manager/internal/audit"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/presets"
+	"github.com/couchbase/config-manager/internal/query"
+	"github.com/couchbase/config-manager/internal/quota"
+	"github.com/couchbase/config-manager/internal/templates"
+)
+
+// The request and response models are the server's own types, re-exported
+// so importers outside this module can build requests without copying
+// structs that would drift.
+type (
+	SnapshotRequest          = models.SnapshotRequest
+	SnapshotResponse         = models.SnapshotResponse
+	ConfigObject             = models.ConfigObject
+	Credentials              = models.Credentials
+	TLSConfig                = models.TLSConfig
+	DisplaySnapshot          = models.DisplaySnapshot
+	SnapshotPatchRequest     = models.SnapshotPatchRequest
+	TargetsPatchRequest      = models.TargetsPatchRequest
+	TargetsResponse          = models.TargetsResponse
+	TargetGroup              = models.TargetGroup
+	CustomPanelsConfig       = models.CustomPanelsConfig
+	CustomPanelOverride      = models.CustomPanelOverride
+	CustomPanelsPatchRequest = models.CustomPanelsPatchRequest
+	CustomPanelsResponse     = models.CustomPanelsResponse
+	Preset                   = presets.Preset
+	AuditEntry               = audit.Entry
+	Archive                  = archive.Archive
+	Template                 = templates.Template
+	RestoreRequest           = models.RestoreRequest
+	CloneRequest             = models.CloneRequest
+	Quota                    = quota.Report
+	Overlap                  = models.Overlap
+	OverlapReport            = models.OverlapReport
+	SnapshotMetadata         = models.SnapshotMetadata
+	MetadataQuery            = models.MetadataQuery
+	QueryCondition           = models.QueryCondition
+	QueryResponse            = models.QueryResponse
+	Limit                    = apierror.Limit
+)
+
+// Config types accepted in ConfigObject.Type.
+const (
+	ConfigTypeSD     = models.ConfigTypeSD
+	ConfigTypeStatic = models.ConfigTypeStatic
+	ConfigTypeDNS    = models.ConfigTypeDNS
+	ConfigTypeFile   = models.ConfigTypeFile
+)
+
+// Operators accepted in QueryCondition.Op.
+const (
+	QueryEq     = query.OpEq
+	QueryPrefix = query.OpPrefix
+	QueryIn     = query.OpIn
+	QueryRange  = query.OpRange
+	QueryExists = query.OpExists
+)
+
+// Error codes returned in APIError.Code.
+const (
+	CodeInvalidRequest   = apierror.CodeInvalidRequest
+	CodeValidationFailed = apierror.CodeValidationFailed
+	CodeNotFound         = apierror.CodeNotFound
+	CodeMethodNotAllowed = apierror.CodeMethodNotAllowed
+	CodeUnauthorized     = apierror.CodeUnauthorized
+	CodeForbidden        = apierror.CodeForbidden
+	CodeConflict         = apierror.CodeConflict
+	CodeInternal         = apierror.CodeInternal
+	CodeUnavailable      = apierror.CodeUnavailable
+	CodeQuotaExceeded    = apierror.CodeQuotaExceeded
+	CodeLimitExceeded    = apierror.CodeLimitExceeded
+)
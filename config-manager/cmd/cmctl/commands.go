@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/couchbase/config-manager/client"
+	"gopkg.in/yaml.v3"
+)
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("cmctl "+name, flag.ContinueOnError)
+}
+
+// readSpec loads a SnapshotRequest from a YAML or JSON file ("-" reads
+// stdin). YAML is converted to JSON first so the request's json tags are
+// the only field names to learn.
+func readSpec(path string) (*client.SnapshotRequest, error) {
+	if path == "" {
+		return nil, errors.New("-f FILE is required")
+	}
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".json" {
+		var generic interface{}
+		if err := yaml.Unmarshal(data, &generic); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		if data, err = json.Marshal(generic); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+
+	var req client.SnapshotRequest
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &req, nil
+}
+
+func runCreate(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("create")
+	file := fs.String("f", "", "Spec file (YAML or JSON, - for stdin)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	req, err := readSpec(*file)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.CreateSnapshot(ctx, req)
+	if err != nil {
+		return err
+	}
+	if a.output == "json" {
+		return a.printJSON(resp)
+	}
+	fmt.Fprintln(a.stdout, resp.ID)
+	return nil
+}
+
+func runRender(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("render")
+	file := fs.String("f", "", "Spec file (YAML or JSON, - for stdin)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	req, err := readSpec(*file)
+	if err != nil {
+		return err
+	}
+	out, err := a.client.RenderSnapshot(ctx, req)
+	if err != nil {
+		return err
+	}
+	if a.output == "json" {
+		var jobs interface{}
+		if err := yaml.Unmarshal(out, &jobs); err != nil {
+			return err
+		}
+		return a.printJSON(jobs)
+	}
+	_, err = a.stdout.Write(out)
+	return err
+}
+
+func runList(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("list")
+	var tags listFlag
+	fs.Var(&tags, "tag", "Only list snapshots with this tag (key or key=value), repeatable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: cmctl list [-tag KEY[=VALUE]]...")
+	}
+	snapshots, err := a.client.ListSnapshots(ctx, tags...)
+	if err != nil {
+		return err
+	}
+	if a.output == "json" {
+		return a.printJSON(snapshots)
+	}
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tLABEL\tSHARD\tLAST KEEP-ALIVE\tURLS\tTARGETS\tTAGS")
+	for _, s := range snapshots {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", s.Name, s.Label, s.Shard, s.TimeStamp.Format(time.RFC3339), len(s.Urls)+len(s.DNSNames), len(s.Targets), formatTags(s.Tags))
+	}
+	return tw.Flush()
+}
+
+// formatTags renders tags as sorted key=value pairs.
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func runGet(ctx context.Context, a *app, args []string) error {
+	id, err := oneID("get", args)
+	if err != nil {
+		return err
+	}
+	s, err := a.client.GetSnapshot(ctx, id)
+	if err != nil {
+		return err
+	}
+	if a.output == "json" {
+		return a.printJSON(s)
+	}
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%s\n", s.Name)
+	fmt.Fprintf(tw, "Last keep-alive:\t%s\n", s.TimeStamp.Format(time.RFC3339))
+	for _, u := range s.Urls {
+		fmt.Fprintf(tw, "SD URL:\t%s\n", u)
+	}
+	for _, n := range s.DNSNames {
+		fmt.Fprintf(tw, "DNS name:\t%s\n", n)
+	}
+	for _, t := range s.Targets {
+		fmt.Fprintf(tw, "Target:\t%s\n", t)
+	}
+	return tw.Flush()
+}
+
+func runPhase(ctx context.Context, a *app, args []string) error {
+	if len(args) != 3 || (args[0] != "start" && args[0] != "end") {
+		return errors.New("usage: cmctl phase start|end ID PHASE")
+	}
+	mode, id, phase := args[0], args[1], args[2]
+	var err error
+	if mode == "start" {
+		err = a.client.StartPhase(ctx, id, phase)
+	} else {
+		err = a.client.EndPhase(ctx, id, phase)
+	}
+	if err != nil {
+		return err
+	}
+	return a.done(map[string]string{"id": id, "phase": phase, "mode": mode})
+}
+
+func runServices(ctx context.Context, a *app, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: cmctl services ID SERVICE...")
+	}
+	if err := a.client.UpdateServices(ctx, args[0], args[1:]); err != nil {
+		return err
+	}
+	return a.done(map[string]interface{}{"id": args[0], "services": args[1:]})
+}
+
+// listFlag collects repeated -add/-remove values.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func runTargets(ctx context.Context, a *app, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New("usage: cmctl targets ID [-add T]... [-remove T]... [-set T,T] [-scheme S]")
+	}
+	id := args[0]
+	fs := newFlagSet("targets")
+	var add, remove listFlag
+	fs.Var(&add, "add", "Target to add (host:port), repeatable")
+	fs.Var(&remove, "remove", "Target to remove (host:port), repeatable")
+	set := fs.String("set", "", "Comma-separated list replacing every target")
+	scheme := fs.String("scheme", "", "http or https, when the snapshot has file configs for both")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	patch := &client.TargetsPatchRequest{Scheme: *scheme, Add: add, Remove: remove}
+	if *set != "" {
+		patch.Targets = strings.Split(*set, ",")
+	}
+	resp, err := a.client.PatchTargets(ctx, id, patch)
+	if err != nil {
+		return err
+	}
+	if a.output == "json" {
+		return a.printJSON(resp)
+	}
+	for _, t := range resp.Targets {
+		fmt.Fprintln(a.stdout, t)
+	}
+	return nil
+}
+
+func runTags(ctx context.Context, a *app, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: cmctl tags ID KEY=VALUE... (KEY= removes a tag)")
+	}
+	tags := make(map[string]string, len(args)-1)
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("tag %q must be KEY=VALUE", arg)
+		}
+		tags[key] = value
+	}
+	if err := a.client.SetTags(ctx, args[0], tags); err != nil {
+		return err
+	}
+	return a.done(map[string]interface{}{"id": args[0], "tags": tags})
+}
+
+func runKeepAlive(ctx context.Context, a *app, args []string) error {
+	id, err := oneID("keepalive", args)
+	if err != nil {
+		return err
+	}
+	if err := a.client.KeepAlive(ctx, id); err != nil {
+		return err
+	}
+	return a.done(map[string]string{"id": id})
+}
+
+func runDelete(ctx context.Context, a *app, args []string) error {
+	id, err := oneID("delete", args)
+	if err != nil {
+		return err
+	}
+	if err := a.client.DeleteSnapshot(ctx, id); err != nil {
+		return err
+	}
+	return a.done(map[string]string{"id": id, "status": "deleted"})
+}
+
+// runEvents polls the snapshot list and reports differences between
+// polls until interrupted.
+func runEvents(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("events")
+	interval := fs.Duration("interval", 5*time.Second, "Poll interval")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	type event struct {
+		Time  time.Time `json:"time"`
+		Event string    `json:"event"`
+		ID    string    `json:"id"`
+	}
+	emit := func(kind, id string) error {
+		e := event{Time: time.Now().UTC(), Event: kind, ID: id}
+		if a.output == "json" {
+			return json.NewEncoder(a.stdout).Encode(e)
+		}
+		_, err := fmt.Fprintf(a.stdout, "%s  %-9s  %s\n", e.Time.Format(time.RFC3339), e.Event, e.ID)
+		return err
+	}
+
+	var seen map[string]time.Time
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		snapshots, err := a.client.ListSnapshots(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		current := make(map[string]time.Time, len(snapshots))
+		for _, s := range snapshots {
+			current[s.Name] = s.TimeStamp
+			if seen == nil {
+				continue
+			}
+			prev, ok := seen[s.Name]
+			switch {
+			case !ok:
+				err = emit("created", s.Name)
+			case !prev.Equal(s.TimeStamp):
+				err = emit("refreshed", s.Name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for id := range seen {
+			if _, ok := current[id]; !ok {
+				if err := emit("ended", id); err != nil {
+					return err
+				}
+			}
+		}
+		seen = current
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func oneID(cmd string, args []string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", fmt.Errorf("usage: cmctl %s ID", cmd)
+	}
+	return args[0], nil
+}
+
+func (a *app) printJSON(v interface{}) error {
+	enc := json.NewEncoder(a.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// done reports a successful mutation: the summary object in JSON mode,
+// nothing in table mode.
+func (a *app) done(summary interface{}) error {
+	if a.output == "json" {
+		return a.printJSON(summary)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+// Command cmctl is the command-line front end for config-manager. It
+// wraps the client package so operators don't have to hand-craft curl
+// calls:
+//
+//	cmctl create -f snapshot.yaml
+//	cmctl phase start <id> load
+//	cmctl list -o json
+//
+// The server URL and credentials come from flags, CMCTL_* environment
+// variables or a YAML config file, in that order of precedence.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/couchbase/config-manager/client"
+	"gopkg.in/yaml.v3"
+)
+
+// settings is the resolved connection configuration.
+type settings struct {
+	Server   string `yaml:"server"`
+	Token    string `yaml:"token"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, app *app, args []string) error
+}
+
+var commands = []command{
+	{"create", "create -f FILE", "Create a snapshot from a YAML or JSON spec file", runCreate},
+	{"render", "render -f FILE", "Print the scrape config a spec file would produce, without creating it", runRender},
+	{"list", "list [-tag KEY[=VALUE]]...", "List active snapshots, optionally filtered by tag", runList},
+	{"get", "get ID", "Show a snapshot's scrape targets", runGet},
+	{"phase", "phase start|end ID PHASE", "Start or end a phase", runPhase},
+	{"services", "services ID SERVICE...", "Add services to a snapshot's metadata", runServices},
+	{"tags", "tags ID KEY=VALUE...", "Set tags on a snapshot (KEY= removes a tag)", runTags},
+	{"targets", "targets ID [-add T]... [-remove T]... [-set T,T] [-scheme S]", "Edit the targets of file-type configs", runTargets},
+	{"keepalive", "keepalive ID", "Refresh a snapshot so it is not expired", runKeepAlive},
+	{"delete", "delete ID", "End a snapshot and remove its scrape config (alias: end)", runDelete},
+	{"end", "end ID", "", runDelete},
+	{"events", "events [-interval D]", "Watch snapshots being created, refreshed and ended", runEvents},
+}
+
+// app carries what every command needs.
+type app struct {
+	client *client.Client
+	output string
+	stdout io.Writer
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "cmctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	global := flag.NewFlagSet("cmctl", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	configPath := global.String("config", "", "Path to a YAML config file (default $CMCTL_CONFIG or ~/.config/cmctl/config.yaml)")
+	server := global.String("server", "", "config-manager base URL (env CMCTL_SERVER)")
+	output := global.String("o", "table", "Output format: table or json")
+	global.Usage = func() { usage(stderr, global) }
+	if err := global.Parse(args); err != nil {
+		return err
+	}
+	if global.NArg() == 0 {
+		usage(stderr, global)
+		return errors.New("no command given")
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	name, cmdArgs := global.Arg(0), global.Args()[1:]
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		usage(stderr, global)
+		return fmt.Errorf("unknown command %q", name)
+	}
+
+	cfg, err := loadSettings(*configPath, *server)
+	if err != nil {
+		return err
+	}
+	var opts []client.Option
+	switch {
+	case cfg.Token != "":
+		opts = append(opts, client.WithBearerToken(cfg.Token))
+	case cfg.Username != "":
+		opts = append(opts, client.WithBasicAuth(cfg.Username, cfg.Password))
+	}
+	opts = append(opts, client.WithUserAgent("cmctl"))
+	c, err := client.New(cfg.Server, opts...)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return cmd.run(ctx, &app{client: c, output: *output, stdout: stdout}, cmdArgs)
+}
+
+func usage(w io.Writer, global *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: cmctl [flags] COMMAND [args]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range commands {
+		if c.summary == "" {
+			continue
+		}
+		fmt.Fprintf(w, "  %-60s %s\n", c.usage, c.summary)
+	}
+	fmt.Fprintln(w, "\nFlags:")
+	global.PrintDefaults()
+	fmt.Fprintln(w, "\nEnvironment: CMCTL_SERVER, CMCTL_TOKEN, CMCTL_USERNAME, CMCTL_PASSWORD, CMCTL_CONFIG")
+}
+
+// loadSettings merges the config file, environment and flags. Flags win
+// over the environment, which wins over the file.
+func loadSettings(configPath, serverFlag string) (*settings, error) {
+	cfg := &settings{}
+
+	explicit := configPath != ""
+	if configPath == "" {
+		configPath = os.Getenv("CMCTL_CONFIG")
+		explicit = configPath != ""
+	}
+	if configPath == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			configPath = filepath.Join(dir, "cmctl", "config.yaml")
+		}
+	}
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		switch {
+		case err == nil:
+			if err := yaml.Unmarshal(data, cfg); err != nil {
+				return nil, fmt.Errorf("parse %s: %w", configPath, err)
+			}
+		case os.IsNotExist(err) && !explicit:
+			// The default location is optional.
+		default:
+			return nil, fmt.Errorf("read %s: %w", configPath, err)
+		}
+	}
+
+	for env, field := range map[string]*string{
+		"CMCTL_SERVER":   &cfg.Server,
+		"CMCTL_TOKEN":    &cfg.Token,
+		"CMCTL_USERNAME": &cfg.Username,
+		"CMCTL_PASSWORD": &cfg.Password,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+	if serverFlag != "" {
+		cfg.Server = serverFlag
+	}
+	if cfg.Server == "" {
+		cfg.Server = "http://localhost:8080"
+	}
+	return cfg, nil
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/archive"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+// GetArchiveRequest handles GET /api/v1/snapshot/{id}/archive, which
+// returns what was kept of an ended snapshot.
+func (h *Handler) GetArchiveRequest(w http.ResponseWriter, r *http.Request) {
+	snapshotID, _ := snapshotPath(r.URL.Path)
+	if snapshotID == "" {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Missing snapshot ID")
+		return
+	}
+
+	a, err := h.archives.Get(snapshotID)
+	if err != nil {
+		writeStorageError(w, err, "Failed to get archive")
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// RestoreSnapshotRequest handles POST /api/v1/snapshot/{id}/restore,
+// which starts a new snapshot, with a new id, from an archived one.
+func (h *Handler) RestoreSnapshotRequest(w http.ResponseWriter, r *http.Request) {
+	snapshotID, _ := snapshotPath(r.URL.Path)
+	if snapshotID == "" {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Missing snapshot ID")
+		return
+	}
+
+	var payload models.RestoreRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid payload request")
+			return
+		}
+	}
+
+	a, err := h.archives.Get(snapshotID)
+	if err != nil {
+		writeStorageError(w, err, "Failed to get archive")
+		return
+	}
+	if a.Request == nil {
+		writeError(w, http.StatusConflict, apierror.CodeConflict, fmt.Sprintf("archive of %s has no request to restore from", snapshotID))
+		return
+	}
+
+	req, err := restoredRequest(a.Request, payload)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	h.createSnapshot(w, r, req, func(id string, metadata *models.SnapshotMetadata) error {
+		metadata.RestoredFrom = snapshotID
+		return h.storage.ReplaceTargetGroups(id, a.FileTargets)
+	})
+}
+
+// restoredRequest rebuilds a create request from an archived, redacted
+// one and the secrets and overrides in payload.
+func restoredRequest(archived *models.SnapshotRequest, payload models.RestoreRequest) (*models.SnapshotRequest, error) {
+	req := *archived
+	// A chosen id belongs to the snapshot it named; the new snapshot
+	// keeps only the prefix.
+	req.ID = ""
+
+	if archived.Credentials.Password != "" || archived.Credentials.Username != "" {
+		if payload.Credentials == nil {
+			return nil, &ValidationError{Field: "credentials", Message: "credentials are required; archives do not keep passwords"}
+		}
+	}
+	if payload.Credentials != nil {
+		req.Credentials = *payload.Credentials
+	}
+
+	if archive.HasRedactedKeys(archived) {
+		if payload.TLS == nil {
+			return nil, &ValidationError{Field: "tls", Message: "tls is required; the snapshot used client certificates and archives do not keep private keys"}
+		}
+		if req.TLS != nil && req.TLS.Key == archive.Redacted {
+			req.TLS = payload.TLS
+		}
+		req.Configs = append([]models.ConfigObject(nil), archived.Configs...)
+		for i := range req.Configs {
+			if req.Configs[i].TLS != nil && req.Configs[i].TLS.Key == archive.Redacted {
+				req.Configs[i].TLS = payload.TLS
+			}
+		}
+	}
+
+	if err := validateTags(payload.Tags, true); err != nil {
+		return nil, err
+	}
+	applyOverrides(&req, payload.Label, payload.Tags)
+	return &req, nil
+}
+
+// applyOverrides replaces the label when one is given and merges tags,
+// an empty value removing the key.
+func applyOverrides(req *models.SnapshotRequest, label string, tags map[string]string) {
+	if label != "" {
+		req.Label = label
+	}
+	if len(tags) == 0 {
+		return
+	}
+	merged := make(map[string]string, len(req.Tags)+len(tags))
+	for k, v := range req.Tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		if v == "" {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	req.Tags = merged
+}
+
+// CloneSnapshotRequest handles POST /api/v1/snapshot/{id}/clone, which
+// starts a new snapshot from the configuration of an active snapshot or,
+// once it has ended, from its archive.
+func (h *Handler) CloneSnapshotRequest(w http.ResponseWriter, r *http.Request) {
+	snapshotID, _ := snapshotPath(r.URL.Path)
+	if snapshotID == "" {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Missing snapshot ID")
+		return
+	}
+
+	var payload models.CloneRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid payload request")
+			return
+		}
+	}
+
+	var (
+		req         *models.SnapshotRequest
+		fileTargets map[string][]models.TargetGroup
+	)
+	_, err := h.storage.GetSnapshot(snapshotID)
+	switch {
+	case err == nil:
+		stored, err := h.storage.GetRequest(snapshotID)
+		if errors.Is(err, storage.ErrRequestNotFound) {
+			writeError(w, http.StatusConflict, apierror.CodeConflict, fmt.Sprintf("snapshot %s predates stored requests and can't be cloned", snapshotID))
+			return
+		}
+		if err != nil {
+			writeStorageError(w, err, "Failed to get snapshot request")
+			return
+		}
+		if fileTargets, err = h.storage.TargetGroups(snapshotID); err != nil {
+			writeStorageError(w, err, "Failed to get snapshot targets")
+			return
+		}
+		if payload.Credentials != nil {
+			stored.Credentials = *payload.Credentials
+		}
+		if payload.TLS != nil {
+			stored.TLS = payload.TLS
+		}
+		if err := validateTags(payload.Tags, true); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		applyOverrides(stored, payload.Label, payload.Tags)
+		stored.ID = ""
+		req = stored
+	case errors.Is(err, storage.ErrSnapshotNotFound):
+		a, err := h.archives.Get(snapshotID)
+		if err != nil {
+			writeStorageError(w, err, "Failed to get archive")
+			return
+		}
+		if a.Request == nil {
+			writeError(w, http.StatusConflict, apierror.CodeConflict, fmt.Sprintf("archive of %s has no request to clone from", snapshotID))
+			return
+		}
+		if req, err = restoredRequest(a.Request, models.RestoreRequest(payload)); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		fileTargets = a.FileTargets
+	default:
+		writeStorageError(w, err, "Failed to get snapshot")
+		return
+	}
+
+	h.createSnapshot(w, r, req, func(id string, metadata *models.SnapshotMetadata) error {
+		metadata.ClonedFrom = snapshotID
+		return h.storage.ReplaceTargetGroups(id, fileTargets)
+	})
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/archive"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+func TestArchiveAndRestore(t *testing.T) {
+	srv := newTestServer(t, nil)
+
+	_, body := doRequest(t, srv, http.MethodPost, "/api/v1/snapshot", staticSnapshot, "")
+	var created struct{ ID string }
+	if err := json.Unmarshal(body, &created); err != nil || created.ID == "" {
+		t.Fatalf("create returned no id: %s", body)
+	}
+	item := "/api/v1/snapshot/" + created.ID
+	doRequest(t, srv, http.MethodPatch, item+"/targets", `{"add":["node2:9100"]}`, "")
+	if resp, body := doRequest(t, srv, http.MethodDelete, item, "", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", resp.StatusCode, body)
+	}
+
+	_, body = doRequest(t, srv, http.MethodGet, item+"/archive", "", "")
+	var a archive.Archive
+	if err := json.Unmarshal(body, &a); err != nil {
+		t.Fatalf("decode archive: %v (%s)", err, body)
+	}
+	if a.Reason != archive.ReasonDeleted || a.Request == nil || a.Request.Label != "contract" {
+		t.Fatalf("unexpected archive: %s", body)
+	}
+	if a.Request.Credentials.Password != archive.Redacted || strings.Contains(a.ScrapeConfig, "password: p") || !strings.Contains(a.ScrapeConfig, archive.Redacted) {
+		t.Errorf("archive still holds the password: %s", body)
+	}
+	if got := a.FileTargets["http"]; len(got) == 0 || !reflect.DeepEqual(got[0].Targets, []string{"node1:9100", "node2:9100"}) {
+		t.Errorf("archive file targets = %+v, want the patched list", a.FileTargets)
+	}
+
+	resp, body := doRequest(t, srv, http.MethodPost, item+"/restore", `{"credentials":{"username":"u","password":"p"},"tags":{"build":""}}`, "")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("restore: %d %s", resp.StatusCode, body)
+	}
+	var restored struct{ ID string }
+	if err := json.Unmarshal(body, &restored); err != nil || restored.ID == "" || restored.ID == created.ID {
+		t.Fatalf("restore returned %s", body)
+	}
+	_, body = doRequest(t, srv, http.MethodGet, "/api/v1/snapshot/"+restored.ID, "", "")
+	var snapshot struct{ Targets []string }
+	if err := json.Unmarshal(body, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snapshot.Targets, []string{"node1:9100", "node2:9100"}) {
+		t.Errorf("restored targets = %v, want the archived list", snapshot.Targets)
+	}
+}
+
+// failingArchive is an archive store whose saves fail.
+type failingArchive struct{ archive.Store }
+
+func (failingArchive) Save(*archive.Archive) error { return errors.New("archive store down") }
+
+// endingMetadata serves the metadata it saved and records which
+// snapshots were ended.
+type endingMetadata struct {
+	*savedMetadata
+	ended []string
+}
+
+func (m *endingMetadata) GetMetadata(id string) (*models.SnapshotMetadata, error) {
+	if md, ok := m.docs[id]; ok {
+		return &md, nil
+	}
+	return nil, storage.ErrMetadataNotFound
+}
+
+func (m *endingMetadata) EoLSnapshot(id, endedBy string) error {
+	m.ended = append(m.ended, id)
+	return nil
+}
+
+func TestDeleteArchiveFailure(t *testing.T) {
+	h := newTestHandler(t)
+	archives := h.archives
+	md := &endingMetadata{savedMetadata: &savedMetadata{FileMetadataStorage: storage.NewFileMetadataStorage(t.TempDir()), docs: map[string]models.SnapshotMetadata{}}}
+	h.metadataStorage = md
+	srv := serveHandler(t, h, nil)
+
+	_, body := doRequest(t, srv, http.MethodPost, "/api/v1/snapshot", staticSnapshot, "")
+	var created struct{ ID string }
+	if err := json.Unmarshal(body, &created); err != nil || created.ID == "" {
+		t.Fatalf("create returned no id: %s", body)
+	}
+	item := "/api/v1/snapshot/" + created.ID
+
+	// A failed archive fails the delete before anything is ended or
+	// removed.
+	h.SetArchive(failingArchive{})
+	if resp, body := doRequest(t, srv, http.MethodDelete, item, "", ""); resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("delete with failing archive: %d %s", resp.StatusCode, body)
+	}
+	if len(md.ended) != 0 {
+		t.Errorf("snapshot ended despite the failed archive: %v", md.ended)
+	}
+	if resp, body := doRequest(t, srv, http.MethodGet, item, "", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("snapshot gone after failed delete: %d %s", resp.StatusCode, body)
+	}
+
+	// The retry goes through, and the archive records the end even though
+	// the metadata was ended after it was taken.
+	h.SetArchive(archives)
+	if resp, body := doRequest(t, srv, http.MethodDelete, item, "", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("retried delete: %d %s", resp.StatusCode, body)
+	}
+	if !reflect.DeepEqual(md.ended, []string{created.ID}) {
+		t.Errorf("ended = %v, want %s", md.ended, created.ID)
+	}
+	a, err := archives.Get(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Metadata == nil || a.Metadata.TsEnd == "" || a.Metadata.TsEnd == "now" {
+		t.Errorf("archived metadata = %+v, want an end time", a.Metadata)
+	}
+}
+
+func TestCloneSnapshot(t *testing.T) {
+	srv := newTestServer(t, nil)
+
+	_, body := doRequest(t, srv, http.MethodPost, "/api/v1/snapshot", staticSnapshot, "")
+	var created struct{ ID string }
+	if err := json.Unmarshal(body, &created); err != nil || created.ID == "" {
+		t.Fatalf("create returned no id: %s", body)
+	}
+	item := "/api/v1/snapshot/" + created.ID
+	doRequest(t, srv, http.MethodPatch, item+"/targets", `{"add":["node2:9100"]}`, "")
+
+	clone := func(body string) string {
+		t.Helper()
+		resp, out := doRequest(t, srv, http.MethodPost, item+"/clone", body, "")
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("clone: %d %s", resp.StatusCode, out)
+		}
+		var cloned struct{ ID string }
+		if err := json.Unmarshal(out, &cloned); err != nil || cloned.ID == "" || cloned.ID == created.ID {
+			t.Fatalf("clone returned %s", out)
+		}
+		_, out = doRequest(t, srv, http.MethodGet, "/api/v1/snapshot/"+cloned.ID, "", "")
+		var snapshot struct{ Targets []string }
+		if err := json.Unmarshal(out, &snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(snapshot.Targets, []string{"node1:9100", "node2:9100"}) {
+			t.Errorf("cloned targets = %v, want the source's current list", snapshot.Targets)
+		}
+		return cloned.ID
+	}
+
+	// An active source needs no secrets: its stored request has them.
+	activeClone := clone(`{"label":"repeat","tags":{"build":""}}`)
+	// The file metadata store keeps nothing, so read the overrides back
+	// from the clone's archived request.
+	doRequest(t, srv, http.MethodDelete, "/api/v1/snapshot/"+activeClone, "", "")
+	_, body = doRequest(t, srv, http.MethodGet, "/api/v1/snapshot/"+activeClone+"/archive", "", "")
+	var a archive.Archive
+	if err := json.Unmarshal(body, &a); err != nil || a.Request == nil {
+		t.Fatalf("decode archive: %v (%s)", err, body)
+	}
+	if a.Request.Label != "repeat" || len(a.Request.Tags) != 0 {
+		t.Errorf("clone label/tags = %q/%v, want repeat and no tags", a.Request.Label, a.Request.Tags)
+	}
+
+	doRequest(t, srv, http.MethodDelete, item, "", "")
+	if resp, body := doRequest(t, srv, http.MethodPost, item+"/clone", "", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("clone of archive without credentials: %d %s", resp.StatusCode, body)
+	}
+	clone(`{"credentials":{"username":"u","password":"p"}}`)
+}
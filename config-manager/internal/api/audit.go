@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/audit"
+	"github.com/couchbase/config-manager/internal/auth"
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// maxCapturedResponse bounds how much of a response auditRecorder keeps;
+// it only needs the created id or the error envelope.
+const maxCapturedResponse = 4096
+
+// auditRecorder captures the status and the start of the body a handler
+// writes.
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *auditRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *auditRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if room := maxCapturedResponse - r.body.Len(); room > 0 {
+		r.body.Write(b[:min(len(b), room)])
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// audited wraps a mutating handler so every call lands in the audit log
+// with the caller, the redacted request body and the outcome. Keep-alives
+// (a PATCH with no body), dry runs and wrong methods change nothing and
+// are skipped.
+func (h *Handler) audited(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if action == audit.ActionPatch && len(body) == 0 {
+			next(w, r)
+			return
+		}
+		if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+			next(w, r)
+			return
+		}
+
+		rec := &auditRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status == http.StatusMethodNotAllowed {
+			return
+		}
+		entry := audit.Entry{
+			Action:     action,
+			Caller:     auth.CallerName(r),
+			RemoteAddr: r.RemoteAddr,
+			Request:    audit.Redact(body),
+			Status:     status,
+			Outcome:    audit.OutcomeSuccess,
+		}
+		entry.SnapshotID, _ = snapshotPath(r.URL.Path)
+		if status >= http.StatusBadRequest {
+			entry.Outcome = audit.OutcomeFailure
+			var env apierror.Response
+			if json.Unmarshal(rec.body.Bytes(), &env) == nil {
+				entry.Error = env.Error.Message
+			}
+		} else if action == audit.ActionCreate {
+			var created models.SnapshotResponse
+			if json.Unmarshal(rec.body.Bytes(), &created) == nil {
+				entry.SnapshotID = created.ID
+			}
+		}
+		h.recordAudit(entry)
+	}
+}
+
+// recordAudit writes entry, logging rather than failing when the audit
+// log is unavailable: the mutation has already happened.
+func (h *Handler) recordAudit(entry audit.Entry) {
+	if err := h.audit.Record(entry); err != nil {
+		logger.Error("Failed to record audit entry", "action", entry.Action, "snapshotID", entry.SnapshotID, "error", err)
+	}
+}
+
+// ListAudit handles GET /api/v1/audit. `snapshot` restricts the entries
+// to one snapshot and `limit` caps how many of the most recent are
+// returned.
+func (h *Handler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	q := audit.Query{SnapshotID: r.URL.Query().Get("snapshot")}
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > audit.MaxLimit {
+			writeValidationError(w, &ValidationError{Field: "limit", Message: "limit must be between 1 and " + strconv.Itoa(audit.MaxLimit)})
+			return
+		}
+		q.Limit = limit
+	}
+
+	entries, err := h.audit.Query(q)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to query audit log: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
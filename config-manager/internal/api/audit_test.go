@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/audit"
+)
+
+func TestAuditRecordsMutations(t *testing.T) {
+	srv := newTestServer(t, nil)
+
+	_, body := doRequest(t, srv, http.MethodPost, "/api/v1/snapshot", staticSnapshot, "")
+	var created struct{ ID string }
+	if err := json.Unmarshal(body, &created); err != nil || created.ID == "" {
+		t.Fatalf("create returned no id: %s", body)
+	}
+	item := "/api/v1/snapshot/" + created.ID
+
+	doRequest(t, srv, http.MethodPatch, item, "", "")
+	doRequest(t, srv, http.MethodPatch, item, `{"phase":"load","mode":"pause"}`, "")
+	doRequest(t, srv, http.MethodPatch, item+"/targets", `{"add":["node2:9100"]}`, "")
+	doRequest(t, srv, http.MethodPost, "/api/v1/snapshot?dry_run=true", staticSnapshot, "")
+	doRequest(t, srv, http.MethodDelete, item, "", "")
+
+	_, body = doRequest(t, srv, http.MethodGet, "/api/v1/audit?snapshot="+created.ID, "", "")
+	var entries []audit.Entry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		t.Fatalf("decode audit: %v (%s)", err, body)
+	}
+
+	want := []struct{ action, outcome string }{
+		{audit.ActionCreate, audit.OutcomeSuccess},
+		{audit.ActionPatch, audit.OutcomeFailure},
+		{audit.ActionPatchTargets, audit.OutcomeSuccess},
+		{audit.ActionDelete, audit.OutcomeSuccess},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %s", len(entries), len(want), body)
+	}
+	for i, w := range want {
+		if entries[i].Action != w.action || entries[i].Outcome != w.outcome {
+			t.Errorf("entry %d = %s/%s, want %s/%s", i, entries[i].Action, entries[i].Outcome, w.action, w.outcome)
+		}
+		if entries[i].RemoteAddr == "" || entries[i].Timestamp.IsZero() {
+			t.Errorf("entry %d is missing its remote address or timestamp: %+v", i, entries[i])
+		}
+	}
+	if strings.Contains(string(entries[0].Request), `"p"`) || !strings.Contains(string(entries[0].Request), "REDACTED") {
+		t.Errorf("create request not redacted: %s", entries[0].Request)
+	}
+	if entries[1].Error == "" || entries[1].Status != http.StatusBadRequest {
+		t.Errorf("failed patch not recorded with its error: %+v", entries[1])
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/archive"
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/presets"
+	"github.com/couchbase/config-manager/internal/storage"
+	"github.com/couchbase/config-manager/internal/templates"
+)
+
+// ValidationError represents a validation error
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// writeError sends the JSON error envelope without a field.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	apierror.Write(w, status, code, "", message)
+}
+
+// writeValidationError sends a 400. ValidationErrors keep their field so
+// clients can point at the offending input.
+func writeValidationError(w http.ResponseWriter, err error) {
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeValidationFailed, ve.Field, ve.Message)
+		return
+	}
+	apierror.Write(w, http.StatusBadRequest, apierror.CodeValidationFailed, "", err.Error())
+}
+
+// writeStorageError maps storage sentinel errors onto statuses; anything
+// unrecognised is a 500 prefixed with what the handler was doing.
+func writeStorageError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, storage.ErrSnapshotNotFound), errors.Is(err, storage.ErrMetadataNotFound), errors.Is(err, archive.ErrNotFound), errors.Is(err, templates.ErrNotFound):
+		writeError(w, http.StatusNotFound, apierror.CodeNotFound, err.Error())
+	case errors.Is(err, templates.ErrDisabled), errors.Is(err, storage.ErrMetadataUnavailable):
+		writeError(w, http.StatusServiceUnavailable, apierror.CodeUnavailable, err.Error())
+	case errors.Is(err, storage.ErrMetadataNewerVersion):
+		writeError(w, http.StatusConflict, apierror.CodeConflict, err.Error())
+	case errors.Is(err, storage.ErrNoFileTargets), errors.Is(err, storage.ErrSchemeRequired), errors.Is(err, storage.ErrInvalidMode):
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, apierror.CodeInternal, action+": "+err.Error())
+	}
+}
+
+// methodNotAllowed sends a 405 with the Allow header set.
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+}
+
+// writeJSON encodes v as the response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		// Headers are already sent, so the client just sees a short body.
+		logger.Warn("Failed to encode response", "error", err)
+	}
+}
+
+// writeSelectionError reports a preset or custom panel selection that
+// the registry rejected, on the request field it came from.
+func writeSelectionError(w http.ResponseWriter, err error) {
+	var sel *presets.SelectionError
+	if errors.As(err, &sel) {
+		writeValidationError(w, &ValidationError{Field: sel.Field, Message: sel.Message})
+		return
+	}
+	writeError(w, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
+}
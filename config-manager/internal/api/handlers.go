@@ -2,54 +2,160 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/archive"
+	"github.com/couchbase/config-manager/internal/audit"
+	"github.com/couchbase/config-manager/internal/auth"
+	"github.com/couchbase/config-manager/internal/idempotency"
 	"github.com/couchbase/config-manager/internal/logger"
 	"github.com/couchbase/config-manager/internal/metrics"
 	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/overlap"
 	"github.com/couchbase/config-manager/internal/presets"
 	"github.com/couchbase/config-manager/internal/products"
+	"github.com/couchbase/config-manager/internal/quota"
+	"github.com/couchbase/config-manager/internal/sd"
+	"github.com/couchbase/config-manager/internal/services"
 	"github.com/couchbase/config-manager/internal/storage"
+	"github.com/couchbase/config-manager/internal/templates"
 )
 
 // Handler handles HTTP requests for the config-manager service
 type Handler struct {
-	storage         *storage.FileStorage
+	storage         *storage.Shards
 	metadataStorage storage.MetadataStorage
 	agentType       string
+	presets         atomic.Pointer[presets.Registry]
+	audit           audit.Log
+	archives        archive.Store
+	limits          atomic.Pointer[quota.Limits]
+	// admission serialises the quota check with the save it allows;
+	// pending holds the quota.Snapshot of each create between its save
+	// and its metadata landing, so concurrent checks still count it.
+	admission   sync.Mutex
+	pending     sync.Map
+	idempotency *idempotency.Store
+	// overlapPolicy is one of the overlap.Policy* values.
+	overlapPolicy atomic.Pointer[string]
+	readiness     Readiness
+	sdResolver    *sd.Resolver
+	templates     templates.Store
+	// templatesMu serialises template writes, so versions go up by one.
+	templatesMu sync.Mutex
 }
 
-// NewHandler creates a new API handler
-func NewHandler(storage *storage.FileStorage, metadataStorage storage.MetadataStorage, agentType string) *Handler {
-	return &Handler{
+// NewHandler creates a new API handler over the agent shards in storage.
+func NewHandler(storage *storage.Shards, metadataStorage storage.MetadataStorage, agentType string) *Handler {
+	h := &Handler{
 		storage:         storage,
 		metadataStorage: metadataStorage,
 		agentType:       agentType,
+		audit:           audit.Discard,
+		archives:        archive.Discard,
+		idempotency:     idempotency.New(0),
+		sdResolver:      sd.NewResolver(defaultSDCacheTTL, defaultSDTimeout),
+		templates:       templates.Disabled,
 	}
+	h.presets.Store(presets.Default())
+	h.limits.Store(&quota.Limits{})
+	h.SetOverlapPolicy(overlap.PolicyWarn)
+	return h
+}
+
+// SetPresets replaces the preset registry, which defaults to the
+// built-in presets only. It is safe to call while serving requests.
+func (h *Handler) SetPresets(registry *presets.Registry) {
+	h.presets.Store(registry)
+}
+
+// SetAudit sets the log that snapshot mutations are recorded in, which
+// defaults to audit.Discard. Call it before serving requests.
+func (h *Handler) SetAudit(log audit.Log) {
+	h.audit = log
+}
+
+// SetArchive sets where ended snapshots are archived, which defaults to
+// archive.Discard. Call it before serving requests.
+func (h *Handler) SetArchive(store archive.Store) {
+	h.archives = store
 }
 
 // CreateSnapshot handles POST /api/v1/snapshot
 func (h *Handler) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, http.MethodPost)
 		return
 	}
 
 	// Parse request body
 	var req models.SnapshotRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request body")
 		return
 	}
 
-	// Validate request
-	if err := h.validateSnapshotRequest(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+		h.createIdempotent(w, r, &req, key)
 		return
 	}
+	h.createSnapshot(w, r, &req, nil)
+}
+
+// snapshotOrigin links a new snapshot to the one it was made from. It
+// runs after the scrape file is written and before the metadata is
+// saved; an error removes the new snapshot again.
+type snapshotOrigin func(id string, metadata *models.SnapshotMetadata) error
+
+// createSnapshot validates req, writes the snapshot and its metadata,
+// and responds with the new id, which it returns. It returns "" once it
+// has written an error or a dry run. It backs POST /api/v1/snapshot and
+// the endpoints that start a snapshot from an existing one.
+func (h *Handler) createSnapshot(w http.ResponseWriter, r *http.Request, req *models.SnapshotRequest, origin snapshotOrigin) string {
+	// Expand a template reference into the request it stands for
+	var template *templates.Template
+	if req.Template != "" {
+		var err error
+		if template, err = h.resolveTemplate(req); err != nil {
+			var ve *ValidationError
+			if errors.As(err, &ve) {
+				writeValidationError(w, err)
+				return ""
+			}
+			writeStorageError(w, err, "Failed to get template")
+			return ""
+		}
+	} else if len(req.Overrides) > 0 {
+		writeValidationError(w, &ValidationError{Field: "overrides", Message: "overrides requires a template"})
+		return ""
+	}
+
+	// Validate request
+	if err := h.validateSnapshotRequest(req); err != nil {
+		writeValidationError(w, err)
+		return ""
+	}
+	if qerr := h.limits.Load().CheckRequest(req); qerr != nil {
+		writeQuotaError(w, qerr)
+		return ""
+	}
+
+	customPanels, err := h.presets.Load().BuildCustomPanels(req)
+	if err != nil {
+		writeSelectionError(w, err)
+		return ""
+	}
 
 	// Convert cluster info to map for storage
 	configs := make([]interface{}, len(req.Configs))
@@ -62,6 +168,8 @@ func (h *Handler) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
 			"sd_path":           config.SDPath,
 			"scheme":            config.Scheme,
 			"use_alt_addresses": config.UseAltAddresses,
+			"dns_record_type":   config.DNSRecordType,
+			"tls":               config.TLS,
 		}
 	}
 
@@ -74,13 +182,84 @@ func (h *Handler) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
 		"scheme": req.Scheme,
 	}
 
-	// Save snapshot to file
-	id, err := h.storage.SaveSnapshot(clusterMap, h.agentType)
+	if req.ID != "" {
+		if err := h.checkSnapshotID(req.ID); err != nil {
+			writeSnapshotIDError(w, err)
+			return ""
+		}
+	}
+
+	// dry_run renders the scrape config the request would produce and
+	// stops there: nothing is written and no metadata is collected.
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		jobName := req.ID
+		if jobName == "" {
+			jobName = "dry-run"
+		}
+		content, err := h.storage.RenderSnapshot(collectProducts(req.Configs), clusterMap, h.agentType, jobName)
+		if errors.Is(err, storage.ErrNoCapacity) {
+			writeError(w, http.StatusServiceUnavailable, apierror.CodeUnavailable, err.Error())
+			return ""
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to render snapshot: "+err.Error())
+			return ""
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(content)
+		return ""
+	}
+
+	// Admit the snapshot and apply the overlap policy to its targets,
+	// then save it to file on the shard placement picks
+	caller := auth.CallerName(r)
+	h.admission.Lock()
+	if err := h.admit(caller, req.Tags); err != nil {
+		h.admission.Unlock()
+		writeQuotaError(w, err)
+		return ""
+	}
+	policy := *h.overlapPolicy.Load()
+	var active []overlap.Snapshot
+	if policy != overlap.PolicyAllow {
+		if active, err = h.activeForOverlap(); err != nil {
+			h.admission.Unlock()
+			writeError(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to check overlaps: "+err.Error())
+			return ""
+		}
+	}
+	overlaps, ok := h.checkOverlaps(w, policy, overlap.FromRequest("", req), active)
+	if !ok {
+		h.admission.Unlock()
+		return ""
+	}
+	id, shard, err := h.storage.SaveSnapshot(newSnapshotID(req), collectProducts(req.Configs), clusterMap, h.agentType)
+	if err == nil {
+		h.pending.Store(id, quota.Snapshot{ID: id, Caller: caller, Tags: req.Tags})
+		defer h.pending.Delete(id)
+	}
+	h.admission.Unlock()
+	if errors.Is(err, storage.ErrSnapshotExists) {
+		writeSnapshotIDError(w, err)
+		return ""
+	}
+	if errors.Is(err, storage.ErrNoCapacity) {
+		writeError(w, http.StatusServiceUnavailable, apierror.CodeUnavailable, err.Error())
+		return ""
+	}
 	if err != nil {
-		http.Error(w, "Failed to save snapshot"+err.Error(), http.StatusInternalServerError)
-		return
+		writeError(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to save snapshot: "+err.Error())
+		return ""
+	}
+	// Reload outside the admission lock: it waits on the agent, and other
+	// creates shouldn't.
+	h.storage.Reload(shard)
+	if err := h.storage.SaveRequest(id, caller, req); err != nil {
+		h.discardSnapshot(id)
+		writeError(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to save snapshot: "+err.Error())
+		return ""
 	}
-	metrics.SnapshotsCreated.Inc()
 
 	// Collect per-product metadata via the registry. Configs whose
 	// product is unknown (or has no GetMetadata) are skipped quietly —
@@ -93,24 +272,43 @@ func (h *Handler) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
 		TsStart:      time.Now(),
 		TsEnd:        "now",
 		Label:        req.Label,
-		CustomPanels: presets.BuildCustomPanels(&req),
+		Tags:         req.Tags,
+		CustomPanels: customPanels,
 		Services:     []string{},
 		Products:     collectProducts(req.Configs),
+		CreatedBy:    caller,
+		Shard:        shard.Name,
+	}
+	if template != nil {
+		metadataRecord.Template = template.Name
+		metadataRecord.TemplateVersion = template.Version
+	}
+	if origin != nil {
+		if err := origin(id, metadataRecord); err != nil {
+			h.discardSnapshot(id)
+			writeError(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to save snapshot: "+err.Error())
+			return ""
+		}
 	}
 	hasMetadata := false
 
 	for _, config := range req.Configs {
+		// DNS configs carry record names, not hosts we could query.
+		if config.Type == models.ConfigTypeDNS {
+			continue
+		}
 		product := products.Get(config.Product)
 		if product == nil || product.GetMetadata == nil {
 			continue
 		}
 		for _, hostname := range config.Hostnames {
-			metadata, err := product.GetMetadata(
+			metadata, err := product.CollectMetadata(
 				config.Scheme,
 				hostname,
 				config.Port,
 				req.Credentials.Username,
 				req.Credentials.Password,
+				config.TLS,
 			)
 			if err != nil {
 				logger.Warn("Warning: Failed to collect product metadata", "product", config.Product, "error", err)
@@ -185,6 +383,22 @@ func (h *Handler) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Cluster UUIDs are only known now, so check them against the
+	// active snapshots' before the snapshot is kept.
+	if len(metadataRecord.Clusters) > 0 {
+		self := overlap.FromRequest(id, req)
+		self.SetClusters(metadataRecord.Clusters)
+		if overlaps, ok = h.checkOverlaps(w, policy, self, active); !ok {
+			h.discardSnapshot(id)
+			return ""
+		}
+	}
+	if len(overlaps) > 0 {
+		metrics.SnapshotOverlaps.WithLabelValues(overlap.PolicyWarn).Inc()
+		logger.Warn("Snapshot overlaps active snapshots", "id", id, "overlaps", overlap.IDs(overlaps))
+	}
+	metrics.SnapshotsCreated.Inc()
+
 	// Persist the metadata document for every snapshot so the label and
 	// timestamps always land in the bucket. When no product contributed
 	// cluster metadata and no custom panels were requested, services/
@@ -196,20 +410,104 @@ func (h *Handler) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
 		logger.Info("Successfully saved metadata for snapshot", "id", id, "hasClusterMetadata", hasMetadata, "customPanels", len(metadataRecord.CustomPanels))
 	}
 
+	metrics.TrackSnapshot(id, req.Label, metadataRecord.Products, metadataRecord.TsStart)
+
 	// Create response
 	response := models.SnapshotResponse{
-		ID: id,
+		ID:       id,
+		Overlaps: overlaps,
+	}
+
+	writeJSON(w, http.StatusCreated, response)
+	return id
+}
+
+// discardSnapshot removes a snapshot whose creation failed part-way.
+func (h *Handler) discardSnapshot(id string) {
+	if err := h.storage.DeleteSnapshot(id); err != nil {
+		logger.Error("Failed to remove partially created snapshot", "id", id, "error", err)
+	}
+}
+
+// ListSnapshots handles GET /api/v1/snapshots. Each entry carries the
+// label and tags from its metadata document. Repeated `tag` query
+// parameters filter the list: `tag=key=value` matches a tag exactly and
+// `tag=key` matches any snapshot that has the key. All filters must
+// match.
+func (h *Handler) ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
 	}
 
-	// Set response headers
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	filters, err := parseTagFilters(r.URL.Query()["tag"])
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
 
-	// Write response
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	snapshots, err := h.storage.ListSnapshots()
+	if err != nil {
+		writeStorageError(w, err, "Failed to list snapshots")
 		return
 	}
+
+	out := make([]models.DisplaySnapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		metadata, err := h.metadataStorage.GetMetadata(snapshot.Name)
+		if err != nil && !errors.Is(err, storage.ErrMetadataNotFound) {
+			logger.Warn("Failed to get metadata for snapshot listing", "id", snapshot.Name, "error", err)
+		}
+		if metadata != nil {
+			snapshot.Label = metadata.Label
+			snapshot.Tags = metadata.Tags
+		}
+		if matchesTags(snapshot.Tags, filters) {
+			out = append(out, snapshot)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// tagFilter is one parsed `tag` query parameter. An unset value matches
+// any value.
+type tagFilter struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+func parseTagFilters(raw []string) ([]tagFilter, error) {
+	filters := make([]tagFilter, 0, len(raw))
+	for _, f := range raw {
+		key, value, hasValue := strings.Cut(f, "=")
+		if !tagKeyPattern.MatchString(key) {
+			return nil, &ValidationError{Field: "tag", Message: fmt.Sprintf("tag filter %q must be key or key=value", f)}
+		}
+		filters = append(filters, tagFilter{key: key, value: value, hasValue: hasValue})
+	}
+	return filters, nil
+}
+
+func matchesTags(tags map[string]string, filters []tagFilter) bool {
+	for _, f := range filters {
+		value, ok := tags[f.key]
+		if !ok || (f.hasValue && value != f.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// ListPresets handles GET /api/v1/presets
+func (h *Handler) ListPresets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.presets.Load().List())
 }
 
 // collectProducts returns the distinct, order-preserving set of products
@@ -235,11 +533,28 @@ func collectProducts(configs []models.ConfigObject) []string {
 
 // validateSnapshotRequest validates the snapshot request
 func (h *Handler) validateSnapshotRequest(req *models.SnapshotRequest) error {
+	if req.ID != "" && req.IDPrefix != "" {
+		return &ValidationError{Field: "id_prefix", Message: "id and id_prefix can't both be set"}
+	}
+	for _, field := range []struct{ name, value string }{{"id", req.ID}, {"id_prefix", req.IDPrefix}} {
+		if field.value != "" && !snapshotIDPattern.MatchString(field.value) {
+			return &ValidationError{Field: field.name, Message: field.name + " must be 1-63 lowercase letters, digits, '_' or '-', starting with a letter or digit"}
+		}
+	}
 	if req.Scheme == "" {
 		req.Scheme = "http"
 	} else if req.Scheme != "http" && req.Scheme != "https" {
 		return &ValidationError{Field: "scheme", Message: "scheme must be either 'http' or 'https'"}
 	}
+	if req.TLS != nil {
+		if _, err := services.TLSClientConfig(req.TLS); err != nil {
+			return &ValidationError{Field: "tls", Message: err.Error()}
+		}
+	}
+
+	// file-type configs share one target file per scheme, so they must
+	// also share one scrape job and therefore one TLS setup.
+	fileTLS := make(map[string]*models.TLSConfig)
 
 	for i := range req.Configs {
 		cfg := &req.Configs[i]
@@ -247,18 +562,18 @@ func (h *Handler) validateSnapshotRequest(req *models.SnapshotRequest) error {
 		if len(cfg.Hostnames) == 0 {
 			return &ValidationError{Field: "configs.hostnames", Message: "at least one cluster/hostname is required"}
 		}
-		// TO DO: add validation to check against non existent Types
 		if cfg.Type == "" {
-			cfg.Type = "sd"
-		}
-		if cfg.Port == 0 {
-			return &ValidationError{Field: "configs.port", Message: "port is required"}
+			cfg.Type = models.ConfigTypeSD
 		}
 
-		// SD targets without an explicit product default to couchbase.
-		// We need *some* path: either caller-supplied (sd_path) or
-		// provided by the product registry. Reject when neither is set.
-		if cfg.Type == "sd" {
+		switch cfg.Type {
+		case models.ConfigTypeSD:
+			if cfg.Port == 0 {
+				return &ValidationError{Field: "configs.port", Message: "port is required"}
+			}
+			// SD targets without an explicit product default to couchbase.
+			// We need *some* path: either caller-supplied (sd_path) or
+			// provided by the product registry. Reject when neither is set.
 			cfg.SDPath = strings.TrimSpace(cfg.SDPath)
 			if cfg.Product == "" {
 				cfg.Product = "couchbase"
@@ -272,6 +587,39 @@ func (h *Handler) validateSnapshotRequest(req *models.SnapshotRequest) error {
 					return &ValidationError{Field: "configs.sd_path", Message: fmt.Sprintf("sd_path is required (product %q has no default SD path)", cfg.Product)}
 				}
 			}
+		case models.ConfigTypeStatic, models.ConfigTypeFile:
+			if cfg.Port == 0 {
+				return &ValidationError{Field: "configs.port", Message: "port is required"}
+			}
+			if cfg.SDPath != "" {
+				return &ValidationError{Field: "configs.sd_path", Message: fmt.Sprintf("sd_path is only valid for type %q", models.ConfigTypeSD)}
+			}
+		case models.ConfigTypeDNS:
+			cfg.DNSRecordType = strings.ToUpper(cfg.DNSRecordType)
+			if cfg.DNSRecordType == "" {
+				cfg.DNSRecordType = "SRV"
+			}
+			switch cfg.DNSRecordType {
+			case "SRV":
+			case "A", "AAAA":
+				if cfg.Port == 0 {
+					return &ValidationError{Field: "configs.port", Message: fmt.Sprintf("port is required for %s lookups", cfg.DNSRecordType)}
+				}
+			default:
+				return &ValidationError{Field: "configs.dns_record_type", Message: "dns_record_type must be one of 'SRV', 'A' or 'AAAA'"}
+			}
+			if cfg.SDPath != "" {
+				return &ValidationError{Field: "configs.sd_path", Message: fmt.Sprintf("sd_path is only valid for type %q", models.ConfigTypeSD)}
+			}
+		default:
+			return &ValidationError{Field: "configs.type", Message: fmt.Sprintf("unknown type %q; must be one of 'sd', 'static', 'dns' or 'file'", cfg.Type)}
+		}
+
+		if cfg.Port < 0 || cfg.Port > 65535 {
+			return &ValidationError{Field: "configs.port", Message: "port must be between 1 and 65535"}
+		}
+		if cfg.DNSRecordType != "" && cfg.Type != models.ConfigTypeDNS {
+			return &ValidationError{Field: "configs.dns_record_type", Message: fmt.Sprintf("dns_record_type is only valid for type %q", models.ConfigTypeDNS)}
 		}
 
 		if cfg.Scheme == "" {
@@ -279,6 +627,24 @@ func (h *Handler) validateSnapshotRequest(req *models.SnapshotRequest) error {
 		} else if cfg.Scheme != "http" && cfg.Scheme != "https" {
 			return &ValidationError{Field: "configs.scheme", Message: "scheme must be either 'http' or 'https'"}
 		}
+
+		if cfg.TLS == nil && cfg.Scheme == "https" {
+			cfg.TLS = req.TLS
+		}
+		if cfg.TLS != nil {
+			if cfg.Scheme != "https" {
+				return &ValidationError{Field: "configs.tls", Message: "tls is only valid with the https scheme"}
+			}
+			if _, err := services.TLSClientConfig(cfg.TLS); err != nil {
+				return &ValidationError{Field: "configs.tls", Message: err.Error()}
+			}
+		}
+		if cfg.Type == models.ConfigTypeFile {
+			if prev, ok := fileTLS[cfg.Scheme]; ok && !reflect.DeepEqual(prev, cfg.TLS) {
+				return &ValidationError{Field: "configs.tls", Message: "file configs with the same scheme must use the same tls settings"}
+			}
+			fileTLS[cfg.Scheme] = cfg.TLS
+		}
 	}
 
 	if req.Credentials.Username == "" {
@@ -289,112 +655,191 @@ func (h *Handler) validateSnapshotRequest(req *models.SnapshotRequest) error {
 		return &ValidationError{Field: "credentials.password", Message: "password is required"}
 	}
 
+	if err := presets.ValidateCustomPanels(req.CustomPanels); err != nil {
+		return &ValidationError{Field: "custom_panels", Message: err.Error()}
+	}
+
+	if err := validateTags(req.Tags, false); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// ValidationError represents a validation error
-type ValidationError struct {
-	Field   string `json:"field"`
-	Message string `json:"message"`
-}
+// snapshotIDPattern limits caller-chosen ids and prefixes to characters
+// that are safe in a file name, a URL path segment and a job label.
+var snapshotIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_\-]{0,62}$`)
 
-func (e *ValidationError) Error() string {
-	return e.Message
+// tagKeyPattern keeps tag keys usable as query parameters and, later,
+// as metric label names after sanitising.
+var tagKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.\-/]{0,62}$`)
+
+const maxTagValueLength = 256
+
+// validateTags checks tag keys and values. allowEmpty permits empty
+// values, which PATCH uses to remove a key.
+func validateTags(tags map[string]string, allowEmpty bool) error {
+	for key, value := range tags {
+		if !tagKeyPattern.MatchString(key) {
+			return &ValidationError{Field: "tags", Message: fmt.Sprintf("tag key %q must be 1-63 letters, digits, '_', '.', '-' or '/', starting with a letter or digit", key)}
+		}
+		if value == "" && !allowEmpty {
+			return &ValidationError{Field: "tags." + key, Message: "tag value must not be empty"}
+		}
+		if len(value) > maxTagValueLength {
+			return &ValidationError{Field: "tags." + key, Message: fmt.Sprintf("tag value must be at most %d characters", maxTagValueLength)}
+		}
+		if strings.ContainsFunc(value, unicode.IsControl) {
+			return &ValidationError{Field: "tags." + key, Message: "tag value must not contain control characters"}
+		}
+	}
+	return nil
 }
+
+// Manager dispatches /api/v1/snapshot/{id} and its sub-resources.
 func (h *Handler) Manager(w http.ResponseWriter, r *http.Request) {
+	switch _, sub := snapshotPath(r.URL.Path); sub {
+	case "":
+	case "targets":
+		if r.Method != http.MethodPatch {
+			methodNotAllowed(w, http.MethodPatch)
+			return
+		}
+		h.audited(audit.ActionPatchTargets, h.PatchTargetsRequest)(w, r)
+		return
+	case "custom_panels":
+		if r.Method != http.MethodPatch {
+			methodNotAllowed(w, http.MethodPatch)
+			return
+		}
+		h.audited(audit.ActionPatchCustomPanels, h.PatchCustomPanelsRequest)(w, r)
+		return
+	case "archive":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.GetArchiveRequest(w, r)
+		return
+	case "restore":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		h.audited(audit.ActionRestore, h.RestoreSnapshotRequest)(w, r)
+		return
+	case "clone":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		h.audited(audit.ActionClone, h.CloneSnapshotRequest)(w, r)
+		return
+	case "overlaps":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		h.GetOverlapsRequest(w, r)
+		return
+	default:
+		writeError(w, http.StatusNotFound, apierror.CodeNotFound, "unknown snapshot sub-resource: "+sub)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		h.GetSnapshotRequest(w, r)
 	case http.MethodDelete:
-		h.DeleteSnapshotRequest(w, r)
+		h.audited(audit.ActionDelete, h.DeleteSnapshotRequest)(w, r)
 	case http.MethodPatch:
-		h.PatchSnapshotRequest(w, r)
+		h.audited(audit.ActionPatch, h.PatchSnapshotRequest)(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, http.MethodGet, http.MethodPatch, http.MethodDelete)
 	}
 }
 
 func (h *Handler) GetSnapshotRequest(w http.ResponseWriter, r *http.Request) {
-	segments := strings.Split(r.URL.Path, "/")
-	if len(segments) < 4 || segments[3] == "" {
-		http.Error(w, "Missing snapshot ID", http.StatusBadRequest)
+	snapshotID, _ := snapshotPath(r.URL.Path)
+	if snapshotID == "" {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Missing snapshot ID")
 		return
 	}
 
-	snapshotID := segments[len(segments)-1]
 	snapshot, err := h.storage.GetSnapshot(snapshotID)
 	if err != nil {
-		if strings.Contains(err.Error(), "config file does not exist") {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err, "Failed to get snapshot")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	writeJSON(w, http.StatusOK, snapshot)
 }
 
 func (h *Handler) DeleteSnapshotRequest(w http.ResponseWriter, r *http.Request) {
-	segments := strings.Split(r.URL.Path, "/")
-	if len(segments) < 4 || segments[3] == "" {
-		http.Error(w, "Missing snapshot ID", http.StatusBadRequest)
+	snapshotID, _ := snapshotPath(r.URL.Path)
+	if snapshotID == "" {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Missing snapshot ID")
 		return
 	}
 
-	snapshotID := segments[len(segments)-1]
+	// Archive first: once the files are gone there is nothing left to
+	// archive, and a failure here leaves the snapshot running and its
+	// metadata untouched, so the delete can simply be retried.
+	if err := archive.Snapshot(h.archives, h.storage, h.metadataStorage, snapshotID, archive.ReasonDeleted, auth.CallerName(r)); err != nil {
+		writeStorageError(w, err, "Failed to archive snapshot")
+		return
+	}
 
-	if err := h.metadataStorage.EoLSnapshot(snapshotID); err != nil {
-		http.Error(w, "Failed to update end of life for snapshot metadata", http.StatusInternalServerError)
+	// A snapshot whose metadata never made it to the store can still be
+	// deleted; only real store failures stop us here.
+	if err := h.metadataStorage.EoLSnapshot(snapshotID, auth.CallerName(r)); err != nil && !errors.Is(err, storage.ErrMetadataNotFound) {
+		writeStorageError(w, err, "Failed to update end of life for snapshot metadata")
 		return
 	}
 
 	if err := h.storage.DeleteSnapshot(snapshotID); err != nil {
-		http.Error(w, "Failed to delete snapshot", http.StatusInternalServerError)
-		// http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err, "Failed to delete snapshot")
 		return
 	}
 	metrics.SnapshotsDeleted.Inc()
+	metrics.UntrackSnapshot(snapshotID)
 
-	// Set response headers
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *Handler) PatchSnapshotRequest(w http.ResponseWriter, r *http.Request) {
-	segments := strings.Split(r.URL.Path, "/")
-	if len(segments) < 4 || segments[3] == "" {
-		http.Error(w, "Missing snapshot ID", http.StatusBadRequest)
+	snapshotID, _ := snapshotPath(r.URL.Path)
+	if snapshotID == "" {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Missing snapshot ID")
 		return
 	}
 
-	snapshotID := segments[len(segments)-1]
-
-	var payload struct {
-		Phase    string   `json:"phase,omitempty"`
-		Mode     string   `json:"mode,omitempty"`
-		Services []string `json:"services,omitempty"`
-	}
+	var payload models.SnapshotPatchRequest
 
+	// An empty body is a keep-alive: it only refreshes the scrape file so
+	// the manager loop doesn't expire the snapshot.
 	if r.ContentLength != 0 {
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-			http.Error(w, "Invalid payload request", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid payload request")
 			return
 		}
 
-		// Validate that at least one operation is requested
-		hasPhaseUpdate := payload.Phase != "" && (payload.Mode == "start" || payload.Mode == "end")
+		if payload.Phase != "" && payload.Mode != "start" && payload.Mode != "end" {
+			writeValidationError(w, &ValidationError{Field: "mode", Message: "mode must be either 'start' or 'end' when phase is set"})
+			return
+		}
+		if err := validateTags(payload.Tags, true); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		hasPhaseUpdate := payload.Phase != ""
 		hasServiceUpdate := len(payload.Services) > 0
 
 		// Handle phase update
 		if hasPhaseUpdate {
 			if err := h.metadataStorage.UpdatePhase(snapshotID, payload.Phase, payload.Mode); err != nil {
-				http.Error(w, "Failed to update phase: "+err.Error(), http.StatusInternalServerError)
+				writeStorageError(w, err, "Failed to update phase")
 				return
 			}
 		}
@@ -402,19 +847,142 @@ func (h *Handler) PatchSnapshotRequest(w http.ResponseWriter, r *http.Request) {
 		// Handle services update
 		if hasServiceUpdate {
 			if err := h.metadataStorage.UpdateServices(snapshotID, payload.Services); err != nil {
-				http.Error(w, "Failed to update services: "+err.Error(), http.StatusInternalServerError)
+				writeStorageError(w, err, "Failed to update services")
+				return
+			}
+		}
+
+		if len(payload.Tags) > 0 {
+			if err := h.metadataStorage.UpdateTags(snapshotID, payload.Tags); err != nil {
+				writeStorageError(w, err, "Failed to update tags")
 				return
 			}
 		}
 	}
 
 	if err := h.storage.PatchSnapshot(snapshotID); err != nil {
-		http.Error(w, "Failed to patch snapshot: "+err.Error(), http.StatusInternalServerError)
+		writeStorageError(w, err, "Failed to patch snapshot")
 		return
 	}
 	metrics.SnapshotsPatched.Inc()
+	metrics.SnapshotKeptAlive(snapshotID, time.Now())
 
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+}
+
+// PatchTargetsRequest handles PATCH /api/v1/snapshot/{id}/targets, which
+// edits the target list behind a snapshot's file-type configs.
+func (h *Handler) PatchTargetsRequest(w http.ResponseWriter, r *http.Request) {
+	snapshotID, _ := snapshotPath(r.URL.Path)
+	if snapshotID == "" {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Missing snapshot ID")
+		return
+	}
+
+	var payload models.TargetsPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid payload request")
+		return
+	}
+	if payload.Targets == nil && len(payload.Add) == 0 && len(payload.Remove) == 0 {
+		writeValidationError(w, &ValidationError{Field: "targets", Message: "one of targets, add or remove is required"})
+		return
+	}
+	if payload.Scheme != "" && payload.Scheme != "http" && payload.Scheme != "https" {
+		writeValidationError(w, &ValidationError{Field: "scheme", Message: "scheme must be either 'http' or 'https'"})
+		return
+	}
+	for field, list := range map[string][]string{"targets": payload.Targets, "add": payload.Add, "remove": payload.Remove} {
+		for _, t := range list {
+			if _, port, found := strings.Cut(t, ":"); !found || port == "" {
+				writeValidationError(w, &ValidationError{Field: field, Message: fmt.Sprintf("target %q must be in host:port form", t)})
+				return
+			}
+		}
+	}
+
+	targets, err := h.storage.PatchTargets(snapshotID, payload, h.limits.Load().MaxTargets)
+	var limitErr *storage.TargetLimitError
+	if errors.As(err, &limitErr) {
+		writeQuotaError(w, quota.Limits{MaxTargets: limitErr.Max}.CheckTargets("targets", limitErr.Count))
+		return
+	}
+	if err != nil {
+		writeStorageError(w, err, "Failed to patch targets")
+		return
+	}
+	metrics.SnapshotsPatched.Inc()
+
+	writeJSON(w, http.StatusOK, models.TargetsResponse{Targets: targets})
+}
+
+// PatchCustomPanelsRequest handles PATCH /api/v1/snapshot/{id}/custom_panels,
+// which adds named presets and inline panels to a running snapshot.
+func (h *Handler) PatchCustomPanelsRequest(w http.ResponseWriter, r *http.Request) {
+	snapshotID, _ := snapshotPath(r.URL.Path)
+	if snapshotID == "" {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Missing snapshot ID")
+		return
+	}
+
+	var payload models.CustomPanelsPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid payload request")
+		return
+	}
+	if len(payload.Presets) == 0 && len(payload.CustomPanels) == 0 {
+		writeValidationError(w, &ValidationError{Field: "custom_panels", Message: "one of presets or custom_panels is required"})
+		return
+	}
+	if err := presets.ValidateCustomPanels(payload.CustomPanels); err != nil {
+		writeValidationError(w, &ValidationError{Field: "custom_panels", Message: err.Error()})
+		return
+	}
+	panels, err := h.presets.Load().ResolvePresets(payload.Presets)
+	if err != nil {
+		writeSelectionError(w, err)
+		return
+	}
+	panels = append(panels, payload.CustomPanels...)
+
+	// Only running snapshots can be changed; the metadata of an ended
+	// one is a historical record.
+	if _, err := h.storage.GetSnapshot(snapshotID); err != nil {
+		writeStorageError(w, err, "Failed to get snapshot")
+		return
+	}
 
+	updated, err := h.metadataStorage.UpdateCustomPanels(snapshotID, panels)
+	if err != nil {
+		writeStorageError(w, err, "Failed to update custom panels")
+		return
+	}
+	metrics.SnapshotsPatched.Inc()
+
+	writeJSON(w, http.StatusOK, models.CustomPanelsResponse{CustomPanels: updated})
+}
+
+// snapshotRoute maps a request under /api/v1/snapshot/ to its route
+// template for the request duration metric, keeping snapshot IDs and
+// unknown sub-resources out of the label values.
+func snapshotRoute(r *http.Request) string {
+	switch _, sub := snapshotPath(r.URL.Path); sub {
+	case "":
+		return "/api/v1/snapshot/{id}"
+	case "targets", "custom_panels", "archive", "restore", "clone", "overlaps":
+		return "/api/v1/snapshot/{id}/" + sub
+	default:
+		return "/api/v1/snapshot/{id}/{unknown}"
+	}
+}
+
+// snapshotPath splits /api/v1/snapshot/{id}[/{sub}] into the snapshot id
+// and the optional sub-resource name.
+func snapshotPath(path string) (id, sub string) {
+	rest := strings.TrimPrefix(path, "/api/v1/snapshot/")
+	if rest == path {
+		return "", ""
+	}
+	id, sub, _ = strings.Cut(strings.Trim(rest, "/"), "/")
+	return id, sub
 }
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+func TestValidateSnapshotRequestConfigs(t *testing.T) {
+	h := newTestHandler(t)
+	tls := func(serverName string) *models.TLSConfig { return &models.TLSConfig{ServerName: serverName} }
+	cases := []struct {
+		name    string
+		configs []models.ConfigObject
+		field   string
+	}{
+		{"unknown type", []models.ConfigObject{{Hostnames: []string{"a"}, Port: 1, Type: "consul"}}, "configs.type"},
+		{"default type is sd", []models.ConfigObject{{Hostnames: []string{"a"}, Port: 8091}}, ""},
+		{"sd path without slash", []models.ConfigObject{{Hostnames: []string{"a"}, Port: 8091, SDPath: "sd"}}, "configs.sd_path"},
+		{"sd without default path", []models.ConfigObject{{Hostnames: []string{"a"}, Port: 8091, Product: "unknown"}}, "configs.sd_path"},
+		{"sd path on static", []models.ConfigObject{{Hostnames: []string{"a"}, Port: 9100, Type: models.ConfigTypeStatic, SDPath: "/sd"}}, "configs.sd_path"},
+		{"sd path on file", []models.ConfigObject{{Hostnames: []string{"a"}, Port: 9100, Type: models.ConfigTypeFile, SDPath: "/sd"}}, "configs.sd_path"},
+		{"sd path on dns", []models.ConfigObject{{Hostnames: []string{"a"}, Type: models.ConfigTypeDNS, SDPath: "/sd"}}, "configs.sd_path"},
+		{"srv without port", []models.ConfigObject{{Hostnames: []string{"_cb._tcp.example.com"}, Type: models.ConfigTypeDNS}}, ""},
+		{"srv with port", []models.ConfigObject{{Hostnames: []string{"_cb._tcp.example.com"}, Type: models.ConfigTypeDNS, DNSRecordType: "SRV", Port: 9100}}, ""},
+		{"a without port", []models.ConfigObject{{Hostnames: []string{"a.example.com"}, Type: models.ConfigTypeDNS, DNSRecordType: "a"}}, "configs.port"},
+		{"aaaa without port", []models.ConfigObject{{Hostnames: []string{"a.example.com"}, Type: models.ConfigTypeDNS, DNSRecordType: "AAAA"}}, "configs.port"},
+		{"a with port", []models.ConfigObject{{Hostnames: []string{"a.example.com"}, Type: models.ConfigTypeDNS, DNSRecordType: "A", Port: 9100}}, ""},
+		{"bad record type", []models.ConfigObject{{Hostnames: []string{"a.example.com"}, Type: models.ConfigTypeDNS, DNSRecordType: "MX", Port: 9100}}, "configs.dns_record_type"},
+		{"record type on static", []models.ConfigObject{{Hostnames: []string{"a"}, Port: 9100, Type: models.ConfigTypeStatic, DNSRecordType: "A"}}, "configs.dns_record_type"},
+		{"file missing port", []models.ConfigObject{{Hostnames: []string{"a"}, Type: models.ConfigTypeFile}}, "configs.port"},
+		{"file mixed tls", []models.ConfigObject{
+			{Hostnames: []string{"a"}, Port: 9100, Type: models.ConfigTypeFile, Scheme: "https", TLS: tls("a")},
+			{Hostnames: []string{"b"}, Port: 9100, Type: models.ConfigTypeFile, Scheme: "https", TLS: tls("b")},
+		}, "configs.tls"},
+		{"file same tls", []models.ConfigObject{
+			{Hostnames: []string{"a"}, Port: 9100, Type: models.ConfigTypeFile, Scheme: "https", TLS: tls("a")},
+			{Hostnames: []string{"b"}, Port: 9100, Type: models.ConfigTypeFile, Scheme: "https", TLS: tls("a")},
+		}, ""},
+		{"file tls per scheme", []models.ConfigObject{
+			{Hostnames: []string{"a"}, Port: 9100, Type: models.ConfigTypeFile},
+			{Hostnames: []string{"b"}, Port: 9100, Type: models.ConfigTypeFile, Scheme: "https", TLS: tls("b")},
+		}, ""},
+		{"tls on http", []models.ConfigObject{{Hostnames: []string{"a"}, Port: 9100, Type: models.ConfigTypeStatic, TLS: tls("a")}}, "configs.tls"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &models.SnapshotRequest{Configs: tc.configs, Credentials: models.Credentials{Username: "u", Password: "p"}}
+			err := h.validateSnapshotRequest(req)
+			if tc.field == "" {
+				if err != nil {
+					t.Fatalf("validateSnapshotRequest = %v, want nil", err)
+				}
+				return
+			}
+			var ve *ValidationError
+			if !errors.As(err, &ve) || ve.Field != tc.field {
+				t.Fatalf("validateSnapshotRequest = %v, want an error on %s", err, tc.field)
+			}
+		})
+	}
+
+	// Defaults are filled in for the scrape config to use.
+	req := &models.SnapshotRequest{
+		Configs:     []models.ConfigObject{{Hostnames: []string{"a"}, Port: 8091}, {Hostnames: []string{"b"}, Type: models.ConfigTypeDNS, DNSRecordType: "aaaa", Port: 9100}},
+		Credentials: models.Credentials{Username: "u", Password: "p"},
+	}
+	if err := h.validateSnapshotRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	if c := req.Configs[0]; c.Type != models.ConfigTypeSD || c.Product != "couchbase" || c.Scheme != "http" {
+		t.Errorf("sd defaults = %+v", c)
+	}
+	if c := req.Configs[1]; c.DNSRecordType != "AAAA" {
+		t.Errorf("dns_record_type = %q, want AAAA", c.DNSRecordType)
+	}
+}
+
+func TestCreateReloadsOutsideLocks(t *testing.T) {
+	// The agent holds the first reload until release is closed.
+	var reloads atomic.Int32
+	first, release := make(chan struct{}), make(chan struct{})
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reloads.Add(1) == 1 {
+			close(first)
+			<-release
+		}
+	}))
+	defer agent.Close()
+	defer func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	}()
+
+	h := newTestHandler(t)
+	h.storage = storage.NewShards("", &storage.Shard{FileStorage: storage.NewFileStorage(t.TempDir(), ""), Name: "a", ReloadURL: agent.URL + "/-/reload"})
+	srv := serveHandler(t, h, nil)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if resp, body := doRequest(t, srv, http.MethodPost, "/api/v1/snapshot", staticSnapshot, ""); resp.StatusCode != http.StatusCreated {
+			t.Errorf("create with a slow reload: %d %s", resp.StatusCode, body)
+		}
+	}()
+	<-first
+
+	// A second create is admitted and saved while the first waits on the
+	// agent.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if resp, body := doRequest(t, srv, http.MethodPost, "/api/v1/snapshot", staticSnapshot, ""); resp.StatusCode != http.StatusCreated {
+			t.Errorf("second create: %d %s", resp.StatusCode, body)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("second create waited for the first one's reload")
+	}
+	close(release)
+	wg.Wait()
+	if got := reloads.Load(); got != 2 {
+		t.Errorf("agent reloaded %d times, want 2", got)
+	}
+}
+
+// newerMetadata refuses every update, as the Couchbase store does for
+// documents written by a newer release.
+type newerMetadata struct {
+	*storage.FileMetadataStorage
+}
+
+func (newerMetadata) UpdateTags(id string, tags map[string]string) error {
+	return fmt.Errorf("%w: snapshot %s", storage.ErrMetadataNewerVersion, id)
+}
+
+func TestPatchNewerMetadataConflicts(t *testing.T) {
+	s := loadSpec(t)
+	h := newTestHandler(t)
+	h.metadataStorage = newerMetadata{storage.NewFileMetadataStorage(t.TempDir())}
+	srv := serveHandler(t, h, nil)
+
+	resp, body := doRequest(t, srv, http.MethodPost, "/api/v1/snapshot", staticSnapshot, "")
+	var created models.SnapshotResponse
+	if err := json.Unmarshal(body, &created); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: %d %s", resp.StatusCode, body)
+	}
+	checkContract(t, s, srv, contractCase{name: "tags on a newer document", method: http.MethodPatch, url: "/api/v1/snapshot/" + created.ID, specPath: "/api/v1/snapshot/{id}",
+		body: `{"tags":{"owner":"qe"}}`, wantStatus: http.StatusConflict})
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+// Readiness check names and statuses.
+const (
+	CheckAgentDirectory = "agent_directory"
+	CheckAgentReload    = "agent_reload"
+	CheckMetadata       = "metadata"
+	CheckManager        = "manager"
+
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+)
+
+// checkTimeout bounds each readiness check that makes a network call.
+const checkTimeout = 2 * time.Second
+
+// Readiness configures the checks of GET /readyz.
+type Readiness struct {
+	// MetadataEnabled fails the metadata check while the store has
+	// fallen back to file storage, which keeps nothing.
+	MetadataEnabled bool
+	// Heartbeat returns when the manager last finished a pass and the
+	// interval it runs at. The check fails once a pass is two intervals
+	// overdue. Nil skips the check.
+	Heartbeat func() (time.Time, time.Duration)
+}
+
+// SetReadiness configures the readiness checks. Without it, /readyz
+// only checks the agent shards and pings the metadata store. Call it
+// before serving requests.
+func (h *Handler) SetReadiness(readiness Readiness) {
+	h.readiness = readiness
+}
+
+// Healthz handles GET /healthz. It answers as long as the process is
+// serving requests.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	writeJSON(w, http.StatusOK, models.HealthResponse{Status: StatusOK})
+}
+
+// Readyz handles GET /readyz. It runs every readiness check
+// concurrently and answers 200 when all pass, 503 otherwise, reporting
+// each check either way.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	var checks []func() models.HealthCheck
+	for _, shard := range h.storage.List() {
+		checks = append(checks, func() models.HealthCheck {
+			return healthCheck(CheckAgentDirectory, shard.Name, shard.CheckWritable())
+		})
+		if shard.ReloadURL != "" {
+			checks = append(checks, func() models.HealthCheck {
+				return healthCheck(CheckAgentReload, shard.Name, storage.CheckAgent(shard, checkTimeout))
+			})
+		}
+	}
+	checks = append(checks, func() models.HealthCheck {
+		return healthCheck(CheckMetadata, "", h.checkMetadata(checkTimeout))
+	})
+	if h.readiness.Heartbeat != nil {
+		checks = append(checks, func() models.HealthCheck {
+			return healthCheck(CheckManager, "", checkHeartbeat(h.readiness.Heartbeat, time.Now()))
+		})
+	}
+
+	resp := models.HealthResponse{Status: StatusReady, Checks: make([]models.HealthCheck, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp.Checks[i] = check()
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, c := range resp.Checks {
+		if c.Status != StatusOK {
+			resp.Status = StatusNotReady
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, resp)
+}
+
+func healthCheck(name, shard string, err error) models.HealthCheck {
+	c := models.HealthCheck{Name: name, Shard: shard, Status: StatusOK}
+	if err != nil {
+		c.Status = StatusFail
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// checkMetadata pings the metadata store. With metadata enabled, the
+// file fallback is a failure: snapshots are served but their metadata
+// is lost.
+func (h *Handler) checkMetadata(timeout time.Duration) error {
+	if h.readiness.MetadataEnabled && h.metadataStorage.Type() == "file" {
+		return fmt.Errorf("metadata is enabled but the store fell back to file storage")
+	}
+	return storage.Ping(h.metadataStorage, timeout)
+}
+
+// checkHeartbeat fails until the manager finishes its first pass, and
+// once its last pass is more than two intervals old.
+func checkHeartbeat(heartbeat func() (time.Time, time.Duration), now time.Time) error {
+	last, interval := heartbeat()
+	if last.IsZero() {
+		return fmt.Errorf("the manager hasn't finished a pass yet")
+	}
+	if age := now.Sub(last); age > 2*interval {
+		return fmt.Errorf("the manager's last pass was %s ago; it runs every %s", age.Round(time.Second), interval)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+func TestReadyz(t *testing.T) {
+	s := loadSpec(t)
+	// A port nothing listens on.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+
+	ok := &storage.Shard{FileStorage: storage.NewFileStorage(t.TempDir(), ""), Name: "ok"}
+	broken := &storage.Shard{FileStorage: storage.NewFileStorage(filepath.Join(t.TempDir(), "missing"), ""), Name: "broken", ReloadURL: "http://" + closed + "/-/reload"}
+	h := NewHandler(storage.NewShards("", ok, broken), storage.NewFileMetadataStorage(t.TempDir()), "vmagent")
+	lastRun := time.Now().Add(-time.Hour)
+	h.SetReadiness(Readiness{
+		MetadataEnabled: true,
+		Heartbeat:       func() (time.Time, time.Duration) { return lastRun, 5 * time.Minute },
+	})
+	srv := serveHandler(t, h, nil)
+
+	body := checkContract(t, s, srv, contractCase{name: "not ready", method: http.MethodGet, url: "/readyz", specPath: "/readyz", wantStatus: http.StatusServiceUnavailable})
+	var resp models.HealthResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, c := range resp.Checks {
+		got[c.Name+"/"+c.Shard] = c.Status
+		if c.Status == StatusFail && c.Error == "" {
+			t.Errorf("%s/%s failed without an error", c.Name, c.Shard)
+		}
+	}
+	want := map[string]string{
+		"agent_directory/ok":     StatusOK,
+		"agent_directory/broken": StatusFail,
+		"agent_reload/broken":    StatusFail,
+		"metadata/":              StatusFail,
+		"manager/":               StatusFail,
+	}
+	if resp.Status != StatusNotReady || !reflect.DeepEqual(got, want) {
+		t.Errorf("readyz = %s %v, want not_ready %v", resp.Status, got, want)
+	}
+
+	// Liveness doesn't depend on any of it.
+	checkContract(t, s, srv, contractCase{name: "healthz", method: http.MethodGet, url: "/healthz", specPath: "/healthz", wantStatus: http.StatusOK})
+}
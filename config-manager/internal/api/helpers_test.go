@@ -0,0 +1,95 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/archive"
+	"github.com/couchbase/config-manager/internal/audit"
+	"github.com/couchbase/config-manager/internal/auth"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/storage"
+	"github.com/couchbase/config-manager/internal/templates"
+)
+
+func newTestServer(t *testing.T, authn auth.Authenticator) *httptest.Server {
+	t.Helper()
+	return serveHandler(t, newTestHandler(t), authn)
+}
+
+// newTestHandler returns a handler over temp directories with a file
+// audit log, archive and template store.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+	h := NewHandler(storage.SingleShard(storage.NewFileStorage(dir, "")), storage.NewFileMetadataStorage(dir), "vmagent")
+	auditLog, err := audit.NewFileLog(filepath.Join(t.TempDir(), "audit.jsonl"), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+	h.SetAudit(auditLog)
+	archives, err := archive.NewFileStore(filepath.Join(t.TempDir(), "archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetArchive(archives)
+	tmpls, err := templates.NewFileStore(filepath.Join(t.TempDir(), "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetTemplates(tmpls)
+	return h
+}
+
+func serveHandler(t *testing.T, h *Handler, authn auth.Authenticator) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(NewRouter(h, RouterOptions{Authenticator: authn, PublicMetrics: true}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func doRequest(t *testing.T, srv *httptest.Server, method, url, body, authHeader string) (*http.Response, []byte) {
+	t.Helper()
+	var rdr io.Reader
+	if body != "" {
+		rdr = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, srv.URL+url, rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, b
+}
+
+const staticSnapshot = `{"configs":[{"hostnames":["node1"],"port":9100,"type":"file"}],"credentials":{"username":"u","password":"p"},"label":"contract","tags":{"build":"7.6.2-3721"}}`
+
+const labTemplate = `{"name":"lab-a-kv","description":"KV lab A","request":{"configs":[{"hostnames":["node3"],"port":9100,"type":"static"}],"credentials":{"username":"u"},"label":"kv","tags":{"lab":"a"}}}`
+
+// savedMetadata keeps the metadata the file store would drop, so tests
+// can read what a create recorded.
+type savedMetadata struct {
+	*storage.FileMetadataStorage
+	docs map[string]models.SnapshotMetadata
+}
+
+func (m *savedMetadata) SaveMetadata(metadata *models.SnapshotMetadata) error {
+	m.docs[metadata.SnapshotID] = *metadata
+	return nil
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/archive"
+	"github.com/couchbase/config-manager/internal/auth"
+	"github.com/couchbase/config-manager/internal/idempotency"
+	"github.com/couchbase/config-manager/internal/metrics"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	// idempotencyKeyHeader makes a create safe to retry: within the
+	// window, the same key and body return the snapshot the first
+	// request created.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks a response replayed for a key.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// SetIdempotencyWindow sets how long an Idempotency-Key maps to the
+// snapshot it created. It defaults to 0, which ignores the header, and
+// is safe to call while serving requests.
+func (h *Handler) SetIdempotencyWindow(window time.Duration) {
+	h.idempotency.SetWindow(window)
+}
+
+// createIdempotent runs createSnapshot for a request carrying an
+// Idempotency-Key, answering retries with the snapshot the first
+// request created. Dry runs write nothing, so they skip the key.
+func (h *Handler) createIdempotent(w http.ResponseWriter, r *http.Request, req *models.SnapshotRequest, key string) {
+	if !idempotency.ValidKey(key) {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, idempotency.ErrInvalidKey.Error())
+		return
+	}
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		h.createSnapshot(w, r, req, nil)
+		return
+	}
+
+	// Fingerprint before createSnapshot fills in defaults, so a retry
+	// of the same body always matches.
+	fingerprint, err := idempotency.Fingerprint(req)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to fingerprint request: "+err.Error())
+		return
+	}
+	caller := auth.CallerName(r)
+	id, err := h.idempotency.Begin(caller, key, fingerprint)
+	if err != nil {
+		writeError(w, http.StatusConflict, apierror.CodeConflict, err.Error())
+		return
+	}
+	if id != "" {
+		metrics.IdempotentReplays.Inc()
+		w.Header().Set(idempotentReplayedHeader, "true")
+		writeJSON(w, http.StatusCreated, models.SnapshotResponse{ID: id})
+		return
+	}
+
+	defer func() {
+		if id == "" {
+			h.idempotency.Abandon(caller, key)
+			return
+		}
+		h.idempotency.Finish(caller, key, id)
+	}()
+	id = h.createSnapshot(w, r, req, nil)
+}
+
+// newSnapshotID returns the id req asks for: its chosen id, its prefix
+// joined to a new UUID, or "" for the storage layer to generate one.
+func newSnapshotID(req *models.SnapshotRequest) string {
+	if req.IDPrefix != "" {
+		return req.IDPrefix + "-" + uuid.NewString()
+	}
+	return req.ID
+}
+
+// checkSnapshotID returns storage.ErrSnapshotExists when a caller-chosen
+// id was already used: by an active snapshot, by metadata that outlives
+// an ended one, or by an archive. Reusing it would mix two runs under
+// one job label.
+func (h *Handler) checkSnapshotID(id string) error {
+	if _, err := h.storage.Locate(id); err == nil {
+		return fmt.Errorf("%w: %s is an active snapshot", storage.ErrSnapshotExists, id)
+	}
+	metadata, err := h.metadataStorage.GetMetadata(id)
+	if err != nil && !errors.Is(err, storage.ErrMetadataNotFound) {
+		return err
+	}
+	if metadata != nil {
+		return fmt.Errorf("%w: %s has snapshot metadata", storage.ErrSnapshotExists, id)
+	}
+	if _, err := h.archives.Get(id); err == nil {
+		return fmt.Errorf("%w: %s is an archived snapshot", storage.ErrSnapshotExists, id)
+	} else if !errors.Is(err, archive.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// writeSnapshotIDError sends the 409 for a taken id, or a 500 when the
+// stores couldn't be checked.
+func writeSnapshotIDError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrSnapshotExists) {
+		writeError(w, http.StatusConflict, apierror.CodeConflict, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to check snapshot id: "+err.Error())
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotIDs(t *testing.T) {
+	s := loadSpec(t)
+	srv := newTestServer(t, nil)
+	create := func(name, body string, want int) string {
+		t.Helper()
+		out := checkContract(t, s, srv, contractCase{name: name, method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: body, wantStatus: want})
+		var created struct{ ID string }
+		_ = json.Unmarshal(out, &created)
+		return created.ID
+	}
+	withID := func(field, value string) string {
+		return strings.Replace(staticSnapshot, `{"configs"`, `{"`+field+`":"`+value+`","configs"`, 1)
+	}
+
+	if id := create("chosen id", withID("id", "rebalance-42"), http.StatusCreated); id != "rebalance-42" {
+		t.Fatalf("chosen id created %q", id)
+	}
+	create("active id taken", withID("id", "rebalance-42"), http.StatusConflict)
+	create("bad id", withID("id", "../etc"), http.StatusBadRequest)
+	create("uppercase id", withID("id", "Rebalance"), http.StatusBadRequest)
+	create("id and prefix", strings.Replace(withID("id", "a"), `"id":"a"`, `"id":"a","id_prefix":"b"`, 1), http.StatusBadRequest)
+	if id := create("prefix", withID("id_prefix", "kv"), http.StatusCreated); !strings.HasPrefix(id, "kv-") || len(id) != len("kv-")+36 {
+		t.Errorf("prefixed id = %q, want kv-<uuid>", id)
+	}
+
+	// A clone starts a new snapshot rather than reusing the chosen id.
+	resp, body := doRequest(t, srv, http.MethodPost, "/api/v1/snapshot/rebalance-42/clone", "", "")
+	var cloned struct{ ID string }
+	if err := json.Unmarshal(body, &cloned); err != nil || resp.StatusCode != http.StatusCreated || cloned.ID == "rebalance-42" {
+		t.Errorf("clone of chosen id: %d %s", resp.StatusCode, body)
+	}
+
+	// Ended snapshots keep their id: the archive still holds it.
+	doRequest(t, srv, http.MethodDelete, "/api/v1/snapshot/rebalance-42", "", "")
+	create("archived id taken", withID("id", "rebalance-42"), http.StatusConflict)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	h := newTestHandler(t)
+	h.SetIdempotencyWindow(time.Hour)
+	srv := serveHandler(t, h, nil)
+	post := func(key, body string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/v1/snapshot", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Idempotency-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var created struct{ ID string }
+		_ = json.NewDecoder(resp.Body).Decode(&created)
+		return resp, created.ID
+	}
+
+	first, id := post("run-1", staticSnapshot)
+	if first.StatusCode != http.StatusCreated || first.Header.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first create: %d, replayed %q", first.StatusCode, first.Header.Get("Idempotent-Replayed"))
+	}
+	retry, retryID := post("run-1", staticSnapshot)
+	if retry.StatusCode != http.StatusCreated || retryID != id || retry.Header.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry: %d %q (replayed %q), want 201 %q replayed", retry.StatusCode, retryID, retry.Header.Get("Idempotent-Replayed"), id)
+	}
+	if resp, _ := post("run-1", strings.Replace(staticSnapshot, `"contract"`, `"other"`, 1)); resp.StatusCode != http.StatusConflict {
+		t.Errorf("key reused with another body: %d, want 409", resp.StatusCode)
+	}
+	if resp, _ := post("bad key", staticSnapshot); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid key: %d, want 400", resp.StatusCode)
+	}
+	if resp, otherID := post("run-2", staticSnapshot); resp.StatusCode != http.StatusCreated || otherID == id {
+		t.Errorf("new key: %d %q, want a new snapshot", resp.StatusCode, otherID)
+	}
+
+	list, err := h.storage.ListSnapshots()
+	if err != nil || len(list) != 2 {
+		t.Errorf("%d snapshots active (%v), want 2", len(list), err)
+	}
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec is the OpenAPI 3 contract for every route NewRouter
+// registers. Keep it in step with the handlers; openapi_test.go fails
+// when a response doesn't match what the document promises.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPI handles GET /api/v1/openapi.json
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(openAPISpec)
+}
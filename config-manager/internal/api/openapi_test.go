@@ -0,0 +1,304 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/auth"
+	"github.com/couchbase/config-manager/internal/config"
+)
+
+// spec is a minimal view of the embedded OpenAPI document: enough to
+// look up an operation's documented responses and resolve $refs.
+type spec struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas   map[string]map[string]interface{} `json:"schemas"`
+		Responses map[string]map[string]interface{} `json:"responses"`
+	} `json:"components"`
+}
+
+func loadSpec(t *testing.T) *spec {
+	t.Helper()
+	var s spec
+	if err := json.Unmarshal(openAPISpec, &s); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return &s
+}
+
+// responseSchema returns the JSON schema documented for (path, method,
+// status), or nil when the response has no JSON body. It fails the test
+// when the status isn't documented at all.
+func (s *spec) responseSchema(t *testing.T, path, method string, status int) map[string]interface{} {
+	t.Helper()
+	if _, ok := s.Paths[path]; !ok {
+		t.Fatalf("%s is not in the spec", path)
+	}
+	raw, ok := s.Paths[path][strings.ToLower(method)]
+	if !ok {
+		// Undocumented methods on a documented path must be rejected
+		// with the standard envelope.
+		if status == http.StatusMethodNotAllowed {
+			return map[string]interface{}{"$ref": "#/components/schemas/ErrorResponse"}
+		}
+		t.Fatalf("%s %s is not in the spec", method, path)
+	}
+	var op struct {
+		Responses map[string]map[string]interface{} `json:"responses"`
+	}
+	if err := json.Unmarshal(raw, &op); err != nil {
+		t.Fatalf("decode operation %s %s: %v", method, path, err)
+	}
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		t.Fatalf("%s %s returned undocumented status %d", method, path, status)
+	}
+	if ref, ok := resp["$ref"].(string); ok {
+		resp = s.Components.Responses[strings.TrimPrefix(ref, "#/components/responses/")]
+	}
+	content, _ := resp["content"].(map[string]interface{})
+	media, _ := content["application/json"].(map[string]interface{})
+	schema, _ := media["schema"].(map[string]interface{})
+	return schema
+}
+
+func (s *spec) resolve(schema map[string]interface{}) map[string]interface{} {
+	if ref, ok := schema["$ref"].(string); ok {
+		return s.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// validate checks v against the subset of JSON Schema the document uses:
+// type, required, properties, items and enum.
+func (s *spec) validate(schema map[string]interface{}, v interface{}, at string) error {
+	schema = s.resolve(schema)
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if e == v {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", at, v, enum)
+		}
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %T", at, v)
+		}
+		if req, ok := schema["required"].([]interface{}); ok {
+			for _, name := range req {
+				if _, ok := obj[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing required property %q", at, name)
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		for name, val := range obj {
+			p, ok := props[name].(map[string]interface{})
+			if !ok {
+				if len(props) > 0 {
+					return fmt.Errorf("%s: undocumented property %q", at, name)
+				}
+				continue
+			}
+			if err := s.validate(p, val, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %T", at, v)
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range arr {
+			if err := s.validate(items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: expected string, got %T", at, v)
+		}
+	case "integer", "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: expected number, got %T", at, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %T", at, v)
+		}
+	}
+	return nil
+}
+
+type contractCase struct {
+	name       string
+	method     string
+	url        string
+	specPath   string
+	body       string
+	authHeader string
+	wantStatus int
+}
+
+func checkContract(t *testing.T, s *spec, srv *httptest.Server, tc contractCase) []byte {
+	t.Helper()
+	resp, body := doRequest(t, srv, tc.method, tc.url, tc.body, tc.authHeader)
+	if resp.StatusCode != tc.wantStatus {
+		t.Fatalf("%s: status = %d, want %d (body %s)", tc.name, resp.StatusCode, tc.wantStatus, body)
+	}
+	schema := s.responseSchema(t, tc.specPath, tc.method, resp.StatusCode)
+	if schema == nil {
+		return body
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("%s: Content-Type = %q, want application/json", tc.name, ct)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("%s: body is not JSON: %v (%s)", tc.name, err, body)
+	}
+	if err := s.validate(schema, decoded, "body"); err != nil {
+		t.Fatalf("%s: response does not match spec: %v", tc.name, err)
+	}
+	return body
+}
+
+func TestHandlersMatchOpenAPISpec(t *testing.T) {
+	s := loadSpec(t)
+	srv := newTestServer(t, nil)
+
+	body := checkContract(t, s, srv, contractCase{name: "create", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: staticSnapshot, wantStatus: http.StatusCreated})
+	var created struct{ ID string }
+	if err := json.Unmarshal(body, &created); err != nil || created.ID == "" {
+		t.Fatalf("create returned no id: %s", body)
+	}
+	item := "/api/v1/snapshot/" + created.ID
+
+	cases := []contractCase{
+		{name: "create invalid", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: `{"configs":[{"hostnames":["a"]}],"credentials":{"username":"u","password":"p"}}`, wantStatus: http.StatusBadRequest},
+		{name: "create malformed", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "create wrong method", method: http.MethodPut, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", wantStatus: http.StatusMethodNotAllowed},
+		{name: "dry run", method: http.MethodPost, url: "/api/v1/snapshot?dry_run=true", specPath: "/api/v1/snapshot", body: staticSnapshot, wantStatus: http.StatusOK},
+		{name: "create with custom panels", method: http.MethodPost, url: "/api/v1/snapshot?dry_run=true", specPath: "/api/v1/snapshot", body: `{"configs":[{"hostnames":["node1"],"port":9100,"type":"file"}],"credentials":{"username":"u","password":"p"},"custom_panels":[{"title":"Experimental","match":"exp_.*"}]}`, wantStatus: http.StatusOK},
+		{name: "create unknown preset", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: `{"configs":[{"hostnames":["node1"],"port":9100,"type":"file"}],"credentials":{"username":"u","password":"p"},"presets":["nope"]}`, wantStatus: http.StatusBadRequest},
+		{name: "presets", method: http.MethodGet, url: "/api/v1/presets", specPath: "/api/v1/presets", wantStatus: http.StatusOK},
+		{name: "list", method: http.MethodGet, url: "/api/v1/snapshots", specPath: "/api/v1/snapshots", wantStatus: http.StatusOK},
+		{name: "list by tag", method: http.MethodGet, url: "/api/v1/snapshots?tag=build&tag=owner=perf", specPath: "/api/v1/snapshots", wantStatus: http.StatusOK},
+		{name: "query active snapshots", method: http.MethodPost, url: "/api/v1/snapshots/query", specPath: "/api/v1/snapshots/query", body: `{"where":[{"field":"label","op":"prefix","value":"kv_"},{"field":"ts_start","op":"range","from":"2026-10-11T00:00:00Z"}],"limit":10}`, wantStatus: http.StatusOK},
+		{name: "query bad operator", method: http.MethodPost, url: "/api/v1/snapshots/query", specPath: "/api/v1/snapshots/query", body: `{"where":[{"field":"label","op":"like","value":"kv_"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "query wrong method", method: http.MethodGet, url: "/api/v1/snapshots/query", specPath: "/api/v1/snapshots/query", wantStatus: http.StatusMethodNotAllowed},
+		{name: "sd", method: http.MethodGet, url: "/api/v1/sd", specPath: "/api/v1/sd", wantStatus: http.StatusOK},
+		{name: "sd for a shard", method: http.MethodGet, url: "/api/v1/sd?shard=default", specPath: "/api/v1/sd", wantStatus: http.StatusOK},
+		{name: "sd unknown shard", method: http.MethodGet, url: "/api/v1/sd?shard=nope", specPath: "/api/v1/sd", wantStatus: http.StatusBadRequest},
+		{name: "sd wrong method", method: http.MethodPost, url: "/api/v1/sd", specPath: "/api/v1/sd", wantStatus: http.StatusMethodNotAllowed},
+		{name: "list bad tag filter", method: http.MethodGet, url: "/api/v1/snapshots?tag==x", specPath: "/api/v1/snapshots", wantStatus: http.StatusBadRequest},
+		{name: "patch tags", method: http.MethodPatch, url: item, specPath: "/api/v1/snapshot/{id}", body: `{"tags":{"owner":"perf-team","stale":""}}`, wantStatus: http.StatusOK},
+		{name: "patch bad tag", method: http.MethodPatch, url: item, specPath: "/api/v1/snapshot/{id}", body: `{"tags":{"bad key":"x"}}`, wantStatus: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, url: item, specPath: "/api/v1/snapshot/{id}", wantStatus: http.StatusOK},
+		{name: "get missing", method: http.MethodGet, url: "/api/v1/snapshot/missing", specPath: "/api/v1/snapshot/{id}", wantStatus: http.StatusNotFound},
+		{name: "patch keep-alive", method: http.MethodPatch, url: item, specPath: "/api/v1/snapshot/{id}", wantStatus: http.StatusOK},
+		{name: "patch phase", method: http.MethodPatch, url: item, specPath: "/api/v1/snapshot/{id}", body: `{"phase":"load","mode":"start"}`, wantStatus: http.StatusOK},
+		{name: "patch bad mode", method: http.MethodPatch, url: item, specPath: "/api/v1/snapshot/{id}", body: `{"phase":"load","mode":"pause"}`, wantStatus: http.StatusBadRequest},
+		{name: "patch missing", method: http.MethodPatch, url: "/api/v1/snapshot/missing", specPath: "/api/v1/snapshot/{id}", wantStatus: http.StatusNotFound},
+		{name: "patch targets", method: http.MethodPatch, url: item + "/targets", specPath: "/api/v1/snapshot/{id}/targets", body: `{"add":["node2:9100"]}`, wantStatus: http.StatusOK},
+		{name: "patch targets invalid", method: http.MethodPatch, url: item + "/targets", specPath: "/api/v1/snapshot/{id}/targets", body: `{"add":["node2"]}`, wantStatus: http.StatusBadRequest},
+		{name: "patch custom panels", method: http.MethodPatch, url: item + "/custom_panels", specPath: "/api/v1/snapshot/{id}/custom_panels", body: `{"presets":["cbagent"],"custom_panels":[{"title":"Experimental","match":"exp_.*","overrides":{"exp_ops_total":{"transformFunction":"irate"}}}]}`, wantStatus: http.StatusOK},
+		{name: "patch custom panels invalid", method: http.MethodPatch, url: item + "/custom_panels", specPath: "/api/v1/snapshot/{id}/custom_panels", body: `{"custom_panels":[{"title":"x","match":"exp_(.*"}]}`, wantStatus: http.StatusBadRequest},
+		{name: "patch custom panels missing", method: http.MethodPatch, url: "/api/v1/snapshot/missing/custom_panels", specPath: "/api/v1/snapshot/{id}/custom_panels", body: `{"presets":["cbagent"]}`, wantStatus: http.StatusNotFound},
+		{name: "targets wrong method", method: http.MethodGet, url: item + "/targets", specPath: "/api/v1/snapshot/{id}/targets", wantStatus: http.StatusMethodNotAllowed},
+		{name: "clone", method: http.MethodPost, url: item + "/clone", specPath: "/api/v1/snapshot/{id}/clone", body: `{"label":"repeat"}`, wantStatus: http.StatusCreated},
+		{name: "clone bad tag", method: http.MethodPost, url: item + "/clone", specPath: "/api/v1/snapshot/{id}/clone", body: `{"tags":{"bad key":"x"}}`, wantStatus: http.StatusBadRequest},
+		{name: "clone missing", method: http.MethodPost, url: "/api/v1/snapshot/missing/clone", specPath: "/api/v1/snapshot/{id}/clone", wantStatus: http.StatusNotFound},
+		{name: "clone wrong method", method: http.MethodGet, url: item + "/clone", specPath: "/api/v1/snapshot/{id}/clone", wantStatus: http.StatusMethodNotAllowed},
+		{name: "create template", method: http.MethodPost, url: "/api/v1/templates", specPath: "/api/v1/templates", body: labTemplate, wantStatus: http.StatusCreated},
+		{name: "create template again", method: http.MethodPost, url: "/api/v1/templates", specPath: "/api/v1/templates", body: labTemplate, wantStatus: http.StatusConflict},
+		{name: "create template invalid", method: http.MethodPost, url: "/api/v1/templates", specPath: "/api/v1/templates", body: `{"name":"bad","request":{"configs":[{"hostnames":["a"],"type":"bogus"}]}}`, wantStatus: http.StatusBadRequest},
+		{name: "list templates", method: http.MethodGet, url: "/api/v1/templates", specPath: "/api/v1/templates", wantStatus: http.StatusOK},
+		{name: "templates wrong method", method: http.MethodDelete, url: "/api/v1/templates", specPath: "/api/v1/templates", wantStatus: http.StatusMethodNotAllowed},
+		{name: "get template", method: http.MethodGet, url: "/api/v1/templates/lab-a-kv", specPath: "/api/v1/templates/{name}", wantStatus: http.StatusOK},
+		{name: "get template missing", method: http.MethodGet, url: "/api/v1/templates/missing", specPath: "/api/v1/templates/{name}", wantStatus: http.StatusNotFound},
+		{name: "update template", method: http.MethodPut, url: "/api/v1/templates/lab-a-kv", specPath: "/api/v1/templates/{name}", body: strings.Replace(labTemplate, `"name"`, `"version":1,"name"`, 1), wantStatus: http.StatusOK},
+		{name: "update template stale", method: http.MethodPut, url: "/api/v1/templates/lab-a-kv", specPath: "/api/v1/templates/{name}", body: strings.Replace(labTemplate, `"name"`, `"version":1,"name"`, 1), wantStatus: http.StatusConflict},
+		{name: "create from template", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: `{"template":"lab-a-kv","label":"run 42","overrides":{"credentials":{"password":"p"}}}`, wantStatus: http.StatusCreated},
+		{name: "create from missing template", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: `{"template":"missing"}`, wantStatus: http.StatusBadRequest},
+		{name: "delete template", method: http.MethodDelete, url: "/api/v1/templates/lab-a-kv", specPath: "/api/v1/templates/{name}", wantStatus: http.StatusNoContent},
+		{name: "delete template missing", method: http.MethodDelete, url: "/api/v1/templates/lab-a-kv", specPath: "/api/v1/templates/{name}", wantStatus: http.StatusNotFound},
+		{name: "quota", method: http.MethodGet, url: "/api/v1/quota", specPath: "/api/v1/quota", wantStatus: http.StatusOK},
+		{name: "quota wrong method", method: http.MethodPost, url: "/api/v1/quota", specPath: "/api/v1/quota", wantStatus: http.StatusMethodNotAllowed},
+		{name: "audit", method: http.MethodGet, url: "/api/v1/audit?snapshot=" + created.ID, specPath: "/api/v1/audit", wantStatus: http.StatusOK},
+		{name: "audit bad limit", method: http.MethodGet, url: "/api/v1/audit?limit=0", specPath: "/api/v1/audit", wantStatus: http.StatusBadRequest},
+		{name: "openapi", method: http.MethodGet, url: "/api/v1/openapi.json", specPath: "/api/v1/openapi.json", wantStatus: http.StatusOK},
+		{name: "metrics", method: http.MethodGet, url: "/metrics", specPath: "/metrics", wantStatus: http.StatusOK},
+		{name: "healthz", method: http.MethodGet, url: "/healthz", specPath: "/healthz", wantStatus: http.StatusOK},
+		{name: "readyz", method: http.MethodGet, url: "/readyz", specPath: "/readyz", wantStatus: http.StatusOK},
+		{name: "readyz wrong method", method: http.MethodPost, url: "/readyz", specPath: "/readyz", wantStatus: http.StatusMethodNotAllowed},
+		{name: "delete", method: http.MethodDelete, url: item, specPath: "/api/v1/snapshot/{id}", wantStatus: http.StatusNoContent},
+		{name: "delete missing", method: http.MethodDelete, url: item, specPath: "/api/v1/snapshot/{id}", wantStatus: http.StatusNotFound},
+		{name: "archive", method: http.MethodGet, url: item + "/archive", specPath: "/api/v1/snapshot/{id}/archive", wantStatus: http.StatusOK},
+		{name: "archive missing", method: http.MethodGet, url: "/api/v1/snapshot/missing/archive", specPath: "/api/v1/snapshot/{id}/archive", wantStatus: http.StatusNotFound},
+		{name: "restore without credentials", method: http.MethodPost, url: item + "/restore", specPath: "/api/v1/snapshot/{id}/restore", wantStatus: http.StatusBadRequest},
+		{name: "restore", method: http.MethodPost, url: item + "/restore", specPath: "/api/v1/snapshot/{id}/restore", body: `{"credentials":{"username":"u","password":"p"},"label":"again"}`, wantStatus: http.StatusCreated},
+		{name: "restore missing", method: http.MethodPost, url: "/api/v1/snapshot/missing/restore", specPath: "/api/v1/snapshot/{id}/restore", body: `{"credentials":{"username":"u","password":"p"}}`, wantStatus: http.StatusNotFound},
+		{name: "clone archived", method: http.MethodPost, url: item + "/clone", specPath: "/api/v1/snapshot/{id}/clone", body: `{"credentials":{"username":"u","password":"p"}}`, wantStatus: http.StatusCreated},
+		{name: "restore wrong method", method: http.MethodGet, url: item + "/restore", specPath: "/api/v1/snapshot/{id}/restore", wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			checkContract(t, s, srv, tc)
+		})
+	}
+}
+
+func TestValidationErrorEnvelope(t *testing.T) {
+	srv := newTestServer(t, nil)
+	_, body := doRequest(t, srv, http.MethodPost, "/api/v1/snapshot", `{"configs":[{"hostnames":["a"],"type":"bogus","port":1}],"credentials":{"username":"u","password":"p"}}`, "")
+
+	var env struct {
+		Error struct{ Code, Field, Message string }
+	}
+	if err := json.Unmarshal(body, &env); err != nil {
+		t.Fatalf("decode: %v (%s)", err, body)
+	}
+	if env.Error.Code != "validation_failed" || env.Error.Field != "configs.type" || env.Error.Message == "" {
+		t.Fatalf("unexpected envelope: %+v", env.Error)
+	}
+}
+
+func TestAuthErrorsMatchOpenAPISpec(t *testing.T) {
+	s := loadSpec(t)
+	authn, err := auth.NewStatic(config.AuthConfig{
+		Enabled: true,
+		Tokens:  []config.AuthToken{{Name: "reader", Token: "r", Role: "reader"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, authn)
+
+	cases := []contractCase{
+		{name: "anonymous create", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: staticSnapshot, wantStatus: http.StatusUnauthorized},
+		{name: "reader create", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: staticSnapshot, authHeader: "Bearer r", wantStatus: http.StatusForbidden},
+		{name: "reader delete", method: http.MethodDelete, url: "/api/v1/snapshot/x", specPath: "/api/v1/snapshot/{id}", authHeader: "Bearer r", wantStatus: http.StatusForbidden},
+		{name: "reader audit", method: http.MethodGet, url: "/api/v1/audit", specPath: "/api/v1/audit", authHeader: "Bearer r", wantStatus: http.StatusForbidden},
+		{name: "public openapi", method: http.MethodGet, url: "/api/v1/openapi.json", specPath: "/api/v1/openapi.json", wantStatus: http.StatusOK},
+		{name: "public readyz", method: http.MethodGet, url: "/readyz", specPath: "/readyz", wantStatus: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			checkContract(t, s, srv, tc)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/metrics"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/overlap"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+// SetOverlapPolicy sets what a create does when the new snapshot
+// overlaps an active one: one of the overlap.Policy* values, warn by
+// default. It is safe to call while serving requests.
+func (h *Handler) SetOverlapPolicy(policy string) {
+	h.overlapPolicy.Store(&policy)
+}
+
+// activeForOverlap returns every active snapshot with its endpoints, and
+// its label and cluster UUIDs from metadata.
+func (h *Handler) activeForOverlap() ([]overlap.Snapshot, error) {
+	active, err := h.storage.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]overlap.Snapshot, 0, len(active))
+	for _, d := range active {
+		s := overlap.FromDisplay(d)
+		metadata, err := h.metadataStorage.GetMetadata(d.Name)
+		if err != nil && !errors.Is(err, storage.ErrMetadataNotFound) {
+			logger.Warn("Failed to get metadata for overlap detection", "id", d.Name, "error", err)
+		}
+		if metadata != nil {
+			s.Label = metadata.Label
+			s.SetClusters(metadata.Clusters)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// checkOverlaps applies the overlap policy to s. It returns the overlaps
+// to warn about, or writes the 409 and returns ok false when the policy
+// rejects them.
+func (h *Handler) checkOverlaps(w http.ResponseWriter, policy string, s overlap.Snapshot, active []overlap.Snapshot) (overlaps []models.Overlap, ok bool) {
+	if policy == overlap.PolicyAllow {
+		return nil, true
+	}
+	overlaps = overlap.Find(s, active)
+	if len(overlaps) == 0 {
+		return nil, true
+	}
+	if policy == overlap.PolicyReject {
+		metrics.SnapshotOverlaps.WithLabelValues(overlap.PolicyReject).Inc()
+		writeError(w, http.StatusConflict, apierror.CodeConflict,
+			fmt.Sprintf("snapshot would scrape the same targets or clusters as active snapshots %s", overlap.IDs(overlaps)))
+		return nil, false
+	}
+	return overlaps, true
+}
+
+// GetOverlapsRequest handles GET /api/v1/snapshot/{id}/overlaps, which
+// reports the other active snapshots sharing targets or clusters with
+// an active snapshot.
+func (h *Handler) GetOverlapsRequest(w http.ResponseWriter, r *http.Request) {
+	snapshotID, _ := snapshotPath(r.URL.Path)
+	if snapshotID == "" {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Missing snapshot ID")
+		return
+	}
+
+	active, err := h.activeForOverlap()
+	if err != nil {
+		writeStorageError(w, err, "Failed to list snapshots")
+		return
+	}
+	for _, s := range active {
+		if s.ID != snapshotID {
+			continue
+		}
+		overlaps := overlap.Find(s, active)
+		if overlaps == nil {
+			overlaps = []models.Overlap{}
+		}
+		writeJSON(w, http.StatusOK, models.OverlapReport{SnapshotID: snapshotID, Overlaps: overlaps})
+		return
+	}
+	writeStorageError(w, fmt.Errorf("%w: %s", storage.ErrSnapshotNotFound, snapshotID), "Failed to get snapshot")
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/overlap"
+)
+
+func TestOverlapPolicy(t *testing.T) {
+	s := loadSpec(t)
+	h := newTestHandler(t)
+	srv := serveHandler(t, h, nil)
+	create := func(name string, want int) models.SnapshotResponse {
+		t.Helper()
+		body := checkContract(t, s, srv, contractCase{name: name, method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: staticSnapshot, wantStatus: want})
+		var resp models.SnapshotResponse
+		_ = json.Unmarshal(body, &resp)
+		return resp
+	}
+
+	first := create("first", http.StatusCreated)
+	if len(first.Overlaps) != 0 {
+		t.Errorf("first snapshot overlaps %+v", first.Overlaps)
+	}
+	second := create("warn", http.StatusCreated)
+	if len(second.Overlaps) != 1 || second.Overlaps[0].SnapshotID != first.ID || !reflect.DeepEqual(second.Overlaps[0].Targets, []string{"node1:9100"}) {
+		t.Errorf("warn overlaps = %+v, want node1:9100 shared with %s", second.Overlaps, first.ID)
+	}
+
+	body := checkContract(t, s, srv, contractCase{name: "overlaps", method: http.MethodGet, url: "/api/v1/snapshot/" + first.ID + "/overlaps", specPath: "/api/v1/snapshot/{id}/overlaps", wantStatus: http.StatusOK})
+	var report models.OverlapReport
+	if err := json.Unmarshal(body, &report); err != nil || len(report.Overlaps) != 1 || report.Overlaps[0].SnapshotID != second.ID {
+		t.Errorf("overlap report = %s", body)
+	}
+	checkContract(t, s, srv, contractCase{name: "overlaps missing", method: http.MethodGet, url: "/api/v1/snapshot/missing/overlaps", specPath: "/api/v1/snapshot/{id}/overlaps", wantStatus: http.StatusNotFound})
+	checkContract(t, s, srv, contractCase{name: "overlaps wrong method", method: http.MethodPost, url: "/api/v1/snapshot/" + first.ID + "/overlaps", specPath: "/api/v1/snapshot/{id}/overlaps", wantStatus: http.StatusMethodNotAllowed})
+
+	h.SetOverlapPolicy(overlap.PolicyReject)
+	create("reject", http.StatusConflict)
+	checkContract(t, s, srv, contractCase{name: "reject clone", method: http.MethodPost, url: "/api/v1/snapshot/" + first.ID + "/clone", specPath: "/api/v1/snapshot/{id}/clone", wantStatus: http.StatusConflict})
+
+	h.SetOverlapPolicy(overlap.PolicyAllow)
+	if resp := create("allow", http.StatusCreated); len(resp.Overlaps) != 0 {
+		t.Errorf("allow listed overlaps %+v", resp.Overlaps)
+	}
+	if list, _ := h.storage.ListSnapshots(); len(list) != 3 {
+		t.Errorf("%d snapshots active, want 3", len(list))
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/migrate"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/query"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+// QuerySnapshots handles POST /api/v1/snapshots/query, which returns the
+// metadata of every snapshot, active or ended, matching a filter.
+func (h *Handler) QuerySnapshots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var q models.MetadataQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request body")
+		return
+	}
+	if err := query.Validate(q); err != nil {
+		var qe *query.Error
+		if errors.As(err, &qe) {
+			err = &ValidationError{Field: qe.Field, Message: qe.Message}
+		}
+		writeValidationError(w, err)
+		return
+	}
+
+	snapshots, truncated, err := h.metadataStorage.QueryMetadata(q)
+	if errors.Is(err, storage.ErrQueryUnsupported) {
+		snapshots, truncated, err = h.queryActive(q)
+	}
+	if err != nil {
+		writeStorageError(w, err, "Failed to query snapshots")
+		return
+	}
+	writeJSON(w, http.StatusOK, models.QueryResponse{Snapshots: snapshots, Truncated: truncated})
+}
+
+// queryActive evaluates q in memory for a metadata store that keeps no
+// documents, like the file fallback. The active snapshots are all there
+// is to search then, each described by the request it was created from:
+// fields only collected metadata has, like server or services, are empty.
+func (h *Handler) queryActive(q models.MetadataQuery) ([]models.SnapshotMetadata, bool, error) {
+	active, err := h.storage.ListSnapshots()
+	if err != nil {
+		return nil, false, err
+	}
+	docs := make([]models.SnapshotMetadata, 0, len(active))
+	for _, s := range active {
+		doc := models.SnapshotMetadata{SnapshotID: s.Name, TsEnd: "now", Shard: s.Shard, Services: []string{}}
+		stored, err := h.storage.GetStoredRequest(s.Name)
+		switch {
+		case err == nil:
+			doc.TsStart = stored.CreatedAt
+			doc.Label = stored.Label
+			doc.Tags = stored.Tags
+			doc.CreatedBy = stored.CreatedBy
+			doc.Products = collectProducts(stored.Configs)
+		case errors.Is(err, storage.ErrSnapshotNotFound):
+			// Deleted since it was listed.
+			continue
+		case !errors.Is(err, storage.ErrRequestNotFound):
+			return nil, false, err
+		}
+		migrate.Stamp(&doc)
+		docs = append(docs, doc)
+	}
+	out, truncated := query.Filter(q, docs, time.Now())
+	return out, truncated, nil
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/auth"
+	"github.com/couchbase/config-manager/internal/config"
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+func TestQuerySnapshotsInMemory(t *testing.T) {
+	s := loadSpec(t)
+	authn, err := auth.NewStatic(config.AuthConfig{
+		Enabled: true,
+		Tokens: []config.AuthToken{
+			{Name: "alice", Token: "a", Role: "admin"},
+			{Name: "bob", Token: "b", Role: "writer"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// newTestHandler has no metadata store, so queries are answered from
+	// the active snapshots' request files.
+	srv := newTestServer(t, authn)
+
+	create := func(token, body string) string {
+		t.Helper()
+		resp, out := doRequest(t, srv, http.MethodPost, "/api/v1/snapshot", body, "Bearer "+token)
+		var created models.SnapshotResponse
+		if err := json.Unmarshal(out, &created); err != nil || resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create: %d %s", resp.StatusCode, out)
+		}
+		return created.ID
+	}
+	kv := create("a", `{"configs":[{"hostnames":["node1"],"port":9100,"type":"file"}],"credentials":{"username":"u","password":"p"},"label":"kv_rebalance","tags":{"team":"kv"}}`)
+	sgw := create("b", `{"configs":[{"hostnames":["sgw1"],"port":4986,"type":"static","product":"sgw"}],"credentials":{"username":"u","password":"p"},"label":"kv_sgw","tags":{"team":"mobile"}}`)
+	ended := create("b", `{"configs":[{"hostnames":["node2"],"port":9100,"type":"file"}],"credentials":{"username":"u","password":"p"},"label":"kv_ended"}`)
+	if resp, out := doRequest(t, srv, http.MethodDelete, "/api/v1/snapshot/"+ended, "", "Bearer a"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", resp.StatusCode, out)
+	}
+
+	cases := []struct {
+		name      string
+		body      string
+		want      []string
+		truncated bool
+	}{
+		{"prefix, newest first", `{"where":[{"field":"label","op":"prefix","value":"kv_"}]}`, []string{sgw, kv}, false},
+		{"tag", `{"where":[{"field":"tags.team","op":"eq","value":"kv"}]}`, []string{kv}, false},
+		{"created_by", `{"where":[{"field":"created_by","op":"in","values":["bob"]}]}`, []string{sgw}, false},
+		{"products", `{"where":[{"field":"products","op":"eq","value":"sgw"}]}`, []string{sgw}, false},
+		{"started in range", `{"where":[{"field":"ts_start","op":"range","from":"2000-01-01T00:00:00Z"},{"field":"ts_end","op":"range","from":"2000-01-01T00:00:00Z"}]}`, []string{sgw, kv}, false},
+		{"collected fields are empty", `{"where":[{"field":"services","op":"exists"}]}`, []string{}, false},
+		{"limit", `{"limit":1}`, []string{sgw}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := checkContract(t, s, srv, contractCase{name: tc.name, method: http.MethodPost, url: "/api/v1/snapshots/query", specPath: "/api/v1/snapshots/query", body: tc.body, authHeader: "Bearer a", wantStatus: http.StatusOK})
+			var got models.QueryResponse
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, m := range got.Snapshots {
+				ids = append(ids, m.SnapshotID)
+			}
+			if !reflect.DeepEqual(ids, tc.want) || got.Truncated != tc.truncated {
+				t.Errorf("query = %v (truncated %v), want %v (truncated %v)", ids, got.Truncated, tc.want, tc.truncated)
+			}
+		})
+	}
+}
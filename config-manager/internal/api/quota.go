@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/auth"
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/metrics"
+	"github.com/couchbase/config-manager/internal/quota"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+// SetLimits replaces the admission limits, which default to none. It is
+// safe to call while serving requests.
+func (h *Handler) SetLimits(limits quota.Limits) {
+	h.limits.Store(&limits)
+	metrics.QuotaLimit.Reset()
+	for name, max := range map[string]int{
+		quota.LimitActive:    limits.MaxActive,
+		quota.LimitPerCaller: limits.MaxActivePerCaller,
+		quota.LimitTargets:   limits.MaxTargets,
+		quota.LimitHostnames: limits.MaxHostnames,
+	} {
+		metrics.QuotaLimit.WithLabelValues(name, "").Set(float64(max))
+	}
+	for tag, max := range limits.MaxActivePerTag {
+		metrics.QuotaLimit.WithLabelValues(quota.LimitPerTag, tag).Set(float64(max))
+	}
+}
+
+// admit applies the count limits to a new snapshot for caller with
+// tags. The caller must hold h.admission so the check and the save it
+// allows can't interleave with another create.
+func (h *Handler) admit(caller string, tags map[string]string) error {
+	limits := h.limits.Load()
+	if limits.MaxActive == 0 && !limits.CountsOwners() {
+		return nil
+	}
+	usage, err := h.usage(*limits, limits.CountsOwners())
+	if err != nil {
+		return err
+	}
+	if qerr := limits.Admit(usage, caller, tags); qerr != nil {
+		return qerr
+	}
+	return nil
+}
+
+// usage counts the active snapshots against limits. With owners set,
+// each snapshot's caller and tags come from the pending entry of a
+// create still collecting its metadata, or else from owner.
+func (h *Handler) usage(limits quota.Limits, owners bool) (quota.Usage, error) {
+	active, err := h.storage.ListSnapshots()
+	if err != nil {
+		return quota.Usage{}, err
+	}
+	snapshots := make([]quota.Snapshot, 0, len(active))
+	for _, s := range active {
+		snapshot := quota.Snapshot{ID: s.Name}
+		if pending, ok := h.pending.Load(s.Name); ok {
+			snapshot = pending.(quota.Snapshot)
+		} else if owners {
+			snapshot.Caller, snapshot.Tags = h.owner(s.Name)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	usage := limits.Count(snapshots)
+	recordUsage(usage)
+	return usage, nil
+}
+
+// owner returns the caller and tags of active snapshot id. Its metadata
+// has the current tags, but the file fallback keeps none and a create
+// only logs a failed metadata save, so without it they come from the
+// request file written at create time.
+func (h *Handler) owner(id string) (string, map[string]string) {
+	metadata, err := h.metadataStorage.GetMetadata(id)
+	if err != nil && !errors.Is(err, storage.ErrMetadataNotFound) {
+		logger.Warn("Failed to get metadata for quota usage", "id", id, "error", err)
+	}
+	if metadata != nil {
+		return metadata.CreatedBy, metadata.Tags
+	}
+	stored, err := h.storage.GetStoredRequest(id)
+	if err != nil {
+		if !errors.Is(err, storage.ErrRequestNotFound) {
+			logger.Warn("Failed to get snapshot request for quota usage", "id", id, "error", err)
+		}
+		return "", nil
+	}
+	return stored.CreatedBy, stored.Tags
+}
+
+// recordUsage publishes usage on /metrics, replacing the series of
+// callers and tag values that no longer have active snapshots.
+func recordUsage(usage quota.Usage) {
+	metrics.QuotaUsage.Reset()
+	metrics.QuotaUsage.WithLabelValues(quota.LimitActive, "").Set(float64(usage.Active))
+	for caller, n := range usage.ByCaller {
+		metrics.QuotaUsage.WithLabelValues(quota.LimitPerCaller, caller).Set(float64(n))
+	}
+	for tag, values := range usage.ByTag {
+		for value, n := range values {
+			metrics.QuotaUsage.WithLabelValues(quota.LimitPerTag, tag+"="+value).Set(float64(n))
+		}
+	}
+}
+
+// writeQuotaError sends the 429 or 422 for a request refused by a
+// limit, or a 500 when err is something else.
+func writeQuotaError(w http.ResponseWriter, err error) {
+	var qerr *quota.Error
+	if !errors.As(err, &qerr) {
+		writeError(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to check quota: "+err.Error())
+		return
+	}
+	metrics.QuotaRejections.WithLabelValues(qerr.Limit).Inc()
+	apierror.WriteBody(w, qerr.Status, apierror.Body{
+		Code:    qerr.Code,
+		Field:   qerr.Field,
+		Message: qerr.Message,
+		Limit:   &apierror.Limit{Name: qerr.Limit, Max: qerr.Max, Current: qerr.Current},
+	})
+}
+
+// GetQuota handles GET /api/v1/quota, reporting the admission limits and
+// how much of them the active snapshots use.
+func (h *Handler) GetQuota(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	limits := *h.limits.Load()
+	usage, err := h.usage(limits, true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, apierror.CodeInternal, fmt.Sprintf("Failed to count active snapshots: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, quota.Report{Limits: limits, Usage: usage, Caller: auth.CallerName(r)})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/auth"
+	"github.com/couchbase/config-manager/internal/config"
+	"github.com/couchbase/config-manager/internal/quota"
+)
+
+func TestAdmissionLimits(t *testing.T) {
+	s := loadSpec(t)
+	h := newTestHandler(t)
+	h.SetLimits(quota.Limits{MaxActive: 1, MaxTargets: 2, MaxHostnames: 1})
+	srv := serveHandler(t, h, nil)
+
+	limited := func(tc contractCase, name string) {
+		t.Helper()
+		body := checkContract(t, s, srv, tc)
+		var env apierror.Response
+		if err := json.Unmarshal(body, &env); err != nil || env.Error.Limit == nil || env.Error.Limit.Name != name {
+			t.Errorf("%s: error = %s, want limit %s", tc.name, body, name)
+		}
+	}
+
+	limited(contractCase{name: "too many hostnames", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot",
+		body:       `{"configs":[{"hostnames":["node1","node2"],"port":9100,"type":"file"}],"credentials":{"username":"u","password":"p"}}`,
+		wantStatus: http.StatusUnprocessableEntity}, quota.LimitHostnames)
+	limited(contractCase{name: "too many targets", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot",
+		body:       `{"configs":[{"hostnames":["node1"],"port":9100,"type":"file"},{"hostnames":["node2"],"port":9100,"type":"file"},{"hostnames":["node3"],"port":9100}],"credentials":{"username":"u","password":"p"}}`,
+		wantStatus: http.StatusUnprocessableEntity}, quota.LimitTargets)
+
+	body := checkContract(t, s, srv, contractCase{name: "create", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: staticSnapshot, wantStatus: http.StatusCreated})
+	var created struct{ ID string }
+	if err := json.Unmarshal(body, &created); err != nil || created.ID == "" {
+		t.Fatalf("create returned no id: %s", body)
+	}
+	limited(contractCase{name: "over active limit", method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot", body: staticSnapshot, wantStatus: http.StatusTooManyRequests}, quota.LimitActive)
+	limited(contractCase{name: "targets patch over limit", method: http.MethodPatch, url: "/api/v1/snapshot/" + created.ID + "/targets", specPath: "/api/v1/snapshot/{id}/targets",
+		body: `{"add":["node2:9100","node3:9100"]}`, wantStatus: http.StatusUnprocessableEntity}, quota.LimitTargets)
+
+	body = checkContract(t, s, srv, contractCase{name: "quota", method: http.MethodGet, url: "/api/v1/quota", specPath: "/api/v1/quota", wantStatus: http.StatusOK})
+	var q quota.Report
+	if err := json.Unmarshal(body, &q); err != nil || q.Usage.Active != 1 || q.Limits.MaxActive != 1 {
+		t.Errorf("quota = %s", body)
+	}
+}
+
+func TestAdmissionOwnerLimits(t *testing.T) {
+	s := loadSpec(t)
+	authn, err := auth.NewStatic(config.AuthConfig{
+		Enabled: true,
+		Tokens: []config.AuthToken{
+			{Name: "alice", Token: "a", Role: "writer"},
+			{Name: "bob", Token: "b", Role: "writer"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// newTestHandler has no metadata store, so owners are counted from
+	// the request files alone.
+	h := newTestHandler(t)
+	h.SetLimits(quota.Limits{MaxActivePerCaller: 1, MaxActivePerTag: map[string]int{"team": 1}})
+	srv := serveHandler(t, h, authn)
+
+	create := func(name, token, team string, want int, limit string) {
+		t.Helper()
+		body := checkContract(t, s, srv, contractCase{name: name, method: http.MethodPost, url: "/api/v1/snapshot", specPath: "/api/v1/snapshot",
+			body:       `{"configs":[{"hostnames":["node1"],"port":9100,"type":"file"}],"credentials":{"username":"u","password":"p"},"tags":{"team":"` + team + `"}}`,
+			authHeader: "Bearer " + token, wantStatus: want})
+		if limit == "" {
+			return
+		}
+		var env apierror.Response
+		if err := json.Unmarshal(body, &env); err != nil || env.Error.Code != apierror.CodeQuotaExceeded || env.Error.Limit == nil || env.Error.Limit.Name != limit {
+			t.Errorf("%s: error = %s, want %s exceeded", name, body, limit)
+		}
+	}
+
+	create("alice kv", "a", "kv", http.StatusCreated, "")
+	create("alice again", "a", "query", http.StatusTooManyRequests, quota.LimitPerCaller)
+	create("bob kv", "b", "kv", http.StatusTooManyRequests, quota.LimitPerTag)
+	create("bob query", "b", "query", http.StatusCreated, "")
+
+	_, body := doRequest(t, srv, http.MethodGet, "/api/v1/quota", "", "Bearer a")
+	var q quota.Report
+	if err := json.Unmarshal(body, &q); err != nil {
+		t.Fatal(err)
+	}
+	if q.Usage.ByCaller["alice"] != 1 || q.Usage.ByCaller["bob"] != 1 || q.Usage.ByTag["team"]["kv"] != 1 || q.Usage.ByTag["team"]["query"] != 1 {
+		t.Errorf("quota usage = %+v", q.Usage)
+	}
+}
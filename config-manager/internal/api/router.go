@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/couchbase/config-manager/internal/audit"
+	"github.com/couchbase/config-manager/internal/auth"
+	"github.com/couchbase/config-manager/internal/metrics"
+)
+
+// RouterOptions configures NewRouter.
+type RouterOptions struct {
+	// Authenticator checks callers; nil disables authentication.
+	Authenticator auth.Authenticator
+	// PublicMetrics serves /metrics without credentials.
+	PublicMetrics bool
+}
+
+// NewRouter registers every config-manager route on a new mux, wrapped
+// in the auth middleware with each route's required role and the
+// request duration metric. main and the
+// tests share it so they exercise the same routing table.
+func NewRouter(h *Handler, opts RouterOptions) http.Handler {
+	metricsRole := auth.RoleReader
+	if opts.PublicMetrics {
+		metricsRole = auth.RoleNone
+	}
+
+	mux := http.NewServeMux()
+	handle := func(pattern string, route func(*http.Request) string, handler http.Handler) {
+		mux.Handle(pattern, metrics.InstrumentHandler(route, handler))
+	}
+	handle("/api/v1/snapshot", metrics.Route("/api/v1/snapshot"), auth.Middleware(opts.Authenticator, auth.Require(auth.RoleWriter), h.audited(audit.ActionCreate, h.CreateSnapshot)))
+	handle("/api/v1/snapshots", metrics.Route("/api/v1/snapshots"), auth.Middleware(opts.Authenticator, auth.Require(auth.RoleReader), http.HandlerFunc(h.ListSnapshots)))
+	handle("/api/v1/snapshots/query", metrics.Route("/api/v1/snapshots/query"), auth.Middleware(opts.Authenticator, auth.Require(auth.RoleReader), http.HandlerFunc(h.QuerySnapshots)))
+	handle("/api/v1/sd", metrics.Route("/api/v1/sd"), auth.Middleware(opts.Authenticator, auth.Require(auth.RoleReader), http.HandlerFunc(h.ServiceDiscovery)))
+	handle("/api/v1/presets", metrics.Route("/api/v1/presets"), auth.Middleware(opts.Authenticator, auth.Require(auth.RoleReader), http.HandlerFunc(h.ListPresets)))
+	handle("/api/v1/quota", metrics.Route("/api/v1/quota"), auth.Middleware(opts.Authenticator, auth.Require(auth.RoleReader), http.HandlerFunc(h.GetQuota)))
+	handle("/api/v1/templates", metrics.Route("/api/v1/templates"), auth.Middleware(opts.Authenticator, auth.ByMethod, http.HandlerFunc(h.Templates)))
+	handle("/api/v1/templates/", metrics.Route("/api/v1/templates/{name}"), auth.Middleware(opts.Authenticator, auth.ByMethod, http.HandlerFunc(h.Template)))
+	handle("/api/v1/audit", metrics.Route("/api/v1/audit"), auth.Middleware(opts.Authenticator, auth.Require(auth.RoleAdmin), http.HandlerFunc(h.ListAudit)))
+	handle("/api/v1/snapshot/", snapshotRoute, auth.Middleware(opts.Authenticator, auth.ByMethod, http.HandlerFunc(h.Manager)))
+	handle("/api/v1/openapi.json", metrics.Route("/api/v1/openapi.json"), http.HandlerFunc(h.OpenAPI))
+	handle("/healthz", metrics.Route("/healthz"), http.HandlerFunc(h.Healthz))
+	handle("/readyz", metrics.Route("/readyz"), http.HandlerFunc(h.Readyz))
+	handle("/metrics", metrics.Route("/metrics"), auth.Middleware(opts.Authenticator, auth.Require(metricsRole), metrics.Handler()))
+	return mux
+}
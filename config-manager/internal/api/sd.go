@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/sd"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+// Defaults for the resolver a Handler starts with, the same as the sd
+// section of the configuration.
+const (
+	defaultSDCacheTTL = 30 * time.Second
+	defaultSDTimeout  = 5 * time.Second
+)
+
+// SetSDResolver sets the resolver GET /api/v1/sd fetches sd-type
+// configs' discovery endpoints through. Call it before serving requests.
+func (h *Handler) SetSDResolver(resolver *sd.Resolver) {
+	h.sdResolver = resolver
+}
+
+// ServiceDiscovery handles GET /api/v1/sd, which serves the targets of
+// every active snapshot as Prometheus HTTP SD target groups. Repeated
+// shard parameters limit it to the snapshots on those shards, so each
+// remote agent can poll for its own.
+func (h *Handler) ServiceDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	shards := h.storage.List()
+	if names := r.URL.Query()["shard"]; len(names) > 0 {
+		var selected []*storage.Shard
+		for _, name := range names {
+			i := slices.IndexFunc(shards, func(sh *storage.Shard) bool { return sh.Name == name })
+			if i < 0 {
+				writeValidationError(w, &ValidationError{Field: "shard", Message: fmt.Sprintf("unknown shard %q", name)})
+				return
+			}
+			selected = append(selected, shards[i])
+		}
+		shards = selected
+	}
+
+	var sources []sd.Source
+	for _, sh := range shards {
+		snapshots, err := sh.ListSnapshots()
+		if err != nil {
+			writeStorageError(w, fmt.Errorf("shard %s: %w", sh.Name, err), "Failed to list snapshots")
+			return
+		}
+		for _, s := range snapshots {
+			src := sd.Source{ID: s.Name, Targets: s.Targets}
+			req, err := sh.GetRequest(s.Name)
+			if err != nil && !errors.Is(err, storage.ErrRequestNotFound) {
+				logger.Warn("Failed to read snapshot request for service discovery", "id", s.Name, "error", err)
+				continue
+			}
+			src.Request = req
+			if src.Files, err = sh.TargetGroups(s.Name); err != nil {
+				logger.Warn("Failed to read file targets for service discovery", "id", s.Name, "error", err)
+				continue
+			}
+			sources = append(sources, src)
+		}
+	}
+
+	// Resolving sd-type configs may wait on their endpoints, so the
+	// snapshots are built concurrently and joined in order.
+	built := make([][]models.TargetGroup, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			groups, errs := h.sdResolver.Build(src)
+			for _, err := range errs {
+				logger.Warn("Failed to resolve service discovery endpoint", "id", src.ID, "error", err)
+			}
+			built[i] = groups
+		}()
+	}
+	wg.Wait()
+
+	out := []models.TargetGroup{}
+	for _, groups := range built {
+		out = append(out, groups...)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
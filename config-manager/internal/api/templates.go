@@ -0,0 +1,253 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/archive"
+	"github.com/couchbase/config-manager/internal/auth"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/presets"
+	"github.com/couchbase/config-manager/internal/templates"
+)
+
+// SetTemplates sets where snapshot templates are kept, which defaults to
+// templates.Disabled. Call it before serving requests.
+func (h *Handler) SetTemplates(store templates.Store) {
+	h.templates = store
+}
+
+// Templates handles GET and POST /api/v1/templates.
+func (h *Handler) Templates(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := h.templates.List()
+		if err != nil {
+			writeStorageError(w, err, "Failed to list templates")
+			return
+		}
+		for i := range list {
+			list[i] = *redactTemplate(&list[i])
+		}
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		var t templates.Template
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request body")
+			return
+		}
+		if err := h.validateTemplate(&t); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		h.templatesMu.Lock()
+		defer h.templatesMu.Unlock()
+		_, err := h.templates.Get(t.Name)
+		if err == nil {
+			writeError(w, http.StatusConflict, apierror.CodeConflict, fmt.Sprintf("template %s already exists", t.Name))
+			return
+		}
+		if !errors.Is(err, templates.ErrNotFound) {
+			writeStorageError(w, err, "Failed to get template")
+			return
+		}
+		now := time.Now().UTC()
+		caller := auth.CallerName(r)
+		t.Version = 1
+		t.CreatedAt, t.UpdatedAt = now, now
+		t.CreatedBy, t.UpdatedBy = caller, caller
+		if err := h.templates.Save(&t); err != nil {
+			writeStorageError(w, err, "Failed to save template")
+			return
+		}
+		writeJSON(w, http.StatusCreated, redactTemplate(&t))
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// Template handles GET, PUT and DELETE /api/v1/templates/{name}. PUT
+// replaces the template and bumps its version; a version in the body
+// must match the stored one, so concurrent editors don't overwrite each
+// other.
+func (h *Handler) Template(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/templates/"), "/")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Missing template name")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		t, err := h.templates.Get(name)
+		if err != nil {
+			writeStorageError(w, err, "Failed to get template")
+			return
+		}
+		writeJSON(w, http.StatusOK, redactTemplate(t))
+	case http.MethodPut:
+		var t templates.Template
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			writeError(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request body")
+			return
+		}
+		if t.Name != "" && t.Name != name {
+			writeValidationError(w, &ValidationError{Field: "name", Message: "name can't be changed; create a new template instead"})
+			return
+		}
+		t.Name = name
+		if err := h.validateTemplate(&t); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+
+		h.templatesMu.Lock()
+		defer h.templatesMu.Unlock()
+		current, err := h.templates.Get(name)
+		if err != nil {
+			writeStorageError(w, err, "Failed to get template")
+			return
+		}
+		if t.Version != 0 && t.Version != current.Version {
+			writeError(w, http.StatusConflict, apierror.CodeConflict, fmt.Sprintf("template %s is at version %d, not %d", name, current.Version, t.Version))
+			return
+		}
+		t.Version = current.Version + 1
+		t.CreatedAt, t.CreatedBy = current.CreatedAt, current.CreatedBy
+		t.UpdatedAt, t.UpdatedBy = time.Now().UTC(), auth.CallerName(r)
+		if err := h.templates.Save(&t); err != nil {
+			writeStorageError(w, err, "Failed to save template")
+			return
+		}
+		writeJSON(w, http.StatusOK, redactTemplate(&t))
+	case http.MethodDelete:
+		h.templatesMu.Lock()
+		defer h.templatesMu.Unlock()
+		if err := h.templates.Delete(name); err != nil {
+			writeStorageError(w, err, "Failed to delete template")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+// redactTemplate returns a copy of t without its password or TLS private
+// keys, which responses never carry.
+func redactTemplate(t *templates.Template) *templates.Template {
+	out := *t
+	if out.Request != nil {
+		out.Request = archive.RedactRequest(out.Request)
+	}
+	return &out
+}
+
+// validateTemplate checks t's name and runs its request through the
+// create validation. Credentials may be left out, wholly or in part, for
+// creates to supply through overrides.
+func (h *Handler) validateTemplate(t *templates.Template) error {
+	if !snapshotIDPattern.MatchString(t.Name) {
+		return &ValidationError{Field: "name", Message: "name must be 1-63 lowercase letters, digits, '_' or '-', starting with a letter or digit"}
+	}
+	req := t.Request
+	if req == nil {
+		return &ValidationError{Field: "request", Message: "request is required"}
+	}
+	if req.Template != "" || len(req.Overrides) > 0 {
+		return &ValidationError{Field: "request.template", Message: "a template can't reference another template"}
+	}
+	if req.ID != "" {
+		return &ValidationError{Field: "request.id", Message: "a template can't set id, which names a single snapshot; use id_prefix"}
+	}
+	if req.Credentials.Password == archive.Redacted || archive.HasRedactedKeys(req) {
+		return &ValidationError{Field: "request", Message: "secrets must be sent in full; responses redact them"}
+	}
+
+	// Validation fills in defaults, which the stored template shouldn't
+	// pick up, so it runs on a copy.
+	var check models.SnapshotRequest
+	data, err := json.Marshal(req)
+	if err == nil {
+		err = json.Unmarshal(data, &check)
+	}
+	if err != nil {
+		return err
+	}
+	if check.Credentials.Username == "" {
+		check.Credentials.Username = "template"
+	}
+	if check.Credentials.Password == "" {
+		check.Credentials.Password = "template"
+	}
+	if err := h.validateSnapshotRequest(&check); err != nil {
+		var ve *ValidationError
+		if errors.As(err, &ve) {
+			return &ValidationError{Field: "request." + ve.Field, Message: ve.Message}
+		}
+		return err
+	}
+	if _, err := h.presets.Load().BuildCustomPanels(&check); err != nil {
+		var sel *presets.SelectionError
+		if errors.As(err, &sel) {
+			return &ValidationError{Field: "request." + sel.Field, Message: sel.Message}
+		}
+		return err
+	}
+	return nil
+}
+
+// resolveTemplate replaces req, a create naming a template, with the
+// template's request, its overrides applied, and returns the template.
+// Errors are *ValidationError unless the store failed.
+func (h *Handler) resolveTemplate(req *models.SnapshotRequest) (*templates.Template, error) {
+	for _, field := range []struct {
+		name string
+		set  bool
+	}{
+		{"configs", len(req.Configs) > 0},
+		{"credentials", req.Credentials != (models.Credentials{})},
+		{"scheme", req.Scheme != ""},
+		{"tls", req.TLS != nil},
+		{"presets", len(req.Presets) > 0},
+		{"custom_panels", len(req.CustomPanels) > 0},
+		{"cbagent", req.Cbagent},
+		{"capella", req.Capella},
+	} {
+		if field.set {
+			return nil, &ValidationError{Field: field.name, Message: field.name + " can't be set with a template; set it in overrides"}
+		}
+	}
+	if err := validateTags(req.Tags, true); err != nil {
+		return nil, err
+	}
+
+	t, err := h.templates.Get(req.Template)
+	if errors.Is(err, templates.ErrNotFound) {
+		return nil, &ValidationError{Field: "template", Message: fmt.Sprintf("unknown template %q", req.Template)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := templates.Resolve(t, req.Overrides)
+	if err != nil {
+		return nil, &ValidationError{Field: "overrides", Message: err.Error()}
+	}
+	if req.ID != "" {
+		resolved.ID = req.ID
+		resolved.IDPrefix = ""
+	}
+	if req.IDPrefix != "" {
+		resolved.IDPrefix = req.IDPrefix
+	}
+	applyOverrides(resolved, req.Label, req.Tags)
+	resolved.Template, resolved.Overrides = "", nil
+	*req = *resolved
+	return t, nil
+}
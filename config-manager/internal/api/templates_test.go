@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/storage"
+	"github.com/couchbase/config-manager/internal/templates"
+)
+
+func TestSnapshotTemplates(t *testing.T) {
+	h := newTestHandler(t)
+	md := &savedMetadata{FileMetadataStorage: storage.NewFileMetadataStorage(t.TempDir()), docs: map[string]models.SnapshotMetadata{}}
+	h.metadataStorage = md
+	srv := serveHandler(t, h, nil)
+
+	expect := func(method, url, body string, want int, field string) []byte {
+		t.Helper()
+		resp, out := doRequest(t, srv, method, url, body, "")
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s %s: %d %s, want %d", method, url, body, resp.StatusCode, out, want)
+		}
+		if field != "" {
+			var env struct{ Error struct{ Field string } }
+			if err := json.Unmarshal(out, &env); err != nil || env.Error.Field != field {
+				t.Errorf("%s %s %s: error on field %q, want %q", method, url, body, env.Error.Field, field)
+			}
+		}
+		return out
+	}
+
+	// Templates are validated like creates, on their request's fields.
+	expect(http.MethodPost, "/api/v1/templates", `{"name":"bad","request":{"configs":[{"hostnames":["a"],"type":"bogus","port":1}]}}`, http.StatusBadRequest, "request.configs.type")
+	expect(http.MethodPost, "/api/v1/templates", `{"name":"bad","request":{"id":"fixed","configs":[{"hostnames":["a"],"port":1}]}}`, http.StatusBadRequest, "request.id")
+	expect(http.MethodPost, "/api/v1/templates", `{"name":"Bad Name","request":{"configs":[{"hostnames":["a"],"port":1}]}}`, http.StatusBadRequest, "name")
+
+	// Responses never carry secrets, and a redacted one can't be saved.
+	withPassword := strings.Replace(labTemplate, `{"username":"u"}`, `{"username":"u","password":"secret"}`, 1)
+	out := expect(http.MethodPost, "/api/v1/templates", withPassword, http.StatusCreated, "")
+	if strings.Contains(string(out), "secret") {
+		t.Errorf("template response has the password: %s", out)
+	}
+	redacted := strings.Replace(labTemplate, `{"username":"u"}`, `{"username":"u","password":"REDACTED"}`, 1)
+	expect(http.MethodPut, "/api/v1/templates/lab-a-kv", redacted, http.StatusBadRequest, "request")
+	out = expect(http.MethodPut, "/api/v1/templates/lab-a-kv", labTemplate, http.StatusOK, "")
+	var tmpl templates.Template
+	if err := json.Unmarshal(out, &tmpl); err != nil || tmpl.Version != 2 || tmpl.CreatedAt.IsZero() {
+		t.Fatalf("updated template = %s, want version 2", out)
+	}
+
+	// Only identity, label and tags go next to a template; the rest goes
+	// in overrides.
+	expect(http.MethodPost, "/api/v1/snapshot", `{"template":"lab-a-kv","scheme":"https"}`, http.StatusBadRequest, "scheme")
+	expect(http.MethodPost, "/api/v1/snapshot", `{"overrides":{"label":"x"}}`, http.StatusBadRequest, "overrides")
+	expect(http.MethodPost, "/api/v1/snapshot", `{"template":"lab-a-kv"}`, http.StatusBadRequest, "credentials.password")
+	expect(http.MethodPost, "/api/v1/snapshot", `{"template":"lab-a-kv","overrides":{"configs":"node1"}}`, http.StatusBadRequest, "overrides")
+
+	out = expect(http.MethodPost, "/api/v1/snapshot", `{"template":"lab-a-kv","id_prefix":"run","label":"run 42","tags":{"build":"7.6"},"overrides":{"credentials":{"password":"p"},"configs":[{"hostnames":["node4"],"port":9100,"type":"static"}]}}`, http.StatusCreated, "")
+	var created models.SnapshotResponse
+	if err := json.Unmarshal(out, &created); err != nil || !strings.HasPrefix(created.ID, "run-") {
+		t.Fatalf("create from template returned %s", out)
+	}
+	got := md.docs[created.ID]
+	if got.Template != "lab-a-kv" || got.TemplateVersion != 2 || got.Label != "run 42" || !reflect.DeepEqual(got.Tags, map[string]string{"lab": "a", "build": "7.6"}) {
+		t.Errorf("metadata = %+v, want template lab-a-kv v2, label run 42 and merged tags", got)
+	}
+	req, err := h.storage.GetRequest(created.ID)
+	if err != nil || req.Template != "" || req.Credentials.Password != "p" || req.Configs[0].Hostnames[0] != "node4" {
+		t.Errorf("stored request = %+v, %v; want the resolved request", req, err)
+	}
+
+	// Deleting the template leaves the snapshots made from it alone.
+	expect(http.MethodDelete, "/api/v1/templates/lab-a-kv", "", http.StatusNoContent, "")
+	expect(http.MethodPost, "/api/v1/snapshot", `{"template":"lab-a-kv"}`, http.StatusBadRequest, "template")
+	expect(http.MethodGet, "/api/v1/snapshot/"+created.ID, "", http.StatusOK, "")
+}
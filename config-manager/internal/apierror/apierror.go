@@ -0,0 +1,63 @@
+// Package apierror writes the JSON error envelope every config-manager
+// endpoint uses, so clients can branch on a stable code instead of
+// parsing messages:
+//
+//	{"error": {"code": "validation_failed", "field": "configs.port", "message": "port is required"}}
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes. They are part of the API contract; add new ones rather
+// than changing the meaning of existing ones.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeValidationFailed = "validation_failed"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeConflict         = "conflict"
+	CodeInternal         = "internal"
+	CodeUnavailable      = "unavailable"
+	// CodeQuotaExceeded (429) and CodeLimitExceeded (422) come with a
+	// Limit naming the admission limit that refused the request.
+	CodeQuotaExceeded = "quota_exceeded"
+	CodeLimitExceeded = "limit_exceeded"
+)
+
+// Body is the content of the "error" member of the envelope.
+type Body struct {
+	Code    string `json:"code"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+	Limit   *Limit `json:"limit,omitempty"`
+}
+
+// Limit describes the admission limit behind a quota_exceeded or
+// limit_exceeded error.
+type Limit struct {
+	Name    string `json:"name"`
+	Max     int    `json:"max"`
+	Current int    `json:"current"`
+}
+
+// Response is the error envelope.
+type Response struct {
+	Error Body `json:"error"`
+}
+
+// Write sends an error envelope with the given status.
+func Write(w http.ResponseWriter, status int, code, field, message string) {
+	WriteBody(w, status, Body{Code: code, Field: field, Message: message})
+}
+
+// WriteBody sends an error envelope holding body with the given status.
+func WriteBody(w http.ResponseWriter, status int, body Body) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(Response{Error: body})
+}
@@ -0,0 +1,208 @@
+// Package archive keeps what produced a snapshot's data after the
+// snapshot ends: its final scrape config and target lists, the request it
+// was created from and its final metadata. Secrets are redacted before
+// anything is stored, so a restore has to supply them again.
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces secrets in archived configs and requests.
+const Redacted = "REDACTED"
+
+// Reasons recorded in Archive.Reason.
+const (
+	ReasonDeleted = "deleted"
+	ReasonExpired = "expired"
+)
+
+// ErrNotFound means there is no archive for the snapshot id.
+var ErrNotFound = errors.New("archive not found")
+
+// Archive is the record kept for an ended snapshot.
+type Archive struct {
+	SnapshotID string    `json:"snapshot_id"`
+	ArchivedAt time.Time `json:"archived_at"`
+	Reason     string    `json:"reason"`
+	EndedBy    string    `json:"ended_by,omitempty"`
+	// ScrapeConfig is the final vmagent YAML with passwords and private
+	// keys redacted.
+	ScrapeConfig string `json:"scrape_config"`
+	// FileTargets are the final file_sd target lists keyed by scheme.
+	FileTargets map[string][]models.TargetGroup `json:"file_targets,omitempty"`
+	// Request is the create request with credentials and private keys
+	// redacted. It is nil for snapshots created before requests were
+	// kept, which can't be restored.
+	Request  *models.SnapshotRequest  `json:"request,omitempty"`
+	Metadata *models.SnapshotMetadata `json:"metadata,omitempty"`
+}
+
+// Store persists archives.
+type Store interface {
+	Save(a *Archive) error
+	// Get returns ErrNotFound (wrapped) when id has no archive.
+	Get(id string) (*Archive, error)
+	Close() error
+	Type() string
+}
+
+// Files reads an active snapshot's files. Both a single
+// storage.FileStorage and storage.Shards provide it.
+type Files interface {
+	ReadSnapshot(id string) ([]byte, error)
+	GetRequest(id string) (*models.SnapshotRequest, error)
+	TargetGroups(id string) (map[string][]models.TargetGroup, error)
+}
+
+// Capture builds the archive of an active snapshot from its scrape file,
+// kept request and metadata. Call it before the snapshot's end is recorded
+// in the metadata, so a failure leaves the snapshot untouched; metadata
+// that still reads as running is archived with the capture time as its
+// end.
+func Capture(files Files, metadata storage.MetadataStorage, id, reason, endedBy string) (*Archive, error) {
+	content, err := files.ReadSnapshot(id)
+	if err != nil {
+		return nil, err
+	}
+	scrapeConfig, err := redactScrapeConfig(content)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := files.TargetGroups(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target files: %w", err)
+	}
+	a := &Archive{
+		SnapshotID:   id,
+		ArchivedAt:   time.Now().UTC(),
+		Reason:       reason,
+		EndedBy:      endedBy,
+		ScrapeConfig: scrapeConfig,
+	}
+	if len(targets) > 0 {
+		a.FileTargets = targets
+	}
+
+	req, err := files.GetRequest(id)
+	if err != nil && !errors.Is(err, storage.ErrRequestNotFound) {
+		return nil, err
+	}
+	if req != nil {
+		a.Request = RedactRequest(req)
+	}
+
+	md, err := metadata.GetMetadata(id)
+	if err != nil && !errors.Is(err, storage.ErrMetadataNotFound) {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+	if md != nil && (md.TsEnd == "" || md.TsEnd == "now") {
+		ended := *md
+		ended.TsEnd = a.ArchivedAt.Format(time.RFC3339Nano)
+		md = &ended
+	}
+	a.Metadata = md
+	return a, nil
+}
+
+// RedactRequest returns a copy of req without its password or TLS
+// private keys.
+func RedactRequest(req *models.SnapshotRequest) *models.SnapshotRequest {
+	out := *req
+	if out.Credentials.Password != "" {
+		out.Credentials.Password = Redacted
+	}
+	out.TLS = redactTLS(req.TLS)
+	out.Configs = make([]models.ConfigObject, len(req.Configs))
+	for i, c := range req.Configs {
+		c.TLS = redactTLS(c.TLS)
+		out.Configs[i] = c
+	}
+	return &out
+}
+
+func redactTLS(t *models.TLSConfig) *models.TLSConfig {
+	if t == nil {
+		return nil
+	}
+	out := *t
+	if out.Key != "" {
+		out.Key = Redacted
+	}
+	return &out
+}
+
+// HasRedactedKeys reports whether any TLS settings in req had their
+// private key redacted.
+func HasRedactedKeys(req *models.SnapshotRequest) bool {
+	if req.TLS != nil && req.TLS.Key == Redacted {
+		return true
+	}
+	for _, c := range req.Configs {
+		if c.TLS != nil && c.TLS.Key == Redacted {
+			return true
+		}
+	}
+	return false
+}
+
+// redactScrapeConfig replaces basic_auth passwords and inline TLS keys
+// in a vmagent scrape config.
+func redactScrapeConfig(content []byte) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return "", fmt.Errorf("failed to parse scrape config: %w", err)
+	}
+	redactNode(&doc)
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode scrape config: %w", err)
+	}
+	return string(out), nil
+}
+
+func redactNode(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if (key.Value == "password" || key.Value == "key") && value.Kind == yaml.ScalarNode {
+				value.Value = Redacted
+				value.Style = 0
+				continue
+			}
+			redactNode(value)
+		}
+		return
+	}
+	for _, child := range n.Content {
+		redactNode(child)
+	}
+}
+
+// Snapshot captures the archive of an active snapshot and saves it in
+// store.
+func Snapshot(store Store, files Files, metadata storage.MetadataStorage, id, reason, endedBy string) error {
+	a, err := Capture(files, metadata, id, reason, endedBy)
+	if err != nil {
+		return err
+	}
+	return store.Save(a)
+}
+
+// Discard is a Store that keeps nothing, for callers that don't archive.
+var Discard Store = discard{}
+
+type discard struct{}
+
+func (discard) Save(*Archive) error { return nil }
+func (discard) Get(id string) (*Archive, error) {
+	return nil, fmt.Errorf("%w for snapshot %s", ErrNotFound, id)
+}
+func (discard) Close() error { return nil }
+func (discard) Type() string { return "discard" }
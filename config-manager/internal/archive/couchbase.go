@@ -0,0 +1,66 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/gocb/v2"
+)
+
+// CouchbaseStore keeps archives as documents keyed by snapshot id in a
+// collection of the metadata bucket.
+type CouchbaseStore struct {
+	cluster    *gocb.Cluster
+	collection *gocb.Collection
+	timeout    time.Duration
+}
+
+// NewCouchbaseStore uses the named collection in the bucket's scope,
+// creating it when missing. The store owns cluster and closes it on
+// Close.
+func NewCouchbaseStore(cluster *gocb.Cluster, bucket *gocb.Bucket, scope, collection string, timeout time.Duration) (*CouchbaseStore, error) {
+	err := bucket.CollectionsV2().CreateCollection(scope, collection, nil, &gocb.CreateCollectionOptions{Timeout: timeout})
+	if err != nil && !errors.Is(err, gocb.ErrCollectionExists) {
+		return nil, fmt.Errorf("failed to create archive collection %q: %w", collection, err)
+	}
+	return &CouchbaseStore{
+		cluster:    cluster,
+		collection: bucket.Scope(scope).Collection(collection),
+		timeout:    timeout,
+	}, nil
+}
+
+// Save upserts the archive.
+func (cs *CouchbaseStore) Save(a *Archive) error {
+	if _, err := cs.collection.Upsert(a.SnapshotID, a, &gocb.UpsertOptions{Timeout: cs.timeout}); err != nil {
+		return fmt.Errorf("failed to save archive to Couchbase: %w", err)
+	}
+	return nil
+}
+
+// Get fetches the archive of id.
+func (cs *CouchbaseStore) Get(id string) (*Archive, error) {
+	result, err := cs.collection.Get(id, &gocb.GetOptions{Timeout: cs.timeout})
+	if err != nil {
+		if errors.Is(err, gocb.ErrDocumentNotFound) {
+			return nil, fmt.Errorf("%w for snapshot %s", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get archive from Couchbase: %w", err)
+	}
+	var a Archive
+	if err := result.Content(&a); err != nil {
+		return nil, fmt.Errorf("failed to decode archive: %w", err)
+	}
+	return &a, nil
+}
+
+// Close closes the Couchbase connection
+func (cs *CouchbaseStore) Close() error {
+	return cs.cluster.Close(nil)
+}
+
+// Type returns the type of the archive store
+func (cs *CouchbaseStore) Type() string {
+	return "couchbase"
+}
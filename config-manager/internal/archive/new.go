@@ -0,0 +1,25 @@
+package archive
+
+import (
+	"github.com/couchbase/config-manager/internal/config"
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+// New opens the archive store the configuration calls for: a collection
+// in the metadata bucket when metadata is enabled, otherwise the archive
+// directory, which it also falls back to when the cluster can't be used.
+func New(cfg *config.Config) (Store, error) {
+	if cfg.Metadata.Enabled {
+		cluster, bucket, err := storage.ConnectCouchbase(cfg)
+		if err == nil {
+			var s *CouchbaseStore
+			if s, err = NewCouchbaseStore(cluster, bucket, cfg.Metadata.Scope, cfg.Archive.Collection, cfg.Metadata.Timeout); err == nil {
+				return s, nil
+			}
+			cluster.Close(nil)
+		}
+		logger.Warn("Failed to open Couchbase archive store; falling back to directory", "directory", cfg.Archive.Directory, "error", err)
+	}
+	return NewFileStore(cfg.Archive.Directory)
+}
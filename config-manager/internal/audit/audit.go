@@ -0,0 +1,86 @@
+// Package audit records every snapshot mutation — who made it, what
+// they asked for and how it turned out — in an append-only log that can
+// be queried by snapshot.
+package audit
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Actions recorded in Entry.Action.
+const (
+	ActionCreate            = "create"
+	ActionPatch             = "patch"
+	ActionPatchTargets      = "patch_targets"
+	ActionPatchCustomPanels = "patch_custom_panels"
+	ActionDelete            = "delete"
+	ActionExpire            = "expire"
+	ActionRestore           = "restore"
+	ActionClone             = "clone"
+)
+
+// Outcomes recorded in Entry.Outcome.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Query limits.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Entry is one audit record. Request is the request body with secrets
+// redacted (see Redact); Status and Error describe the response.
+type Entry struct {
+	Timestamp  time.Time       `json:"ts"`
+	Action     string          `json:"action"`
+	SnapshotID string          `json:"snapshot_id"`
+	Caller     string          `json:"caller,omitempty"`
+	RemoteAddr string          `json:"remote_addr,omitempty"`
+	Request    json.RawMessage `json:"request,omitempty"`
+	Outcome    string          `json:"outcome"`
+	Status     int             `json:"status,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// Query selects audit entries. An empty SnapshotID matches every entry.
+// Limit keeps the most recent entries; zero means DefaultLimit.
+type Query struct {
+	SnapshotID string
+	Limit      int
+}
+
+// limit returns q.Limit clamped to (0, MaxLimit].
+func (q Query) limit() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultLimit
+	case q.Limit > MaxLimit:
+		return MaxLimit
+	default:
+		return q.Limit
+	}
+}
+
+// Log is an append-only audit log.
+type Log interface {
+	// Record appends an entry, setting its timestamp if unset.
+	Record(entry Entry) error
+	// Query returns matching entries, oldest first.
+	Query(q Query) ([]Entry, error)
+	Close() error
+	Type() string
+}
+
+// Discard is a Log that records nothing, for callers that don't audit.
+var Discard Log = discard{}
+
+type discard struct{}
+
+func (discard) Record(Entry) error           { return nil }
+func (discard) Query(Query) ([]Entry, error) { return []Entry{}, nil }
+func (discard) Close() error                 { return nil }
+func (discard) Type() string                 { return "discard" }
@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/gocb/v2"
+	"github.com/google/uuid"
+)
+
+// CouchbaseLog stores each entry as its own document in a collection of
+// the metadata bucket, so the audit trail lives next to the metadata it
+// describes.
+type CouchbaseLog struct {
+	cluster    *gocb.Cluster
+	collection *gocb.Collection
+	keyspace   string
+	timeout    time.Duration
+}
+
+// NewCouchbaseLog uses the named collection in the bucket's scope,
+// creating it and its query index when they don't exist. The log owns
+// cluster and closes it on Close.
+func NewCouchbaseLog(cluster *gocb.Cluster, bucket *gocb.Bucket, scope, collection string, timeout time.Duration) (*CouchbaseLog, error) {
+	err := bucket.CollectionsV2().CreateCollection(scope, collection, nil, &gocb.CreateCollectionOptions{Timeout: timeout})
+	if err != nil && !errors.Is(err, gocb.ErrCollectionExists) {
+		return nil, fmt.Errorf("failed to create audit collection %q: %w", collection, err)
+	}
+
+	l := &CouchbaseLog{
+		cluster:    cluster,
+		collection: bucket.Scope(scope).Collection(collection),
+		keyspace:   fmt.Sprintf("`%s`.`%s`.`%s`", bucket.Name(), scope, collection),
+		timeout:    timeout,
+	}
+
+	// Queries filter on snapshot_id and sort on ts. Without the index
+	// they fail, but recording still works, so this is only a warning.
+	_, err = cluster.Query(
+		"CREATE INDEX `idx_audit_snapshot_ts` IF NOT EXISTS ON "+l.keyspace+"(snapshot_id, ts)",
+		&gocb.QueryOptions{Timeout: timeout},
+	)
+	if err != nil {
+		logger.Warn("Failed to create audit log index; audit queries will fail until it exists", "keyspace", l.keyspace, "error", err)
+	}
+	return l, nil
+}
+
+// Record inserts entry under a time-ordered key.
+func (l *CouchbaseLog) Record(entry Entry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now().UTC()
+	}
+	key := fmt.Sprintf("audit::%s::%s", entry.Timestamp.Format(time.RFC3339Nano), uuid.NewString())
+	if _, err := l.collection.Insert(key, entry, &gocb.InsertOptions{Timeout: l.timeout}); err != nil {
+		return fmt.Errorf("failed to save audit entry to Couchbase: %w", err)
+	}
+	return nil
+}
+
+// Query fetches the newest q.Limit matches and returns them oldest first.
+func (l *CouchbaseLog) Query(q Query) ([]Entry, error) {
+	statement := "SELECT a.* FROM " + l.keyspace + " AS a WHERE a.snapshot_id IS NOT MISSING"
+	params := map[string]interface{}{"limit": q.limit()}
+	if q.SnapshotID != "" {
+		statement += " AND a.snapshot_id = $snapshot"
+		params["snapshot"] = q.SnapshotID
+	}
+	statement += " ORDER BY a.ts DESC LIMIT $limit"
+
+	rows, err := l.cluster.Query(statement, &gocb.QueryOptions{
+		NamedParameters: params,
+		Timeout:         l.timeout,
+		Readonly:        true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Entry
+	for rows.Next() {
+		var entry Entry
+		if err := rows.Row(&entry); err != nil {
+			return nil, fmt.Errorf("failed to decode audit entry: %w", err)
+		}
+		out = append(out, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+
+	// Newest first from the query; callers get chronological order.
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	if out == nil {
+		out = []Entry{}
+	}
+	return out, nil
+}
+
+// Close closes the Couchbase connection
+func (l *CouchbaseLog) Close() error {
+	return l.cluster.Close(nil)
+}
+
+// Type returns the type of the audit log
+func (l *CouchbaseLog) Type() string {
+	return "couchbase"
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileLogQuery(t *testing.T) {
+	l, err := NewFileLog(filepath.Join(t.TempDir(), "audit.jsonl"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		id := "a"
+		if i%2 == 1 {
+			id = "b"
+		}
+		if err := l.Record(Entry{Action: ActionPatch, SnapshotID: id, Status: i, Outcome: OutcomeSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := l.Query(Query{})
+	if err != nil || len(all) != 5 {
+		t.Fatalf("Query() = %d entries, %v; want 5", len(all), err)
+	}
+	if all[0].Timestamp.IsZero() {
+		t.Errorf("Record did not set the timestamp")
+	}
+
+	got, err := l.Query(Query{SnapshotID: "a", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Status != 2 || got[1].Status != 4 {
+		t.Errorf("Query(a, 2) = %+v, want the last two entries for a", got)
+	}
+}
+
+func TestFileLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := NewFileLog(path, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// 1 KiB files so a handful of entries rotate a few times.
+	l.maxSize = 1024
+
+	padding := strings.Repeat("x", 300)
+	for i := 0; i < 12; i++ {
+		if err := l.Record(Entry{Action: ActionCreate, SnapshotID: fmt.Sprint(i), Error: padding, Outcome: OutcomeFailure}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("expected %s: %v", name, err)
+		}
+		if info.Size() > 1024 {
+			t.Errorf("%s is %d bytes, over the rotation size", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept more than max_backups files: %v", err)
+	}
+
+	// The query spans the backups in order and has lost only the oldest.
+	got, err := l.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 || len(got) >= 12 || got[len(got)-1].SnapshotID != "11" {
+		t.Fatalf("unexpected entries after rotation: %d, last %+v", len(got), got[len(got)-1])
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].Timestamp.Before(got[i-1].Timestamp) {
+			t.Fatalf("entries out of order at %d", i)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	in := `{"credentials":{"username":"u","password":"secret"},"tls":{"cert":"c","key":"k"},"configs":[{"tls":{"key":"k2"}}],"label":"x"}`
+	out := string(Redact([]byte(in)))
+	for _, secret := range []string{"secret", `"k"`, `"k2"`} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Redact left %s in %s", secret, out)
+		}
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &v); err != nil || v["label"] != "x" {
+		t.Errorf("Redact changed non-secret fields: %s", out)
+	}
+	if Redact([]byte("not json")) != nil {
+		t.Errorf("Redact kept a non-JSON body")
+	}
+}
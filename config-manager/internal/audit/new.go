@@ -0,0 +1,26 @@
+package audit
+
+import (
+	"github.com/couchbase/config-manager/internal/config"
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+// New opens the audit log the configuration calls for: a collection in
+// the metadata bucket when metadata is enabled, otherwise the rotating
+// JSONL file. Like metadata storage, it falls back to the file when the
+// cluster can't be used.
+func New(cfg *config.Config) (Log, error) {
+	if cfg.Metadata.Enabled {
+		cluster, bucket, err := storage.ConnectCouchbase(cfg)
+		if err == nil {
+			var l *CouchbaseLog
+			if l, err = NewCouchbaseLog(cluster, bucket, cfg.Metadata.Scope, cfg.Audit.Collection, cfg.Metadata.Timeout); err == nil {
+				return l, nil
+			}
+			cluster.Close(nil)
+		}
+		logger.Warn("Failed to open Couchbase audit log; falling back to file", "file", cfg.Audit.File, "error", err)
+	}
+	return NewFileLog(cfg.Audit.File, cfg.Audit.MaxSizeMB, cfg.Audit.MaxBackups)
+}
@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// redacted replaces secret values in recorded requests.
+const redacted = "REDACTED"
+
+// secretKeys are the JSON object keys whose values never reach the log:
+// cluster passwords and TLS private keys in snapshot requests.
+var secretKeys = map[string]bool{
+	"password": true,
+	"key":      true,
+	"token":    true,
+}
+
+// Redact returns body with the value of every secret key replaced, at any
+// depth. A body that isn't JSON is dropped rather than logged verbatim.
+func Redact(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil
+	}
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if secretKeys[strings.ToLower(k)] {
+				if s, ok := child.(string); !ok || s != "" {
+					t[k] = redacted
+				}
+				continue
+			}
+			t[k] = redactValue(child)
+		}
+	case []interface{}:
+		for i, child := range t {
+			t[i] = redactValue(child)
+		}
+	}
+	return v
+}
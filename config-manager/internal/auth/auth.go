@@ -0,0 +1,230 @@
+// Package auth authenticates config-manager API callers and checks
+// their role against the route they are calling. Credentials come from
+// the service config: static bearer tokens for automation (perfrunner,
+// CI jobs) and basic-auth users for people.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/config"
+)
+
+// Role is a caller's permission level. Roles are ordered: every role
+// may do everything the roles below it can.
+type Role int
+
+const (
+	// RoleNone is only used for routes that don't require a caller.
+	RoleNone Role = iota
+	// RoleReader may read snapshots.
+	RoleReader
+	// RoleWriter may also create and patch snapshots.
+	RoleWriter
+	// RoleAdmin may also delete snapshots and use admin endpoints.
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleReader:
+		return "reader"
+	case RoleWriter:
+		return "writer"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// ParseRole converts a configured role name into a Role.
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "reader":
+		return RoleReader, nil
+	case "writer":
+		return RoleWriter, nil
+	case "admin":
+		return RoleAdmin, nil
+	default:
+		return RoleNone, fmt.Errorf("unknown role %q (expected reader, writer or admin)", s)
+	}
+}
+
+// Identity is an authenticated caller.
+type Identity struct {
+	Name string
+	Role Role
+}
+
+// Authenticator resolves the caller of a request. ok is false when the
+// request carries no credentials or they don't match.
+type Authenticator interface {
+	Authenticate(r *http.Request) (id *Identity, ok bool)
+}
+
+// unknownUserPassword stands in for the password of a basic-auth user
+// that isn't configured. Matching it never authenticates anyone.
+const unknownUserPassword = "config-manager-unknown-user"
+
+type staticUser struct {
+	password string
+	identity Identity
+}
+
+// Static authenticates against the tokens and users listed in config.
+type Static struct {
+	tokens map[string]Identity
+	users  map[string]staticUser
+}
+
+// NewStatic builds a Static authenticator from the auth config section.
+func NewStatic(cfg config.AuthConfig) (*Static, error) {
+	s := &Static{
+		tokens: make(map[string]Identity, len(cfg.Tokens)),
+		users:  make(map[string]staticUser, len(cfg.Users)),
+	}
+	for _, t := range cfg.Tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("token %q has an empty value", t.Name)
+		}
+		role, err := ParseRole(t.Role)
+		if err != nil {
+			return nil, fmt.Errorf("token %q: %w", t.Name, err)
+		}
+		name := t.Name
+		if name == "" {
+			name = "token"
+		}
+		s.tokens[t.Token] = Identity{Name: name, Role: role}
+	}
+	for _, u := range cfg.Users {
+		if u.Username == "" || u.Password == "" {
+			return nil, fmt.Errorf("user entries need both username and password")
+		}
+		role, err := ParseRole(u.Role)
+		if err != nil {
+			return nil, fmt.Errorf("user %q: %w", u.Username, err)
+		}
+		s.users[u.Username] = staticUser{password: u.Password, identity: Identity{Name: u.Username, Role: role}}
+	}
+	return s, nil
+}
+
+// Authenticate implements Authenticator.
+func (s *Static) Authenticate(r *http.Request) (*Identity, bool) {
+	if token, ok := bearerToken(r); ok {
+		// Compare against every token so lookup time doesn't leak which
+		// prefix matched.
+		var found *Identity
+		for candidate, id := range s.tokens {
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+				id := id
+				found = &id
+			}
+		}
+		return found, found != nil
+	}
+	if username, password, ok := r.BasicAuth(); ok {
+		// Unknown users are still compared, against a dummy password, so
+		// response time doesn't leak which usernames exist.
+		u, exists := s.users[username]
+		if !exists {
+			u.password = unknownUserPassword
+		}
+		if subtle.ConstantTimeCompare([]byte(u.password), []byte(password)) != 1 || !exists {
+			return nil, false
+		}
+		id := u.identity
+		return &id, true
+	}
+	return nil, false
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(h[7:])
+	return token, token != ""
+}
+
+// RoleForMethod is the default policy for snapshot routes: reads need
+// reader, writes need writer and deletes need admin.
+func RoleForMethod(method string) Role {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return RoleReader
+	case http.MethodDelete:
+		return RoleAdmin
+	default:
+		return RoleWriter
+	}
+}
+
+type contextKey struct{}
+
+// FromContext returns the caller stored by Middleware, or nil when auth
+// is disabled or the route is public.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(contextKey{}).(*Identity)
+	return id
+}
+
+// WithIdentity returns a copy of ctx carrying id.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// CallerName is the name recorded on documents for the request's caller,
+// or "" when the request is anonymous.
+func CallerName(r *http.Request) string {
+	if id := FromContext(r.Context()); id != nil {
+		return id.Name
+	}
+	return ""
+}
+
+// Middleware rejects requests whose caller can't be authenticated (401)
+// or whose role is below required(r) (403). A nil authenticator disables
+// auth entirely and every request passes through anonymously.
+func Middleware(authn Authenticator, required func(*http.Request) Role, next http.Handler) http.Handler {
+	if authn == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need := required(r)
+		if need == RoleNone {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, ok := authn.Authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="config-manager", Basic realm="config-manager"`)
+			apierror.Write(w, http.StatusUnauthorized, apierror.CodeUnauthorized, "", "valid bearer token or basic-auth credentials required")
+			return
+		}
+		if id.Role < need {
+			apierror.Write(w, http.StatusForbidden, apierror.CodeForbidden, "", fmt.Sprintf("%s role required", need))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// ByMethod is the RoleForMethod policy in Middleware form.
+func ByMethod(r *http.Request) Role {
+	return RoleForMethod(r.Method)
+}
+
+// Require is a convenience policy for routes with a fixed role.
+func Require(role Role) func(*http.Request) Role {
+	return func(*http.Request) Role { return role }
+}
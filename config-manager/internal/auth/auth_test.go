@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/config"
+)
+
+func newTestAuthenticator(t *testing.T) *Static {
+	t.Helper()
+	a, err := NewStatic(config.AuthConfig{
+		Enabled: true,
+		Tokens: []config.AuthToken{
+			{Name: "perfrunner", Token: "writer-token", Role: "writer"},
+			{Name: "dashboard", Token: "reader-token", Role: "reader"},
+		},
+		Users: []config.AuthUser{
+			{Username: "ops", Password: "secret", Role: "admin"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewStatic: %v", err)
+	}
+	return a
+}
+
+func TestMiddlewareRoles(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	var gotCaller string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotCaller = CallerName(r)
+		w.WriteHeader(http.StatusOK)
+	})
+	h := Middleware(a, ByMethod, next)
+
+	cases := []struct {
+		name       string
+		method     string
+		setAuth    func(*http.Request)
+		wantStatus int
+		wantCaller string
+	}{
+		{"no credentials", http.MethodGet, func(*http.Request) {}, http.StatusUnauthorized, ""},
+		{"unknown token", http.MethodGet, func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized, ""},
+		{"reader may read", http.MethodGet, func(r *http.Request) { r.Header.Set("Authorization", "Bearer reader-token") }, http.StatusOK, "dashboard"},
+		{"reader may not patch", http.MethodPatch, func(r *http.Request) { r.Header.Set("Authorization", "Bearer reader-token") }, http.StatusForbidden, ""},
+		{"writer may patch", http.MethodPatch, func(r *http.Request) { r.Header.Set("Authorization", "bearer writer-token") }, http.StatusOK, "perfrunner"},
+		{"writer may not delete", http.MethodDelete, func(r *http.Request) { r.Header.Set("Authorization", "Bearer writer-token") }, http.StatusForbidden, ""},
+		{"admin may delete", http.MethodDelete, func(r *http.Request) { r.SetBasicAuth("ops", "secret") }, http.StatusOK, "ops"},
+		{"wrong password", http.MethodGet, func(r *http.Request) { r.SetBasicAuth("ops", "guess") }, http.StatusUnauthorized, ""},
+		{"unknown user", http.MethodGet, func(r *http.Request) { r.SetBasicAuth("nobody", "secret") }, http.StatusUnauthorized, ""},
+		{"unknown user with the stand-in password", http.MethodGet, func(r *http.Request) { r.SetBasicAuth("nobody", unknownUserPassword) }, http.StatusUnauthorized, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotCaller = ""
+			req := httptest.NewRequest(tc.method, "/api/v1/snapshot/abc", nil)
+			tc.setAuth(req)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tc.wantStatus)
+			}
+			if gotCaller != tc.wantCaller {
+				t.Errorf("caller = %q, want %q", gotCaller, tc.wantCaller)
+			}
+		})
+	}
+}
+
+func TestMiddlewarePublicRoute(t *testing.T) {
+	a := newTestAuthenticator(t)
+	h := Middleware(a, Require(RoleNone), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("public route status = %d, want 200", rec.Code)
+	}
+}
+
+func TestNewStaticRejectsUnknownRole(t *testing.T) {
+	_, err := NewStatic(config.AuthConfig{Tokens: []config.AuthToken{{Name: "x", Token: "t", Role: "root"}}})
+	if err == nil {
+		t.Fatal("expected an error for an unknown role")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/couchbase/config-manager/internal/logger"
 	"gopkg.in/yaml.v3"
 )
 
@@ -19,26 +20,215 @@ type Config struct {
 	Agent struct {
 		Type      string `yaml:"type"`
 		Directory string `yaml:"directory"`
+		// FileSDDirectory is where the agent sees Directory when the two
+		// processes mount it at different paths. file_sd_configs entries
+		// point into it. Defaults to Directory.
+		FileSDDirectory string `yaml:"file_sd_directory"`
+		// ReloadURL is called with POST after a scrape file is added or
+		// removed, e.g. vmagent's http://host:8429/-/reload. Empty leaves
+		// the agent to notice changes on its own.
+		ReloadURL string `yaml:"reload_url"`
+		// Placement picks the shard for a new snapshot: least_loaded
+		// (fewest active snapshots) or first_fit (fill shards in order).
+		Placement string `yaml:"placement"`
+		// Shards lists the agents snapshots are spread across. When
+		// empty, Directory, FileSDDirectory and ReloadURL make up a
+		// single shard named "default".
+		Shards []AgentShard `yaml:"shards"`
 	} `yaml:"agent"`
 	Logging struct {
 		Level string `yaml:"level"`
 	} `yaml:"logging"`
 	Manager struct {
-		Interval     time.Duration  `yaml:"interval"`
-		MinInterval  time.Duration  `yaml:"min_interval"`
-		StaleThreshold time.Duration   `yaml:"stale_threshold"`
+		Interval       time.Duration `yaml:"interval"`
+		MinInterval    time.Duration `yaml:"min_interval"`
+		StaleThreshold time.Duration `yaml:"stale_threshold"`
 	} `yaml:"manager"`
 	Metadata struct {
-		Enabled     bool   `yaml:"enabled"`
-		Host        string `yaml:"host"`
-		Username    string `yaml:"username"`
-		Password    string `yaml:"password"`
-		Bucket      string `yaml:"bucket"`
-		Timeout     time.Duration `yaml:"timeout"`
+		Enabled bool `yaml:"enabled"`
+		// Host is a host name or a full connection string. A host
+		// without a scheme connects with couchbase://, or couchbases://
+		// when TLS is set.
+		Host     string `yaml:"host"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		Bucket   string `yaml:"bucket"`
+		// Scope and Collection hold the metadata documents. The audit,
+		// archive and templates collections are created in the same scope.
+		Scope      string `yaml:"scope"`
+		Collection string `yaml:"collection"`
+		// TLS connects with couchbases://. CAFile verifies the cluster
+		// instead of the system roots; CertFile and KeyFile authenticate
+		// with a client certificate instead of the username and password.
+		TLS                bool          `yaml:"tls"`
+		CAFile             string        `yaml:"ca_file"`
+		CertFile           string        `yaml:"cert_file"`
+		KeyFile            string        `yaml:"key_file"`
+		InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+		Timeout            time.Duration `yaml:"timeout"`
+		// JournalFile records metadata mutations the store couldn't take,
+		// so they can be replayed every ReplayInterval once it recovers.
+		// Empty disables the journal and those mutations are lost.
+		JournalFile    string        `yaml:"journal_file"`
+		ReplayInterval time.Duration `yaml:"replay_interval"`
 	} `yaml:"metadata"`
+	Presets struct {
+		// Directory holds one YAML or JSON custom-panels preset per
+		// file, loaded on top of the built-in presets. Empty loads
+		// only the built-ins.
+		Directory string `yaml:"directory"`
+	} `yaml:"presets"`
+	Audit struct {
+		// File is the JSONL audit log used when metadata is disabled
+		// (or its cluster is unreachable at startup).
+		File       string `yaml:"file"`
+		MaxSizeMB  int    `yaml:"max_size_mb"`
+		MaxBackups int    `yaml:"max_backups"`
+		// Collection is where audit entries go in the metadata scope
+		// when metadata is enabled.
+		Collection string `yaml:"collection"`
+	} `yaml:"audit"`
+	Archive struct {
+		// Directory holds one JSON archive per ended snapshot when
+		// metadata is disabled (or its cluster is unreachable at startup).
+		Directory string `yaml:"directory"`
+		// Collection is where archives go in the metadata scope when
+		// metadata is enabled.
+		Collection string `yaml:"collection"`
+	} `yaml:"archive"`
+	Templates struct {
+		// Directory holds one JSON file per snapshot template when
+		// metadata is disabled (or its cluster is unreachable at startup).
+		Directory string `yaml:"directory"`
+		// Collection is where templates go in the metadata scope when
+		// metadata is enabled.
+		Collection string `yaml:"collection"`
+	} `yaml:"templates"`
+	Limits struct {
+		// MaxActiveSnapshots caps the active snapshots across every
+		// shard. Every limit here is unlimited at 0.
+		MaxActiveSnapshots int `yaml:"max_active_snapshots"`
+		// MaxActivePerCaller caps each authenticated caller's active
+		// snapshots.
+		MaxActivePerCaller int `yaml:"max_active_per_caller"`
+		// MaxActivePerTag caps the active snapshots sharing a value of
+		// each listed tag, e.g. {owner: 10}.
+		MaxActivePerTag map[string]int `yaml:"max_active_per_tag"`
+		// MaxTargetsPerSnapshot caps the hostnames across a snapshot's
+		// configs, and the file targets PATCH .../targets may leave.
+		MaxTargetsPerSnapshot int `yaml:"max_targets_per_snapshot"`
+		// MaxHostnamesPerConfig caps the hostnames of a single config.
+		MaxHostnamesPerConfig int `yaml:"max_hostnames_per_config"`
+	} `yaml:"limits"`
+	Overlap struct {
+		// Policy decides what a create does when the new snapshot shares
+		// targets or cluster UUIDs with an active one: reject, warn or
+		// allow.
+		Policy string `yaml:"policy"`
+	} `yaml:"overlap"`
+	Idempotency struct {
+		// Window is how long an Idempotency-Key maps to the snapshot it
+		// created. 0 ignores the header.
+		Window time.Duration `yaml:"window"`
+	} `yaml:"idempotency"`
+	SD struct {
+		// CacheTTL is how long GET /api/v1/sd reuses an sd-type config's
+		// discovery answer before fetching it again.
+		CacheTTL time.Duration `yaml:"cache_ttl"`
+		// Timeout bounds each fetch of a discovery endpoint.
+		Timeout time.Duration `yaml:"timeout"`
+	} `yaml:"sd"`
+	Auth AuthConfig `yaml:"auth"`
+}
+
+// AgentShard is one agent instance and the directory it reads scrape
+// files from.
+type AgentShard struct {
+	Name            string `yaml:"name"`
+	Directory       string `yaml:"directory"`
+	FileSDDirectory string `yaml:"file_sd_directory"`
+	ReloadURL       string `yaml:"reload_url"`
+	// Capacity caps the active snapshots on the shard; 0 is unlimited.
+	Capacity int `yaml:"capacity"`
+	// Products dedicates the shard to snapshots scraping only these
+	// products. Shards without products take everything else.
+	Products []string `yaml:"products"`
+}
+
+// Placement policies for Agent.Placement.
+const (
+	PlacementLeastLoaded = "least_loaded"
+	PlacementFirstFit    = "first_fit"
+)
+
+// DefaultShard names the shard built from the single-agent settings.
+const DefaultShard = "default"
+
+// AgentShards returns the configured shards, or the single default
+// shard when none are listed, and checks they can be used together.
+func (c *Config) AgentShards() ([]AgentShard, error) {
+	shards := c.Agent.Shards
+	if len(shards) == 0 {
+		shards = []AgentShard{{
+			Name:            DefaultShard,
+			Directory:       c.Agent.Directory,
+			FileSDDirectory: c.Agent.FileSDDirectory,
+			ReloadURL:       c.Agent.ReloadURL,
+		}}
+	}
+	switch c.Agent.Placement {
+	case "", PlacementLeastLoaded, PlacementFirstFit:
+	default:
+		return nil, fmt.Errorf("agent.placement: unknown policy %q (supported: %s, %s)", c.Agent.Placement, PlacementLeastLoaded, PlacementFirstFit)
+	}
+	names := make(map[string]bool, len(shards))
+	dirs := make(map[string]bool, len(shards))
+	for i, s := range shards {
+		switch {
+		case s.Name == "":
+			return nil, fmt.Errorf("agent.shards[%d]: name is required", i)
+		case names[s.Name]:
+			return nil, fmt.Errorf("agent.shards[%d]: duplicate name %q", i, s.Name)
+		case s.Directory == "":
+			return nil, fmt.Errorf("agent.shards[%d]: directory is required", i)
+		case dirs[s.Directory]:
+			return nil, fmt.Errorf("agent.shards[%d]: directory %s is used by another shard", i, s.Directory)
+		case s.Capacity < 0:
+			return nil, fmt.Errorf("agent.shards[%d]: capacity must not be negative", i)
+		}
+		names[s.Name], dirs[s.Directory] = true, true
+	}
+	return shards, nil
 }
 
-// LoadConfig loads configuration from file and optionally applies flag overrides
+// AuthConfig controls API authentication. When Enabled is false every
+// route is open, matching the behaviour before auth existed.
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// PublicMetrics leaves /metrics reachable without credentials so
+	// Prometheus can scrape it without a token.
+	PublicMetrics bool        `yaml:"public_metrics"`
+	Tokens        []AuthToken `yaml:"tokens"`
+	Users         []AuthUser  `yaml:"users"`
+}
+
+// AuthToken is a static bearer token and the role it grants.
+type AuthToken struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+	Role  string `yaml:"role"`
+}
+
+// AuthUser is a basic-auth user and the role it grants.
+type AuthUser struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Role     string `yaml:"role"`
+}
+
+// LoadConfig loads configuration from file, then applies CM_* environment
+// overrides and finally flag overrides, so a flag beats the environment
+// and the environment beats the file.
 func LoadConfig(configPath string, flagOverrides map[string]string) (*Config, error) {
 	var config Config
 	// Always set defaults first
@@ -50,6 +240,11 @@ func LoadConfig(configPath string, flagOverrides map[string]string) (*Config, er
 		}
 	}
 
+	// Environment overrides sit between the file and the flags
+	if err := ApplyFlagOverrides(&config, EnvOverrides(os.Environ())); err != nil {
+		return nil, fmt.Errorf("failed to apply environment overrides: %w", err)
+	}
+
 	// Apply flag overrides if provided
 	if len(flagOverrides) > 0 {
 		if err := ApplyFlagOverrides(&config, flagOverrides); err != nil {
@@ -84,7 +279,9 @@ func ApplyFlagOverrides(config *Config, overrides map[string]string) error {
 	return nil
 }
 
-// setConfigValue sets a config value using dot notation path
+// setConfigValue sets a config value using dot notation path. Section
+// and field names are the YAML keys (`manager.min_interval`); the Go
+// field names are accepted too, case-insensitively, as they were before.
 func setConfigValue(config *Config, path, value string) error {
 	parts := strings.Split(path, ".")
 	if len(parts) < 2 {
@@ -95,7 +292,7 @@ func setConfigValue(config *Config, path, value string) error {
 	field := parts[1]
 
 	configValue := reflect.ValueOf(config).Elem()
-	sectionField := configValue.FieldByName(strings.Title(section))
+	sectionField := fieldByName(configValue, section)
 	if !sectionField.IsValid() {
 		return fmt.Errorf("unknown section: %s", section)
 	}
@@ -104,7 +301,7 @@ func setConfigValue(config *Config, path, value string) error {
 		return fmt.Errorf("section %s is not a struct", section)
 	}
 
-	fieldValue := sectionField.FieldByName(strings.Title(field))
+	fieldValue := fieldByName(sectionField, field)
 	if !fieldValue.IsValid() {
 		return fmt.Errorf("unknown field: %s in section: %s", field, section)
 	}
@@ -121,16 +318,93 @@ func setConfigValue(config *Config, path, value string) error {
 	return nil
 }
 
+// fieldByName finds the struct field whose YAML key is name, falling
+// back to a case-insensitive match on the Go field name.
+func fieldByName(v reflect.Value, name string) reflect.Value {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if yamlKey(t.Field(i)) == name {
+			return v.Field(i)
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if strings.EqualFold(t.Field(i).Name, name) {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+func yamlKey(f reflect.StructField) string {
+	key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	return key
+}
+
+// EnvPrefix starts every environment override, e.g. CM_METADATA_PASSWORD.
+const EnvPrefix = "CM_"
+
+// EnvOverrides maps CM_SECTION_FIELD environment variables onto the
+// section.field paths ApplyFlagOverrides takes. The variable names are
+// derived from the YAML keys of every scalar field, so CM_MANAGER_MIN_INTERVAL
+// sets manager.min_interval. List fields such as auth.tokens can only
+// be set in the file. A CM_ variable that names no field is logged and
+// skipped, so a typo shows up without stopping the service over a
+// variable meant for something else, such as a client's CM_TOKEN.
+func EnvOverrides(environ []string) map[string]string {
+	known := make(map[string]string)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		section := t.Field(i)
+		if section.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.Type.NumField(); j++ {
+			field := section.Type.Field(j)
+			if !isScalar(field.Type) {
+				continue
+			}
+			name := EnvPrefix + strings.ToUpper(yamlKey(section)+"_"+yamlKey(field))
+			known[name] = yamlKey(section) + "." + yamlKey(field)
+		}
+	}
+
+	overrides := make(map[string]string)
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		path, ok := known[name]
+		if !ok {
+			logger.Warn("Ignoring unknown configuration environment variable", "variable", name)
+			continue
+		}
+		overrides[path] = value
+	}
+	return overrides
+}
+
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
 // setFieldValue sets a field value with proper type conversion
 func setFieldValue(field reflect.Value, value string) error {
-	 if field.Type() == reflect.TypeOf(time.Duration(0)) {
-        dur, err := time.ParseDuration(value)
-        if err != nil {
-            return fmt.Errorf("invalid duration value: %s", value)
-        }
-        field.Set(reflect.ValueOf(dur))
-        return nil
-    }
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		dur, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration value: %s", value)
+		}
+		field.Set(reflect.ValueOf(dur))
+		return nil
+	}
 	switch field.Kind() {
 	case reflect.String:
 		field.SetString(value)
@@ -173,6 +447,7 @@ func setDefaults(config *Config) {
 	// Agent defaults
 	config.Agent.Type = "vmagent"
 	config.Agent.Directory = "./temp_path"
+	config.Agent.Placement = PlacementLeastLoaded
 
 	// Logging defaults
 	config.Logging.Level = "info"
@@ -188,5 +463,58 @@ func setDefaults(config *Config) {
 	config.Metadata.Username = "Administrator"
 	config.Metadata.Password = "password"
 	config.Metadata.Bucket = "metadata"
+	config.Metadata.Scope = "_default"
+	config.Metadata.Collection = "_default"
 	config.Metadata.Timeout = 30 * time.Second
+	config.Metadata.JournalFile = "./metadata-journal.jsonl"
+	config.Metadata.ReplayInterval = 30 * time.Second
+
+	// Audit defaults
+	config.Audit.File = "./audit.jsonl"
+	config.Audit.MaxSizeMB = 100
+	config.Audit.MaxBackups = 5
+	config.Audit.Collection = "audit"
+
+	// Archive defaults
+	config.Archive.Directory = "./archive"
+	config.Archive.Collection = "archive"
+
+	// Template defaults
+	config.Templates.Directory = "./templates"
+	config.Templates.Collection = "templates"
+
+	// Overlap defaults
+	config.Overlap.Policy = "warn"
+
+	// Idempotency defaults
+	config.Idempotency.Window = 24 * time.Hour
+
+	// Service discovery defaults
+	config.SD.CacheTTL = 30 * time.Second
+	config.SD.Timeout = 5 * time.Second
+
+	// Auth defaults
+	config.Auth.Enabled = false
+	config.Auth.PublicMetrics = true
+}
+
+// redacted replaces a secret in Redacted output.
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the config with passwords and tokens
+// replaced, safe to print or log.
+func (c *Config) Redacted() *Config {
+	out := *c
+	if out.Metadata.Password != "" {
+		out.Metadata.Password = redacted
+	}
+	out.Auth.Tokens = append([]AuthToken(nil), c.Auth.Tokens...)
+	for i := range out.Auth.Tokens {
+		out.Auth.Tokens[i].Token = redacted
+	}
+	out.Auth.Users = append([]AuthUser(nil), c.Auth.Users...)
+	for i := range out.Auth.Users {
+		out.Auth.Users[i].Password = redacted
+	}
+	return &out
 }
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApplyFlagOverridesUsesYAMLKeys(t *testing.T) {
+	var cfg Config
+	setDefaults(&cfg)
+
+	err := ApplyFlagOverrides(&cfg, map[string]string{
+		"manager.min_interval":    "7m",
+		"agent.file_sd_directory": "/etc/vmagent/targets",
+		"auth.public_metrics":     "false",
+		"server.Port":             "9090",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Manager.MinInterval != 7*time.Minute || cfg.Agent.FileSDDirectory != "/etc/vmagent/targets" || cfg.Auth.PublicMetrics || cfg.Server.Port != 9090 {
+		t.Fatalf("overrides not applied: %+v", cfg)
+	}
+
+	if err := ApplyFlagOverrides(&cfg, map[string]string{"manager.nope": "1"}); err == nil {
+		t.Fatal("expected an unknown field error")
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	overrides := EnvOverrides([]string{
+		"CM_METADATA_PASSWORD=s3cret",
+		"CM_MANAGER_STALE_THRESHOLD=10m",
+		"CM_PRESETS_DIRECTORY=/presets",
+		"HOME=/root",
+		// Unknown names and list fields are skipped with a warning.
+		"CM_METADATA_PASWORD=typo",
+		"CM_AUTH_TOKENS=x",
+		"CM_TOKEN=client",
+	})
+	want := map[string]string{
+		"metadata.password":       "s3cret",
+		"manager.stale_threshold": "10m",
+		"presets.directory":       "/presets",
+	}
+	if len(overrides) != len(want) {
+		t.Fatalf("overrides = %v, want %v", overrides, want)
+	}
+	for k, v := range want {
+		if overrides[k] != v {
+			t.Fatalf("overrides[%s] = %q, want %q", k, overrides[k], v)
+		}
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("logging:\n  level: warn\nserver:\n  port: 8000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CM_LOGGING_LEVEL", "debug")
+	t.Setenv("CM_SERVER_PORT", "8100")
+
+	cfg, err := LoadConfig(path, map[string]string{"server.port": "8200"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Logging.Level != "debug" {
+		t.Fatalf("environment did not override the file: level = %q", cfg.Logging.Level)
+	}
+	if cfg.Server.Port != 8200 {
+		t.Fatalf("flag did not override the environment: port = %d", cfg.Server.Port)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	var cfg Config
+	setDefaults(&cfg)
+	cfg.Auth.Tokens = []AuthToken{{Name: "ci", Token: "t0k3n", Role: "writer"}}
+	cfg.Auth.Users = []AuthUser{{Username: "ops", Password: "pw", Role: "admin"}}
+
+	r := cfg.Redacted()
+	if r.Metadata.Password != redacted || r.Auth.Tokens[0].Token != redacted || r.Auth.Users[0].Password != redacted {
+		t.Fatalf("secrets not redacted: %+v", r)
+	}
+	if r.Auth.Tokens[0].Name != "ci" || r.Auth.Users[0].Username != "ops" {
+		t.Fatalf("non-secret fields changed: %+v", r.Auth)
+	}
+	if cfg.Auth.Tokens[0].Token != "t0k3n" || cfg.Metadata.Password != "password" {
+		t.Fatal("Redacted modified the original config")
+	}
+}
+
+func TestAgentShards(t *testing.T) {
+	var cfg Config
+	setDefaults(&cfg)
+	cfg.Agent.ReloadURL = "http://vmagent:8429/-/reload"
+
+	shards, err := cfg.AgentShards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shards) != 1 || shards[0].Name != DefaultShard || shards[0].Directory != cfg.Agent.Directory || shards[0].ReloadURL != cfg.Agent.ReloadURL {
+		t.Fatalf("default shards = %+v", shards)
+	}
+
+	for name, tc := range map[string]struct {
+		placement string
+		shards    []AgentShard
+	}{
+		"unknown placement": {placement: "random", shards: []AgentShard{{Name: "a", Directory: "/a"}}},
+		"missing name":      {shards: []AgentShard{{Directory: "/a"}}},
+		"duplicate name":    {shards: []AgentShard{{Name: "a", Directory: "/a"}, {Name: "a", Directory: "/b"}}},
+		"missing directory": {shards: []AgentShard{{Name: "a"}}},
+		"shared directory":  {shards: []AgentShard{{Name: "a", Directory: "/a"}, {Name: "b", Directory: "/a"}}},
+		"negative capacity": {shards: []AgentShard{{Name: "a", Directory: "/a", Capacity: -1}}},
+	} {
+		cfg.Agent.Placement, cfg.Agent.Shards = tc.placement, tc.shards
+		if _, err := cfg.AgentShards(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
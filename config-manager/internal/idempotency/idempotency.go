@@ -0,0 +1,139 @@
+// Package idempotency remembers which snapshot each Idempotency-Key
+// created, so a create retried after a timeout returns the snapshot the
+// first attempt made instead of starting a duplicate. Keys are scoped to
+// the caller and kept in memory for a configurable window.
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// MaxKeyLength is the longest Idempotency-Key accepted.
+const MaxKeyLength = 255
+
+var (
+	// ErrInvalidKey means the key is empty, too long or not printable
+	// ASCII.
+	ErrInvalidKey = errors.New("Idempotency-Key must be 1-255 printable ASCII characters")
+	// ErrInProgress means an earlier request with the key hasn't
+	// finished yet.
+	ErrInProgress = errors.New("a request with this Idempotency-Key is still in progress")
+	// ErrMismatch means the key was first used with a different request.
+	ErrMismatch = errors.New("Idempotency-Key was already used with a different request")
+)
+
+// ValidKey reports whether key is acceptable as an Idempotency-Key.
+func ValidKey(key string) bool {
+	if key == "" || len(key) > MaxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Fingerprint identifies a request body, so a reused key can be told
+// apart from a retry. v is JSON-encoded first, which sorts map keys and
+// drops formatting differences.
+func Fingerprint(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type entry struct {
+	fingerprint string
+	// id is the snapshot created; empty while the request is in flight.
+	id      string
+	created time.Time
+}
+
+// Store maps (caller, key) pairs to the snapshot they created.
+type Store struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+// New returns a store remembering keys for window. A zero window turns
+// idempotency off: Begin never finds an earlier request.
+func New(window time.Duration) *Store {
+	return &Store{window: window, entries: make(map[string]*entry), now: time.Now}
+}
+
+// SetWindow changes how long keys are remembered, including the ones
+// already stored. It is safe to call while serving requests.
+func (s *Store) SetWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window = window
+}
+
+// Begin claims key for caller's request with fingerprint. It returns the
+// id of the snapshot an earlier request with the same key and body
+// created, or "" when the caller should go ahead and then call Finish
+// or Abandon. ErrInProgress and ErrMismatch refuse the request.
+func (s *Store) Begin(caller, key, fingerprint string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.window <= 0 {
+		return "", nil
+	}
+	s.expire()
+	scoped := caller + "\x00" + key
+	if e, ok := s.entries[scoped]; ok {
+		switch {
+		case e.fingerprint != fingerprint:
+			return "", ErrMismatch
+		case e.id == "":
+			return "", ErrInProgress
+		default:
+			return e.id, nil
+		}
+	}
+	s.entries[scoped] = &entry{fingerprint: fingerprint, created: s.now()}
+	return "", nil
+}
+
+// Finish records that caller's request with key created id.
+func (s *Store) Finish(caller, key, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[caller+"\x00"+key]; ok {
+		e.id = id
+	}
+}
+
+// Abandon releases key after a request that created nothing, so a retry
+// starts afresh.
+func (s *Store) Abandon(caller, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scoped := caller + "\x00" + key
+	if e, ok := s.entries[scoped]; ok && e.id == "" {
+		delete(s.entries, scoped)
+	}
+}
+
+// expire drops the finished entries older than the window. In-flight
+// entries stay until their request finishes or is abandoned.
+func (s *Store) expire() {
+	cutoff := s.now().Add(-s.window)
+	for k, e := range s.entries {
+		if e.id != "" && e.created.Before(cutoff) {
+			delete(s.entries, k)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package idempotency
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	s := New(time.Hour)
+	s.now = func() time.Time { return now }
+
+	if id, err := s.Begin("ci", "k1", "a"); id != "" || err != nil {
+		t.Fatalf("first Begin = %q, %v", id, err)
+	}
+	if _, err := s.Begin("ci", "k1", "a"); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Begin while in flight = %v, want ErrInProgress", err)
+	}
+	// Keys are scoped to the caller.
+	if id, err := s.Begin("perf", "k1", "a"); id != "" || err != nil {
+		t.Errorf("other caller's Begin = %q, %v", id, err)
+	}
+
+	s.Finish("ci", "k1", "snap-1")
+	if id, err := s.Begin("ci", "k1", "a"); id != "snap-1" || err != nil {
+		t.Errorf("retry = %q, %v; want snap-1", id, err)
+	}
+	if _, err := s.Begin("ci", "k1", "b"); !errors.Is(err, ErrMismatch) {
+		t.Errorf("reuse with another body = %v, want ErrMismatch", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if id, err := s.Begin("ci", "k1", "b"); id != "" || err != nil {
+		t.Errorf("Begin after the window = %q, %v; want a fresh claim", id, err)
+	}
+	s.Abandon("ci", "k1")
+	if id, err := s.Begin("ci", "k1", "c"); id != "" || err != nil {
+		t.Errorf("Begin after Abandon = %q, %v; want a fresh claim", id, err)
+	}
+}
+
+func TestValidKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"":                        false,
+		"retry-7f3a":              true,
+		"has space":               false,
+		"tab\there":               false,
+		"café":                    false,
+		string(make([]byte, 256)): false,
+	} {
+		if got := ValidKey(key); got != want {
+			t.Errorf("ValidKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
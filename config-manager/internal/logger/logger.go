@@ -6,27 +6,19 @@ import (
 	"strings"
 )
 
-var globalLogger *slog.Logger
+var (
+	globalLogger *slog.Logger
+	// level is shared by the handler so SetLevel can change it while
+	// the service is running.
+	level = new(slog.LevelVar)
+)
 
 // InitLogger initializes the global logger with the specified log level
-func InitLogger(level string) {
-	var logLevel slog.Level
-
-	switch strings.ToLower(level) {
-	case "debug":
-		logLevel = slog.LevelDebug
-	case "info":
-		logLevel = slog.LevelInfo
-	case "warn", "warning":
-		logLevel = slog.LevelWarn
-	case "error":
-		logLevel = slog.LevelError
-	default:
-		logLevel = slog.LevelInfo
-	}
+func InitLogger(logLevel string) {
+	SetLevel(logLevel)
 
 	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: logLevel,
+		Level: level,
 	})
 	globalLogger = slog.New(handler)
 
@@ -34,6 +26,32 @@ func InitLogger(level string) {
 	slog.SetDefault(globalLogger)
 }
 
+// SetLevel changes the level of the global logger. Unknown levels fall
+// back to info.
+func SetLevel(logLevel string) {
+	level.Set(parseLevel(logLevel))
+}
+
+// Level returns the current level of the global logger.
+func Level() slog.Level {
+	return level.Level()
+}
+
+func parseLevel(logLevel string) slog.Level {
+	switch strings.ToLower(logLevel) {
+	case "debug":
+		return slog.LevelDebug
+	case "info":
+		return slog.LevelInfo
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
 // GetLogger returns the global logger instance
 func GetLogger() *slog.Logger {
 	if globalLogger == nil {
@@ -4,9 +4,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/couchbase/config-manager/internal/config"
+	"github.com/couchbase/config-manager/internal/archive"
+	"github.com/couchbase/config-manager/internal/audit"
 	"github.com/couchbase/config-manager/internal/logger"
 	"github.com/couchbase/config-manager/internal/metrics"
 	"github.com/couchbase/config-manager/internal/storage"
@@ -18,62 +20,173 @@ type Information struct {
 	StaleThreshold time.Duration
 }
 
-func StartManagerWithInterval(information Information, directory string, cfg *config.Config) {
-	// Initialize metadata storage
-	metadataStorage, err := storage.NewMetadataStorage(cfg)
-	if err != nil {
-		logger.Error("Failed to initialize metadata storage", "error", err)
-		return
+// Validated returns a copy with out-of-range values replaced by their
+// defaults, logging a warning for each one replaced.
+func (information Information) Validated() Information {
+	if information.Interval > 30*time.Minute || information.Interval < information.MinInterval {
+		logger.Warn("Manager interval should be between 5 and 30 minutes", "interval", information.Interval, "default", 5*time.Minute)
+		information.Interval = information.MinInterval // Default to 5 minutes if invalid
+	}
+	if information.StaleThreshold > 30*time.Minute || information.StaleThreshold < 5*time.Minute {
+		logger.Warn("Manager stale threshold should be between 5 and 30 minutes", "stale_threshold", information.StaleThreshold, "default", 5*time.Minute)
+		information.StaleThreshold = 5 * time.Minute // Default to 5 minutes if invalid
+	}
+	return information
+}
+
+var (
+	current atomic.Pointer[Information]
+	// wake cuts the loop's sleep short when the intervals change.
+	wake = make(chan struct{}, 1)
+	// lastRun is when the loop last finished a pass, in Unix nanoseconds.
+	lastRun atomic.Int64
+)
+
+// Heartbeat returns when the loop last finished a pass over the shards,
+// the zero time before its first, and the interval it runs at.
+func Heartbeat() (time.Time, time.Duration) {
+	var interval time.Duration
+	if information := current.Load(); information != nil {
+		interval = information.Interval
+	}
+	last := lastRun.Load()
+	if last == 0 {
+		return time.Time{}, interval
 	}
-	defer metadataStorage.Close()
+	return time.Unix(0, last), interval
+}
+
+// SetInformation replaces the intervals of a running manager loop. The
+// loop runs a check straight away and then sleeps for the new interval.
+func SetInformation(information Information) {
+	current.Store(&information)
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartManagerWithInterval runs the expiry loop over every agent shard.
+// A shard whose directory can't be read is skipped for that pass.
+// Expired snapshots are ended in metadataStorage, which the API shares,
+// archived in archives and each expiry is recorded in auditLog.
+func StartManagerWithInterval(information Information, shards *storage.Shards, metadataStorage storage.MetadataStorage, auditLog audit.Log, archives archive.Store) {
+	current.Store(&information)
 
 	for {
+		information := *current.Load()
+
 		// Manager logic goes here
-		logger.Debug("Manager is checking the directory", "directory", directory)
-		files, err := os.ReadDir(directory)
+		start := time.Now()
+		total := 0
+		active := make(map[string]struct{})
+		for _, shard := range shards.List() {
+			n, err := checkShard(information, shards, shard, metadataStorage, auditLog, archives, active)
+			if err != nil {
+				logger.Error("Failed to read directory", "shard", shard.Name, "directory", shard.Directory(), "error", err)
+				continue
+			}
+			metrics.ShardSnapshots.WithLabelValues(shard.Name).Set(float64(n))
+			total += n
+		}
+		metrics.SetActiveSnapshots(total)
+		metrics.RetainSnapshots(active)
+		metrics.ManagerLoopDuration.Observe(time.Since(start).Seconds())
+		metrics.ManagerLastRun.SetToCurrentTime()
+		lastRun.Store(time.Now().UnixNano())
+
+		select {
+		case <-time.After(information.Interval):
+		case <-wake:
+		}
+	}
+}
+
+// checkShard expires the stale snapshots on one shard and adds the rest
+// to active. It returns the number of scrape files found.
+func checkShard(information Information, shards *storage.Shards, shard *storage.Shard, metadataStorage storage.MetadataStorage, auditLog audit.Log, archives archive.Store, active map[string]struct{}) (int, error) {
+	directory := shard.Directory()
+	logger.Debug("Manager is checking the directory", "shard", shard.Name, "directory", directory)
+	files, err := os.ReadDir(directory)
+	if err != nil {
+		return 0, err
+	}
+	logger.Info("Scrape files found", "shard", shard.Name, "count", len(files))
+	ymlCount := 0
+	expired := false
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".yml" {
+			continue
+		}
+		ymlCount++
+		filepath := filepath.Join(directory, file.Name())
+		info, err := os.Stat(filepath)
 		if err != nil {
-			logger.Error("Failed to read directory", "directory", directory, "error", err)
-			return
+			logger.Error("Failed to stat file", "filepath", filepath, "error", err)
+			continue
 		}
-		logger.Info("Scrape files found", "count", len(files))
-		ymlCount := 0
-		for _, f := range files {
-			if filepath.Ext(f.Name()) == ".yml" {
-				ymlCount++
+		// Process the file
+		logger.Debug("Processing file", "filepath", filepath)
+
+		// Extract snapshot ID from filename (remove .yml extension)
+		snapshotID := strings.TrimSuffix(file.Name(), ".yml")
+
+		if time.Since(info.ModTime()) > information.StaleThreshold {
+			// Archive while the metadata still reads as it did when the
+			// snapshot was live, as the delete handler does. An expired
+			// snapshot is removed even when this fails: unlike a delete,
+			// nobody is waiting to retry it.
+			if err := archive.Snapshot(archives, shard, metadataStorage, snapshotID, archive.ReasonExpired, "manager"); err != nil {
+				logger.Error("Failed to archive stale snapshot", "snapshotID", snapshotID, "error", err)
 			}
-		}
-		metrics.SetActiveSnapshots(ymlCount)
-		for _, file := range files {
-			if filepath.Ext(file.Name()) == ".yml" {
-				filepath := filepath.Join(directory, file.Name())
-				info, err := os.Stat(filepath)
-				if err != nil {
-					logger.Error("Failed to stat file", "filepath", filepath, "error", err)
-					continue
-				}
-				// Process the file
-				logger.Debug("Processing file", "filepath", filepath)
-
-				if time.Since(info.ModTime()) > information.StaleThreshold {
-					// Extract snapshot ID from filename (remove .yml extension)
-						snapshotID := strings.TrimSuffix(file.Name(), ".yml")
-					// Update metadata to mark snapshot as ended
-					if err := metadataStorage.EoLSnapshot(snapshotID); err != nil {
-						logger.Error("Failed to update snapshot end time in metadata", "snapshotID", snapshotID, "error", err)
-					} else {
-						logger.Info("Successfully updated snapshot end time in metadata", "snapshotID", snapshotID)
-					}
-
-					// Delete the stale file
-					if err := os.Remove(filepath); err != nil {
-						logger.Error("Failed to delete stale file", "filepath", filepath, "error", err)
-					} else {
-						logger.Info("Deleted stale file", "filepath", filepath, "age_minutes", int(time.Since(info.ModTime()).Minutes()))
-						metrics.SnapshotsExpired.Inc()
-					}
-				}
+
+			// Update metadata to mark snapshot as ended
+			if err := metadataStorage.EoLSnapshot(snapshotID, "manager"); err != nil {
+				logger.Error("Failed to update snapshot end time in metadata", "snapshotID", snapshotID, "error", err)
+			} else {
+				logger.Info("Successfully updated snapshot end time in metadata", "snapshotID", snapshotID)
 			}
+
+			// Delete the stale file along with any file_sd target lists
+			entry := audit.Entry{Action: audit.ActionExpire, SnapshotID: snapshotID, Caller: "manager", Outcome: audit.OutcomeSuccess}
+			if err := shard.DeleteSnapshot(snapshotID); err != nil {
+				logger.Error("Failed to delete stale file", "filepath", filepath, "error", err)
+				entry.Outcome, entry.Error = audit.OutcomeFailure, err.Error()
+			} else {
+				logger.Info("Deleted stale file", "shard", shard.Name, "filepath", filepath, "age_minutes", int(time.Since(info.ModTime()).Minutes()))
+				metrics.SnapshotsExpired.Inc()
+				metrics.UntrackSnapshot(snapshotID)
+				expired = true
+				ymlCount--
+			}
+			if err := auditLog.Record(entry); err != nil {
+				logger.Error("Failed to record audit entry", "action", entry.Action, "snapshotID", snapshotID, "error", err)
+			}
+			continue
+		}
+
+		active[snapshotID] = struct{}{}
+		trackSnapshot(metadataStorage, snapshotID, info.ModTime())
+	}
+	// One reload covers every scrape file removed in this pass.
+	if expired {
+		shards.Reload(shard)
+	}
+	return ymlCount, nil
+}
+
+// trackSnapshot keeps the per-snapshot metrics in step with the agent
+// directory. Snapshots the API didn't create in this process (e.g.
+// before a restart) are picked up from their metadata document; the
+// scrape file's mtime is the last keep-alive either way.
+func trackSnapshot(metadataStorage storage.MetadataStorage, snapshotID string, modTime time.Time) {
+	if !metrics.IsSnapshotTracked(snapshotID) {
+		label, created := "", time.Time{}
+		var productNames []string
+		if md, err := metadataStorage.GetMetadata(snapshotID); err == nil && md != nil {
+			label, productNames, created = md.Label, md.Products, md.TsStart
 		}
-		time.Sleep(information.Interval)
+		metrics.TrackSnapshot(snapshotID, label, productNames, created)
 	}
+	metrics.SnapshotKeptAlive(snapshotID, modTime)
 }
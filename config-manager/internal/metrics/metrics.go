@@ -20,7 +20,7 @@ var (
 	})
 	ActiveSnapshots = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "config_manager_active_snapshots",
-		Help: "Number of active snapshot .yml files in the agent directory.",
+		Help: "Number of active snapshot .yml files across the agent shards.",
 	})
 	SnapshotsCreated = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "config_manager_snapshots_created_total",
@@ -38,6 +38,98 @@ var (
 		Name: "config_manager_snapshots_expired_total",
 		Help: "Total stale snapshots cleaned up by the manager loop.",
 	})
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "config_manager_http_request_duration_seconds",
+		Help:    "API request duration, by route template, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	ProductMetadataFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "config_manager_product_metadata_fetch_duration_seconds",
+		Help:    "Duration of per-host product metadata collection, by product.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"product"})
+	ProductMetadataFetchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_manager_product_metadata_fetch_errors_total",
+		Help: "Failed product metadata collections, by product and reason (timeout, connection, tls, auth, http_status, decode, other).",
+	}, []string{"product", "reason"})
+	ManagerLoopDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "config_manager_manager_loop_duration_seconds",
+		Help:    "Duration of one manager loop pass over the agent shards.",
+		Buckets: prometheus.DefBuckets,
+	})
+	ManagerLastRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "config_manager_manager_last_run_timestamp_seconds",
+		Help: "Unix timestamp (seconds) when the manager loop last finished a pass.",
+	})
+	StorageOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "config_manager_metadata_storage_operation_duration_seconds",
+		Help:    "Metadata storage operation latency, by backend, operation and result (ok, not_found, error).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"backend", "operation", "result"})
+	ShardSnapshots = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "config_manager_shard_active_snapshots",
+		Help: "Number of active snapshots on each agent shard.",
+	}, []string{"shard"})
+	ShardCapacity = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "config_manager_shard_capacity",
+		Help: "Configured snapshot capacity of each agent shard; 0 is unlimited.",
+	}, []string{"shard"})
+	AgentReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_manager_agent_reloads_total",
+		Help: "Agent reload requests after scrape file changes, by shard and result (success or failure).",
+	}, []string{"shard", "result"})
+	QuotaLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "config_manager_quota_limit",
+		Help: "Configured admission limits, by limit name and, for max_active_per_tag, tag; 0 is unlimited.",
+	}, []string{"limit", "tag"})
+	QuotaUsage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "config_manager_quota_usage",
+		Help: "Active snapshots counted against each count limit, by limit name and key (caller or tag=value), as of the last admission check.",
+	}, []string{"limit", "key"})
+	QuotaRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_manager_quota_rejections_total",
+		Help: "Requests refused by an admission limit, by limit name.",
+	}, []string{"limit"})
+	SnapshotOverlaps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_manager_snapshot_overlaps_total",
+		Help: "Creates that overlapped an active snapshot's targets or clusters, by action taken (reject or warn).",
+	}, []string{"action"})
+	IdempotentReplays = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "config_manager_idempotent_replays_total",
+		Help: "Creates answered with the snapshot an earlier request with the same Idempotency-Key made.",
+	})
+	MetadataMigrations = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "config_manager_metadata_migrations_total",
+		Help: "Metadata documents upgraded to the current schema version when read.",
+	})
+	MetadataJournalEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_manager_metadata_journal_entries_total",
+		Help: "Metadata mutations written to the local journal while the store was unavailable, by operation.",
+	}, []string{"op"})
+	MetadataJournalDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "config_manager_metadata_journal_depth",
+		Help: "Journaled metadata mutations not yet replayed into the store.",
+	})
+	MetadataJournalReplayLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "config_manager_metadata_journal_replay_lag_seconds",
+		Help: "Age of the oldest journaled metadata mutation not yet replayed; 0 when the journal is empty.",
+	})
+	MetadataJournalReplays = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_manager_metadata_journal_replays_total",
+		Help: "Journaled metadata mutations replayed into the store, by result (success, dropped or failure).",
+	}, []string{"result"})
+	SDResolutions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_manager_sd_resolutions_total",
+		Help: "Discovery endpoints of sd-type configs resolved for GET /api/v1/sd, by result (cached, fetched, stale or failed).",
+	}, []string{"result"})
+	ConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_manager_config_reloads_total",
+		Help: "Total SIGHUP configuration reloads, by result (success or failure).",
+	}, []string{"result"})
+	ConfigLastReloadSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "config_manager_config_last_reload_success_timestamp_seconds",
+		Help: "Unix timestamp (seconds) of the last successful configuration reload.",
+	})
 )
 
 func MarkUp() {
@@ -45,6 +137,16 @@ func MarkUp() {
 	StartTime.Set(float64(time.Now().Unix()))
 }
 
+// ReloadResult records the outcome of a configuration reload.
+func ReloadResult(err error) {
+	if err != nil {
+		ConfigReloads.WithLabelValues("failure").Inc()
+		return
+	}
+	ConfigReloads.WithLabelValues("success").Inc()
+	ConfigLastReloadSuccess.SetToCurrentTime()
+}
+
 func SetActiveSnapshots(n int) { ActiveSnapshots.Set(float64(n)) }
 
 func Handler() http.Handler { return promhttp.Handler() }
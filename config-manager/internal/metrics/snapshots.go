@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// snapshotState is what the snapshot collector knows about one active
+// snapshot. Zero times are unknown and their age series are skipped.
+type snapshotState struct {
+	label     string
+	products  string
+	created   time.Time
+	keepAlive time.Time
+}
+
+// snapshotCollector reports per-snapshot series computed at scrape time,
+// so ages are current however long ago the manager loop last ran.
+type snapshotCollector struct {
+	mu        sync.Mutex
+	snapshots map[string]*snapshotState
+
+	info         *prometheus.Desc
+	age          *prometheus.Desc
+	keepAliveAge *prometheus.Desc
+}
+
+var snapshots = &snapshotCollector{
+	snapshots: make(map[string]*snapshotState),
+	info: prometheus.NewDesc(
+		"config_manager_snapshot_info",
+		"Always 1 for each active snapshot; carries its label and comma-separated products.",
+		[]string{"id", "label", "products"}, nil,
+	),
+	age: prometheus.NewDesc(
+		"config_manager_snapshot_age_seconds",
+		"Seconds since the active snapshot was created.",
+		[]string{"id"}, nil,
+	),
+	keepAliveAge: prometheus.NewDesc(
+		"config_manager_snapshot_keepalive_age_seconds",
+		"Seconds since the active snapshot was last created, patched or kept alive.",
+		[]string{"id"}, nil,
+	),
+}
+
+func init() {
+	prometheus.MustRegister(snapshots)
+}
+
+func (c *snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.info
+	ch <- c.age
+	ch <- c.keepAliveAge
+}
+
+func (c *snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for id, s := range c.snapshots {
+		ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, id, s.label, s.products)
+		if !s.created.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.age, prometheus.GaugeValue, now.Sub(s.created).Seconds(), id)
+		}
+		if !s.keepAlive.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.keepAliveAge, prometheus.GaugeValue, now.Sub(s.keepAlive).Seconds(), id)
+		}
+	}
+}
+
+// TrackSnapshot starts (or refreshes) the per-snapshot series for id.
+func TrackSnapshot(id, label string, products []string, created time.Time) {
+	snapshots.mu.Lock()
+	defer snapshots.mu.Unlock()
+	s, ok := snapshots.snapshots[id]
+	if !ok {
+		s = &snapshotState{keepAlive: created}
+		snapshots.snapshots[id] = s
+	}
+	s.label = label
+	s.products = strings.Join(products, ",")
+	s.created = created
+}
+
+// SnapshotKeptAlive records a keep-alive for a tracked snapshot.
+func SnapshotKeptAlive(id string, at time.Time) {
+	snapshots.mu.Lock()
+	defer snapshots.mu.Unlock()
+	if s, ok := snapshots.snapshots[id]; ok {
+		s.keepAlive = at
+	}
+}
+
+// UntrackSnapshot drops the series for a deleted or expired snapshot.
+func UntrackSnapshot(id string) {
+	snapshots.mu.Lock()
+	defer snapshots.mu.Unlock()
+	delete(snapshots.snapshots, id)
+}
+
+// IsSnapshotTracked reports whether id already has series.
+func IsSnapshotTracked(id string) bool {
+	snapshots.mu.Lock()
+	defer snapshots.mu.Unlock()
+	_, ok := snapshots.snapshots[id]
+	return ok
+}
+
+// RetainSnapshots drops the series of every snapshot not in active. The
+// manager loop calls it with the snapshots it found on disk, so removals
+// that bypassed the API don't leave series behind.
+func RetainSnapshots(active map[string]struct{}) {
+	snapshots.mu.Lock()
+	defer snapshots.mu.Unlock()
+	for id := range snapshots.snapshots {
+		if _, ok := active[id]; !ok {
+			delete(snapshots.snapshots, id)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package migrate upgrades snapshot metadata documents written by older
+// releases to the current schema. Documents carry their version in
+// schema_version; one without it is version 0, the shape that grew up
+// before the field existed. Migrations work on the raw JSON document so
+// they can read shapes the current models no longer decode.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// CurrentVersion is the schema version this release writes.
+const CurrentVersion = 1
+
+// VersionField is the document field holding the schema version.
+const VersionField = "schema_version"
+
+// ErrNewerVersion is returned for a document written by a newer release.
+// It is left as it is rather than downgraded.
+var ErrNewerVersion = errors.New("metadata document has a newer schema version")
+
+// Migration upgrades a document from the previous version to Version.
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(doc map[string]interface{}) error
+}
+
+// Migrations is every migration in version order.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "normalise ts_end and custom_panels, default products and extras",
+		Apply:       toV1,
+	},
+}
+
+// Version returns the schema version of doc, 0 when it has none.
+func Version(doc map[string]interface{}) (int, error) {
+	raw, ok := doc[VersionField]
+	if !ok || raw == nil {
+		return 0, nil
+	}
+	// JSON numbers decode as float64.
+	switch v := raw.(type) {
+	case float64:
+		if v != float64(int(v)) || v < 0 {
+			return 0, fmt.Errorf("invalid %s %v", VersionField, v)
+		}
+		return int(v), nil
+	case int:
+		return v, nil
+	}
+	return 0, fmt.Errorf("invalid %s %v", VersionField, raw)
+}
+
+// Document applies every migration newer than doc's version, in place.
+// It reports whether doc changed, so running it on a current document
+// is a no-op and a backfill can be run any number of times.
+func Document(doc map[string]interface{}) (changed bool, err error) {
+	version, err := Version(doc)
+	if err != nil {
+		return false, err
+	}
+	if version > CurrentVersion {
+		return false, fmt.Errorf("%w: %d (this release writes %d)", ErrNewerVersion, version, CurrentVersion)
+	}
+	for _, m := range Migrations {
+		if m.Version <= version {
+			continue
+		}
+		if err := m.Apply(doc); err != nil {
+			return false, fmt.Errorf("migration to schema version %d: %w", m.Version, err)
+		}
+		doc[VersionField] = m.Version
+		changed = true
+	}
+	return changed, nil
+}
+
+// Stamp marks metadata as the current version before it is saved, and
+// fills in the fields the current version always has.
+func Stamp(metadata *models.SnapshotMetadata) {
+	metadata.SchemaVersion = CurrentVersion
+	if metadata.Products == nil {
+		metadata.Products = []string{}
+	}
+	if metadata.Extras == nil {
+		metadata.Extras = map[string]interface{}{}
+	}
+}
+
+// toV1 handles the variants written before schema_version existed:
+// ts_end missing or empty for a running snapshot, custom_panels as a
+// single object, and documents from before products and extras were
+// added. Those were all Couchbase snapshots.
+func toV1(doc map[string]interface{}) error {
+	switch v := doc["ts_end"].(type) {
+	case nil:
+		doc["ts_end"] = "now"
+	case string:
+		if v == "" {
+			doc["ts_end"] = "now"
+		} else if v != "now" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				return fmt.Errorf("ts_end %q is neither \"now\" nor RFC3339", v)
+			}
+		}
+	default:
+		return fmt.Errorf("ts_end has unexpected type %T", v)
+	}
+
+	switch v := doc["custom_panels"].(type) {
+	case nil:
+		delete(doc, "custom_panels")
+	case map[string]interface{}:
+		doc["custom_panels"] = []interface{}{v}
+	case []interface{}:
+	default:
+		return fmt.Errorf("custom_panels has unexpected type %T", v)
+	}
+
+	if doc["products"] == nil {
+		doc["products"] = []interface{}{"couchbase"}
+	}
+	if doc["extras"] == nil {
+		doc["extras"] = map[string]interface{}{}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+func decode(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "legacy running snapshot",
+			in:   `{"id":"a","services":["kv"],"custom_panels":{"match":"kv_.*"}}`,
+			want: `{"schema_version":1,"id":"a","services":["kv"],"ts_end":"now","custom_panels":[{"match":"kv_.*"}],"products":["couchbase"],"extras":{}}`,
+		},
+		{
+			name: "legacy ended snapshot",
+			in:   `{"id":"b","ts_end":"2025-01-02T03:04:05.123Z","custom_panels":[{"match":"x"}],"products":["sgw"],"extras":{"sgw":{}}}`,
+			want: `{"schema_version":1,"id":"b","ts_end":"2025-01-02T03:04:05.123Z","custom_panels":[{"match":"x"}],"products":["sgw"],"extras":{"sgw":{}}}`,
+		},
+		{
+			name: "null fields",
+			in:   `{"id":"c","ts_end":"","custom_panels":null,"products":null}`,
+			want: `{"schema_version":1,"id":"c","ts_end":"now","products":["couchbase"],"extras":{}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := decode(t, tt.in)
+			changed, err := Document(doc)
+			if err != nil || !changed {
+				t.Fatalf("Document = %v, %v; want changed", changed, err)
+			}
+			// Compare through JSON so numbers have the same type.
+			data, _ := json.Marshal(doc)
+			if got, want := decode(t, string(data)), decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("migrated to %s\nwant %s", data, tt.want)
+			}
+
+			// A second run finds nothing to do.
+			if changed, err := Document(doc); changed || err != nil {
+				t.Errorf("second Document = %v, %v; want a no-op", changed, err)
+			}
+
+			// The result decodes into the model.
+			var m models.SnapshotMetadata
+			if err := json.Unmarshal(data, &m); err != nil || m.SchemaVersion != CurrentVersion {
+				t.Errorf("decoding migrated document = %+v, %v", m, err)
+			}
+		})
+	}
+}
+
+func TestDocumentErrors(t *testing.T) {
+	if _, err := Document(decode(t, `{"schema_version":99}`)); !errors.Is(err, ErrNewerVersion) {
+		t.Errorf("newer version: err = %v, want ErrNewerVersion", err)
+	}
+	for _, in := range []string{
+		`{"schema_version":"1"}`,
+		`{"ts_end":"yesterday"}`,
+		`{"ts_end":12}`,
+		`{"custom_panels":"kv"}`,
+	} {
+		if _, err := Document(decode(t, in)); err == nil {
+			t.Errorf("Document(%s) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestStamp(t *testing.T) {
+	m := &models.SnapshotMetadata{SnapshotID: "a"}
+	Stamp(m)
+	data, _ := json.Marshal(m)
+	doc := decode(t, string(data))
+	if changed, err := Document(doc); changed || err != nil {
+		t.Errorf("stamped metadata %s needs migrating: %v, %v", data, changed, err)
+	}
+}
@@ -9,20 +9,40 @@ import "time"
 // for now; a future iteration may split product metadata into per-
 // product sub-documents.
 type SnapshotMetadata struct {
-	SnapshotID   string                 `json:"id"`
-	Services     []string               `json:"services"` // same thing for buckets and nodes for services
-	Clusters     []Cluster              `json:"clusters,omitempty"`
-	Server       string                 `json:"server,omitempty"`
-	TsStart      time.Time              `json:"ts_start,omitempty"`
-	TsEnd        string                 `json:"ts_end,omitempty"`
-	Phases       []Phase                `json:"phases,omitempty"`
-	Label        string                 `json:"label,omitempty"`
-	CustomPanels []CustomPanelsConfig   `json:"custom_panels,omitempty"`
-	Extras       map[string]interface{} `json:"extras,omitempty"`
+	// SchemaVersion is the document's shape; see the migrate package.
+	SchemaVersion int                    `json:"schema_version"`
+	SnapshotID    string                 `json:"id"`
+	Services      []string               `json:"services"` // same thing for buckets and nodes for services
+	Clusters      []Cluster              `json:"clusters,omitempty"`
+	Server        string                 `json:"server,omitempty"`
+	TsStart       time.Time              `json:"ts_start,omitempty"`
+	TsEnd         string                 `json:"ts_end,omitempty"`
+	Phases        []Phase                `json:"phases,omitempty"`
+	Label         string                 `json:"label,omitempty"`
+	Tags          map[string]string      `json:"tags,omitempty"`
+	CustomPanels  []CustomPanelsConfig   `json:"custom_panels,omitempty"`
+	Extras        map[string]interface{} `json:"extras"`
 	// Products is the distinct, order-preserving set of products this
 	// snapshot scrapes (e.g. ["couchbase"], ["couchbase","sgw"], ["kafka"]).
 	// cbmonitor uses it to decide whether the Couchbase baseline tabs apply.
-	Products []string `json:"products,omitempty"`
+	Products []string `json:"products"`
+	// CreatedBy and EndedBy name the API caller that created and ended
+	// the snapshot. Both are empty when auth is disabled; EndedBy is
+	// "manager" when the snapshot expired.
+	CreatedBy string `json:"created_by,omitempty"`
+	EndedBy   string `json:"ended_by,omitempty"`
+	// RestoredFrom is the id of the archived snapshot this one was
+	// restored from.
+	RestoredFrom string `json:"restored_from,omitempty"`
+	// ClonedFrom is the id of the snapshot, active or archived, whose
+	// configuration this one was cloned from.
+	ClonedFrom string `json:"cloned_from,omitempty"`
+	// Shard names the agent shard the snapshot's scrape file was placed on.
+	Shard string `json:"shard,omitempty"`
+	// Template and TemplateVersion name the template, and the version of
+	// it, the snapshot was created from.
+	Template        string `json:"template,omitempty"`
+	TemplateVersion int    `json:"template_version,omitempty"`
 }
 
 // CustomPanelsConfig matches the shape cbmonitor's snapshot service
@@ -58,3 +78,30 @@ type NodeInfo struct {
 	Services []string `json:"services"`
 	Server   string   `json:"version,omitempty"`
 }
+
+// MetadataQuery is the body of POST /api/v1/snapshots/query. Every
+// condition must match.
+type MetadataQuery struct {
+	Where []QueryCondition `json:"where"`
+	// Limit caps the results; 0 uses the default.
+	Limit int `json:"limit,omitempty"`
+}
+
+// QueryCondition tests one metadata field. Value is used by eq and
+// prefix, Values by in, From and To by range, and exists takes nothing.
+type QueryCondition struct {
+	Field  string     `json:"field"`
+	Op     string     `json:"op"`
+	Value  string     `json:"value,omitempty"`
+	Values []string   `json:"values,omitempty"`
+	From   *time.Time `json:"from,omitempty"`
+	To     *time.Time `json:"to,omitempty"`
+}
+
+// QueryResponse is the response of POST /api/v1/snapshots/query, newest
+// snapshot first.
+type QueryResponse struct {
+	Snapshots []SnapshotMetadata `json:"snapshots"`
+	// Truncated is set when more snapshots matched than the limit.
+	Truncated bool `json:"truncated,omitempty"`
+}
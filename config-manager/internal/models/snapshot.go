@@ -1,21 +1,57 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // SnapshotRequest represents the payload for creating a snapshot
 // Contains information about the cluster to be monitored
 type SnapshotRequest struct {
+	// ID names the snapshot instead of a generated UUID. It becomes the
+	// scrape file name and the job label, so it is limited to lowercase
+	// letters, digits, '_' and '-'.
+	ID string `json:"id,omitempty"`
+	// IDPrefix is prepended to a generated UUID, as prefix-uuid. It has
+	// the same character limits as ID and can't be combined with it.
+	IDPrefix string `json:"id_prefix,omitempty"`
+
 	Configs     []ConfigObject `json:"configs"`
 	Credentials Credentials    `json:"credentials"`
 	Scheme      string         `json:"scheme,omitempty"`
 	TimeStamp   time.Time      `json:"timestamp,omitempty"`
 	Label       string         `json:"label,omitempty"`
+	// Tags are structured key/value labels for finding and grouping
+	// runs, e.g. build=7.6.2-3721 or owner=perf-team.
+	Tags map[string]string `json:"tags,omitempty"`
+	// TLS is the default for https configs that don't set their own.
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// Presets names custom-panel presets from config-manager's preset
+	// registry. Each one expands into one entry in the snapshot's
+	// `custom_panels` field via Registry.BuildCustomPanels.
+	Presets []string `json:"presets,omitempty"`
+
+	// CustomPanels are one-off panel definitions for metric families
+	// that don't warrant a named preset. They are appended after the
+	// presets and must have unique titles.
+	CustomPanels []CustomPanelsConfig `json:"custom_panels,omitempty"`
 
-	// Boolean opt-ins for the canned custom-panel presets owned by
-	// config-manager. Each `true` flag expands into one entry in the
-	// snapshot's `custom_panels` field via presets.BuildCustomPanels.
+	// Boolean opt-ins for the built-in presets, kept for callers that
+	// predate `presets`. `cbagent: true` is the same as
+	// `presets: ["cbagent"]`.
 	Cbagent bool `json:"cbagent,omitempty"`
 	Capella bool `json:"capella,omitempty"`
+
+	// Template names a stored template to start from instead of giving
+	// the configuration here. Only ID, IDPrefix, Label and Tags may be
+	// set next to it; Label replaces the template's label and Tags are
+	// merged into its tags, an empty value removing the key.
+	Template string `json:"template,omitempty"`
+	// Overrides is a JSON merge patch (RFC 7386) applied to the
+	// template's request, e.g. {"credentials": {...}} or a replacement
+	// "configs" list.
+	Overrides json.RawMessage `json:"overrides,omitempty"`
 }
 
 // Credentials for cluster authentication
@@ -28,6 +64,26 @@ type Credentials struct {
 // SnapshotResponse represents the response after creating a snapshot
 type SnapshotResponse struct {
 	ID string `json:"id"`
+	// Overlaps lists the active snapshots scraping the same targets or
+	// clusters, when the overlap policy is warn.
+	Overlaps []Overlap `json:"overlaps,omitempty"`
+}
+
+// Overlap is another active snapshot sharing targets or clusters with
+// a snapshot.
+type Overlap struct {
+	SnapshotID string `json:"snapshot_id"`
+	Label      string `json:"label,omitempty"`
+	// Targets are the shared host:port endpoints and dns:name entries.
+	Targets []string `json:"targets,omitempty"`
+	// ClusterUUIDs are the shared clusters, from metadata.
+	ClusterUUIDs []string `json:"cluster_uuids,omitempty"`
+}
+
+// OverlapReport is the response of GET /api/v1/snapshot/{id}/overlaps.
+type OverlapReport struct {
+	SnapshotID string    `json:"snapshot_id"`
+	Overlaps   []Overlap `json:"overlaps"`
 }
 
 // ConfigObject represents the configuration for each different config object type.
@@ -41,6 +97,17 @@ type SnapshotResponse struct {
 // `SDPath` is the discovery endpoint path appended to {scheme}://{host}:{port}
 // when Type=="sd" AND Product != "couchbase" (e.g. "/sd/targets"). It must
 // begin with "/" and may include a query string.
+//
+// `Type` selects how the agent finds targets:
+//   - "sd" (default): http_sd_configs against {scheme}://{host}:{port}{path}
+//   - "static": static_configs listing {host}:{port}
+//   - "dns": dns_sd_configs; Hostnames are DNS names (SRV records such as
+//     `_couchbases._tcp.cb.example.cloud.couchbase.com` by default)
+//   - "file": file_sd_configs backed by a JSON target list config-manager
+//     maintains next to the scrape file; see PATCH .../targets
+//
+// `DNSRecordType` only applies to Type=="dns". SRV records carry their own
+// port, so Port is optional for them; A and AAAA lookups need Port.
 type ConfigObject struct {
 	Hostnames       []string `json:"hostnames"`
 	Type            string   `json:"type,omitempty"`
@@ -49,6 +116,64 @@ type ConfigObject struct {
 	SDPath          string   `json:"sd_path,omitempty"`
 	Scheme          string   `json:"scheme,omitempty"`
 	UseAltAddresses bool     `json:"use_alt_addresses,omitempty"`
+	DNSRecordType   string   `json:"dns_record_type,omitempty"`
+	// TLS applies to scraping, discovery and metadata collection for this
+	// config. Only valid when the config's scheme is https.
+	TLS *TLSConfig `json:"tls,omitempty"`
+}
+
+// TLSConfig describes how to verify, and authenticate to, an https
+// target. CA, Cert and Key are inline PEM. Without CA the system roots
+// are used; InsecureSkipVerify turns verification off entirely and must
+// be asked for explicitly.
+type TLSConfig struct {
+	CA                 string `json:"ca,omitempty"`
+	Cert               string `json:"cert,omitempty"`
+	Key                string `json:"key,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// Supported ConfigObject.Type values.
+const (
+	ConfigTypeSD     = "sd"
+	ConfigTypeStatic = "static"
+	ConfigTypeDNS    = "dns"
+	ConfigTypeFile   = "file"
+)
+
+// TargetGroup is one entry of a Prometheus file_sd/http_sd target list.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// SnapshotPatchRequest is the payload for PATCH /api/v1/snapshot/{id}.
+// Every field is optional; an empty body only refreshes the snapshot's
+// keep-alive timestamp.
+type SnapshotPatchRequest struct {
+	Phase    string   `json:"phase,omitempty"`
+	Mode     string   `json:"mode,omitempty"`
+	Services []string `json:"services,omitempty"`
+	// Tags are merged into the snapshot's tags; an empty value removes
+	// the key.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// TargetsResponse is returned by PATCH /api/v1/snapshot/{id}/targets.
+type TargetsResponse struct {
+	Targets []string `json:"targets"`
+}
+
+// TargetsPatchRequest is the payload for PATCH /api/v1/snapshot/{id}/targets.
+// Targets replaces the whole list when set; otherwise Add and Remove are
+// applied to the current list. Scheme picks which file to edit when the
+// snapshot has file-type configs under both http and https.
+type TargetsPatchRequest struct {
+	Scheme  string   `json:"scheme,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+	Add     []string `json:"add,omitempty"`
+	Remove  []string `json:"remove,omitempty"`
 }
 
 // DisplaySnapshot represents the snapshot structure for GET responses or display purposes
@@ -56,7 +181,14 @@ type DisplaySnapshot struct {
 	Name      string    `json:"name"`
 	Urls      []string  `json:"urls,omitempty"`
 	Targets   []string  `json:"targets,omitempty"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
 	TimeStamp time.Time `json:"timestamp"`
+	// Label and Tags come from the metadata document and are only set
+	// in listings.
+	Label string            `json:"label,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+	// Shard names the agent shard holding the scrape file.
+	Shard string `json:"shard,omitempty"`
 }
 
 type Cluster struct {
@@ -64,3 +196,58 @@ type Cluster struct {
 	UID     string   `json:"uid"`
 	Targets []string `json:"targets,omitempty"`
 }
+
+// CustomPanelsPatchRequest is the payload for
+// PATCH /api/v1/snapshot/{id}/custom_panels. Presets are expanded by name
+// and applied before CustomPanels; a panel whose title is already on the
+// snapshot replaces it, any other is appended.
+type CustomPanelsPatchRequest struct {
+	Presets      []string             `json:"presets,omitempty"`
+	CustomPanels []CustomPanelsConfig `json:"custom_panels,omitempty"`
+}
+
+// CustomPanelsResponse is returned by PATCH /api/v1/snapshot/{id}/custom_panels
+// with the snapshot's panels after the update.
+type CustomPanelsResponse struct {
+	CustomPanels []CustomPanelsConfig `json:"custom_panels"`
+}
+
+// RestoreRequest is the payload for POST /api/v1/snapshot/{id}/restore.
+// Archives keep no secrets, so Credentials is required when the original
+// request had a password, and TLS when a private key was redacted; TLS
+// then replaces the settings of every config that lost its key. Label
+// replaces the archived label when set and Tags are merged into the
+// archived tags, an empty value removing the key.
+type RestoreRequest struct {
+	Credentials *Credentials      `json:"credentials,omitempty"`
+	TLS         *TLSConfig        `json:"tls,omitempty"`
+	Label       string            `json:"label,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// CloneRequest is the payload for POST /api/v1/snapshot/{id}/clone. Label
+// replaces the source's label when set and Tags are merged into its
+// tags, an empty value removing the key. Credentials and TLS follow the
+// RestoreRequest rules when the source has ended and only its archive is
+// left; for an active source they replace the stored ones when set.
+type CloneRequest struct {
+	Credentials *Credentials      `json:"credentials,omitempty"`
+	TLS         *TLSConfig        `json:"tls,omitempty"`
+	Label       string            `json:"label,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// HealthCheck is the result of one readiness check.
+type HealthCheck struct {
+	Name string `json:"name"`
+	// Shard is the agent shard checked, for the per-shard checks.
+	Shard  string `json:"shard,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthResponse is the response of GET /healthz and GET /readyz.
+type HealthResponse struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
@@ -0,0 +1,175 @@
+// Package overlap finds active snapshots that scrape the same targets or
+// clusters as another one. Two snapshots overlap when they share a
+// host:port endpoint, a DNS name or a cluster UUID from their metadata.
+package overlap
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// Policies for what a create does about an overlap.
+const (
+	// PolicyReject refuses the create with a 409.
+	PolicyReject = "reject"
+	// PolicyWarn creates the snapshot and lists the overlaps in the
+	// response.
+	PolicyWarn = "warn"
+	// PolicyAllow creates the snapshot without looking for overlaps.
+	PolicyAllow = "allow"
+)
+
+// ValidatePolicy returns an error unless policy is one of the Policy*
+// values.
+func ValidatePolicy(policy string) error {
+	switch policy {
+	case PolicyReject, PolicyWarn, PolicyAllow:
+		return nil
+	}
+	return fmt.Errorf("unknown overlap policy %q; must be one of %q, %q or %q", policy, PolicyReject, PolicyWarn, PolicyAllow)
+}
+
+// Snapshot is what overlap detection knows about one snapshot.
+type Snapshot struct {
+	ID    string
+	Label string
+	// Endpoints are normalised host:port pairs and dns:name entries.
+	Endpoints []string
+	// ClusterUUIDs come from the snapshot's metadata.
+	ClusterUUIDs []string
+}
+
+// FromRequest returns the endpoints a request's configs would scrape.
+func FromRequest(id string, req *models.SnapshotRequest) Snapshot {
+	s := Snapshot{ID: id, Label: req.Label}
+	for _, cfg := range req.Configs {
+		for _, hostname := range cfg.Hostnames {
+			if cfg.Type == models.ConfigTypeDNS {
+				s.Endpoints = append(s.Endpoints, dnsEndpoint(hostname))
+				continue
+			}
+			s.Endpoints = append(s.Endpoints, endpoint(hostname, strconv.Itoa(cfg.Port)))
+		}
+	}
+	return s
+}
+
+// FromDisplay returns the endpoints an active snapshot scrapes, read
+// back from its scrape and target files.
+func FromDisplay(d models.DisplaySnapshot) Snapshot {
+	s := Snapshot{ID: d.Name, Label: d.Label}
+	for _, u := range d.Urls {
+		if e := urlEndpoint(u); e != "" {
+			s.Endpoints = append(s.Endpoints, e)
+		}
+	}
+	for _, t := range d.Targets {
+		if i := strings.LastIndex(t, ":"); i > 0 {
+			s.Endpoints = append(s.Endpoints, endpoint(t[:i], t[i+1:]))
+		}
+	}
+	for _, name := range d.DNSNames {
+		s.Endpoints = append(s.Endpoints, dnsEndpoint(name))
+	}
+	return s
+}
+
+// SetClusters records the cluster UUIDs in metadata.
+func (s *Snapshot) SetClusters(clusters []models.Cluster) {
+	s.ClusterUUIDs = s.ClusterUUIDs[:0]
+	for _, c := range clusters {
+		if c.UID != "" {
+			s.ClusterUUIDs = append(s.ClusterUUIDs, c.UID)
+		}
+	}
+}
+
+// Find returns how s overlaps each of others, ordered by snapshot id.
+// s itself is skipped if it appears in others.
+func Find(s Snapshot, others []Snapshot) []models.Overlap {
+	endpoints := toSet(s.Endpoints)
+	clusters := toSet(s.ClusterUUIDs)
+	var out []models.Overlap
+	for _, o := range others {
+		if o.ID == s.ID {
+			continue
+		}
+		overlap := models.Overlap{
+			SnapshotID:   o.ID,
+			Label:        o.Label,
+			Targets:      shared(endpoints, o.Endpoints),
+			ClusterUUIDs: shared(clusters, o.ClusterUUIDs),
+		}
+		if len(overlap.Targets) > 0 || len(overlap.ClusterUUIDs) > 0 {
+			out = append(out, overlap)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SnapshotID < out[j].SnapshotID })
+	return out
+}
+
+// IDs lists the snapshot ids in overlaps, for messages.
+func IDs(overlaps []models.Overlap) string {
+	ids := make([]string, len(overlaps))
+	for i, o := range overlaps {
+		ids[i] = o.SnapshotID
+	}
+	return strings.Join(ids, ", ")
+}
+
+// endpoint normalises a host and port. Host names are case-insensitive
+// and may carry a trailing dot; IPv6 literals may be bracketed.
+func endpoint(host, port string) string {
+	host = strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+	return host + ":" + port
+}
+
+func dnsEndpoint(name string) string {
+	return "dns:" + strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// urlEndpoint returns the host:port of an http_sd URL, which the scrape
+// file always writes with an explicit port.
+func urlEndpoint(u string) string {
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+	}
+	if i := strings.Index(u, "/"); i >= 0 {
+		u = u[:i]
+	}
+	i := strings.LastIndex(u, ":")
+	if i <= 0 {
+		return ""
+	}
+	return endpoint(u[:i], u[i+1:])
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// shared returns the values of b that are in a, sorted and deduplicated.
+func shared(a map[string]struct{}, b []string) []string {
+	var out []string
+	seen := make(map[string]struct{})
+	for _, v := range b {
+		if _, ok := a[v]; !ok {
+			continue
+		}
+		if _, dup := seen[v]; dup {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
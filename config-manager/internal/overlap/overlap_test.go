@@ -0,0 +1,55 @@
+package overlap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+func TestFind(t *testing.T) {
+	req := &models.SnapshotRequest{
+		Label: "new",
+		Configs: []models.ConfigObject{
+			{Hostnames: []string{"CB1.example.com.", "cb2.example.com"}, Port: 8091, Type: models.ConfigTypeSD},
+			{Hostnames: []string{"sgw1"}, Port: 4986, Type: models.ConfigTypeStatic},
+			{Hostnames: []string{"_couchbases._tcp.cloud.example.com"}, Type: models.ConfigTypeDNS},
+		},
+	}
+	s := FromRequest("", req)
+	s.SetClusters([]models.Cluster{{UID: "c-1"}})
+
+	active := []Snapshot{
+		FromDisplay(models.DisplaySnapshot{Name: "sd", Urls: []string{"https://cb1.example.com:8091/prometheus_sd_config?clusterLabels=uuidOnly"}}),
+		FromDisplay(models.DisplaySnapshot{Name: "other-port", Targets: []string{"sgw1:9100"}}),
+		FromDisplay(models.DisplaySnapshot{Name: "dns", DNSNames: []string{"_couchbases._tcp.cloud.example.com."}}),
+		{ID: "cluster", Label: "by uuid", ClusterUUIDs: []string{"c-1"}},
+	}
+
+	got := Find(s, active)
+	want := []models.Overlap{
+		{SnapshotID: "cluster", Label: "by uuid", ClusterUUIDs: []string{"c-1"}},
+		{SnapshotID: "dns", Targets: []string{"dns:_couchbases._tcp.cloud.example.com"}},
+		{SnapshotID: "sd", Targets: []string{"cb1.example.com:8091"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Find = %+v\nwant %+v", got, want)
+	}
+
+	// A snapshot doesn't overlap itself.
+	self := FromDisplay(models.DisplaySnapshot{Name: "sd", Urls: []string{"http://cb1.example.com:8091/x"}})
+	if got := Find(self, active); len(got) != 0 {
+		t.Errorf("Find against itself = %+v, want none", got)
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	for _, p := range []string{PolicyReject, PolicyWarn, PolicyAllow} {
+		if err := ValidatePolicy(p); err != nil {
+			t.Errorf("ValidatePolicy(%q) = %v", p, err)
+		}
+	}
+	if err := ValidatePolicy("ignore"); err == nil {
+		t.Error("ValidatePolicy accepted an unknown policy")
+	}
+}
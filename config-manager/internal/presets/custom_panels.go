@@ -1,8 +1,9 @@
 // Package presets owns the canned "custom_panels" templates that
-// config-manager expands when a caller (perfrunner) passes a boolean
-// opt-in on the SnapshotRequest. Each preset corresponds to one tab in
-// the cbmonitor UI; the request's flags decide which presets land in
-// the snapshot's metadata document.
+// config-manager expands when a caller (perfrunner) asks for them on the
+// SnapshotRequest, either by name in `presets` or through the legacy
+// boolean opt-ins. Each preset corresponds to one tab in the cbmonitor
+// UI; the request decides which presets land in the snapshot's metadata
+// document.
 package presets
 
 import "github.com/couchbase/config-manager/internal/models"
@@ -23,20 +24,10 @@ var Capella = models.CustomPanelsConfig{
 	RateMatch: ".*_total",
 }
 
-// BuildCustomPanels turns the SnapshotRequest's preset flags into the
-// ordered slice that lands in `SnapshotMetadata.CustomPanels`. Order is
-// stable: cbagent first when set, then capella. Returns nil when no
-// flag is set so the JSON `custom_panels` field is omitted entirely.
-func BuildCustomPanels(req *models.SnapshotRequest) []models.CustomPanelsConfig {
-	if req == nil {
-		return nil
-	}
-	var out []models.CustomPanelsConfig
-	if req.Cbagent {
-		out = append(out, Cbagent)
-	}
-	if req.Capella {
-		out = append(out, Capella)
-	}
-	return out
+// builtins are always registered, under these names, so the `cbagent`
+// and `capella` request booleans keep working without a presets
+// directory. A file with the same name replaces the built-in.
+var builtins = []Preset{
+	{Name: "cbagent", CustomPanelsConfig: Cbagent},
+	{Name: "capella", CustomPanelsConfig: Capella},
 }
@@ -0,0 +1,206 @@
+package presets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/couchbase/config-manager/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Preset is a named custom-panels template.
+type Preset struct {
+	Name string `json:"name"`
+	// Source is the file the preset was loaded from; empty for built-ins.
+	Source string `json:"source,omitempty"`
+	models.CustomPanelsConfig
+}
+
+// Registry is an immutable set of presets keyed by name.
+type Registry struct {
+	presets map[string]Preset
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Default returns a registry holding only the built-in presets.
+func Default() *Registry {
+	r := &Registry{presets: make(map[string]Preset, len(builtins))}
+	for _, p := range builtins {
+		r.presets[p.Name] = p
+	}
+	return r
+}
+
+// Load returns the built-in presets plus every *.yaml, *.yml and *.json
+// file in dir, one preset per file. A file without a `name` is named
+// after its basename. An empty dir loads only the built-ins. Any
+// invalid file fails the whole load so a typo in one preset can't
+// silently drop it.
+func Load(dir string) (*Registry, error) {
+	r := Default()
+	if dir == "" {
+		return r, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read presets directory: %w", err)
+	}
+
+	loaded := make(map[string]string)
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		p, err := loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if prev, ok := loaded[p.Name]; ok {
+			return nil, fmt.Errorf("preset %q is defined in both %s and %s", p.Name, prev, path)
+		}
+		loaded[p.Name] = path
+		r.presets[p.Name] = *p
+	}
+	return r, nil
+}
+
+// loadFile parses and validates a single preset file. YAML is converted
+// to JSON first so the CustomPanelsConfig json tags (including the
+// camelCase override keys cbmonitor expects) are the only field names.
+func loadFile(path string) (*Preset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read preset %s: %w", path, err)
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".json") {
+		var generic interface{}
+		if err := yaml.Unmarshal(data, &generic); err != nil {
+			return nil, fmt.Errorf("failed to parse preset %s: %w", path, err)
+		}
+		if data, err = json.Marshal(generic); err != nil {
+			return nil, fmt.Errorf("failed to parse preset %s: %w", path, err)
+		}
+	}
+
+	var p Preset
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("failed to parse preset %s: %w", path, err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	p.Source = path
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid preset %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *Preset) validate() error {
+	if !validName.MatchString(p.Name) {
+		return fmt.Errorf("name %q must be lowercase letters, digits, '-' or '_'", p.Name)
+	}
+	if err := ValidateCustomPanel(p.CustomPanelsConfig); err != nil {
+		return err
+	}
+	if p.Title == "" {
+		p.Title = p.Name
+	}
+	return nil
+}
+
+// List returns every preset sorted by name.
+func (r *Registry) List() []Preset {
+	out := make([]Preset, 0, len(r.presets))
+	for _, p := range r.presets {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Get returns the named preset.
+func (r *Registry) Get(name string) (Preset, bool) {
+	p, ok := r.presets[name]
+	return p, ok
+}
+
+// BuildCustomPanels turns the SnapshotRequest's panel selection into
+// the ordered slice that lands in `SnapshotMetadata.CustomPanels`. The
+// legacy booleans come first (cbagent, then capella), followed by
+// `presets` in request order, then the request's inline `custom_panels`;
+// repeated preset names are dropped. Returns nil when nothing is
+// selected so the JSON `custom_panels` field is omitted entirely.
+//
+// Inline panels are expected to have passed ValidateCustomPanels. One
+// whose title matches a selected preset is an error rather than a
+// silent override, since either reading could be what the caller meant.
+func (r *Registry) BuildCustomPanels(req *models.SnapshotRequest) ([]models.CustomPanelsConfig, error) {
+	if req == nil {
+		return nil, nil
+	}
+	names := make([]string, 0, len(req.Presets)+2)
+	if req.Cbagent {
+		names = append(names, "cbagent")
+	}
+	if req.Capella {
+		names = append(names, "capella")
+	}
+	names = append(names, req.Presets...)
+
+	var out []models.CustomPanelsConfig
+	seen := make(map[string]struct{}, len(names))
+	titles := make(map[string]string, len(names))
+	for _, name := range names {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		p, ok := r.presets[name]
+		if !ok {
+			return nil, &SelectionError{Field: "presets", Message: fmt.Sprintf("unknown preset %q", name)}
+		}
+		titles[p.Title] = name
+		out = append(out, p.CustomPanelsConfig)
+	}
+	for i, cp := range req.CustomPanels {
+		if name, ok := titles[cp.Title]; ok {
+			return nil, &SelectionError{Field: "custom_panels", Message: fmt.Sprintf("custom_panels[%d]: title %q is already used by preset %q", i, cp.Title, name)}
+		}
+		out = append(out, cp)
+	}
+	return out, nil
+}
+
+// ResolvePresets returns the panels for the named presets, in order.
+func (r *Registry) ResolvePresets(names []string) ([]models.CustomPanelsConfig, error) {
+	out := make([]models.CustomPanelsConfig, 0, len(names))
+	for _, name := range names {
+		p, ok := r.presets[name]
+		if !ok {
+			return nil, &SelectionError{Field: "presets", Message: fmt.Sprintf("unknown preset %q", name)}
+		}
+		out = append(out, p.CustomPanelsConfig)
+	}
+	return out, nil
+}
+
+// SelectionError reports which request field a bad panel selection came
+// from, so the API can return it in the error envelope.
+type SelectionError struct {
+	Field   string
+	Message string
+}
+
+func (e *SelectionError) Error() string { return e.Message }
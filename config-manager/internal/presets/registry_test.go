@@ -0,0 +1,142 @@
+package presets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+func writePreset(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writePreset(t, dir, "magma.yaml", `
+title: Magma
+match: "kv_magma_.*"
+rate_match: ".*_total"
+overrides:
+  kv_magma_compactions_total:
+    title: Compactions
+    transformFunction: rate
+`)
+	writePreset(t, dir, "capella.json", `{"title":"Capella (custom)","match":"capella_.*"}`)
+	writePreset(t, dir, "README.md", "ignored")
+
+	r, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, p := range r.List() {
+		names = append(names, p.Name)
+	}
+	if want := []string{"capella", "cbagent", "magma"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+
+	magma, _ := r.Get("magma")
+	if magma.Overrides["kv_magma_compactions_total"].TransformFunction != "rate" {
+		t.Fatalf("override not parsed: %+v", magma.Overrides)
+	}
+	if capella, _ := r.Get("capella"); capella.Title != "Capella (custom)" {
+		t.Fatalf("file did not replace built-in: %+v", capella)
+	}
+}
+
+func TestLoadRejectsInvalidPresets(t *testing.T) {
+	cases := map[string]string{
+		"bad match":      `match: "kv_(.*"`,
+		"bad rate_match": "match: kv_.*\nrate_match: \"kv_(?!bytes).*\"",
+		"missing match":  `title: x`,
+		"unknown field":  "match: kv_.*\nmatches: kv_.*",
+		"bad name":       "name: My Preset\nmatch: kv_.*",
+	}
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writePreset(t, dir, "p.yaml", content)
+			if _, err := Load(dir); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadRejectsDuplicateNames(t *testing.T) {
+	dir := t.TempDir()
+	writePreset(t, dir, "a.yaml", "name: team\nmatch: a_.*")
+	writePreset(t, dir, "b.yaml", "name: team\nmatch: b_.*")
+	if _, err := Load(dir); err == nil || !strings.Contains(err.Error(), "team") {
+		t.Fatalf("err = %v, want duplicate name error", err)
+	}
+}
+
+func TestBuildCustomPanels(t *testing.T) {
+	r := Default()
+
+	got, err := r.BuildCustomPanels(&models.SnapshotRequest{Capella: true, Presets: []string{"cbagent", "capella"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []models.CustomPanelsConfig{Capella, Cbagent}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	if got, err := r.BuildCustomPanels(&models.SnapshotRequest{}); err != nil || got != nil {
+		t.Fatalf("empty selection = %v, %v; want nil, nil", got, err)
+	}
+
+	if _, err := r.BuildCustomPanels(&models.SnapshotRequest{Presets: []string{"nope"}}); err == nil {
+		t.Fatal("expected an unknown preset error")
+	}
+}
+
+func TestValidateCustomPanels(t *testing.T) {
+	valid := models.CustomPanelsConfig{
+		Title:     "Experimental",
+		Match:     "exp_.*",
+		Overrides: map[string]models.CustomPanelOverride{"exp_ops_total": {TransformFunction: "increase"}},
+	}
+	if err := ValidateCustomPanels([]models.CustomPanelsConfig{valid}); err != nil {
+		t.Fatalf("valid panel rejected: %v", err)
+	}
+
+	cases := map[string][]models.CustomPanelsConfig{
+		"missing title":     {{Match: "exp_.*"}},
+		"duplicate title":   {valid, valid},
+		"bad regex":         {{Title: "x", Match: "exp_(?=ops)"}},
+		"unknown transform": {{Title: "x", Match: "exp_.*", Overrides: map[string]models.CustomPanelOverride{"exp": {TransformFunction: "deriv"}}}},
+		"bad rate_match":    {{Title: "x", Match: "exp_.*", RateMatch: "("}},
+		"missing match":     {{Title: "x"}},
+	}
+	for name, panels := range cases {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateCustomPanels(panels); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestBuildCustomPanelsRejectsTitleClash(t *testing.T) {
+	req := &models.SnapshotRequest{
+		Presets:      []string{"cbagent"},
+		CustomPanels: []models.CustomPanelsConfig{{Title: "cbagent", Match: "x_.*"}},
+	}
+	_, err := Default().BuildCustomPanels(req)
+	var sel *SelectionError
+	if !errors.As(err, &sel) || sel.Field != "custom_panels" {
+		t.Fatalf("err = %v, want a custom_panels SelectionError", err)
+	}
+}
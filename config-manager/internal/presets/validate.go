@@ -0,0 +1,68 @@
+package presets
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// TransformFunctions are the override transformFunction values cbmonitor
+// knows how to render. It rejects anything else at query time, so the
+// same list is enforced here before a panel is stored.
+var TransformFunctions = []string{"rate", "irate", "increase"}
+
+// ValidateCustomPanel checks one panel definition: `match` is required,
+// both regexes must compile under RE2 (a subset of what cbmonitor's
+// JavaScript engine accepts, so anything valid here also works there),
+// and every override's transformFunction must be known.
+func ValidateCustomPanel(cp models.CustomPanelsConfig) error {
+	if cp.Match == "" {
+		return fmt.Errorf("match is required")
+	}
+	if _, err := regexp.Compile(cp.Match); err != nil {
+		return fmt.Errorf("match: %w", err)
+	}
+	if cp.RateMatch != "" {
+		if _, err := regexp.Compile(cp.RateMatch); err != nil {
+			return fmt.Errorf("rate_match: %w", err)
+		}
+	}
+	for metric, ov := range cp.Overrides {
+		if ov.TransformFunction != "" && !knownTransform(ov.TransformFunction) {
+			return fmt.Errorf("overrides.%s.transformFunction: %q is not one of %s", metric, ov.TransformFunction, strings.Join(TransformFunctions, ", "))
+		}
+	}
+	return nil
+}
+
+// ValidateCustomPanels checks inline panels sent on a request. On top of
+// ValidateCustomPanel, each needs a title and titles must be unique,
+// since the title is both the cbmonitor tab name and the key PATCH uses
+// to replace a panel.
+func ValidateCustomPanels(panels []models.CustomPanelsConfig) error {
+	seen := make(map[string]struct{}, len(panels))
+	for i, cp := range panels {
+		if strings.TrimSpace(cp.Title) == "" {
+			return fmt.Errorf("custom_panels[%d]: title is required", i)
+		}
+		if _, ok := seen[cp.Title]; ok {
+			return fmt.Errorf("custom_panels[%d]: duplicate title %q", i, cp.Title)
+		}
+		seen[cp.Title] = struct{}{}
+		if err := ValidateCustomPanel(cp); err != nil {
+			return fmt.Errorf("custom_panels[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func knownTransform(fn string) bool {
+	for _, known := range TransformFunctions {
+		if fn == known {
+			return true
+		}
+	}
+	return false
+}
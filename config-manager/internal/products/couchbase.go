@@ -3,6 +3,7 @@ package products
 import (
 	"fmt"
 
+	"github.com/couchbase/config-manager/internal/models"
 	"github.com/couchbase/config-manager/internal/services"
 )
 
@@ -47,8 +48,11 @@ func init() {
 // collectCouchbaseMetadata wraps services.MetadataService so the product
 // registry owns the API surface while the HTTP plumbing stays in
 // internal/services/metadata.go.
-func collectCouchbaseMetadata(scheme, hostname string, port int, username, password string) (*Metadata, error) {
-	svc := services.NewMetadataService()
+func collectCouchbaseMetadata(scheme, hostname string, port int, username, password string, tls *models.TLSConfig) (*Metadata, error) {
+	svc, err := services.NewMetadataService(tls)
+	if err != nil {
+		return nil, err
+	}
 	md, err := svc.CollectClusterMetadata(hostname, port, username, password, scheme)
 	if err != nil {
 		return nil, err
@@ -11,7 +11,20 @@
 // need to special-case it.
 package products
 
-import "github.com/couchbase/config-manager/internal/models"
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/metrics"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/services"
+)
 
 // Product is one entry in the registry. All fields are optional — a
 // product can support SD only, metadata only, neither, or both.
@@ -33,8 +46,9 @@ type Product struct {
 
 	// GetMetadata performs product-specific metadata collection against
 	// a single hostname. Returns (nil, nil) when there's nothing to
-	// report (the handler treats that the same as "no fetcher").
-	GetMetadata func(scheme, hostname string, port int, username, password string) (*Metadata, error)
+	// report (the handler treats that the same as "no fetcher"). tls is
+	// the config's TLS settings and is nil for plain http.
+	GetMetadata func(scheme, hostname string, port int, username, password string, tls *models.TLSConfig) (*Metadata, error)
 }
 
 // Metadata is the per-host result of GetMetadata. For backward
@@ -71,3 +85,61 @@ func register(p *Product) {
 func Get(name string) *Product {
 	return registry[name]
 }
+
+// CollectMetadata calls the product's GetMetadata and records its
+// duration and any failure, by reason, in the product metadata fetch
+// metrics. Callers should use it instead of calling GetMetadata
+// directly.
+func (p *Product) CollectMetadata(scheme, hostname string, port int, username, password string, tls *models.TLSConfig) (*Metadata, error) {
+	start := time.Now()
+	md, err := p.GetMetadata(scheme, hostname, port, username, password, tls)
+	metrics.ProductMetadataFetchDuration.WithLabelValues(p.Name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ProductMetadataFetchErrors.WithLabelValues(p.Name, errorReason(err)).Inc()
+	}
+	return md, err
+}
+
+// errorReason buckets a metadata fetch error into a small, fixed set of
+// metric label values.
+func errorReason(err error) string {
+	var statusErr *services.StatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden {
+			return "auth"
+		}
+		return "http_status"
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostnameErr      x509.HostnameError
+		certInvalid      x509.CertificateInvalidError
+		recordHeader     tls.RecordHeaderError
+		verifyErr        *tls.CertificateVerificationError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &certInvalid) || errors.As(err, &recordHeader) ||
+		errors.As(err, &verifyErr) {
+		return "tls"
+	}
+
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return "connection"
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return "decode"
+	}
+	return "other"
+}
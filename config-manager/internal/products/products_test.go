@@ -0,0 +1,39 @@
+package products
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/services"
+)
+
+func TestErrorReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"unauthorized", fmt.Errorf("failed to get services: %w", &services.StatusError{StatusCode: 401}), "auth"},
+		{"forbidden", &services.StatusError{StatusCode: 403}, "auth"},
+		{"server error", &services.StatusError{StatusCode: 500}, "http_status"},
+		{"timeout", &url.Error{Op: "Get", URL: "http://h", Err: context.DeadlineExceeded}, "timeout"},
+		{"refused", &url.Error{Op: "Get", URL: "http://h", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, "connection"},
+		{"dns", &url.Error{Op: "Get", URL: "http://h", Err: &net.DNSError{Name: "h", Err: "no such host"}}, "connection"},
+		{"unknown ca", &url.Error{Op: "Get", URL: "https://h", Err: x509.UnknownAuthorityError{}}, "tls"},
+		{"decode", &json.SyntaxError{}, "decode"},
+		{"other", errors.New("boom"), "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorReason(tt.err); got != tt.want {
+				t.Errorf("errorReason(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
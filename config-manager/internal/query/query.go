@@ -0,0 +1,340 @@
+// Package query implements the filter language of POST
+// /api/v1/snapshots/query over snapshot metadata. A query is a list of
+// conditions that must all match. Match evaluates one in memory, and
+// Compile turns it into parameterised SQL++ for the Couchbase store.
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// Operators.
+const (
+	// OpEq matches a field equal to Value. On a list field, any element
+	// may match.
+	OpEq = "eq"
+	// OpPrefix matches a field starting with Value.
+	OpPrefix = "prefix"
+	// OpIn matches a field equal to one of Values.
+	OpIn = "in"
+	// OpRange matches a timestamp at or after From and before To. Either
+	// bound may be left out.
+	OpRange = "range"
+	// OpExists matches a field that is set and not empty.
+	OpExists = "exists"
+)
+
+// Result limits.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// TagPrefix starts a field naming one tag, e.g. tags.owner.
+const TagPrefix = "tags."
+
+type kind int
+
+const (
+	kindString kind = iota
+	kindList
+	kindPhases
+	kindTag
+	kindTime
+)
+
+// fields maps each queryable field to its kind.
+var fields = map[string]kind{
+	"id":            kindString,
+	"label":         kindString,
+	"server":        kindString,
+	"created_by":    kindString,
+	"ended_by":      kindString,
+	"shard":         kindString,
+	"cloned_from":   kindString,
+	"restored_from": kindString,
+	"template":      kindString,
+	"services":      kindList,
+	"products":      kindList,
+	"phases":        kindPhases,
+	"ts_start":      kindTime,
+	"ts_end":        kindTime,
+}
+
+// Error is a query that can't be run, with the offending field of the
+// request body.
+type Error struct {
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+func fieldKind(field string) (kind, bool) {
+	if key, ok := strings.CutPrefix(field, TagPrefix); ok {
+		return kindTag, key != ""
+	}
+	k, ok := fields[field]
+	return k, ok
+}
+
+// Validate checks every condition names a known field and an operator
+// that applies to it, with the operands it needs.
+func Validate(q models.MetadataQuery) error {
+	if q.Limit < 0 || q.Limit > MaxLimit {
+		return &Error{Field: "limit", Message: fmt.Sprintf("must be between 0 and %d", MaxLimit)}
+	}
+	for i, c := range q.Where {
+		path := fmt.Sprintf("where[%d]", i)
+		k, ok := fieldKind(c.Field)
+		if !ok {
+			return &Error{Field: path + ".field", Message: fmt.Sprintf("unknown field %q", c.Field)}
+		}
+		switch c.Op {
+		case OpEq, OpPrefix:
+			if k == kindTime {
+				return &Error{Field: path + ".op", Message: fmt.Sprintf("%s only supports %s", c.Field, OpRange)}
+			}
+			if c.Value == "" {
+				return &Error{Field: path + ".value", Message: "is required for " + c.Op}
+			}
+		case OpIn:
+			if k == kindTime {
+				return &Error{Field: path + ".op", Message: fmt.Sprintf("%s only supports %s", c.Field, OpRange)}
+			}
+			if len(c.Values) == 0 {
+				return &Error{Field: path + ".values", Message: "is required for " + c.Op}
+			}
+		case OpRange:
+			if k != kindTime {
+				return &Error{Field: path + ".op", Message: "range only applies to ts_start and ts_end"}
+			}
+			if c.From == nil && c.To == nil {
+				return &Error{Field: path + ".from", Message: "range needs from, to or both"}
+			}
+			if c.From != nil && c.To != nil && !c.From.Before(*c.To) {
+				return &Error{Field: path + ".to", Message: "must be after from"}
+			}
+		case OpExists:
+			if k == kindTime {
+				return &Error{Field: path + ".op", Message: fmt.Sprintf("%s only supports %s", c.Field, OpRange)}
+			}
+		default:
+			return &Error{Field: path + ".op", Message: fmt.Sprintf("unknown operator %q; must be one of %s, %s, %s, %s or %s", c.Op, OpEq, OpPrefix, OpIn, OpRange, OpExists)}
+		}
+	}
+	return nil
+}
+
+// Limit returns the number of results q asks for.
+func Limit(q models.MetadataQuery) int {
+	if q.Limit == 0 {
+		return DefaultLimit
+	}
+	return q.Limit
+}
+
+// Match reports whether m satisfies every condition of q. A running
+// snapshot's ts_end counts as now.
+func Match(q models.MetadataQuery, m *models.SnapshotMetadata, now time.Time) bool {
+	for _, c := range q.Where {
+		if !matchCondition(c, m, now) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchCondition(c models.QueryCondition, m *models.SnapshotMetadata, now time.Time) bool {
+	k, _ := fieldKind(c.Field)
+	switch k {
+	case kindTime:
+		t := m.TsStart
+		if c.Field == "ts_end" {
+			t = now
+			if m.TsEnd != "" && m.TsEnd != "now" {
+				parsed, err := time.Parse(time.RFC3339Nano, m.TsEnd)
+				if err != nil {
+					return false
+				}
+				t = parsed
+			}
+		}
+		return (c.From == nil || !t.Before(*c.From)) && (c.To == nil || t.Before(*c.To))
+	case kindTag:
+		value, ok := m.Tags[strings.TrimPrefix(c.Field, TagPrefix)]
+		return ok && matchValue(c, value)
+	case kindString:
+		return matchValue(c, stringField(c.Field, m))
+	}
+
+	values := m.Services
+	switch c.Field {
+	case "products":
+		values = m.Products
+	case "phases":
+		values = make([]string, len(m.Phases))
+		for i, p := range m.Phases {
+			values[i] = p.Label
+		}
+	}
+	if c.Op == OpExists {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if matchValue(c, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchValue(c models.QueryCondition, v string) bool {
+	switch c.Op {
+	case OpEq:
+		return v == c.Value
+	case OpPrefix:
+		return strings.HasPrefix(v, c.Value)
+	case OpIn:
+		for _, want := range c.Values {
+			if v == want {
+				return true
+			}
+		}
+		return false
+	case OpExists:
+		return v != ""
+	}
+	return false
+}
+
+func stringField(field string, m *models.SnapshotMetadata) string {
+	switch field {
+	case "id":
+		return m.SnapshotID
+	case "label":
+		return m.Label
+	case "server":
+		return m.Server
+	case "created_by":
+		return m.CreatedBy
+	case "ended_by":
+		return m.EndedBy
+	case "shard":
+		return m.Shard
+	case "cloned_from":
+		return m.ClonedFrom
+	case "restored_from":
+		return m.RestoredFrom
+	case "template":
+		return m.Template
+	}
+	return ""
+}
+
+// Filter returns the documents matching q, newest first, and whether
+// more matched than its limit.
+func Filter(q models.MetadataQuery, docs []models.SnapshotMetadata, now time.Time) ([]models.SnapshotMetadata, bool) {
+	out := []models.SnapshotMetadata{}
+	for i := range docs {
+		if Match(q, &docs[i], now) {
+			out = append(out, docs[i])
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if !out[i].TsStart.Equal(out[j].TsStart) {
+			return out[i].TsStart.After(out[j].TsStart)
+		}
+		return out[i].SnapshotID < out[j].SnapshotID
+	})
+	if limit := Limit(q); len(out) > limit {
+		return out[:limit], true
+	}
+	return out, false
+}
+
+// IndexFields are the keys of the GSI index Compile's statements use.
+// Every statement constrains ts_start, so the index covers every query.
+const IndexFields = "(ts_start, label, server)"
+
+// Compile turns q into a SQL++ statement over keyspace with named
+// parameters. It selects one row more than the limit so the caller can
+// tell when results were truncated. now stands in for a running
+// snapshot's ts_end.
+func Compile(q models.MetadataQuery, keyspace string, now time.Time) (string, map[string]interface{}) {
+	params := map[string]interface{}{
+		"limit": Limit(q) + 1,
+	}
+	param := func(v interface{}) string {
+		name := fmt.Sprintf("p%d", len(params)-1)
+		params[name] = v
+		return "$" + name
+	}
+
+	where := []string{"m.ts_start IS VALUED"}
+	for _, c := range q.Where {
+		where = append(where, compileCondition(c, param, now))
+	}
+
+	statement := "SELECT m.* FROM " + keyspace + " AS m WHERE " + strings.Join(where, " AND ") +
+		" ORDER BY STR_TO_MILLIS(m.ts_start) DESC, META(m).id LIMIT $limit"
+	return statement, params
+}
+
+func compileCondition(c models.QueryCondition, param func(interface{}) string, now time.Time) string {
+	k, _ := fieldKind(c.Field)
+	switch k {
+	case kindTime:
+		expr := "STR_TO_MILLIS(m.ts_start)"
+		if c.Field == "ts_end" {
+			expr = "(CASE WHEN m.ts_end IS NOT VALUED OR m.ts_end = \"now\" THEN " + param(now.UnixMilli()) + " ELSE STR_TO_MILLIS(m.ts_end) END)"
+		}
+		var terms []string
+		if c.From != nil {
+			terms = append(terms, expr+" >= "+param(c.From.UnixMilli()))
+		}
+		if c.To != nil {
+			terms = append(terms, expr+" < "+param(c.To.UnixMilli()))
+		}
+		return "(" + strings.Join(terms, " AND ") + ")"
+	case kindTag:
+		return compileValue(c, "m.tags.["+param(strings.TrimPrefix(c.Field, TagPrefix))+"]", param)
+	case kindString:
+		return compileValue(c, "m.`"+c.Field+"`", param)
+	}
+
+	if c.Op == OpExists {
+		return "ARRAY_LENGTH(m.`" + c.Field + "`) > 0"
+	}
+	element := "v"
+	if k == kindPhases {
+		element = "v.label"
+	}
+	return "ANY v IN m.`" + c.Field + "` SATISFIES " + compileValue(c, element, param) + " END"
+}
+
+func compileValue(c models.QueryCondition, expr string, param func(interface{}) string) string {
+	switch c.Op {
+	case OpEq:
+		return expr + " = " + param(c.Value)
+	case OpPrefix:
+		return expr + " LIKE " + param(likePrefix(c.Value))
+	case OpIn:
+		return expr + " IN " + param(c.Values)
+	}
+	return "(" + expr + " IS VALUED AND " + expr + " != \"\")"
+}
+
+// likePrefix escapes the LIKE wildcards in prefix, using SQL++'s default
+// backslash escape, and appends %.
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
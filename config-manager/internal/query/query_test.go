@@ -0,0 +1,132 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+func TestFilter(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	lastWeek := now.Add(-7 * 24 * time.Hour)
+	docs := []models.SnapshotMetadata{
+		{SnapshotID: "old", Label: "kv_load", Server: "7.2.4", Services: []string{"kv"}, TsStart: now.Add(-30 * 24 * time.Hour), TsEnd: now.Add(-29 * 24 * time.Hour).Format(time.RFC3339Nano)},
+		{SnapshotID: "a", Label: "kv_rebalance", Server: "7.6.2", Services: []string{"kv", "index"}, Phases: []models.Phase{{Label: "load"}, {Label: "access"}}, TsStart: now.Add(-2 * 24 * time.Hour), TsEnd: "now", Tags: map[string]string{"owner": "perf"}},
+		{SnapshotID: "b", Label: "n1ql", Server: "7.6.2", Services: []string{"n1ql"}, Products: []string{"couchbase", "sgw"}, TsStart: now.Add(-24 * time.Hour), TsEnd: now.Add(-time.Hour).Format(time.RFC3339Nano)},
+	}
+
+	tests := []struct {
+		name  string
+		where []models.QueryCondition
+		want  []string
+	}{
+		{"everything, newest first", nil, []string{"b", "a", "old"}},
+		{"server and phase", []models.QueryCondition{
+			{Field: "server", Op: OpEq, Value: "7.6.2"},
+			{Field: "services", Op: OpEq, Value: "index"},
+			{Field: "phases", Op: OpEq, Value: "access"},
+		}, []string{"a"}},
+		{"label prefix last week", []models.QueryCondition{
+			{Field: "label", Op: OpPrefix, Value: "kv_"},
+			{Field: "ts_start", Op: OpRange, From: &lastWeek},
+		}, []string{"a"}},
+		{"in", []models.QueryCondition{{Field: "label", Op: OpIn, Values: []string{"n1ql", "kv_load"}}}, []string{"b", "old"}},
+		{"products exist", []models.QueryCondition{{Field: "products", Op: OpExists}}, []string{"b"}},
+		{"phases exist", []models.QueryCondition{{Field: "phases", Op: OpExists}}, []string{"a"}},
+		{"tag", []models.QueryCondition{{Field: "tags.owner", Op: OpEq, Value: "perf"}}, []string{"a"}},
+		// A running snapshot's ts_end is now.
+		{"ended or running in the last hour", []models.QueryCondition{{Field: "ts_end", Op: OpRange, From: ptr(now.Add(-time.Hour))}}, []string{"b", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := Filter(models.MetadataQuery{Where: tt.where}, docs, now)
+			if truncated {
+				t.Error("unexpectedly truncated")
+			}
+			ids := []string{}
+			for _, m := range got {
+				ids = append(ids, m.SnapshotID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("Filter = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	got, truncated := Filter(models.MetadataQuery{Limit: 2}, docs, now)
+	if len(got) != 2 || !truncated {
+		t.Errorf("Filter with limit 2 = %d results, truncated %v", len(got), truncated)
+	}
+}
+
+func ptr(t time.Time) *time.Time { return &t }
+
+func TestValidate(t *testing.T) {
+	from := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	valid := models.MetadataQuery{Where: []models.QueryCondition{
+		{Field: "tags.owner", Op: OpExists},
+		{Field: "ts_end", Op: OpRange, From: &from},
+	}}
+	if err := Validate(valid); err != nil {
+		t.Errorf("Validate = %v", err)
+	}
+
+	for field, c := range map[string]models.QueryCondition{
+		"where[0].field":  {Field: "password", Op: OpEq, Value: "x"},
+		"where[0].op":     {Field: "label", Op: "like", Value: "x"},
+		"where[0].value":  {Field: "label", Op: OpPrefix},
+		"where[0].values": {Field: "services", Op: OpIn},
+		"where[0].from":   {Field: "ts_start", Op: OpRange},
+		"where[0].to":     {Field: "ts_start", Op: OpRange, From: &from, To: &from},
+	} {
+		err := Validate(models.MetadataQuery{Where: []models.QueryCondition{c}})
+		var qe *Error
+		if !errors.As(err, &qe) || qe.Field != field {
+			t.Errorf("Validate(%+v) = %v, want an error on %s", c, err, field)
+		}
+	}
+	if err := Validate(models.MetadataQuery{Limit: MaxLimit + 1}); err == nil {
+		t.Error("Validate accepted a limit over the maximum")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	q := models.MetadataQuery{Where: []models.QueryCondition{
+		{Field: "label", Op: OpPrefix, Value: "kv_100%"},
+		{Field: "phases", Op: OpEq, Value: "access"},
+		{Field: "tags.owner", Op: OpIn, Values: []string{"perf"}},
+		{Field: "ts_end", Op: OpRange, To: &now},
+	}, Limit: 10}
+	statement, params := Compile(q, "`metadata`.`_default`.`_default`", now)
+
+	want := "SELECT m.* FROM `metadata`.`_default`.`_default` AS m WHERE m.ts_start IS VALUED" +
+		" AND m.`label` LIKE $p0" +
+		" AND ANY v IN m.`phases` SATISFIES v.label = $p1 END" +
+		" AND m.tags.[$p2] IN $p3" +
+		` AND ((CASE WHEN m.ts_end IS NOT VALUED OR m.ts_end = "now" THEN $p4 ELSE STR_TO_MILLIS(m.ts_end) END) < $p5)` +
+		" ORDER BY STR_TO_MILLIS(m.ts_start) DESC, META(m).id LIMIT $limit"
+	if statement != want {
+		t.Errorf("statement =\n%s\nwant\n%s", statement, want)
+	}
+	wantParams := map[string]interface{}{
+		"limit": 11,
+		"p0":    `kv\_100\%%`,
+		"p1":    "access",
+		"p2":    "owner",
+		"p3":    []string{"perf"},
+		"p4":    now.UnixMilli(),
+		"p5":    now.UnixMilli(),
+	}
+	if !reflect.DeepEqual(params, wantParams) {
+		t.Errorf("params = %v, want %v", params, wantParams)
+	}
+	// Values only ever reach the statement as parameters.
+	if strings.Contains(statement, "perf") || strings.Contains(statement, "access") {
+		t.Error("statement embeds a value")
+	}
+}
@@ -0,0 +1,194 @@
+// Package quota holds the admission limits applied when a snapshot is
+// created: how many may be active at once, overall and per caller or
+// tag, and how large a single request may be. A zero limit is unlimited.
+package quota
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/couchbase/config-manager/internal/apierror"
+	"github.com/couchbase/config-manager/internal/config"
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// Limit names, used in errors, metrics and the quota endpoint.
+const (
+	LimitActive    = "max_active_snapshots"
+	LimitPerCaller = "max_active_per_caller"
+	LimitPerTag    = "max_active_per_tag"
+	LimitTargets   = "max_targets_per_snapshot"
+	LimitHostnames = "max_hostnames_per_config"
+)
+
+// Limits are the configured admission limits.
+type Limits struct {
+	MaxActive          int            `json:"max_active_snapshots"`
+	MaxActivePerCaller int            `json:"max_active_per_caller"`
+	MaxActivePerTag    map[string]int `json:"max_active_per_tag,omitempty"`
+	MaxTargets         int            `json:"max_targets_per_snapshot"`
+	MaxHostnames       int            `json:"max_hostnames_per_config"`
+}
+
+// FromConfig returns the limits in cfg.
+func FromConfig(cfg *config.Config) Limits {
+	return Limits{
+		MaxActive:          cfg.Limits.MaxActiveSnapshots,
+		MaxActivePerCaller: cfg.Limits.MaxActivePerCaller,
+		MaxActivePerTag:    cfg.Limits.MaxActivePerTag,
+		MaxTargets:         cfg.Limits.MaxTargetsPerSnapshot,
+		MaxHostnames:       cfg.Limits.MaxHostnamesPerConfig,
+	}
+}
+
+// CountsOwners reports whether admission needs each active snapshot's
+// caller and tags, which cost a metadata or request file lookup apiece.
+func (l Limits) CountsOwners() bool {
+	return l.MaxActivePerCaller > 0 || len(l.MaxActivePerTag) > 0
+}
+
+// Snapshot is an active snapshot as admission sees it.
+type Snapshot struct {
+	ID     string
+	Caller string
+	Tags   map[string]string
+}
+
+// Usage is how much of each count limit the active snapshots use.
+// ByTag is keyed by tag name, then value.
+type Usage struct {
+	Active   int                       `json:"active"`
+	ByCaller map[string]int            `json:"by_caller"`
+	ByTag    map[string]map[string]int `json:"by_tag"`
+}
+
+// Count tallies the active snapshots. Snapshots without a caller aren't
+// counted against any caller, and only the tags l limits are tallied.
+func (l Limits) Count(snapshots []Snapshot) Usage {
+	u := Usage{
+		Active:   len(snapshots),
+		ByCaller: make(map[string]int),
+		ByTag:    make(map[string]map[string]int, len(l.MaxActivePerTag)),
+	}
+	for key := range l.MaxActivePerTag {
+		u.ByTag[key] = make(map[string]int)
+	}
+	for _, s := range snapshots {
+		if s.Caller != "" {
+			u.ByCaller[s.Caller]++
+		}
+		for key, counts := range u.ByTag {
+			if value, ok := s.Tags[key]; ok {
+				counts[value]++
+			}
+		}
+	}
+	return u
+}
+
+// Report is the response of GET /api/v1/quota.
+type Report struct {
+	Limits Limits `json:"limits"`
+	Usage  Usage  `json:"usage"`
+	// Caller is the authenticated caller the report was built for;
+	// empty when auth is disabled.
+	Caller string `json:"caller,omitempty"`
+}
+
+// Error is a request refused by a limit. Count limits are 429s, since
+// the same request succeeds once other snapshots end; size limits are
+// 422s, since it never will.
+type Error struct {
+	Status  int
+	Code    string
+	Field   string
+	Limit   string
+	Max     int
+	Current int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// CheckRequest applies the size limits to req.
+func (l Limits) CheckRequest(req *models.SnapshotRequest) *Error {
+	targets := 0
+	for i, c := range req.Configs {
+		if l.MaxHostnames > 0 && len(c.Hostnames) > l.MaxHostnames {
+			return &Error{
+				Status:  http.StatusUnprocessableEntity,
+				Code:    apierror.CodeLimitExceeded,
+				Field:   fmt.Sprintf("configs[%d].hostnames", i),
+				Limit:   LimitHostnames,
+				Max:     l.MaxHostnames,
+				Current: len(c.Hostnames),
+				Message: fmt.Sprintf("config %d has %d hostnames; at most %d are allowed", i, len(c.Hostnames), l.MaxHostnames),
+			}
+		}
+		targets += len(c.Hostnames)
+	}
+	return l.CheckTargets("configs", targets)
+}
+
+// CheckTargets applies the per-snapshot target limit to n targets,
+// reported against field.
+func (l Limits) CheckTargets(field string, n int) *Error {
+	if l.MaxTargets > 0 && n > l.MaxTargets {
+		return &Error{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    apierror.CodeLimitExceeded,
+			Field:   field,
+			Limit:   LimitTargets,
+			Max:     l.MaxTargets,
+			Current: n,
+			Message: fmt.Sprintf("snapshot would have %d targets; at most %d are allowed", n, l.MaxTargets),
+		}
+	}
+	return nil
+}
+
+// Admit applies the count limits to one more snapshot for caller with
+// tags, given the current usage.
+func (l Limits) Admit(u Usage, caller string, tags map[string]string) *Error {
+	if l.MaxActive > 0 && u.Active >= l.MaxActive {
+		return tooMany(LimitActive, l.MaxActive, u.Active,
+			fmt.Sprintf("%d snapshots are active; at most %d are allowed", u.Active, l.MaxActive))
+	}
+	if l.MaxActivePerCaller > 0 && caller != "" && u.ByCaller[caller] >= l.MaxActivePerCaller {
+		return tooMany(LimitPerCaller, l.MaxActivePerCaller, u.ByCaller[caller],
+			fmt.Sprintf("caller %s has %d active snapshots; at most %d are allowed", caller, u.ByCaller[caller], l.MaxActivePerCaller))
+	}
+	keys := make([]string, 0, len(l.MaxActivePerTag))
+	for key := range l.MaxActivePerTag {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, ok := tags[key]
+		max := l.MaxActivePerTag[key]
+		if !ok || max <= 0 {
+			continue
+		}
+		if n := u.ByTag[key][value]; n >= max {
+			e := tooMany(LimitPerTag, max, n,
+				fmt.Sprintf("%d active snapshots have tag %s=%s; at most %d are allowed", n, key, value, max))
+			e.Field = "tags." + key
+			return e
+		}
+	}
+	return nil
+}
+
+func tooMany(limit string, max, current int, message string) *Error {
+	return &Error{
+		Status:  http.StatusTooManyRequests,
+		Code:    apierror.CodeQuotaExceeded,
+		Limit:   limit,
+		Max:     max,
+		Current: current,
+		Message: message,
+	}
+}
@@ -0,0 +1,71 @@
+package quota
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+func TestAdmit(t *testing.T) {
+	limits := Limits{MaxActive: 4, MaxActivePerCaller: 2, MaxActivePerTag: map[string]int{"owner": 1}}
+	usage := limits.Count([]Snapshot{
+		{ID: "a", Caller: "perf", Tags: map[string]string{"owner": "perf-team"}},
+		{ID: "b", Caller: "perf"},
+		{ID: "c", Tags: map[string]string{"build": "1"}},
+	})
+	if usage.Active != 3 || usage.ByCaller["perf"] != 2 || usage.ByTag["owner"]["perf-team"] != 1 {
+		t.Fatalf("usage = %+v", usage)
+	}
+	if _, ok := usage.ByTag["build"]; ok {
+		t.Error("usage tallied a tag without a limit")
+	}
+
+	for name, tc := range map[string]struct {
+		caller string
+		tags   map[string]string
+		limit  string
+	}{
+		"admitted":           {caller: "ci", tags: map[string]string{"owner": "qe"}},
+		"anonymous":          {tags: map[string]string{"build": "2"}},
+		"caller at limit":    {caller: "perf", limit: LimitPerCaller},
+		"tag value at limit": {caller: "ci", tags: map[string]string{"owner": "perf-team"}, limit: LimitPerTag},
+	} {
+		err := limits.Admit(usage, tc.caller, tc.tags)
+		switch {
+		case tc.limit == "" && err != nil:
+			t.Errorf("%s: refused: %v", name, err)
+		case tc.limit != "" && (err == nil || err.Limit != tc.limit || err.Status != http.StatusTooManyRequests):
+			t.Errorf("%s: got %+v, want a 429 for %s", name, err, tc.limit)
+		}
+	}
+
+	usage.Active = 4
+	if err := limits.Admit(usage, "ci", nil); err == nil || err.Limit != LimitActive || err.Current != 4 || err.Max != 4 {
+		t.Errorf("global limit: got %+v", err)
+	}
+	if err := (Limits{}).Admit(usage, "perf", map[string]string{"owner": "perf-team"}); err != nil {
+		t.Errorf("zero limits refused: %v", err)
+	}
+}
+
+func TestCheckRequest(t *testing.T) {
+	limits := Limits{MaxTargets: 3, MaxHostnames: 2}
+	req := &models.SnapshotRequest{Configs: []models.ConfigObject{
+		{Hostnames: []string{"a", "b"}},
+		{Hostnames: []string{"c"}},
+	}}
+	if err := limits.CheckRequest(req); err != nil {
+		t.Fatalf("refused a request within limits: %v", err)
+	}
+
+	req.Configs[1].Hostnames = []string{"c", "d", "e"}
+	if err := limits.CheckRequest(req); err == nil || err.Limit != LimitHostnames || err.Field != "configs[1].hostnames" || err.Status != http.StatusUnprocessableEntity {
+		t.Errorf("hostnames limit: got %+v", err)
+	}
+
+	req.Configs[1].Hostnames = []string{"c", "d"}
+	if err := limits.CheckRequest(req); err == nil || err.Limit != LimitTargets || err.Current != 4 {
+		t.Errorf("targets limit: got %+v", err)
+	}
+}
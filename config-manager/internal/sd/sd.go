@@ -0,0 +1,228 @@
+// Package sd builds Prometheus HTTP service discovery target groups from
+// active snapshots, for agents that can't read config-manager's agent
+// directory. Static and file targets come from the snapshot itself;
+// sd-type configs are resolved by fetching their discovery endpoints,
+// whose answers are cached.
+package sd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/metrics"
+	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/services"
+	"github.com/couchbase/config-manager/internal/storage"
+)
+
+// Labels set on the target groups.
+const (
+	// LabelJob is the snapshot id, matching the job label of scrapes
+	// through the agent directory.
+	LabelJob = "job"
+	// LabelProduct is the config's product, when it has one.
+	LabelProduct = "product"
+	// LabelScheme makes Prometheus scrape an https config's targets over
+	// https.
+	LabelScheme = "__scheme__"
+)
+
+// idleTTL is how long a cached answer nobody asks for is kept.
+const idleTTL = time.Hour
+
+// maxResponse bounds a discovery endpoint's answer.
+const maxResponse = 8 << 20
+
+// Source is one active snapshot to build target groups for.
+type Source struct {
+	ID string
+	// Request is the request the snapshot was created from. It is nil
+	// for snapshots created before requests were kept; Targets, from
+	// the scrape file, stand in for it then.
+	Request *models.SnapshotRequest
+	// Files are the snapshot's current file_sd target groups by scheme.
+	Files   map[string][]models.TargetGroup
+	Targets []string
+}
+
+// Resolver fetches the discovery endpoints of sd-type configs, caching
+// each answer for its TTL. When a fetch fails, the last answer is served
+// instead, so a flapping endpoint doesn't make its targets disappear.
+type Resolver struct {
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*cacheEntry
+	// now is time.Now outside tests.
+	now func() time.Time
+}
+
+type cacheEntry struct {
+	groups  []models.TargetGroup
+	fetched time.Time
+	used    time.Time
+}
+
+// NewResolver returns a Resolver caching answers for ttl and giving up on
+// an endpoint after timeout.
+func NewResolver(ttl, timeout time.Duration) *Resolver {
+	return &Resolver{ttl: ttl, timeout: timeout, cache: map[string]*cacheEntry{}, now: time.Now}
+}
+
+// Resolve returns the target groups url answers with. On a failed fetch
+// it returns the last answer with the error, or no groups if there was
+// none.
+func (r *Resolver) Resolve(url string, creds models.Credentials, tlsSettings *models.TLSConfig) ([]models.TargetGroup, error) {
+	key := url + "|" + creds.Username
+	now := r.now()
+
+	r.mu.Lock()
+	for k, e := range r.cache {
+		if now.Sub(e.used) > idleTTL {
+			delete(r.cache, k)
+		}
+	}
+	entry, ok := r.cache[key]
+	if ok {
+		entry.used = now
+		if now.Sub(entry.fetched) < r.ttl {
+			groups := entry.groups
+			r.mu.Unlock()
+			metrics.SDResolutions.WithLabelValues("cached").Inc()
+			return groups, nil
+		}
+	}
+	r.mu.Unlock()
+
+	groups, err := r.fetch(url, creds, tlsSettings)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		if entry, ok := r.cache[key]; ok {
+			metrics.SDResolutions.WithLabelValues("stale").Inc()
+			return entry.groups, err
+		}
+		metrics.SDResolutions.WithLabelValues("failed").Inc()
+		return nil, err
+	}
+	r.cache[key] = &cacheEntry{groups: groups, fetched: now, used: now}
+	metrics.SDResolutions.WithLabelValues("fetched").Inc()
+	return groups, nil
+}
+
+func (r *Resolver) fetch(url string, creds models.Credentials, tlsSettings *models.TLSConfig) ([]models.TargetGroup, error) {
+	tlsCfg, err := services.TLSClientConfig(tlsSettings)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Timeout:   r.timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsCfg, DisableKeepAlives: true},
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(creds.Username, creds.Password)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &services.StatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	var groups []models.TargetGroup
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponse)).Decode(&groups); err != nil {
+		return nil, fmt.Errorf("%s: invalid service discovery response: %w", url, err)
+	}
+	return groups, nil
+}
+
+// Build returns src's target groups: one per static config, its file
+// target groups, and the groups its sd-type configs' endpoints answer
+// with. Each is labelled with the snapshot id as job, the config's
+// product and, for https, the scheme. DNS configs can't be expressed as
+// targets and are left out. Endpoints that can't be resolved are
+// returned as errors alongside the groups that could be built.
+func (r *Resolver) Build(src Source) ([]models.TargetGroup, []error) {
+	out := []models.TargetGroup{}
+	if src.Request == nil {
+		if len(src.Targets) > 0 {
+			out = append(out, models.TargetGroup{Targets: src.Targets, Labels: map[string]string{LabelJob: src.ID}})
+		}
+		return out, nil
+	}
+
+	req := src.Request
+	var errs []error
+	fileConfigs := map[string][]models.ConfigObject{}
+	for _, cfg := range req.Configs {
+		scheme := cfg.Scheme
+		if scheme == "" {
+			scheme = req.Scheme
+		}
+		if scheme == "" {
+			scheme = "http"
+		}
+		switch cfg.Type {
+		case models.ConfigTypeStatic:
+			targets := make([]string, len(cfg.Hostnames))
+			for i, hostname := range cfg.Hostnames {
+				targets[i] = fmt.Sprintf("%s:%d", hostname, cfg.Port)
+			}
+			out = append(out, models.TargetGroup{Targets: targets, Labels: labels(src.ID, cfg.Product, scheme, nil)})
+		case models.ConfigTypeFile:
+			fileConfigs[scheme] = append(fileConfigs[scheme], cfg)
+		case models.ConfigTypeSD, "":
+			for _, hostname := range cfg.Hostnames {
+				url := storage.SDURL(scheme, hostname, cfg.Port, cfg.Product, cfg.SDPath, cfg.UseAltAddresses)
+				groups, err := r.Resolve(url, req.Credentials, cfg.TLS)
+				if err != nil {
+					errs = append(errs, err)
+				}
+				for _, g := range groups {
+					out = append(out, models.TargetGroup{Targets: g.Targets, Labels: labels(src.ID, cfg.Product, scheme, g.Labels)})
+				}
+			}
+		}
+	}
+
+	// The file groups of a scheme follow its file configs in order until
+	// a PATCH .../targets replaces them with one group, which keeps the
+	// first config's product.
+	for _, scheme := range []string{"http", "https"} {
+		for i, g := range src.Files[scheme] {
+			product := ""
+			if cfgs := fileConfigs[scheme]; i < len(cfgs) {
+				product = cfgs[i].Product
+			}
+			if len(g.Targets) == 0 {
+				continue
+			}
+			out = append(out, models.TargetGroup{Targets: g.Targets, Labels: labels(src.ID, product, scheme, g.Labels)})
+		}
+	}
+	return out, errs
+}
+
+// labels returns extra with the job, product and scheme labels set.
+func labels(id, product, scheme string, extra map[string]string) map[string]string {
+	out := make(map[string]string, len(extra)+3)
+	for k, v := range extra {
+		out[k] = v
+	}
+	out[LabelJob] = id
+	if product != "" {
+		out[LabelProduct] = product
+	}
+	if scheme == "https" {
+		out[LabelScheme] = scheme
+	}
+	return out
+}
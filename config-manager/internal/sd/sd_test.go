@@ -0,0 +1,89 @@
+package sd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+func TestBuild(t *testing.T) {
+	fetches := 0
+	failing := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if user, pass, _ := r.BasicAuth(); user != "u" || pass != "p" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode([]models.TargetGroup{{Targets: []string{"node1:9091"}, Labels: map[string]string{"job": "upstream", "node": "n1"}}})
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	src := Source{
+		ID: "snap",
+		Request: &models.SnapshotRequest{
+			Credentials: models.Credentials{Username: "u", Password: "p"},
+			Scheme:      "http",
+			Configs: []models.ConfigObject{
+				{Type: models.ConfigTypeSD, Hostnames: []string{u.Hostname()}, Port: port, Product: "couchbase", SDPath: "/sd"},
+				{Type: models.ConfigTypeStatic, Hostnames: []string{"a", "b"}, Port: 9100, Product: "sgw", Scheme: "https"},
+				{Type: models.ConfigTypeFile, Hostnames: []string{"c"}, Port: 9100, Product: "node"},
+				{Type: models.ConfigTypeDNS, Hostnames: []string{"_prom._tcp.example.com"}},
+			},
+		},
+		Files: map[string][]models.TargetGroup{"http": {{Targets: []string{"c:9100", "d:9100"}}}},
+	}
+	want := []models.TargetGroup{
+		{Targets: []string{"node1:9091"}, Labels: map[string]string{"job": "snap", "product": "couchbase", "node": "n1"}},
+		{Targets: []string{"a:9100", "b:9100"}, Labels: map[string]string{"job": "snap", "product": "sgw", "__scheme__": "https"}},
+		{Targets: []string{"c:9100", "d:9100"}, Labels: map[string]string{"job": "snap", "product": "node"}},
+	}
+
+	now := time.Now()
+	r := NewResolver(time.Minute, time.Second)
+	r.now = func() time.Time { return now }
+	groups, errs := r.Build(src)
+	if len(errs) != 0 || !reflect.DeepEqual(groups, want) {
+		t.Fatalf("Build = %+v, %v\nwant %+v", groups, errs, want)
+	}
+
+	// Within the TTL the answer comes from the cache.
+	if groups, _ := r.Build(src); fetches != 1 || !reflect.DeepEqual(groups, want) {
+		t.Errorf("second Build fetched %d times, groups %+v", fetches, groups)
+	}
+
+	// After it, a failed fetch serves the last answer.
+	now = now.Add(2 * time.Minute)
+	failing = true
+	groups, errs = r.Build(src)
+	if fetches != 2 || len(errs) != 1 || !reflect.DeepEqual(groups, want) {
+		t.Errorf("Build with a failing endpoint fetched %d times = %+v, %v; want the cached answer and an error", fetches, groups, errs)
+	}
+
+	// Without one, its targets are left out.
+	groups, errs = NewResolver(time.Minute, time.Second).Build(src)
+	if len(errs) != 1 || !reflect.DeepEqual(groups, want[1:]) {
+		t.Errorf("Build without a cached answer = %+v, %v", groups, errs)
+	}
+}
+
+func TestBuildWithoutRequest(t *testing.T) {
+	groups, errs := NewResolver(time.Minute, time.Second).Build(Source{ID: "old", Targets: []string{"a:9100"}})
+	want := []models.TargetGroup{{Targets: []string{"a:9100"}, Labels: map[string]string{"job": "old"}}}
+	if len(errs) != 0 || !reflect.DeepEqual(groups, want) {
+		t.Errorf("Build = %+v, %v; want %+v", groups, errs, want)
+	}
+}
@@ -1,7 +1,6 @@
 package services
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -16,22 +15,38 @@ type prometheusSDConfigEntry struct {
 	Labels  map[string]string `json:"labels"`
 }
 
+// StatusError is returned when a metadata endpoint answers with a
+// non-200 status, so callers can tell auth failures from other errors.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: status %d", e.URL, e.StatusCode)
+}
+
 // MetadataService handles collection of Couchbase cluster metadata
 type MetadataService struct {
 	httpClient *http.Client
 }
 
-// NewMetadataService creates a new metadata service instance.
-// TLS verification is disabled to support self-signed certificates used in our test environment.
-func NewMetadataService() *MetadataService {
+// NewMetadataService creates a new metadata service instance. The TLS
+// settings are the snapshot config's, so metadata collection verifies
+// (and presents client certificates) exactly like scraping does.
+func NewMetadataService(tlsSettings *models.TLSConfig) (*MetadataService, error) {
+	tlsCfg, err := TLSClientConfig(tlsSettings)
+	if err != nil {
+		return nil, err
+	}
 	return &MetadataService{
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				TLSClientConfig: tlsCfg,
 			},
 		},
-	}
+	}, nil
 }
 
 // CollectClusterMetadata collects metadata from a Couchbase cluster
@@ -80,7 +95,7 @@ func (ms *MetadataService) GetMetadata(baseURL, username, password string) ([]st
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("failed to get services: status %d", resp.StatusCode)
+		return nil, "", &StatusError{URL: url, StatusCode: resp.StatusCode}
 	}
 
 	var poolInfo models.PoolsDefault
@@ -129,7 +144,7 @@ func (ms *MetadataService) GetClusters(baseURL, username, password string) ([]mo
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get clusters: status %d", resp.StatusCode)
+		return nil, &StatusError{URL: endpoint.String(), StatusCode: resp.StatusCode}
 	}
 
 	var entries []prometheusSDConfigEntry
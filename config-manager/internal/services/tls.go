@@ -0,0 +1,42 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// TLSClientConfig turns a request's TLS settings into a client config.
+// A nil input yields a config that verifies against the system roots.
+func TLSClientConfig(c *models.TLSConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c == nil {
+		return tlsCfg, nil
+	}
+
+	tlsCfg.ServerName = c.ServerName
+	tlsCfg.InsecureSkipVerify = c.InsecureSkipVerify
+
+	if c.CA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(c.CA)) {
+			return nil, fmt.Errorf("ca does not contain any PEM certificates")
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if (c.Cert == "") != (c.Key == "") {
+		return nil, fmt.Errorf("cert and key must be provided together")
+	}
+	if c.Cert != "" {
+		pair, err := tls.X509KeyPair([]byte(c.Cert), []byte(c.Key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{pair}
+	}
+
+	return tlsCfg, nil
+}
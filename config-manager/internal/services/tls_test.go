@@ -0,0 +1,92 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// selfSigned returns a PEM certificate and its key.
+func selfSigned(t *testing.T) (certPEM, keyPEM string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "config-manager"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestTLSClientConfig(t *testing.T) {
+	cert, key := selfSigned(t)
+	cases := []struct {
+		name     string
+		tls      *models.TLSConfig
+		wantErr  bool
+		roots    bool
+		certs    int
+		server   string
+		insecure bool
+	}{
+		{name: "default", tls: nil},
+		{name: "empty", tls: &models.TLSConfig{}},
+		{name: "ca only", tls: &models.TLSConfig{CA: cert}, roots: true},
+		{name: "cert and key", tls: &models.TLSConfig{Cert: cert, Key: key}, certs: 1},
+		{name: "server name", tls: &models.TLSConfig{ServerName: "cb.local"}, server: "cb.local"},
+		{name: "insecure", tls: &models.TLSConfig{InsecureSkipVerify: true}, insecure: true},
+		{name: "cert without key", tls: &models.TLSConfig{Cert: cert}, wantErr: true},
+		{name: "key without cert", tls: &models.TLSConfig{Key: key}, wantErr: true},
+		{name: "bad ca", tls: &models.TLSConfig{CA: "not a certificate"}, wantErr: true},
+		{name: "mismatched key", tls: &models.TLSConfig{Cert: cert, Key: cert}, wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := TLSClientConfig(tc.tls)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("TLSClientConfig succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (cfg.RootCAs != nil) != tc.roots {
+				t.Errorf("RootCAs set = %v, want %v", cfg.RootCAs != nil, tc.roots)
+			}
+			if len(cfg.Certificates) != tc.certs {
+				t.Errorf("got %d certificates, want %d", len(cfg.Certificates), tc.certs)
+			}
+			if cfg.ServerName != tc.server {
+				t.Errorf("ServerName = %q, want %q", cfg.ServerName, tc.server)
+			}
+			if cfg.InsecureSkipVerify != tc.insecure {
+				t.Errorf("InsecureSkipVerify = %v, want %v", cfg.InsecureSkipVerify, tc.insecure)
+			}
+		})
+	}
+}
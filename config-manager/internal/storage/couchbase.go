@@ -2,22 +2,31 @@ package storage
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/couchbase/config-manager/internal/config"
 	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/metrics"
+	"github.com/couchbase/config-manager/internal/migrate"
 	"github.com/couchbase/config-manager/internal/models"
+	"github.com/couchbase/config-manager/internal/query"
 	"github.com/couchbase/gocb/v2"
 )
 
 // CouchbaseStorage handles storing metadata in Couchbase
 type CouchbaseStorage struct {
-	cluster *gocb.Cluster
-	bucket  *gocb.Bucket
-	config  *config.Config
+	cluster    *gocb.Cluster
+	bucket     *gocb.Bucket
+	collection *gocb.Collection
+	keyspace   string
+	config     *config.Config
 }
 
 // NewCouchbaseStorage creates a new Couchbase storage instance
@@ -26,25 +35,57 @@ func NewCouchbaseStorage(cfg *config.Config) (*CouchbaseStorage, error) {
 		return nil, fmt.Errorf("metadata storage is disabled")
 	}
 
-	// Build connection string. If the configured host already includes a
-	// URI scheme, use it as-is; otherwise default to plaintext couchbase://
-	connectionString := cfg.Metadata.Host
-	if !strings.Contains(connectionString, "://") {
-		connectionString = fmt.Sprintf("couchbase://%s", connectionString)
+	cluster, bucket, err := ConnectCouchbase(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Writing to a collection that doesn't exist would fail on every
+	// operation, so refuse to start with it.
+	if err := checkCollection(bucket, cfg.Metadata.Scope, cfg.Metadata.Collection, cfg.Metadata.Timeout); err != nil {
+		cluster.Close(nil)
+		return nil, err
+	}
+
+	// Metadata queries filter on ts_start first. Without the index they
+	// fail, but everything else still works, so this is only a warning.
+	keyspace := fmt.Sprintf("`%s`.`%s`.`%s`", cfg.Metadata.Bucket, cfg.Metadata.Scope, cfg.Metadata.Collection)
+	_, err = cluster.Query(
+		"CREATE INDEX `idx_metadata_query` IF NOT EXISTS ON "+keyspace+query.IndexFields,
+		&gocb.QueryOptions{Timeout: cfg.Metadata.Timeout},
+	)
+	if err != nil {
+		logger.Warn("Failed to create metadata query index; snapshot queries will fail until it exists", "keyspace", keyspace, "error", err)
+	}
+
+	logger.Info("Connected to Couchbase for metadata storage",
+		"host", cfg.Metadata.Host,
+		"bucket", cfg.Metadata.Bucket,
+		"scope", cfg.Metadata.Scope,
+		"collection", cfg.Metadata.Collection)
+
+	return &CouchbaseStorage{
+		cluster:    cluster,
+		bucket:     bucket,
+		collection: bucket.Scope(cfg.Metadata.Scope).Collection(cfg.Metadata.Collection),
+		keyspace:   keyspace,
+		config:     cfg,
+	}, nil
+}
+
+// ConnectCouchbase connects to the configured metadata cluster and waits
+// for its bucket to be ready. Other stores kept in the metadata bucket
+// (e.g. the audit log) use it so they connect the same way.
+func ConnectCouchbase(cfg *config.Config) (*gocb.Cluster, *gocb.Bucket, error) {
+	connectionString, opts, err := couchbaseOptions(cfg)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Connect to Couchbase cluster
-	cluster, err := gocb.Connect(connectionString, gocb.ClusterOptions{
-		Authenticator: gocb.PasswordAuthenticator{
-			Username: cfg.Metadata.Username,
-			Password: cfg.Metadata.Password,
-		},
-		TimeoutsConfig: gocb.TimeoutsConfig{
-			ConnectTimeout: cfg.Metadata.Timeout,
-		},
-	})
+	cluster, err := gocb.Connect(connectionString, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Couchbase cluster: %w", err)
+		return nil, nil, fmt.Errorf("failed to connect to Couchbase cluster: %w", err)
 	}
 
 	// Get bucket reference
@@ -59,24 +100,103 @@ func NewCouchbaseStorage(cfg *config.Config) (*CouchbaseStorage, error) {
 	})
 	if err != nil {
 		cluster.Close(nil)
-		return nil, fmt.Errorf("bucket not ready: %w", err)
+		return nil, nil, fmt.Errorf("bucket not ready: %w", err)
 	}
 
-	logger.Info("Connected to Couchbase for metadata storage",
-		"host", cfg.Metadata.Host,
-		"bucket", cfg.Metadata.Bucket)
+	return cluster, bucket, nil
+}
 
-	return &CouchbaseStorage{
-		cluster: cluster,
-		bucket:  bucket,
-		config:  cfg,
-	}, nil
+// couchbaseOptions builds the connection string and options for the
+// metadata cluster. A host that already includes a URI scheme is used
+// as-is; otherwise it defaults to couchbase://, or couchbases:// with
+// TLS. The CA and client certificate only apply to a TLS connection.
+func couchbaseOptions(cfg *config.Config) (string, gocb.ClusterOptions, error) {
+	m := cfg.Metadata
+	connectionString := m.Host
+	if !strings.Contains(connectionString, "://") {
+		scheme := "couchbase"
+		if m.TLS {
+			scheme = "couchbases"
+		}
+		connectionString = fmt.Sprintf("%s://%s", scheme, connectionString)
+	}
+	secure := strings.HasPrefix(connectionString, "couchbases://")
+
+	opts := gocb.ClusterOptions{
+		Authenticator: gocb.PasswordAuthenticator{
+			Username: m.Username,
+			Password: m.Password,
+		},
+		TimeoutsConfig: gocb.TimeoutsConfig{
+			ConnectTimeout: m.Timeout,
+		},
+	}
+
+	if !secure && (m.CAFile != "" || m.CertFile != "" || m.KeyFile != "" || m.InsecureSkipVerify) {
+		return "", opts, fmt.Errorf("metadata: ca_file, cert_file, key_file and insecure_skip_verify need tls or a couchbases:// host")
+	}
+	if m.TLS && !secure {
+		return "", opts, fmt.Errorf("metadata: tls is set but host %q uses another scheme", m.Host)
+	}
+	if (m.CertFile == "") != (m.KeyFile == "") {
+		return "", opts, fmt.Errorf("metadata: cert_file and key_file must be set together")
+	}
+
+	if m.CAFile != "" {
+		pem, err := os.ReadFile(m.CAFile)
+		if err != nil {
+			return "", opts, fmt.Errorf("metadata: failed to read ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return "", opts, fmt.Errorf("metadata: no certificates found in ca_file %s", m.CAFile)
+		}
+		opts.SecurityConfig.TLSRootCAs = pool
+	}
+	opts.SecurityConfig.TLSSkipVerify = m.InsecureSkipVerify
+	if m.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(m.CertFile, m.KeyFile)
+		if err != nil {
+			return "", opts, fmt.Errorf("metadata: failed to load client certificate: %w", err)
+		}
+		opts.Authenticator = gocb.CertificateAuthenticator{ClientCertificate: &cert}
+	}
+	return connectionString, opts, nil
+}
+
+// ValidateCouchbaseConfig checks the metadata connection settings, and
+// that any CA and client certificate files load, without connecting.
+func ValidateCouchbaseConfig(cfg *config.Config) error {
+	_, _, err := couchbaseOptions(cfg)
+	return err
+}
+
+// checkCollection returns an error unless the bucket has the collection.
+func checkCollection(bucket *gocb.Bucket, scope, collection string, timeout time.Duration) error {
+	scopes, err := bucket.CollectionsV2().GetAllScopes(&gocb.GetAllScopesOptions{Timeout: timeout})
+	if err != nil {
+		return fmt.Errorf("failed to list collections of bucket %s: %w", bucket.Name(), err)
+	}
+	for _, s := range scopes {
+		if s.Name != scope {
+			continue
+		}
+		for _, c := range s.Collections {
+			if c.Name == collection {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("metadata collection %s.%s does not exist in bucket %s", scope, collection, bucket.Name())
 }
 
-// SaveMetadata saves cluster metadata to Couchbase
+// SaveMetadata saves cluster metadata to Couchbase at the current schema
+// version.
 func (cs *CouchbaseStorage) SaveMetadata(metadata *models.SnapshotMetadata) error {
+	migrate.Stamp(metadata)
+
 	// Upsert the document
-	_, err := cs.bucket.DefaultCollection().Upsert(metadata.SnapshotID, metadata, nil)
+	_, err := cs.collection.Upsert(metadata.SnapshotID, metadata, nil)
 	if err != nil {
 		return fmt.Errorf("failed to save metadata to Couchbase: %w", err)
 	}
@@ -86,27 +206,217 @@ func (cs *CouchbaseStorage) SaveMetadata(metadata *models.SnapshotMetadata) erro
 	return nil
 }
 
-// GetMetadata retrieves cluster metadata from Couchbase
+// GetMetadata retrieves cluster metadata from Couchbase. A document
+// written at an older schema version is migrated and written back, so
+// each one is upgraded the first time it is read.
 func (cs *CouchbaseStorage) GetMetadata(snapshotID string) (*models.SnapshotMetadata, error) {
 	// Get the document
-	result, err := cs.bucket.DefaultCollection().Get(snapshotID, nil)
+	result, err := cs.collection.Get(snapshotID, nil)
 	if err != nil {
 		if errors.Is(err, gocb.ErrDocumentNotFound) {
-			return nil, fmt.Errorf("metadata not found for snapshot %s", snapshotID)
+			return nil, fmt.Errorf("%w for snapshot %s", ErrMetadataNotFound, snapshotID)
 		}
 		return nil, fmt.Errorf("failed to get metadata from Couchbase: %w", err)
 	}
 
+	var doc map[string]interface{}
+	if err := result.Content(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	changed, err := migrate.Document(doc)
+	if err != nil && !errors.Is(err, migrate.ErrNewerVersion) {
+		return nil, fmt.Errorf("failed to migrate metadata for snapshot %s: %w", snapshotID, err)
+	}
+
 	// Decode the document
-	var metadata models.SnapshotMetadata
-	err = result.Content(&metadata)
+	metadata, err := decodeMetadata(doc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode metadata: %w", err)
 	}
 
+	if changed {
+		// Replace rather than upsert so a concurrent write isn't lost; it
+		// is already at the current version.
+		_, err := cs.collection.Replace(snapshotID, doc, &gocb.ReplaceOptions{Cas: result.Cas()})
+		if err != nil && !errors.Is(err, gocb.ErrCasMismatch) {
+			logger.Warn("Failed to write back migrated metadata", "id", snapshotID, "error", err)
+		} else if err == nil {
+			metrics.MetadataMigrations.Inc()
+			logger.Info("Migrated metadata on read", "id", snapshotID, "schema_version", migrate.CurrentVersion)
+		}
+	}
+
+	return metadata, nil
+}
+
+// getForUpdate reads snapshotID's metadata for a read-modify-write. A
+// document from a newer release decodes for reading, but saving it back
+// would downgrade it, so it is refused with ErrMetadataNewerVersion.
+func (cs *CouchbaseStorage) getForUpdate(snapshotID string) (*models.SnapshotMetadata, error) {
+	metadata, err := cs.GetMetadata(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWritable(metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// decodeMetadata turns a migrated raw document into the model.
+func decodeMetadata(doc map[string]interface{}) (*models.SnapshotMetadata, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var metadata models.SnapshotMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
 	return &metadata, nil
 }
 
+// QueryMetadata runs q as a parameterised SQL++ query. Older documents
+// in the results are migrated before decoding but not written back.
+func (cs *CouchbaseStorage) QueryMetadata(q models.MetadataQuery) ([]models.SnapshotMetadata, bool, error) {
+	statement, params := query.Compile(q, cs.keyspace, time.Now())
+	rows, err := cs.cluster.Query(statement, &gocb.QueryOptions{
+		NamedParameters: params,
+		Timeout:         cs.config.Metadata.Timeout,
+		Readonly:        true,
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query metadata: %w", err)
+	}
+	defer rows.Close()
+
+	out := []models.SnapshotMetadata{}
+	for rows.Next() {
+		var doc map[string]interface{}
+		if err := rows.Row(&doc); err != nil {
+			return nil, false, fmt.Errorf("failed to decode metadata: %w", err)
+		}
+		if _, err := migrate.Document(doc); err != nil && !errors.Is(err, migrate.ErrNewerVersion) {
+			return nil, false, fmt.Errorf("failed to migrate metadata: %w", err)
+		}
+		metadata, err := decodeMetadata(doc)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to decode metadata: %w", err)
+		}
+		out = append(out, *metadata)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to query metadata: %w", err)
+	}
+
+	if limit := query.Limit(q); len(out) > limit {
+		return out[:limit], true, nil
+	}
+	return out, false, nil
+}
+
+// MigrationResult counts what a MigrateAll run did.
+type MigrationResult struct {
+	// Scanned is the number of documents below the current version.
+	Scanned  int
+	Migrated int
+	// Skipped documents changed between the query and the write, or
+	// were written by a newer release.
+	Skipped int
+	Failed  int
+}
+
+// MigrateAll upgrades every metadata document below the current schema
+// version. Documents are replaced with CAS, so a concurrent write wins
+// and is already current; a run only selects documents that still need
+// migrating, so it can be repeated or resumed after a failure. With
+// dryRun nothing is written.
+func (cs *CouchbaseStorage) MigrateAll(dryRun bool) (MigrationResult, error) {
+	var res MigrationResult
+
+	// Documents without a version are indexed too, so the backfill finds
+	// them without a primary index.
+	_, err := cs.cluster.Query(
+		"CREATE INDEX `idx_metadata_schema_version` IF NOT EXISTS ON "+cs.keyspace+"(schema_version INCLUDE MISSING)",
+		&gocb.QueryOptions{Timeout: cs.config.Metadata.Timeout},
+	)
+	if err != nil {
+		return res, fmt.Errorf("failed to create schema version index: %w", err)
+	}
+
+	rows, err := cs.cluster.Query(
+		"SELECT RAW META(m).id FROM "+cs.keyspace+" AS m WHERE m.schema_version IS MISSING OR m.schema_version < $version",
+		&gocb.QueryOptions{
+			NamedParameters: map[string]interface{}{"version": migrate.CurrentVersion},
+			Timeout:         cs.config.Metadata.Timeout,
+			Readonly:        true,
+		},
+	)
+	if err != nil {
+		return res, fmt.Errorf("failed to query metadata to migrate: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Row(&id); err != nil {
+			rows.Close()
+			return res, fmt.Errorf("failed to decode metadata id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return res, fmt.Errorf("failed to query metadata to migrate: %w", err)
+	}
+
+	for _, id := range ids {
+		res.Scanned++
+		result, err := cs.collection.Get(id, nil)
+		if errors.Is(err, gocb.ErrDocumentNotFound) {
+			res.Skipped++
+			continue
+		}
+		if err != nil {
+			res.Failed++
+			logger.Warn("Failed to read metadata to migrate", "id", id, "error", err)
+			continue
+		}
+		var doc map[string]interface{}
+		if err := result.Content(&doc); err != nil {
+			res.Failed++
+			logger.Warn("Failed to decode metadata to migrate", "id", id, "error", err)
+			continue
+		}
+		changed, err := migrate.Document(doc)
+		if errors.Is(err, migrate.ErrNewerVersion) || (err == nil && !changed) {
+			res.Skipped++
+			continue
+		}
+		if err == nil {
+			_, err = decodeMetadata(doc)
+		}
+		if err != nil {
+			res.Failed++
+			logger.Warn("Failed to migrate metadata", "id", id, "error", err)
+			continue
+		}
+		if dryRun {
+			res.Migrated++
+			continue
+		}
+		_, err = cs.collection.Replace(id, doc, &gocb.ReplaceOptions{Cas: result.Cas()})
+		switch {
+		case errors.Is(err, gocb.ErrCasMismatch), errors.Is(err, gocb.ErrDocumentNotFound):
+			res.Skipped++
+		case err != nil:
+			res.Failed++
+			logger.Warn("Failed to write migrated metadata", "id", id, "error", err)
+		default:
+			res.Migrated++
+		}
+	}
+	return res, nil
+}
+
 // Close closes the Couchbase connection
 func (cs *CouchbaseStorage) Close() error {
 	if cs.cluster != nil {
@@ -120,14 +430,35 @@ func (cs *CouchbaseStorage) Type() string {
 	return "couchbase"
 }
 
+// Ping checks that the bucket's data service answers within timeout.
+func (cs *CouchbaseStorage) Ping(timeout time.Duration) error {
+	res, err := cs.bucket.Ping(&gocb.PingOptions{
+		ServiceTypes: []gocb.ServiceType{gocb.ServiceTypeKeyValue},
+		Timeout:      timeout,
+	})
+	if err != nil {
+		return err
+	}
+	reports := res.Services[gocb.ServiceTypeKeyValue]
+	if len(reports) == 0 {
+		return fmt.Errorf("no data service endpoints for bucket %s", cs.config.Metadata.Bucket)
+	}
+	for _, r := range reports {
+		if r.State != gocb.PingStateOk {
+			return fmt.Errorf("data service endpoint %s: %s", r.Remote, r.Error)
+		}
+	}
+	return nil
+}
+
 func (cs *CouchbaseStorage) UpdatePhase(snapshotID string, phase string, mode string) error {
 	// Implement Couchbase update phase logic here
-	snapshotMetadata, err := cs.GetMetadata(snapshotID)
+	snapshotMetadata, err := cs.getForUpdate(snapshotID)
 	if err != nil {
 		return fmt.Errorf("failed to get metadata for update: %w", err)
 	}
 	if mode != "start" && mode != "end" {
-		return fmt.Errorf("invalid mode: %s", mode)
+		return fmt.Errorf("%w: %s", ErrInvalidMode, mode)
 	} else if mode == "start" {
 		snapshotMetadata.Phases = append(snapshotMetadata.Phases, models.Phase{
 			Label:   phase,
@@ -147,7 +478,7 @@ func (cs *CouchbaseStorage) UpdatePhase(snapshotID string, phase string, mode st
 }
 
 func (cs *CouchbaseStorage) UpdateServices(snapshotID string, services []string) error {
-	snapshotMetadata, err := cs.GetMetadata(snapshotID)
+	snapshotMetadata, err := cs.getForUpdate(snapshotID)
 	if err != nil {
 		return fmt.Errorf("failed to get metadata for update: %w", err)
 	}
@@ -174,13 +505,53 @@ func (cs *CouchbaseStorage) UpdateServices(snapshotID string, services []string)
 	return nil
 }
 
-func (cs *CouchbaseStorage) EoLSnapshot(snapshotID string) error {
-	eol, err := cs.GetMetadata(snapshotID)
+func (cs *CouchbaseStorage) UpdateTags(snapshotID string, tags map[string]string) error {
+	snapshotMetadata, err := cs.getForUpdate(snapshotID)
+	if err != nil {
+		return fmt.Errorf("failed to get metadata for update: %w", err)
+	}
+
+	if snapshotMetadata.Tags == nil {
+		snapshotMetadata.Tags = make(map[string]string, len(tags))
+	}
+	for key, value := range tags {
+		if value == "" {
+			delete(snapshotMetadata.Tags, key)
+			continue
+		}
+		snapshotMetadata.Tags[key] = value
+	}
+
+	if err := cs.SaveMetadata(snapshotMetadata); err != nil {
+		return fmt.Errorf("failed to save updated metadata: %w", err)
+	}
+
+	return nil
+}
+
+func (cs *CouchbaseStorage) UpdateCustomPanels(snapshotID string, panels []models.CustomPanelsConfig) ([]models.CustomPanelsConfig, error) {
+	snapshotMetadata, err := cs.getForUpdate(snapshotID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata for update: %w", err)
+	}
+
+	snapshotMetadata.CustomPanels = mergeCustomPanels(snapshotMetadata.CustomPanels, panels)
+
+	if err := cs.SaveMetadata(snapshotMetadata); err != nil {
+		return nil, fmt.Errorf("failed to save updated metadata: %w", err)
+	}
+
+	return snapshotMetadata.CustomPanels, nil
+}
+
+func (cs *CouchbaseStorage) EoLSnapshot(snapshotID string, endedBy string) error {
+	eol, err := cs.getForUpdate(snapshotID)
 	if err != nil {
 		return fmt.Errorf("failed to get metadata for deletion: %w", err)
 	}
 
 	eol.TsEnd = time.Now().Format(time.RFC3339Nano)
+	eol.EndedBy = endedBy
 
 	err = cs.SaveMetadata(eol)
 	if err != nil {
@@ -188,4 +559,4 @@ func (cs *CouchbaseStorage) EoLSnapshot(snapshotID string) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/config"
+	"github.com/couchbase/gocb/v2"
+)
+
+// writeCert writes a self-signed certificate and its key as PEM files.
+func writeCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "config-manager"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestCouchbaseOptions(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+
+	cfg := &config.Config{}
+	cfg.Metadata.Host = "cb.example.com"
+	if conn, _, err := couchbaseOptions(cfg); err != nil || conn != "couchbase://cb.example.com" {
+		t.Errorf("plain host = %q, %v", conn, err)
+	}
+
+	cfg.Metadata.TLS = true
+	cfg.Metadata.CAFile = certFile
+	cfg.Metadata.CertFile = certFile
+	cfg.Metadata.KeyFile = keyFile
+	conn, opts, err := couchbaseOptions(cfg)
+	if err != nil || conn != "couchbases://cb.example.com" {
+		t.Fatalf("tls host = %q, %v", conn, err)
+	}
+	if opts.SecurityConfig.TLSRootCAs == nil {
+		t.Error("ca_file was not used as the root CAs")
+	}
+	if _, ok := opts.Authenticator.(gocb.CertificateAuthenticator); !ok {
+		t.Errorf("authenticator = %T, want a client certificate", opts.Authenticator)
+	}
+
+	for name, change := range map[string]func(c *config.Config){
+		"ca without tls":      func(c *config.Config) { c.Metadata.TLS = false; c.Metadata.CertFile, c.Metadata.KeyFile = "", "" },
+		"tls with plain host": func(c *config.Config) { c.Metadata.Host = "couchbase://cb.example.com" },
+		"cert without key":    func(c *config.Config) { c.Metadata.KeyFile = "" },
+		"missing ca file":     func(c *config.Config) { c.Metadata.CAFile = filepath.Join(t.TempDir(), "none.pem") },
+		"ca file is a key":    func(c *config.Config) { c.Metadata.CAFile = keyFile },
+	} {
+		c := *cfg
+		change(&c)
+		if _, _, err := couchbaseOptions(&c); err == nil {
+			t.Errorf("%s: couchbaseOptions succeeded, want an error", name)
+		}
+	}
+
+	// A couchbases:// host needs no tls flag.
+	c := *cfg
+	c.Metadata.TLS = false
+	c.Metadata.Host = "couchbases://cb.example.com"
+	if conn, _, err := couchbaseOptions(&c); err != nil || conn != c.Metadata.Host {
+		t.Errorf("couchbases host = %q, %v", conn, err)
+	}
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors returned (wrapped) by the storage layer. Callers use
+// errors.Is to map them onto API responses instead of matching messages.
+var (
+	// ErrSnapshotNotFound means no scrape config exists for the id.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrMetadataNotFound means the metadata store has no document for the id.
+	ErrMetadataNotFound = errors.New("metadata not found")
+	// ErrNoFileTargets means a targets patch hit a snapshot (or scheme)
+	// without file-type configs.
+	ErrNoFileTargets = errors.New("snapshot has no file-type configs")
+	// ErrSchemeRequired means a targets patch is ambiguous because the
+	// snapshot has file-type configs under both schemes.
+	ErrSchemeRequired = errors.New("scheme is required")
+	// ErrRequestNotFound means a snapshot's original request wasn't kept,
+	// because it was created before config-manager started keeping them.
+	ErrRequestNotFound = errors.New("snapshot request not recorded")
+	// ErrSnapshotExists means a caller-chosen id is already taken.
+	ErrSnapshotExists = errors.New("snapshot id already exists")
+	// ErrNoCapacity means every agent shard that could take a new
+	// snapshot is at capacity.
+	ErrNoCapacity = errors.New("no agent shard has capacity")
+	// ErrInvalidMode means a phase update used a mode other than start/end.
+	ErrInvalidMode = errors.New("invalid phase mode")
+	// ErrQueryUnsupported means the metadata store keeps no documents to
+	// query, as with the file fallback; the caller searches what it holds
+	// itself.
+	ErrQueryUnsupported = errors.New("metadata store can't run queries")
+	// ErrMetadataNewerVersion means a mutation was refused because the
+	// document was written by a newer release: saving it from this
+	// release's model would drop fields it doesn't know and downgrade
+	// its schema_version.
+	ErrMetadataNewerVersion = errors.New("metadata was written by a newer release")
+	// ErrMetadataUnavailable means the metadata store isn't connected yet,
+	// so reads that must come from it can't be served.
+	ErrMetadataUnavailable = errors.New("metadata store is unavailable")
+)
+
+// TargetLimitError means a targets patch would leave Count targets on a
+// snapshot allowed at most Max.
+type TargetLimitError struct {
+	Count int
+	Max   int
+}
+
+func (e *TargetLimitError) Error() string {
+	return fmt.Sprintf("snapshot would have %d targets; at most %d are allowed", e.Count, e.Max)
+}
@@ -1,10 +1,14 @@
 package storage
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/couchbase/config-manager/internal/models"
@@ -16,19 +20,67 @@ import (
 // FileStorage handles saving configurations to files
 type FileStorage struct {
 	baseDirectory string
+	// fileSDDirectory is the agent's view of baseDirectory, used when
+	// rendering file_sd_configs paths.
+	fileSDDirectory string
+
+	// targetMu guards targetLocks, which serializes target file edits
+	// per snapshot so concurrent patches can't lose each other's changes.
+	targetMu    sync.Mutex
+	targetLocks map[string]*targetLock
 }
 
-// NewFileStorage creates a new file storage instance
-func NewFileStorage(baseDirectory string) *FileStorage {
+type targetLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// NewFileStorage creates a new file storage instance. fileSDDirectory is
+// the path the agent uses to reach baseDirectory; empty means the same.
+func NewFileStorage(baseDirectory, fileSDDirectory string) *FileStorage {
+	if fileSDDirectory == "" {
+		fileSDDirectory = baseDirectory
+	}
 	return &FileStorage{
-		baseDirectory: baseDirectory,
+		baseDirectory:   baseDirectory,
+		fileSDDirectory: fileSDDirectory,
+		targetLocks:     make(map[string]*targetLock),
 	}
 }
 
-// SaveSnapshot saves a snapshot configuration to a file
-func (fs *FileStorage) SaveSnapshot(clusterInfo interface{}, agentType string) (string, error) {
-	// Generate UUID for filename
-	id := uuid.New().String()
+// lockTargets locks id's target files for a read-modify-write and
+// returns the unlock function. Entries are dropped once nobody holds or
+// waits for them.
+func (fs *FileStorage) lockTargets(id string) func() {
+	fs.targetMu.Lock()
+	l, ok := fs.targetLocks[id]
+	if !ok {
+		l = &targetLock{}
+		fs.targetLocks[id] = l
+	}
+	l.waiters++
+	fs.targetMu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		fs.targetMu.Lock()
+		if l.waiters--; l.waiters == 0 {
+			delete(fs.targetLocks, id)
+		}
+		fs.targetMu.Unlock()
+	}
+}
+
+// SaveSnapshot saves a snapshot configuration to a file named after id,
+// or a new UUID when id is empty. It returns the id used.
+func (fs *FileStorage) SaveSnapshot(id string, clusterInfo interface{}, agentType string) (string, error) {
+	// Generate UUID for filename unless the caller chose the id
+	if id == "" {
+		id = uuid.New().String()
+	} else if fs.has(id) {
+		return "", fmt.Errorf("%w: %s", ErrSnapshotExists, id)
+	}
 
 	// Create filename based on agent type
 	filename := fmt.Sprintf("%s.yml", id)
@@ -37,45 +89,96 @@ func (fs *FileStorage) SaveSnapshot(clusterInfo interface{}, agentType string) (
 	filePath := filepath.Join(fs.baseDirectory, filename)
 
 	// Generate configuration content based on agent type
-	content, err := fs.generateConfigContent(clusterInfo, agentType, id)
+	content, fileTargets, err := fs.generateConfigContent(clusterInfo, agentType, id)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate config content: %w", err)
 	}
 
-	// Write to file
-	if err := os.WriteFile(filePath, content, 0644); err != nil {
+	// file_sd target lists go down first so the agent never sees a scrape
+	// file pointing at a missing target file.
+	for scheme, groups := range fileTargets {
+		if err := fs.writeTargetGroups(id, scheme, groups); err != nil {
+			fs.removeTargetFiles(id)
+			return "", err
+		}
+	}
+
+	// The scrape file holds the basic_auth password and any inline TLS
+	// client key, so only the service's user (and an agent running as
+	// it) may read it.
+	if err := os.WriteFile(filePath, content, 0600); err != nil {
+		fs.removeTargetFiles(id)
 		return "", fmt.Errorf("failed to write config file: %w", err)
 	}
 
 	return id, nil
 }
 
-// generateConfigContent creates vmagent configuration format
-func (fs *FileStorage) generateConfigContent(clusterInfo interface{}, agentType string, id string) ([]byte, error) {
+// RenderSnapshot returns the scrape config SaveSnapshot would write for
+// clusterInfo, without touching the directory. The job is named after id.
+func (fs *FileStorage) RenderSnapshot(clusterInfo interface{}, agentType string, id string) ([]byte, error) {
+	content, _, err := fs.generateConfigContent(clusterInfo, agentType, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate config content: %w", err)
+	}
+	return content, nil
+}
+
+// ListSnapshots returns every active snapshot in the directory, oldest
+// keep-alive first.
+func (fs *FileStorage) ListSnapshots() ([]models.DisplaySnapshot, error) {
+	entries, err := os.ReadDir(fs.baseDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+	snapshots := make([]models.DisplaySnapshot, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".yml" {
+			continue
+		}
+		snapshot, err := fs.GetSnapshot(strings.TrimSuffix(e.Name(), ".yml"))
+		if err != nil {
+			// Deleted between ReadDir and GetSnapshot; skip it.
+			if errors.Is(err, ErrSnapshotNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].TimeStamp.Before(snapshots[j].TimeStamp)
+	})
+	return snapshots, nil
+}
+
+// generateConfigContent creates vmagent configuration format. The second
+// return value holds the initial file_sd target groups keyed by scheme.
+func (fs *FileStorage) generateConfigContent(clusterInfo interface{}, agentType string, id string) ([]byte, map[string][]models.TargetGroup, error) {
 	if strings.ToLower(agentType) != "vmagent" {
-		return nil, fmt.Errorf("unsupported agent type: %s, only vmagent is supported", agentType)
+		return nil, nil, fmt.Errorf("unsupported agent type: %s, only vmagent is supported", agentType)
 	}
 	return fs.generateVMAgentConfig(clusterInfo, id)
 }
 
 // generateVMAgentConfig creates VM Agent scrape configuration
-func (fs *FileStorage) generateVMAgentConfig(clusterInfo interface{}, id string) ([]byte, error) {
+func (fs *FileStorage) generateVMAgentConfig(clusterInfo interface{}, id string) ([]byte, map[string][]models.TargetGroup, error) {
 	clusterMap, ok := clusterInfo.(map[string]interface{})
 	if !ok {
-		return nil, fmt.Errorf("invalid cluster info format")
+		return nil, nil, fmt.Errorf("invalid cluster info format")
 	}
 
 	// Extract configs
 	configsRaw, ok := clusterMap["configs"].([]interface{})
 	if !ok || len(configsRaw) == 0 {
-		return nil, fmt.Errorf("invalid configs format")
+		return nil, nil, fmt.Errorf("invalid configs format")
 	}
 
 	configs := make([]map[string]interface{}, len(configsRaw))
 	for i, c := range configsRaw {
 		m, ok := c.(map[string]interface{})
 		if !ok {
-			return nil, fmt.Errorf("invalid config object format")
+			return nil, nil, fmt.Errorf("invalid config object format")
 		}
 		configs[i] = m
 	}
@@ -83,60 +186,76 @@ func (fs *FileStorage) generateVMAgentConfig(clusterInfo interface{}, id string)
 	// Extract credentials
 	credentials, ok := clusterMap["credentials"].(map[string]interface{})
 	if !ok {
-		return nil, fmt.Errorf("invalid credentials format")
+		return nil, nil, fmt.Errorf("invalid credentials format")
 	}
 
 	username := credentials["username"].(string)
 	password := credentials["password"].(string)
 
+	// Targets are grouped into one scrape job per (scheme, TLS settings)
+	// pair: tls_config is a job-level setting, so two https configs with
+	// different CAs or client certs can't share a job.
 	type scrapeBucket struct {
+		scheme        string
+		tls           *models.TLSConfig
 		httpSDConfigs []map[string]interface{}
 		staticConfigs []map[string]interface{}
+		dnsSDConfigs  []map[string]interface{}
+		fileTargets   []models.TargetGroup
 	}
 	buckets := map[string]*scrapeBucket{}
-	bucketFor := func(scheme string) *scrapeBucket {
-		b, ok := buckets[scheme]
+	bucketOrder := map[string][]string{}
+	bucketFor := func(scheme string, tlsSettings *models.TLSConfig) (*scrapeBucket, error) {
+		key := scheme
+		if tlsSettings != nil {
+			tlsKey, err := json.Marshal(tlsSettings)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tls format: %w", err)
+			}
+			key += "|" + string(tlsKey)
+		}
+		b, ok := buckets[key]
 		if !ok {
-			b = &scrapeBucket{}
-			buckets[scheme] = b
+			b = &scrapeBucket{scheme: scheme, tls: tlsSettings}
+			buckets[key] = b
+			bucketOrder[scheme] = append(bucketOrder[scheme], key)
 		}
-		return b
+		return b, nil
 	}
 
 	for _, config := range configs {
 		hostnames, ok := config["hostnames"].([]string)
 		if !ok || len(hostnames) == 0 {
-			return nil, fmt.Errorf("invalid hostnames format")
+			return nil, nil, fmt.Errorf("invalid hostnames format")
 		}
 
 		port, ok := config["port"].(int)
 		if !ok {
-			return nil, fmt.Errorf("invalid port format")
+			return nil, nil, fmt.Errorf("invalid port format")
 		}
+		configType, _ := config["type"].(string)
 
 		configScheme, _ := config["scheme"].(string)
 		if configScheme == "" {
 			configScheme = "http"
 		}
-		bucket := bucketFor(configScheme)
+		var tlsSettings *models.TLSConfig
+		if configScheme == "https" {
+			tlsSettings, _ = config["tls"].(*models.TLSConfig)
+		}
+		bucket, err := bucketFor(configScheme, tlsSettings)
+		if err != nil {
+			return nil, nil, err
+		}
 
 		useAltAddresses, _ := config["use_alt_addresses"].(bool)
 
-		switch configType := config["type"].(string); configType {
-		case "sd":
+		switch configType {
+		case models.ConfigTypeSD:
 			product, _ := config["product"].(string)
 			sdPath, _ := config["sd_path"].(string)
-			// Caller-supplied sd_path wins; otherwise fall back to the
-			// product registry's default. The validator has already
-			// ensured one of the two is non-empty by this point.
-			path := sdPath
-			if path == "" {
-				if p := products.Get(product); p != nil && p.ResolveSDPath != nil {
-					path = p.ResolveSDPath(configScheme, useAltAddresses)
-				}
-			}
 			for _, hostname := range hostnames {
-				sdURL := fmt.Sprintf("%s://%s:%d%s", configScheme, hostname, port, path)
+				sdURL := SDURL(configScheme, hostname, port, product, sdPath, useAltAddresses)
 				sdEntry := map[string]interface{}{
 					"url": sdURL,
 					"basic_auth": map[string]interface{}{
@@ -145,11 +264,11 @@ func (fs *FileStorage) generateVMAgentConfig(clusterInfo interface{}, id string)
 					},
 				}
 				if configScheme == "https" {
-					sdEntry["tls_config"] = map[string]interface{}{"insecure_skip_verify": true}
+					sdEntry["tls_config"] = renderTLSConfig(tlsSettings)
 				}
 				bucket.httpSDConfigs = append(bucket.httpSDConfigs, sdEntry)
 			}
-		case "static":
+		case models.ConfigTypeStatic:
 			targetList := []string{}
 			for _, hostname := range hostnames {
 				targetList = append(targetList, fmt.Sprintf("%s:%d", hostname, port))
@@ -157,67 +276,150 @@ func (fs *FileStorage) generateVMAgentConfig(clusterInfo interface{}, id string)
 			bucket.staticConfigs = append(bucket.staticConfigs, map[string]interface{}{
 				"targets": targetList,
 			})
+		case models.ConfigTypeDNS:
+			recordType, _ := config["dns_record_type"].(string)
+			if recordType == "" {
+				recordType = "SRV"
+			}
+			dnsEntry := map[string]interface{}{
+				"names": hostnames,
+				"type":  recordType,
+			}
+			// SRV answers carry their own port; A/AAAA need one supplied.
+			if port != 0 {
+				dnsEntry["port"] = port
+			}
+			bucket.dnsSDConfigs = append(bucket.dnsSDConfigs, dnsEntry)
+		case models.ConfigTypeFile:
+			targetList := []string{}
+			for _, hostname := range hostnames {
+				targetList = append(targetList, fmt.Sprintf("%s:%d", hostname, port))
+			}
+			bucket.fileTargets = append(bucket.fileTargets, models.TargetGroup{Targets: targetList})
 		default:
-			return nil, fmt.Errorf("unsupported config type: %s", configType)
+			return nil, nil, fmt.Errorf("unsupported config type: %s", configType)
 		}
 	}
 
-	// Emit one Prometheus job per scheme bucket. When the file contains
-	// more than one bucket, suffix job_name with the scheme so each job is
-	// uniquely named, and use relabel_configs to rewrite the scraped `job`
-	// label back to the snapshot id — cbmonitor's PromQL selects by
-	// job="<id>" and must stay green across both halves.
+	// Emit one Prometheus job per bucket. When the file contains more
+	// than one bucket, suffix job_name with the scheme (and an ordinal for
+	// further TLS variants) so each job is uniquely named, and use
+	// relabel_configs to rewrite the scraped `job` label back to the
+	// snapshot id — cbmonitor's PromQL selects by job="<id>" and must stay
+	// green across every part.
 	jobs := []map[string]interface{}{}
+	fileTargets := map[string][]models.TargetGroup{}
 	multiBucket := len(buckets) > 1
 	for _, scheme := range []string{"http", "https"} {
-		bucket, ok := buckets[scheme]
-		if !ok {
-			continue
-		}
+		for i, key := range bucketOrder[scheme] {
+			bucket := buckets[key]
+
+			jobName := id
+			if multiBucket {
+				jobName = id + "-" + scheme
+				if i > 0 {
+					jobName = fmt.Sprintf("%s-%d", jobName, i+1)
+				}
+			}
 
-		jobName := id
-		if multiBucket {
-			jobName = id + "-" + scheme
-		}
+			yamlConfig := map[string]interface{}{
+				"job_name": jobName,
+				"basic_auth": map[string]interface{}{
+					"username": username,
+					"password": password,
+				},
+				"scheme": scheme,
+			}
 
-		yamlConfig := map[string]interface{}{
-			"job_name": jobName,
-			"basic_auth": map[string]interface{}{
-				"username": username,
-				"password": password,
-			},
-			"scheme": scheme,
-		}
+			if scheme == "https" {
+				yamlConfig["tls_config"] = renderTLSConfig(bucket.tls)
+			}
 
-		if scheme == "https" {
-			yamlConfig["tls_config"] = map[string]interface{}{"insecure_skip_verify": true}
-		}
+			if len(bucket.httpSDConfigs) > 0 {
+				yamlConfig["http_sd_configs"] = bucket.httpSDConfigs
+			}
 
-		if len(bucket.httpSDConfigs) > 0 {
-			yamlConfig["http_sd_configs"] = bucket.httpSDConfigs
-		}
+			if len(bucket.staticConfigs) > 0 {
+				yamlConfig["static_configs"] = bucket.staticConfigs
+			}
 
-		if len(bucket.staticConfigs) > 0 {
-			yamlConfig["static_configs"] = bucket.staticConfigs
-		}
+			if len(bucket.dnsSDConfigs) > 0 {
+				yamlConfig["dns_sd_configs"] = bucket.dnsSDConfigs
+			}
 
-		if multiBucket {
-			yamlConfig["relabel_configs"] = []map[string]interface{}{
-				{"target_label": "job", "replacement": id},
+			if len(bucket.fileTargets) > 0 {
+				if _, dup := fileTargets[scheme]; dup {
+					return nil, nil, fmt.Errorf("file configs for scheme %s must share tls settings", scheme)
+				}
+				yamlConfig["file_sd_configs"] = []map[string]interface{}{
+					{"files": []string{filepath.Join(fs.fileSDDirectory, targetFileName(id, scheme))}},
+				}
+				fileTargets[scheme] = bucket.fileTargets
 			}
+
+			if multiBucket {
+				yamlConfig["relabel_configs"] = []map[string]interface{}{
+					{"target_label": "job", "replacement": id},
+				}
+			}
+
+			jobs = append(jobs, yamlConfig)
 		}
+	}
 
-		jobs = append(jobs, yamlConfig)
+	content, err := yaml.Marshal(jobs)
+	if err != nil {
+		return nil, nil, err
 	}
+	return content, fileTargets, nil
+}
 
-	return yaml.Marshal(jobs)
+// SDURL is the discovery endpoint an sd-type config's hostname is
+// scraped through. A caller-supplied sdPath wins; otherwise the product
+// registry's default is used. The validator has already ensured one of
+// the two is set.
+func SDURL(scheme, hostname string, port int, product, sdPath string, useAltAddresses bool) string {
+	path := sdPath
+	if path == "" {
+		if p := products.Get(product); p != nil && p.ResolveSDPath != nil {
+			path = p.ResolveSDPath(scheme, useAltAddresses)
+		}
+	}
+	return fmt.Sprintf("%s://%s:%d%s", scheme, hostname, port, path)
+}
+
+// renderTLSConfig converts TLS settings into a Prometheus tls_config
+// block. Certificates and the key are inlined (`ca`, `cert`, `key`) so the
+// scrape file stays self-contained. No settings means verify against the
+// agent's system roots.
+func renderTLSConfig(t *models.TLSConfig) map[string]interface{} {
+	out := map[string]interface{}{}
+	if t == nil {
+		return out
+	}
+	if t.CA != "" {
+		out["ca"] = t.CA
+	}
+	if t.Cert != "" {
+		out["cert"] = t.Cert
+	}
+	if t.Key != "" {
+		out["key"] = t.Key
+	}
+	if t.ServerName != "" {
+		out["server_name"] = t.ServerName
+	}
+	if t.InsecureSkipVerify {
+		out["insecure_skip_verify"] = true
+	}
+	return out
 }
 
 func (fs *FileStorage) GetSnapshot(id string) (models.DisplaySnapshot, error) {
 	filePath := filepath.Join(fs.baseDirectory, fmt.Sprintf("%s.yml", id))
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return models.DisplaySnapshot{}, fmt.Errorf("config file does not exist: %s", filePath)
+		return models.DisplaySnapshot{}, fmt.Errorf("%w: config file does not exist: %s", ErrSnapshotNotFound, filePath)
 	} else if err != nil {
 		return models.DisplaySnapshot{}, fmt.Errorf("error checking config file: %w", err)
 	}
@@ -240,6 +442,7 @@ func (fs *FileStorage) GetSnapshot(id string) (models.DisplaySnapshot, error) {
 	name := id
 	var urls []string
 	var targets []string
+	var dnsNames []string
 	for _, job := range snapshot {
 		if sdConfigs, ok := job["http_sd_configs"].([]interface{}); ok {
 			for _, sd := range sdConfigs {
@@ -269,6 +472,34 @@ func (fs *FileStorage) GetSnapshot(id string) (models.DisplaySnapshot, error) {
 				}
 			}
 		}
+		if dnsConfigs, ok := job["dns_sd_configs"].([]interface{}); ok {
+			for _, d := range dnsConfigs {
+				m, ok := d.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				names, _ := m["names"].([]interface{})
+				for _, n := range names {
+					if dnsName, ok := n.(string); ok {
+						dnsNames = append(dnsNames, dnsName)
+					}
+				}
+			}
+		}
+	}
+
+	// file_sd targets live in the side files, not the scrape YAML.
+	for _, scheme := range []string{"http", "https"} {
+		groups, err := fs.readTargetGroups(id, scheme)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return models.DisplaySnapshot{}, err
+		}
+		for _, g := range groups {
+			targets = append(targets, g.Targets...)
+		}
 	}
 
 	info, err := os.Stat(filePath)
@@ -283,6 +514,7 @@ func (fs *FileStorage) GetSnapshot(id string) (models.DisplaySnapshot, error) {
 		Name:      name,
 		Urls:      urls,
 		Targets:   targets,
+		DNSNames:  dnsNames,
 		TimeStamp: timestamp,
 	}
 
@@ -295,7 +527,7 @@ func (fs *FileStorage) DeleteSnapshot(id string) error {
 
 	// Check if file exists
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return fmt.Errorf("config file does not exist: %s", filePath)
+		return fmt.Errorf("%w: config file does not exist: %s", ErrSnapshotNotFound, filePath)
 	} else if err != nil {
 		return fmt.Errorf("error checking config file: %w", err)
 	}
@@ -304,6 +536,8 @@ func (fs *FileStorage) DeleteSnapshot(id string) error {
 	if err := os.Remove(filePath); err != nil {
 		return fmt.Errorf("failed to delete config file: %w", err)
 	}
+	fs.removeTargetFiles(id)
+	os.Remove(fs.requestFilePath(id))
 
 	return nil
 }
@@ -312,5 +546,289 @@ func (fs *FileStorage) PatchSnapshot(id string) error {
 	// TO DO: update this, in the future, to also update other configs
 	filePath := filepath.Join(fs.baseDirectory, fmt.Sprintf("%s.yml", id))
 	now := time.Now()
-	return os.Chtimes(filePath, now, now)
+	if err := os.Chtimes(filePath, now, now); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: config file does not exist: %s", ErrSnapshotNotFound, filePath)
+		}
+		return err
+	}
+	return nil
+}
+
+// PatchTargets edits the file_sd target list of a snapshot and returns
+// the resulting targets. The agent picks the change up on its next file
+// SD refresh, so the scrape YAML is left untouched apart from its mtime.
+// A patch that would leave more than maxTargets targets is refused with
+// a *TargetLimitError; 0 is unlimited. Patches to one snapshot are
+// applied one at a time.
+func (fs *FileStorage) PatchTargets(id string, patch models.TargetsPatchRequest, maxTargets int) ([]string, error) {
+	defer fs.lockTargets(id)()
+
+	filePath := filepath.Join(fs.baseDirectory, fmt.Sprintf("%s.yml", id))
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: config file does not exist: %s", ErrSnapshotNotFound, filePath)
+	}
+
+	scheme := patch.Scheme
+	if scheme == "" {
+		for _, s := range []string{"http", "https"} {
+			if _, err := os.Stat(fs.targetFilePath(id, s)); err == nil {
+				if scheme != "" {
+					return nil, fmt.Errorf("%w: snapshot has file targets for both http and https", ErrSchemeRequired)
+				}
+				scheme = s
+			}
+		}
+	}
+	if scheme == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNoFileTargets, id)
+	}
+
+	groups, err := fs.readTargetGroups(id, scheme)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s (scheme %s)", ErrNoFileTargets, id, scheme)
+		}
+		return nil, err
+	}
+
+	// The first group is the one config-manager edits. Extra groups come
+	// from additional file-type configs in the original request and keep
+	// their targets untouched unless a removal names them.
+	if len(groups) == 0 {
+		groups = []models.TargetGroup{{}}
+	}
+	if patch.Targets != nil {
+		groups = []models.TargetGroup{{Targets: dedupe(patch.Targets), Labels: groups[0].Labels}}
+	} else {
+		removed := make(map[string]struct{}, len(patch.Remove))
+		for _, t := range patch.Remove {
+			removed[t] = struct{}{}
+		}
+		for i := range groups {
+			kept := groups[i].Targets[:0]
+			for _, t := range groups[i].Targets {
+				if _, ok := removed[t]; !ok {
+					kept = append(kept, t)
+				}
+			}
+			groups[i].Targets = kept
+		}
+		groups[0].Targets = dedupe(append(groups[0].Targets, patch.Add...))
+	}
+
+	if maxTargets > 0 {
+		n := 0
+		for _, g := range groups {
+			n += len(g.Targets)
+		}
+		if n > maxTargets {
+			return nil, &TargetLimitError{Count: n, Max: maxTargets}
+		}
+	}
+
+	if err := fs.writeTargetGroups(id, scheme, groups); err != nil {
+		return nil, err
+	}
+	if err := fs.PatchSnapshot(id); err != nil {
+		return nil, fmt.Errorf("failed to touch config file: %w", err)
+	}
+
+	targets := []string{}
+	for _, g := range groups {
+		targets = append(targets, g.Targets...)
+	}
+	return targets, nil
+}
+
+// ReadSnapshot returns the raw scrape config of an active snapshot.
+func (fs *FileStorage) ReadSnapshot(id string) ([]byte, error) {
+	filePath := filepath.Join(fs.baseDirectory, fmt.Sprintf("%s.yml", id))
+	content, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: config file does not exist: %s", ErrSnapshotNotFound, filePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return content, nil
+}
+
+// StoredRequest is a request file: the request a snapshot was created
+// from plus who created it and when, so quotas and queries can use them
+// without a metadata store. Files kept before those were recorded decode
+// with them zero.
+type StoredRequest struct {
+	*models.SnapshotRequest
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SaveRequest keeps the request a snapshot was created from, and the
+// caller that created it, next to its scrape file, so it can be archived,
+// restored and cloned. It holds the same credentials and keys as the
+// scrape file, so it is written with the same 0600 mode.
+func (fs *FileStorage) SaveRequest(id, caller string, req *models.SnapshotRequest) error {
+	content, err := json.Marshal(StoredRequest{SnapshotRequest: req, CreatedBy: caller, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot request: %w", err)
+	}
+	if err := os.WriteFile(fs.requestFilePath(id), content, 0600); err != nil {
+		return fmt.Errorf("failed to write snapshot request: %w", err)
+	}
+	return nil
+}
+
+// GetRequest returns the request an active snapshot was created from.
+// Snapshots created before requests were kept return ErrRequestNotFound.
+func (fs *FileStorage) GetRequest(id string) (*models.SnapshotRequest, error) {
+	stored, err := fs.GetStoredRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	return stored.SnapshotRequest, nil
+}
+
+// GetStoredRequest is GetRequest with the creator and creation time
+// recorded alongside the request.
+func (fs *FileStorage) GetStoredRequest(id string) (*StoredRequest, error) {
+	content, err := os.ReadFile(fs.requestFilePath(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrRequestNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot request: %w", err)
+	}
+	stored := &StoredRequest{SnapshotRequest: &models.SnapshotRequest{}}
+	if err := json.Unmarshal(content, stored); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot request: %w", err)
+	}
+	return stored, nil
+}
+
+// has reports whether id's scrape file is in the directory.
+func (fs *FileStorage) has(id string) bool {
+	_, err := os.Stat(filepath.Join(fs.baseDirectory, id+".yml"))
+	return err == nil
+}
+
+// requestFilePath is where SaveRequest keeps a snapshot's request. Like
+// the target files it doesn't end in .yml, so the agent ignores it.
+func (fs *FileStorage) requestFilePath(id string) string {
+	return filepath.Join(fs.baseDirectory, id+".request.json")
+}
+
+// targetFileName is the file_sd target list for one scheme of a snapshot.
+// It deliberately does not end in .yml so the agent's scrape config glob
+// and the manager loop both ignore it.
+func targetFileName(id, scheme string) string {
+	return fmt.Sprintf("%s-%s-targets.json", id, scheme)
+}
+
+func (fs *FileStorage) targetFilePath(id, scheme string) string {
+	return filepath.Join(fs.baseDirectory, targetFileName(id, scheme))
+}
+
+func (fs *FileStorage) readTargetGroups(id, scheme string) ([]models.TargetGroup, error) {
+	content, err := os.ReadFile(fs.targetFilePath(id, scheme))
+	if err != nil {
+		return nil, err
+	}
+	var groups []models.TargetGroup
+	if err := json.Unmarshal(content, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse target file: %w", err)
+	}
+	return groups, nil
+}
+
+// writeTargetGroups replaces the target file atomically; the agent may
+// read it at any moment and must never see a half-written list.
+func (fs *FileStorage) writeTargetGroups(id, scheme string, groups []models.TargetGroup) error {
+	for i := range groups {
+		if groups[i].Targets == nil {
+			groups[i].Targets = []string{}
+		}
+	}
+	content, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode target file: %w", err)
+	}
+	path := fs.targetFilePath(id, scheme)
+	// CreateTemp gives each writer its own 0600 file in the same
+	// directory, so the rename stays atomic.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write target file: %w", err)
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write target file: %w", err)
+	}
+	return nil
+}
+
+// TargetGroups returns the file_sd target lists of a snapshot keyed by
+// scheme; schemes without file-type configs are absent.
+func (fs *FileStorage) TargetGroups(id string) (map[string][]models.TargetGroup, error) {
+	out := make(map[string][]models.TargetGroup)
+	for _, scheme := range []string{"http", "https"} {
+		groups, err := fs.readTargetGroups(id, scheme)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[scheme] = groups
+	}
+	return out, nil
+}
+
+// ReplaceTargetGroups overwrites the file_sd target lists of a snapshot
+// with groups, e.g. the final lists of the snapshot it was restored
+// from. Schemes the snapshot has no target file for are ignored.
+func (fs *FileStorage) ReplaceTargetGroups(id string, groups map[string][]models.TargetGroup) error {
+	defer fs.lockTargets(id)()
+	for scheme, g := range groups {
+		if _, err := os.Stat(fs.targetFilePath(id, scheme)); err != nil {
+			continue
+		}
+		if err := fs.writeTargetGroups(id, scheme, g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *FileStorage) removeTargetFiles(id string) {
+	for _, scheme := range []string{"http", "https"} {
+		os.Remove(fs.targetFilePath(id, scheme))
+	}
+}
+
+// dedupe drops repeated entries and sorts the rest so target files diff
+// cleanly between patches.
+func dedupe(in []string) []string {
+	seen := make(map[string]struct{}, len(in))
+	out := make([]string, 0, len(in))
+	for _, v := range in {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
 }
@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// scrapeJobs renders cluster through generateVMAgentConfig and decodes
+// the jobs back, as the agent would read them.
+func scrapeJobs(t *testing.T, fs *FileStorage, cluster map[string]interface{}) []map[string]interface{} {
+	t.Helper()
+	content, _, err := fs.generateVMAgentConfig(cluster, "snap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jobs []map[string]interface{}
+	if err := yaml.Unmarshal(content, &jobs); err != nil {
+		t.Fatal(err)
+	}
+	return jobs
+}
+
+func clusterWith(configs ...map[string]interface{}) map[string]interface{} {
+	raw := make([]interface{}, len(configs))
+	for i, c := range configs {
+		raw[i] = c
+	}
+	return map[string]interface{}{
+		"configs":     raw,
+		"credentials": map[string]interface{}{"username": "u", "password": "p"},
+	}
+}
+
+func TestRenderTLSConfig(t *testing.T) {
+	cases := []struct {
+		name string
+		tls  *models.TLSConfig
+		want map[string]interface{}
+	}{
+		{"default", nil, map[string]interface{}{}},
+		{"empty", &models.TLSConfig{}, map[string]interface{}{}},
+		{"ca only", &models.TLSConfig{CA: "CA"}, map[string]interface{}{"ca": "CA"}},
+		{"cert and key", &models.TLSConfig{Cert: "CERT", Key: "KEY"}, map[string]interface{}{"cert": "CERT", "key": "KEY"}},
+		{"server name", &models.TLSConfig{ServerName: "cb.local"}, map[string]interface{}{"server_name": "cb.local"}},
+		{"insecure", &models.TLSConfig{InsecureSkipVerify: true}, map[string]interface{}{"insecure_skip_verify": true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := renderTLSConfig(tc.tls); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("renderTLSConfig = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGenerateVMAgentConfigTLS(t *testing.T) {
+	fs := NewFileStorage(t.TempDir(), "")
+	cases := []struct {
+		name string
+		tls  *models.TLSConfig
+		want map[string]interface{}
+	}{
+		{"default", nil, map[string]interface{}{}},
+		{"ca only", &models.TLSConfig{CA: "CA"}, map[string]interface{}{"ca": "CA"}},
+		{"cert and key", &models.TLSConfig{Cert: "CERT", Key: "KEY"}, map[string]interface{}{"cert": "CERT", "key": "KEY"}},
+		{"server name", &models.TLSConfig{ServerName: "cb.local"}, map[string]interface{}{"server_name": "cb.local"}},
+		{"insecure", &models.TLSConfig{InsecureSkipVerify: true}, map[string]interface{}{"insecure_skip_verify": true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			jobs := scrapeJobs(t, fs, clusterWith(map[string]interface{}{
+				"hostnames": []string{"node1"}, "type": models.ConfigTypeSD, "port": 18091,
+				"product": "couchbase", "scheme": "https", "tls": tc.tls,
+			}))
+			if len(jobs) != 1 {
+				t.Fatalf("got %d jobs, want 1", len(jobs))
+			}
+			if got := jobs[0]["tls_config"]; !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("job tls_config = %v, want %v", got, tc.want)
+			}
+			sd, _ := jobs[0]["http_sd_configs"].([]interface{})
+			if len(sd) != 1 {
+				t.Fatalf("http_sd_configs = %v, want one entry", jobs[0]["http_sd_configs"])
+			}
+			if got := sd[0].(map[string]interface{})["tls_config"]; !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("http_sd_configs tls_config = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	// TLS settings only apply to https; a plain http job carries none.
+	jobs := scrapeJobs(t, fs, clusterWith(map[string]interface{}{
+		"hostnames": []string{"node1"}, "type": models.ConfigTypeSD, "port": 8091,
+		"product": "couchbase", "tls": &models.TLSConfig{InsecureSkipVerify: true},
+	}))
+	if _, ok := jobs[0]["tls_config"]; ok {
+		t.Errorf("http job has tls_config %v", jobs[0]["tls_config"])
+	}
+}
+
+func TestSaveSnapshotFileModes(t *testing.T) {
+	fs := NewFileStorage(t.TempDir(), "")
+	id, err := fs.SaveSnapshot("snap", clusterWith(map[string]interface{}{
+		"hostnames": []string{"node1"}, "type": models.ConfigTypeFile, "port": 9100,
+		"scheme": "https", "tls": &models.TLSConfig{Cert: "CERT", Key: "KEY"},
+	}), "vmagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		filepath.Join(fs.baseDirectory, id+".yml"),
+		fs.targetFilePath(id, "https"),
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("%s has mode %o, want 600", filepath.Base(path), mode)
+		}
+	}
+}
+
+func TestGenerateVMAgentConfigDiscovery(t *testing.T) {
+	fs := NewFileStorage(t.TempDir(), "/sd")
+	cases := []struct {
+		name    string
+		configs []map[string]interface{}
+		key     string
+		want    interface{}
+	}{
+		{
+			name:    "dns srv",
+			configs: []map[string]interface{}{{"hostnames": []string{"_cb._tcp.example.com"}, "type": models.ConfigTypeDNS, "port": 0}},
+			key:     "dns_sd_configs",
+			want:    []interface{}{map[string]interface{}{"names": []interface{}{"_cb._tcp.example.com"}, "type": "SRV"}},
+		},
+		{
+			name:    "dns a with port",
+			configs: []map[string]interface{}{{"hostnames": []string{"a.example.com", "b.example.com"}, "type": models.ConfigTypeDNS, "port": 9100, "dns_record_type": "A"}},
+			key:     "dns_sd_configs",
+			want:    []interface{}{map[string]interface{}{"names": []interface{}{"a.example.com", "b.example.com"}, "type": "A", "port": 9100}},
+		},
+		{
+			name: "file sd",
+			configs: []map[string]interface{}{
+				{"hostnames": []string{"node1", "node2"}, "type": models.ConfigTypeFile, "port": 9100},
+				{"hostnames": []string{"node3"}, "type": models.ConfigTypeFile, "port": 9200},
+			},
+			key:  "file_sd_configs",
+			want: []interface{}{map[string]interface{}{"files": []interface{}{"/sd/snap-http-targets.json"}}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			jobs := scrapeJobs(t, fs, clusterWith(tc.configs...))
+			if len(jobs) != 1 {
+				t.Fatalf("got %d jobs, want 1", len(jobs))
+			}
+			if got := jobs[0][tc.key]; !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%s = %#v, want %#v", tc.key, got, tc.want)
+			}
+			if jobs[0]["job_name"] != "snap" {
+				t.Errorf("job_name = %v, want snap", jobs[0]["job_name"])
+			}
+		})
+	}
+
+	// file configs hand back the target groups to write, one per config.
+	_, targets, err := fs.generateVMAgentConfig(clusterWith(
+		map[string]interface{}{"hostnames": []string{"node1", "node2"}, "type": models.ConfigTypeFile, "port": 9100},
+		map[string]interface{}{"hostnames": []string{"node3"}, "type": models.ConfigTypeFile, "port": 9200},
+	), "snap")
+	want := map[string][]models.TargetGroup{"http": {{Targets: []string{"node1:9100", "node2:9100"}}, {Targets: []string{"node3:9200"}}}}
+	if err != nil || !reflect.DeepEqual(targets, want) {
+		t.Errorf("file targets = %+v, %v; want %+v", targets, err, want)
+	}
+
+	// file configs of one scheme share a target file, so they can't be
+	// split across jobs by differing TLS settings.
+	_, _, err = fs.generateVMAgentConfig(clusterWith(
+		map[string]interface{}{"hostnames": []string{"node1"}, "type": models.ConfigTypeFile, "port": 9100, "scheme": "https", "tls": &models.TLSConfig{ServerName: "a"}},
+		map[string]interface{}{"hostnames": []string{"node2"}, "type": models.ConfigTypeFile, "port": 9100, "scheme": "https", "tls": &models.TLSConfig{ServerName: "b"}},
+	), "snap")
+	if err == nil {
+		t.Error("file configs with mixed tls settings rendered")
+	}
+}
+
+func TestPatchTargets(t *testing.T) {
+	fs := NewFileStorage(t.TempDir(), "")
+	id, err := fs.SaveSnapshot("snap", clusterWith(map[string]interface{}{
+		"hostnames": []string{"node1", "node2"}, "type": models.ConfigTypeFile, "port": 9100,
+	}), "vmagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		patch models.TargetsPatchRequest
+		max   int
+		want  []string
+		limit bool
+	}{
+		{name: "remove absent target", patch: models.TargetsPatchRequest{Remove: []string{"node9:9100"}}, want: []string{"node1:9100", "node2:9100"}},
+		{name: "add duplicate", patch: models.TargetsPatchRequest{Add: []string{"node1:9100", " node3:9100 "}}, want: []string{"node1:9100", "node2:9100", "node3:9100"}},
+		{name: "over the limit", patch: models.TargetsPatchRequest{Add: []string{"node4:9100"}}, max: 3, limit: true},
+		{name: "at the limit", patch: models.TargetsPatchRequest{Remove: []string{"node3:9100"}, Add: []string{"node4:9100"}}, max: 3, want: []string{"node1:9100", "node2:9100", "node4:9100"}},
+		{name: "remove all", patch: models.TargetsPatchRequest{Remove: []string{"node1:9100", "node2:9100", "node4:9100"}}, want: []string{}},
+		{name: "replace", patch: models.TargetsPatchRequest{Targets: []string{"node5:9100"}}, want: []string{"node5:9100"}},
+		{name: "replace with none", patch: models.TargetsPatchRequest{Targets: []string{}}, want: []string{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			before, _ := fs.TargetGroups(id)
+			got, err := fs.PatchTargets(id, tc.patch, tc.max)
+			if tc.limit {
+				var limitErr *TargetLimitError
+				if !errors.As(err, &limitErr) || limitErr.Max != tc.max {
+					t.Fatalf("PatchTargets = %v, want a TargetLimitError with max %d", err, tc.max)
+				}
+				if after, _ := fs.TargetGroups(id); !reflect.DeepEqual(after, before) {
+					t.Errorf("refused patch changed the target file: %+v", after)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("targets = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := fs.PatchTargets("missing", models.TargetsPatchRequest{Add: []string{"node1:9100"}}, 0); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("PatchTargets on a missing snapshot = %v, want ErrSnapshotNotFound", err)
+	}
+}
+
+func TestPatchTargetsConcurrent(t *testing.T) {
+	fs := NewFileStorage(t.TempDir(), "")
+	id, err := fs.SaveSnapshot("snap", clusterWith(map[string]interface{}{
+		"hostnames": []string{"node0"}, "type": models.ConfigTypeFile, "port": 9100,
+	}), "vmagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every add must survive: none may be lost to another patch's
+	// read-modify-write.
+	const n = 50
+	want := []string{"node0:9100"}
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 1; i <= n; i++ {
+		target := fmt.Sprintf("node%d:9100", i)
+		want = append(want, target)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, err := fs.PatchTargets(id, models.TargetsPatchRequest{Add: []string{target}}, 0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	groups, err := fs.TargetGroups(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := append([]string(nil), groups["http"][0].Targets...)
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("targets after concurrent patches = %v, want %v", got, want)
+	}
+	entries, _ := os.ReadDir(fs.baseDirectory)
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == ".tmp" {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+	if len(fs.targetLocks) != 0 {
+		t.Errorf("%d target locks left after the patches finished", len(fs.targetLocks))
+	}
+}
+
+func TestStoredRequest(t *testing.T) {
+	fs := NewFileStorage(t.TempDir(), "")
+	req := &models.SnapshotRequest{Label: "kv", Tags: map[string]string{"team": "kv"}}
+	before := time.Now()
+	if err := fs.SaveRequest("a", "alice", req); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := fs.GetStoredRequest("a")
+	if err != nil || stored.CreatedBy != "alice" || !reflect.DeepEqual(stored.SnapshotRequest, req) {
+		t.Errorf("GetStoredRequest = %+v, %v; want the saved request from alice", stored, err)
+	}
+	if stored != nil && (stored.CreatedAt.Before(before.Add(-time.Second)) || stored.CreatedAt.After(time.Now())) {
+		t.Errorf("created_at = %v, want about %v", stored.CreatedAt, before)
+	}
+	if got, err := fs.GetRequest("a"); err != nil || !reflect.DeepEqual(got, req) {
+		t.Errorf("GetRequest = %+v, %v; want the saved request", got, err)
+	}
+
+	// Request files kept before the creator was recorded still read.
+	if err := os.WriteFile(fs.requestFilePath("b"), []byte(`{"label":"old","tags":{"team":"query"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	stored, err = fs.GetStoredRequest("b")
+	if err != nil || stored.CreatedBy != "" || !stored.CreatedAt.IsZero() || stored.Label != "old" || stored.Tags["team"] != "query" {
+		t.Errorf("GetStoredRequest on an old file = %+v, %v", stored, err)
+	}
+
+	if _, err := fs.GetStoredRequest("missing"); !errors.Is(err, ErrRequestNotFound) {
+		t.Errorf("GetStoredRequest(missing) = %v, want ErrRequestNotFound", err)
+	}
+}
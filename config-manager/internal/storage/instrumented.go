@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/metrics"
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// instrumentedMetadataStorage records the latency and outcome of every
+// operation on the wrapped store in
+// config_manager_metadata_storage_operation_duration_seconds.
+type instrumentedMetadataStorage struct {
+	next MetadataStorage
+}
+
+// Instrument wraps ms so its operations are timed. NewMetadataStorage
+// already does this; callers constructing a store directly should too.
+func Instrument(ms MetadataStorage) MetadataStorage {
+	if _, ok := ms.(*instrumentedMetadataStorage); ok {
+		return ms
+	}
+	return &instrumentedMetadataStorage{next: ms}
+}
+
+func (s *instrumentedMetadataStorage) observe(operation string, start time.Time, err error) {
+	result := "ok"
+	switch {
+	case errors.Is(err, ErrMetadataNotFound), errors.Is(err, ErrSnapshotNotFound):
+		result = "not_found"
+	case err != nil:
+		result = "error"
+	}
+	metrics.StorageOperationDuration.WithLabelValues(s.next.Type(), operation, result).Observe(time.Since(start).Seconds())
+}
+
+func (s *instrumentedMetadataStorage) SaveMetadata(metadata *models.SnapshotMetadata) error {
+	start := time.Now()
+	err := s.next.SaveMetadata(metadata)
+	s.observe("save", start, err)
+	return err
+}
+
+func (s *instrumentedMetadataStorage) GetMetadata(snapshotID string) (*models.SnapshotMetadata, error) {
+	start := time.Now()
+	md, err := s.next.GetMetadata(snapshotID)
+	s.observe("get", start, err)
+	return md, err
+}
+
+func (s *instrumentedMetadataStorage) UpdatePhase(snapshotID string, phase string, mode string) error {
+	start := time.Now()
+	err := s.next.UpdatePhase(snapshotID, phase, mode)
+	s.observe("update_phase", start, err)
+	return err
+}
+
+func (s *instrumentedMetadataStorage) UpdateServices(snapshotID string, services []string) error {
+	start := time.Now()
+	err := s.next.UpdateServices(snapshotID, services)
+	s.observe("update_services", start, err)
+	return err
+}
+
+func (s *instrumentedMetadataStorage) UpdateTags(snapshotID string, tags map[string]string) error {
+	start := time.Now()
+	err := s.next.UpdateTags(snapshotID, tags)
+	s.observe("update_tags", start, err)
+	return err
+}
+
+func (s *instrumentedMetadataStorage) UpdateCustomPanels(snapshotID string, panels []models.CustomPanelsConfig) ([]models.CustomPanelsConfig, error) {
+	start := time.Now()
+	out, err := s.next.UpdateCustomPanels(snapshotID, panels)
+	s.observe("update_custom_panels", start, err)
+	return out, err
+}
+
+func (s *instrumentedMetadataStorage) QueryMetadata(q models.MetadataQuery) ([]models.SnapshotMetadata, bool, error) {
+	start := time.Now()
+	out, truncated, err := s.next.QueryMetadata(q)
+	s.observe("query", start, err)
+	return out, truncated, err
+}
+
+func (s *instrumentedMetadataStorage) EoLSnapshot(snapshotID string, endedBy string) error {
+	start := time.Now()
+	err := s.next.EoLSnapshot(snapshotID, endedBy)
+	s.observe("eol", start, err)
+	return err
+}
+
+func (s *instrumentedMetadataStorage) Close() error {
+	return s.next.Close()
+}
+
+func (s *instrumentedMetadataStorage) Type() string {
+	return s.next.Type()
+}
+
+// Ping pings the wrapped store if it can be pinged, without timing it.
+func (s *instrumentedMetadataStorage) Ping(timeout time.Duration) error {
+	return Ping(s.next, timeout)
+}
@@ -0,0 +1,544 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/metrics"
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// Journal operations, one per MetadataStorage mutation.
+const (
+	journalSave         = "save"
+	journalPhase        = "phase"
+	journalServices     = "services"
+	journalTags         = "tags"
+	journalCustomPanels = "custom_panels"
+	journalEoL          = "eol"
+)
+
+// journalEntry is one metadata mutation the primary store couldn't take.
+// Time is when it happened, so a phase or end time replayed later keeps
+// its original timestamp.
+type journalEntry struct {
+	Time         time.Time                   `json:"ts"`
+	Op           string                      `json:"op"`
+	SnapshotID   string                      `json:"snapshot_id"`
+	Metadata     *models.SnapshotMetadata    `json:"metadata,omitempty"`
+	Phase        string                      `json:"phase,omitempty"`
+	Mode         string                      `json:"mode,omitempty"`
+	Services     []string                    `json:"services,omitempty"`
+	Tags         map[string]string           `json:"tags,omitempty"`
+	CustomPanels []models.CustomPanelsConfig `json:"custom_panels,omitempty"`
+	EndedBy      string                      `json:"ended_by,omitempty"`
+}
+
+// apply makes e's change to m, a document e doesn't create.
+func (e *journalEntry) apply(m *models.SnapshotMetadata) {
+	switch e.Op {
+	case journalPhase:
+		if e.Mode == "start" {
+			m.Phases = append(m.Phases, models.Phase{Label: e.Phase, TsStart: e.Time})
+		} else if len(m.Phases) > 0 {
+			m.Phases[len(m.Phases)-1].TsEnd = e.Time.Format(time.RFC3339Nano)
+		}
+	case journalServices:
+		seen := make(map[string]bool, len(m.Services))
+		for _, s := range m.Services {
+			seen[s] = true
+		}
+		for _, s := range e.Services {
+			if !seen[s] {
+				m.Services = append(m.Services, s)
+				seen[s] = true
+			}
+		}
+	case journalTags:
+		if m.Tags == nil {
+			m.Tags = make(map[string]string, len(e.Tags))
+		}
+		for k, v := range e.Tags {
+			if v == "" {
+				delete(m.Tags, k)
+				continue
+			}
+			m.Tags[k] = v
+		}
+	case journalCustomPanels:
+		m.CustomPanels = mergeCustomPanels(m.CustomPanels, e.CustomPanels)
+	case journalEoL:
+		m.TsEnd = e.Time.Format(time.RFC3339Nano)
+		m.EndedBy = e.EndedBy
+	}
+}
+
+// JournaledStorage writes through to a primary metadata store and, when
+// the store fails, appends the mutation to a local journal file instead.
+// Once anything is journaled, later mutations are journaled too so they
+// stay in order, and reads apply the pending entries on top of the
+// store's document. Run replays the journal into the store once it
+// recovers.
+//
+// The primary may be nil when the store was unreachable at startup; Run
+// then keeps trying to connect.
+//
+// Replay talks to the store without holding mu, so a slow connect or a
+// long replay doesn't block the API's writes, which keep queueing in the
+// journal meanwhile.
+type JournaledStorage struct {
+	mu      sync.Mutex
+	primary MetadataStorage
+	connect func() (MetadataStorage, error)
+	path    string
+	file    *os.File
+	pending []journalEntry
+	// applied is how many leading pending entries a replay has already
+	// applied to the store; they stay pending until the file is
+	// rewritten.
+	applied int
+	// entryMu is held for writing while a replay applies one entry, and
+	// for reading while GetMetadata reads the store, so a read never
+	// sees an entry in the store that is still counted as pending.
+	entryMu sync.RWMutex
+	// replaying serialises Replay calls.
+	replaying sync.Mutex
+}
+
+// NewJournaledStorage opens the journal at path, loading any entries a
+// previous run left unreplayed. connect, when set, is how Run connects
+// to the store while primary is nil.
+func NewJournaledStorage(primary MetadataStorage, connect func() (MetadataStorage, error), path string) (*JournaledStorage, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create metadata journal directory: %w", err)
+		}
+	}
+	pending, err := readJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata journal: %w", err)
+	}
+	s := &JournaledStorage{primary: primary, connect: connect, path: path, file: file, pending: pending}
+	if len(pending) > 0 {
+		logger.Warn("Metadata journal has unreplayed entries from a previous run", "path", path, "entries", len(pending))
+	}
+	s.updateMetrics()
+	return s, nil
+}
+
+func readJournal(path string) ([]journalEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata journal: %w", err)
+	}
+	defer f.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// Only the last line can be cut short, by a crash mid-append.
+			logger.Warn("Skipping unreadable metadata journal entry", "path", path, "error", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metadata journal: %w", err)
+	}
+	return entries, nil
+}
+
+// journalable reports whether err means the store couldn't take a write,
+// rather than that the write itself was wrong.
+func journalable(err error) bool {
+	return err != nil && !errors.Is(err, ErrMetadataNotFound) && !errors.Is(err, ErrInvalidMode) && !errors.Is(err, ErrMetadataNewerVersion)
+}
+
+// write runs op against the primary unless the journal already has
+// entries or there is no primary, and journals e when it can't. The
+// caller holds s.mu.
+func (s *JournaledStorage) write(e journalEntry, op func(MetadataStorage) error) error {
+	if s.primary != nil && len(s.pending) == 0 {
+		err := op(s.primary)
+		if !journalable(err) {
+			return err
+		}
+		logger.Warn("Metadata store write failed; journaling it", "op", e.Op, "id", e.SnapshotID, "error", err)
+	}
+	return s.append(e)
+}
+
+// append writes e to the journal file and syncs it before returning, so
+// an acknowledged mutation survives a crash. The caller holds s.mu.
+func (s *JournaledStorage) append(e journalEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata journal entry: %w", err)
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write metadata journal: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync metadata journal: %w", err)
+	}
+	s.pending = append(s.pending, e)
+	metrics.MetadataJournalEntries.WithLabelValues(e.Op).Inc()
+	s.updateMetrics()
+	return nil
+}
+
+func (s *JournaledStorage) updateMetrics() {
+	metrics.MetadataJournalDepth.Set(float64(len(s.pending)))
+	lag := 0.0
+	if len(s.pending) > 0 {
+		lag = time.Since(s.pending[0].Time).Seconds()
+	}
+	metrics.MetadataJournalReplayLag.Set(lag)
+}
+
+func (s *JournaledStorage) SaveMetadata(metadata *models.SnapshotMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *metadata
+	return s.write(journalEntry{Time: time.Now(), Op: journalSave, SnapshotID: metadata.SnapshotID, Metadata: &cp},
+		func(p MetadataStorage) error { return p.SaveMetadata(metadata) })
+}
+
+// GetMetadata returns the store's document with any pending journal
+// entries for it applied. The store is read without holding s.mu.
+func (s *JournaledStorage) GetMetadata(snapshotID string) (*models.SnapshotMetadata, error) {
+	s.entryMu.RLock()
+	defer s.entryMu.RUnlock()
+
+	s.mu.Lock()
+	primary := s.primary
+	s.mu.Unlock()
+	var metadata *models.SnapshotMetadata
+	var primaryErr error
+	if primary != nil {
+		metadata, primaryErr = primary.GetMetadata(snapshotID)
+	}
+
+	s.mu.Lock()
+	var pending []journalEntry
+	for _, e := range s.pending[s.applied:] {
+		if e.SnapshotID == snapshotID {
+			pending = append(pending, e)
+		}
+	}
+	s.mu.Unlock()
+	return overlay(snapshotID, metadata, primaryErr, pending)
+}
+
+// overlay applies pending to the store's answer for snapshotID.
+func overlay(snapshotID string, metadata *models.SnapshotMetadata, primaryErr error, pending []journalEntry) (*models.SnapshotMetadata, error) {
+	if len(pending) == 0 {
+		if metadata == nil && primaryErr == nil {
+			return nil, fmt.Errorf("%w for snapshot %s", ErrMetadataNotFound, snapshotID)
+		}
+		return metadata, primaryErr
+	}
+	if errors.Is(primaryErr, ErrMetadataNotFound) {
+		primaryErr = nil
+	}
+	for i := range pending {
+		e := &pending[i]
+		if e.Op == journalSave {
+			cp := *e.Metadata
+			metadata = &cp
+			continue
+		}
+		if metadata != nil {
+			e.apply(metadata)
+		}
+	}
+	if metadata == nil {
+		if primaryErr != nil {
+			return nil, primaryErr
+		}
+		return nil, fmt.Errorf("%w for snapshot %s", ErrMetadataNotFound, snapshotID)
+	}
+	return metadata, nil
+}
+
+func (s *JournaledStorage) UpdatePhase(snapshotID string, phase string, mode string) error {
+	if mode != "start" && mode != "end" {
+		return fmt.Errorf("%w: %s", ErrInvalidMode, mode)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(journalEntry{Time: time.Now(), Op: journalPhase, SnapshotID: snapshotID, Phase: phase, Mode: mode},
+		func(p MetadataStorage) error { return p.UpdatePhase(snapshotID, phase, mode) })
+}
+
+func (s *JournaledStorage) UpdateServices(snapshotID string, services []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(journalEntry{Time: time.Now(), Op: journalServices, SnapshotID: snapshotID, Services: services},
+		func(p MetadataStorage) error { return p.UpdateServices(snapshotID, services) })
+}
+
+func (s *JournaledStorage) UpdateTags(snapshotID string, tags map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(journalEntry{Time: time.Now(), Op: journalTags, SnapshotID: snapshotID, Tags: tags},
+		func(p MetadataStorage) error { return p.UpdateTags(snapshotID, tags) })
+}
+
+// UpdateCustomPanels returns the panels after the update. When it is
+// journaled, they are computed from the pending view of the document.
+func (s *JournaledStorage) UpdateCustomPanels(snapshotID string, panels []models.CustomPanelsConfig) ([]models.CustomPanelsConfig, error) {
+	s.mu.Lock()
+	var updated []models.CustomPanelsConfig
+	stored := false
+	err := s.write(journalEntry{Time: time.Now(), Op: journalCustomPanels, SnapshotID: snapshotID, CustomPanels: panels},
+		func(p MetadataStorage) error {
+			var err error
+			updated, err = p.UpdateCustomPanels(snapshotID, panels)
+			stored = err == nil
+			return err
+		})
+	s.mu.Unlock()
+	if err != nil || stored {
+		return updated, err
+	}
+	metadata, err := s.GetMetadata(snapshotID)
+	if err != nil {
+		return panels, nil
+	}
+	return metadata.CustomPanels, nil
+}
+
+func (s *JournaledStorage) EoLSnapshot(snapshotID string, endedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(journalEntry{Time: time.Now(), Op: journalEoL, SnapshotID: snapshotID, EndedBy: endedBy},
+		func(p MetadataStorage) error { return p.EoLSnapshot(snapshotID, endedBy) })
+}
+
+// QueryMetadata queries the store; pending journal entries aren't
+// reflected until they are replayed. Until the store connects it returns
+// ErrMetadataUnavailable, since the journal holds changes, not documents
+// to search.
+func (s *JournaledStorage) QueryMetadata(q models.MetadataQuery) ([]models.SnapshotMetadata, bool, error) {
+	s.mu.Lock()
+	primary := s.primary
+	s.mu.Unlock()
+	if primary == nil {
+		return nil, false, ErrMetadataUnavailable
+	}
+	return primary.QueryMetadata(q)
+}
+
+// Close closes the store and the journal file. Unreplayed entries stay
+// in the file for the next run.
+func (s *JournaledStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.file.Close()
+	if s.primary != nil {
+		if cerr := s.primary.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Type returns the type of the primary store.
+func (s *JournaledStorage) Type() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.primary == nil {
+		return "journal"
+	}
+	return s.primary.Type()
+}
+
+// Ping pings the store. Until it connects, it fails with the number of
+// mutations waiting in the journal.
+func (s *JournaledStorage) Ping(timeout time.Duration) error {
+	s.mu.Lock()
+	primary, depth := s.primary, len(s.pending)
+	s.mu.Unlock()
+	if primary == nil {
+		return fmt.Errorf("not connected; %d mutations journaled", depth)
+	}
+	return Ping(primary, timeout)
+}
+
+// Depth returns the number of journaled mutations not yet replayed.
+func (s *JournaledStorage) Depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// Run replays the journal every interval until stop is closed.
+func (s *JournaledStorage) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.Replay()
+		}
+	}
+}
+
+// Replay connects to the store if needed and applies the pending
+// entries to it in order. It stops at the first entry the store can't
+// take and leaves the rest for the next attempt. An entry for a
+// document the store doesn't have can never apply, so it is dropped.
+//
+// s.mu is only held to read and update the journal, never across a call
+// to the store, so writes keep being journaled during a slow connect or
+// a long replay. Reads wait for at most one entry's round-trip.
+func (s *JournaledStorage) Replay() {
+	s.replaying.Lock()
+	defer s.replaying.Unlock()
+
+	s.mu.Lock()
+	primary, connect := s.primary, s.connect
+	s.mu.Unlock()
+	if primary == nil {
+		if connect == nil {
+			return
+		}
+		connected, err := connect()
+		if err != nil {
+			logger.Debug("Metadata store still unreachable", "error", err)
+			return
+		}
+		s.mu.Lock()
+		s.primary = connected
+		primary = connected
+		logger.Info("Connected to the metadata store", "type", connected.Type(), "journaled", len(s.pending))
+		s.mu.Unlock()
+	}
+
+	// Writes arriving during the pass append behind this batch, and only
+	// Replay removes entries, so the batch stays the head of the journal.
+	s.mu.Lock()
+	batch := append([]journalEntry(nil), s.pending[s.applied:]...)
+	s.mu.Unlock()
+
+	failed := false
+	for i := range batch {
+		e := &batch[i]
+		s.entryMu.Lock()
+		err := replayEntry(primary, e)
+		if journalable(err) {
+			s.entryMu.Unlock()
+			metrics.MetadataJournalReplays.WithLabelValues("failure").Inc()
+			logger.Warn("Metadata journal replay stopped; will retry", "op", e.Op, "id", e.SnapshotID, "remaining", len(batch)-i, "error", err)
+			failed = true
+			break
+		}
+		if err != nil {
+			metrics.MetadataJournalReplays.WithLabelValues("dropped").Inc()
+			logger.Warn("Dropping metadata journal entry that can't apply", "op", e.Op, "id", e.SnapshotID, "error", err)
+		} else {
+			metrics.MetadataJournalReplays.WithLabelValues("success").Inc()
+		}
+		s.mu.Lock()
+		s.applied++
+		s.mu.Unlock()
+		s.entryMu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.updateMetrics()
+	done := s.applied
+	if done == 0 {
+		return
+	}
+	if err := s.truncate(done); err != nil {
+		// The applied entries stay in the file and are skipped by the
+		// next pass until the rewrite succeeds.
+		logger.Error("Failed to rewrite metadata journal", "path", s.path, "error", err)
+		return
+	}
+	s.applied = 0
+	if !failed {
+		logger.Info("Replayed metadata journal", "applied", done, "remaining", len(s.pending))
+	}
+}
+
+// replayEntry applies e to the primary. Mutations other than a save are
+// applied to the current document and saved, so they keep e's time.
+func replayEntry(primary MetadataStorage, e *journalEntry) error {
+	if e.Op == journalSave {
+		return primary.SaveMetadata(e.Metadata)
+	}
+	metadata, err := primary.GetMetadata(e.SnapshotID)
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		return fmt.Errorf("%w for snapshot %s", ErrMetadataNotFound, e.SnapshotID)
+	}
+	if err := checkWritable(metadata); err != nil {
+		return err
+	}
+	e.apply(metadata)
+	return primary.SaveMetadata(metadata)
+}
+
+// truncate drops the first n pending entries and rewrites the file with
+// the rest. The caller holds s.mu.
+func (s *JournaledStorage) truncate(n int) error {
+	rest := s.pending[n:]
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for i := range rest {
+		if err := enc.Encode(&rest[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = file
+	s.pending = append([]journalEntry(nil), rest...)
+	return nil
+}
+
+var _ MetadataStorage = (*JournaledStorage)(nil)
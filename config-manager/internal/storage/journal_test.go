@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/migrate"
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+var errDown = errors.New("store is down")
+
+// flakyMetadata is an in-memory store that fails every call while down.
+type flakyMetadata struct {
+	FileMetadataStorage
+	down bool
+	docs map[string]models.SnapshotMetadata
+}
+
+func newFlakyMetadata() *flakyMetadata {
+	return &flakyMetadata{docs: map[string]models.SnapshotMetadata{}}
+}
+
+func (f *flakyMetadata) SaveMetadata(m *models.SnapshotMetadata) error {
+	if f.down {
+		return errDown
+	}
+	f.docs[m.SnapshotID] = *m
+	return nil
+}
+
+func (f *flakyMetadata) GetMetadata(id string) (*models.SnapshotMetadata, error) {
+	if f.down {
+		return nil, errDown
+	}
+	m, ok := f.docs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w for snapshot %s", ErrMetadataNotFound, id)
+	}
+	return &m, nil
+}
+
+func (f *flakyMetadata) UpdateTags(id string, tags map[string]string) error {
+	m, err := f.GetMetadata(id)
+	if err != nil {
+		return err
+	}
+	if err := checkWritable(m); err != nil {
+		return err
+	}
+	(&journalEntry{Op: journalTags, Tags: tags}).apply(m)
+	return f.SaveMetadata(m)
+}
+
+func (f *flakyMetadata) Type() string { return "flaky" }
+
+func TestJournaledStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	store := newFlakyMetadata()
+	js, err := NewJournaledStorage(store, nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes reach the store while it is up.
+	if err := js.SaveMetadata(&models.SnapshotMetadata{SnapshotID: "a", Label: "up"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.UpdateTags("missing", map[string]string{"k": "v"}); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("UpdateTags on a missing snapshot = %v, want ErrMetadataNotFound", err)
+	}
+	if js.Depth() != 0 {
+		t.Fatalf("depth = %d with the store up", js.Depth())
+	}
+
+	// While it is down they are journaled, and reads include them.
+	store.down = true
+	if err := js.SaveMetadata(&models.SnapshotMetadata{SnapshotID: "b", Label: "down"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.UpdatePhase("b", "load", "start"); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.UpdatePhase("b", "load", "sideways"); !errors.Is(err, ErrInvalidMode) {
+		t.Errorf("UpdatePhase with a bad mode = %v, want ErrInvalidMode", err)
+	}
+	store.down = false
+	// The store is back, but later writes queue behind the journal.
+	if err := js.UpdateTags("a", map[string]string{"owner": "perf"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.EoLSnapshot("gone", "manager"); err != nil {
+		t.Fatal(err)
+	}
+	if js.Depth() != 4 {
+		t.Fatalf("depth = %d, want 4", js.Depth())
+	}
+	if _, ok := store.docs["b"]; ok {
+		t.Fatal("journaled save reached the store")
+	}
+	b, err := js.GetMetadata("b")
+	if err != nil || b.Label != "down" || len(b.Phases) != 1 || b.Phases[0].Label != "load" {
+		t.Errorf("GetMetadata(b) = %+v, %v; want the journaled document", b, err)
+	}
+	a, err := js.GetMetadata("a")
+	if err != nil || a.Label != "up" || a.Tags["owner"] != "perf" {
+		t.Errorf("GetMetadata(a) = %+v, %v; want the stored document with journaled tags", a, err)
+	}
+	phaseStart := b.Phases[0].TsStart
+
+	// A restart picks the journal up from the file.
+	js.file.Close()
+	js, err = NewJournaledStorage(store, nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.Close()
+	if js.Depth() != 4 {
+		t.Fatalf("depth after reopening = %d, want 4", js.Depth())
+	}
+
+	// Replay applies everything in order, keeping the original times, and
+	// drops the entry for a snapshot the store doesn't have.
+	js.Replay()
+	if js.Depth() != 0 {
+		t.Fatalf("depth after replay = %d, want 0", js.Depth())
+	}
+	stored := store.docs["b"]
+	if len(stored.Phases) != 1 || !stored.Phases[0].TsStart.Equal(phaseStart) {
+		t.Errorf("replayed phases = %+v, want load started at %v", stored.Phases, phaseStart)
+	}
+	if store.docs["a"].Tags["owner"] != "perf" {
+		t.Errorf("replayed tags = %v", store.docs["a"].Tags)
+	}
+	if data, err := os.ReadFile(path); err != nil || len(data) != 0 {
+		t.Errorf("journal after replay = %q, %v; want it empty", data, err)
+	}
+
+	// Writes go straight to the store again.
+	if err := js.UpdateTags("b", map[string]string{"owner": "qe"}); err != nil || store.docs["b"].Tags["owner"] != "qe" || js.Depth() != 0 {
+		t.Errorf("UpdateTags after replay = %v, depth %d", err, js.Depth())
+	}
+}
+
+func TestJournaledStorageReplayStopsAtFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	store := newFlakyMetadata()
+	store.down = true
+	js, err := NewJournaledStorage(store, nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.Close()
+	for _, id := range []string{"a", "b"} {
+		if err := js.SaveMetadata(&models.SnapshotMetadata{SnapshotID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	js.Replay()
+	if js.Depth() != 2 {
+		t.Fatalf("depth after a failed replay = %d, want 2", js.Depth())
+	}
+	store.down = false
+	js.Replay()
+	if js.Depth() != 0 || len(store.docs) != 2 {
+		t.Errorf("after replay: depth %d, %d documents stored", js.Depth(), len(store.docs))
+	}
+}
+
+func TestJournaledStorageReplaySkipsNewerDocuments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	store := newFlakyMetadata()
+	newer := models.SnapshotMetadata{SnapshotID: "a", SchemaVersion: migrate.CurrentVersion + 1, Tags: map[string]string{"owner": "dev"}}
+	store.docs["a"] = newer
+	store.down = true
+	js, err := NewJournaledStorage(store, nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.Close()
+	if err := js.UpdateTags("a", map[string]string{"owner": "qe"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving the tag change would downgrade the document, so the entry
+	// is dropped rather than applied or retried forever.
+	store.down = false
+	js.Replay()
+	if js.Depth() != 0 {
+		t.Errorf("depth after replay = %d, want 0", js.Depth())
+	}
+	if got := store.docs["a"]; got.SchemaVersion != newer.SchemaVersion || got.Tags["owner"] != "dev" {
+		t.Errorf("newer document rewritten by replay: %+v", got)
+	}
+
+	// With the store up, the refusal reaches the caller.
+	if err := js.UpdateTags("a", map[string]string{"owner": "qe"}); !errors.Is(err, ErrMetadataNewerVersion) || js.Depth() != 0 {
+		t.Errorf("UpdateTags on a newer document = %v, depth %d; want ErrMetadataNewerVersion and nothing journaled", err, js.Depth())
+	}
+}
+
+func TestJournaledStorageConnects(t *testing.T) {
+	store := newFlakyMetadata()
+	connects := 0
+	connect := func() (MetadataStorage, error) {
+		connects++
+		if connects == 1 {
+			return nil, errDown
+		}
+		return store, nil
+	}
+	js, err := NewJournaledStorage(nil, connect, filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.Close()
+
+	if _, err := js.GetMetadata("a"); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("GetMetadata before connecting = %v, want ErrMetadataNotFound", err)
+	}
+	if err := js.SaveMetadata(&models.SnapshotMetadata{SnapshotID: "a", TsStart: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if js.Type() != "journal" {
+		t.Errorf("Type before connecting = %q", js.Type())
+	}
+	if _, _, err := js.QueryMetadata(models.MetadataQuery{}); !errors.Is(err, ErrMetadataUnavailable) {
+		t.Errorf("QueryMetadata before connecting = %v, want ErrMetadataUnavailable", err)
+	}
+
+	js.Replay()
+	if js.Depth() != 1 {
+		t.Fatalf("depth after a failed connect = %d, want 1", js.Depth())
+	}
+	js.Replay()
+	if js.Depth() != 0 || js.Type() != "flaky" {
+		t.Errorf("after connecting: depth %d, type %q", js.Depth(), js.Type())
+	}
+	if _, ok := store.docs["a"]; !ok {
+		t.Error("journaled save wasn't replayed after connecting")
+	}
+	// Queries now reach the store, here the file fallback's refusal.
+	if _, _, err := js.QueryMetadata(models.MetadataQuery{}); !errors.Is(err, ErrQueryUnsupported) {
+		t.Errorf("QueryMetadata after connecting = %v, want the store's ErrQueryUnsupported", err)
+	}
+}
+
+// blockingMetadata holds every read until release is closed.
+type blockingMetadata struct {
+	*flakyMetadata
+	release chan struct{}
+}
+
+func (b *blockingMetadata) GetMetadata(id string) (*models.SnapshotMetadata, error) {
+	<-b.release
+	return b.flakyMetadata.GetMetadata(id)
+}
+
+func TestJournaledStorageReplayDoesNotBlockWrites(t *testing.T) {
+	store := newFlakyMetadata()
+	connecting := make(chan struct{})
+	release := make(chan struct{})
+	connect := func() (MetadataStorage, error) {
+		close(connecting)
+		<-release
+		return store, nil
+	}
+	js, err := NewJournaledStorage(nil, connect, filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.Close()
+	if err := js.SaveMetadata(&models.SnapshotMetadata{SnapshotID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := make(chan struct{})
+	go func() {
+		js.Replay()
+		close(replayed)
+	}()
+	<-connecting
+
+	// A slow connect holds up neither writes nor reads.
+	done := make(chan error, 1)
+	go func() {
+		if err := js.SaveMetadata(&models.SnapshotMetadata{SnapshotID: "b"}); err != nil {
+			done <- err
+			return
+		}
+		_, err := js.GetMetadata("a")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("SaveMetadata blocked behind the replay's connect")
+	}
+
+	close(release)
+	<-replayed
+	if js.Depth() != 0 || len(store.docs) != 2 {
+		t.Errorf("after replay: depth %d, %d documents stored", js.Depth(), len(store.docs))
+	}
+}
+
+func TestJournaledStorageReadDuringReplay(t *testing.T) {
+	store := newFlakyMetadata()
+	store.docs["a"] = models.SnapshotMetadata{SnapshotID: "a"}
+	slow := &blockingMetadata{flakyMetadata: store, release: make(chan struct{})}
+	js, err := NewJournaledStorage(slow, nil, filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer js.Close()
+	store.down = true
+	if err := js.SaveMetadata(&models.SnapshotMetadata{SnapshotID: "a", Label: "journaled"}); err != nil {
+		t.Fatal(err)
+	}
+	store.down = false
+	if err := js.UpdatePhase("a", "load", "start"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The read sees the document before the replay lands and the entry
+	// after it; it must not apply the phase twice.
+	read := make(chan *models.SnapshotMetadata)
+	go func() {
+		m, _ := js.GetMetadata("a")
+		read <- m
+	}()
+	go js.Replay()
+	close(slow.release)
+	m := <-read
+	if m == nil || len(m.Phases) != 1 {
+		t.Errorf("GetMetadata during replay = %+v, want one phase", m)
+	}
+}
@@ -1,8 +1,12 @@
 package storage
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/couchbase/config-manager/internal/config"
 	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/migrate"
 	"github.com/couchbase/config-manager/internal/models"
 )
 
@@ -12,23 +16,84 @@ type MetadataStorage interface {
 	GetMetadata(snapshotID string) (*models.SnapshotMetadata, error)
 	UpdatePhase(snapshotID string, phase string, mode string) error
 	UpdateServices(snapshotID string, services []string) error
-	EoLSnapshot(snapshotID string) error
+	// UpdateTags merges tags into the snapshot's tags; an empty value
+	// removes the key.
+	UpdateTags(snapshotID string, tags map[string]string) error
+	// UpdateCustomPanels adds panels to the snapshot, replacing any with
+	// the same title, and returns the resulting list.
+	UpdateCustomPanels(snapshotID string, panels []models.CustomPanelsConfig) ([]models.CustomPanelsConfig, error)
+	EoLSnapshot(snapshotID string, endedBy string) error
+	// QueryMetadata returns the documents matching q, newest first, and
+	// whether more matched than its limit. q has been validated.
+	QueryMetadata(q models.MetadataQuery) ([]models.SnapshotMetadata, bool, error)
 	Close() error
 	Type() string
 }
 
-// NewMetadataStorage creates the appropriate metadata storage based on configuration
+// checkWritable returns ErrMetadataNewerVersion when metadata was read
+// from a document with a newer schema_version than this release writes.
+func checkWritable(metadata *models.SnapshotMetadata) error {
+	if metadata.SchemaVersion > migrate.CurrentVersion {
+		return fmt.Errorf("%w: snapshot %s has schema_version %d (this release writes %d)",
+			ErrMetadataNewerVersion, metadata.SnapshotID, metadata.SchemaVersion, migrate.CurrentVersion)
+	}
+	return nil
+}
+
+// Pinger is implemented by metadata stores that can check they are
+// reachable without reading a document.
+type Pinger interface {
+	Ping(timeout time.Duration) error
+}
+
+// Ping checks ms is reachable. A store that can't be pinged, like the
+// file fallback, always is.
+func Ping(ms MetadataStorage, timeout time.Duration) error {
+	if p, ok := ms.(Pinger); ok {
+		return p.Ping(timeout)
+	}
+	return nil
+}
+
+// NewMetadataStorage creates the appropriate metadata storage based on
+// configuration, instrumented with operation latency metrics.
+//
+// With a journal file configured, the Couchbase store is wrapped in a
+// JournaledStorage, and a cluster that is unreachable at startup isn't
+// an error: mutations are journaled until Run connects to it.
 func NewMetadataStorage(cfg *config.Config) (MetadataStorage, error) {
 	if cfg.Metadata.Enabled {
-		return NewCouchbaseStorage(cfg)
+		connect := func() (MetadataStorage, error) {
+			cs, err := NewCouchbaseStorage(cfg)
+			if err != nil {
+				return nil, err
+			}
+			return Instrument(cs), nil
+		}
+		if cfg.Metadata.JournalFile == "" {
+			return connect()
+		}
+		primary, err := connect()
+		if err != nil {
+			logger.Error("Metadata store is unreachable; journaling metadata until it connects", "journal", cfg.Metadata.JournalFile, "error", err)
+			primary = nil
+		}
+		js, err := NewJournaledStorage(primary, connect, cfg.Metadata.JournalFile)
+		if err != nil {
+			if primary != nil {
+				primary.Close()
+			}
+			return nil, err
+		}
+		return js, nil
 	}
 
 	// Fallback to file storage if metadata is disabled
 	logger.Info("Metadata storage is disabled. Storing metadata in file.", "directory", cfg.Agent.Directory)
-	return NewFileMetadataStorage(cfg.Agent.Directory), nil
+	return Instrument(NewFileMetadataStorage(cfg.Agent.Directory)), nil
 }
 
-// All of these methods are fall-back, they are supposed to have implementations for physical files 
+// All of these methods are fall-back, they are supposed to have implementations for physical files
 // Since metadata is enabled, they theoretically should not be used
 // FileMetadataStorage implements MetadataStorage using files (fallback)
 type FileMetadataStorage struct {
@@ -65,13 +130,47 @@ func (fs *FileMetadataStorage) Type() string {
 }
 
 func (fs *FileMetadataStorage) UpdatePhase(snapshotID string, phase string, mode string) error {
-	return nil	
+	return nil
 }
 
 func (fs *FileMetadataStorage) UpdateServices(snapshotID string, services []string) error {
 	return nil
 }
 
-func (fs *FileMetadataStorage) EoLSnapshot(snapshotID string) error {
-	return nil	
+func (fs *FileMetadataStorage) UpdateTags(snapshotID string, tags map[string]string) error {
+	return nil
+}
+
+func (fs *FileMetadataStorage) UpdateCustomPanels(snapshotID string, panels []models.CustomPanelsConfig) ([]models.CustomPanelsConfig, error) {
+	return panels, nil
+}
+
+func (fs *FileMetadataStorage) EoLSnapshot(snapshotID string, endedBy string) error {
+	return nil
+}
+
+// QueryMetadata returns ErrQueryUnsupported: the fallback keeps no
+// metadata, so an empty result would hide the active snapshots the
+// caller can still search itself.
+func (fs *FileMetadataStorage) QueryMetadata(q models.MetadataQuery) ([]models.SnapshotMetadata, bool, error) {
+	return nil, false, ErrQueryUnsupported
+}
+
+// mergeCustomPanels replaces panels in existing that share a title with
+// one in updates and appends the rest, keeping the original order.
+func mergeCustomPanels(existing, updates []models.CustomPanelsConfig) []models.CustomPanelsConfig {
+	out := append([]models.CustomPanelsConfig(nil), existing...)
+	index := make(map[string]int, len(out))
+	for i, cp := range out {
+		index[cp.Title] = i
+	}
+	for _, cp := range updates {
+		if i, ok := index[cp.Title]; ok {
+			out[i] = cp
+			continue
+		}
+		index[cp.Title] = len(out)
+		out = append(out, cp)
+	}
+	return out
 }
@@ -0,0 +1,412 @@
+package storage
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/config"
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/metrics"
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// reloadTimeout bounds a call to an agent's reload endpoint.
+const reloadTimeout = 5 * time.Second
+
+// Shard is one agent instance and the FileStorage holding the scrape
+// files it reads.
+type Shard struct {
+	*FileStorage
+	Name      string
+	ReloadURL string
+	// Capacity caps the active snapshots on the shard; 0 is unlimited.
+	Capacity int
+	// Products dedicates the shard to snapshots scraping only these
+	// products.
+	Products []string
+}
+
+// Directory is the directory the shard's scrape files are written to.
+func (sh *Shard) Directory() string {
+	return sh.baseDirectory
+}
+
+// Count returns the number of active snapshots on the shard.
+func (sh *Shard) Count() (int, error) {
+	entries, err := os.ReadDir(sh.baseDirectory)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read directory: %w", err)
+	}
+	n := 0
+	for _, e := range entries {
+		if !e.IsDir() && filepath.Ext(e.Name()) == ".yml" {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// CheckWritable creates and removes a temporary file in the shard's
+// directory, to tell whether scrape files can be written there. The
+// file's name doesn't end in .yml, so the agent never reads it.
+func (sh *Shard) CheckWritable() error {
+	f, err := os.CreateTemp(sh.baseDirectory, ".readyz-*.tmp")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	err = f.Close()
+	if rerr := os.Remove(name); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// CheckAgent reports whether sh's agent accepts connections at the host
+// of its reload URL, without calling the endpoint and reloading it. A
+// shard without a reload URL has nothing to check.
+func CheckAgent(sh *Shard, timeout time.Duration) error {
+	if sh.ReloadURL == "" {
+		return nil
+	}
+	u, err := url.Parse(sh.ReloadURL)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// dedicatedTo reports whether the shard lists every one of products.
+func (sh *Shard) dedicatedTo(products []string) bool {
+	if len(sh.Products) == 0 || len(products) == 0 {
+		return false
+	}
+	for _, p := range products {
+		if !slices.Contains(sh.Products, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Shards spreads snapshots across agent shards. New snapshots are placed
+// by the placement policy; every other operation finds the shard that
+// holds the snapshot, so callers address snapshots by id alone.
+type Shards struct {
+	shards    []*Shard
+	placement string
+	client    *http.Client
+	// mu serialises placement with the save it decides on, so two
+	// concurrent creates can't both take a shard's last slot.
+	mu sync.Mutex
+}
+
+// NewShards returns shards placed by policy, one of the
+// config.Placement* values; empty means least_loaded.
+func NewShards(policy string, shards ...*Shard) *Shards {
+	if policy == "" {
+		policy = config.PlacementLeastLoaded
+	}
+	for _, sh := range shards {
+		metrics.ShardCapacity.WithLabelValues(sh.Name).Set(float64(sh.Capacity))
+	}
+	return &Shards{
+		shards:    shards,
+		placement: policy,
+		client:    &http.Client{Timeout: reloadTimeout},
+	}
+}
+
+// SingleShard wraps fs as the only, unlimited shard.
+func SingleShard(fs *FileStorage) *Shards {
+	return NewShards("", &Shard{FileStorage: fs, Name: config.DefaultShard})
+}
+
+// NewShardsFromConfig builds the shards listed in cfg, or the default
+// shard built from the single-agent settings.
+func NewShardsFromConfig(cfg *config.Config) (*Shards, error) {
+	specs, err := cfg.AgentShards()
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]*Shard, 0, len(specs))
+	for _, spec := range specs {
+		shards = append(shards, &Shard{
+			FileStorage: NewFileStorage(spec.Directory, spec.FileSDDirectory),
+			Name:        spec.Name,
+			ReloadURL:   spec.ReloadURL,
+			Capacity:    spec.Capacity,
+			Products:    spec.Products,
+		})
+	}
+	return NewShards(cfg.Agent.Placement, shards...), nil
+}
+
+// List returns the shards in configuration order.
+func (s *Shards) List() []*Shard {
+	return s.shards
+}
+
+// Locate returns the shard holding id, or ErrSnapshotNotFound.
+func (s *Shards) Locate(id string) (*Shard, error) {
+	for _, sh := range s.shards {
+		if sh.has(id) {
+			return sh, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no shard holds %s", ErrSnapshotNotFound, id)
+}
+
+// Place picks the shard for a new snapshot scraping products. Shards
+// dedicated to all of the products come first; the shards without
+// products take the snapshot when none of those has room. It returns
+// ErrNoCapacity when every candidate is full.
+func (s *Shards) Place(products []string) (*Shard, error) {
+	return s.place(products, true)
+}
+
+// place implements Place. With checkCapacity false, full shards stay
+// candidates, for rendering a snapshot that won't be written.
+func (s *Shards) place(products []string, checkCapacity bool) (*Shard, error) {
+	var dedicated, general []*Shard
+	for _, sh := range s.shards {
+		switch {
+		case sh.dedicatedTo(products):
+			dedicated = append(dedicated, sh)
+		case len(sh.Products) == 0:
+			general = append(general, sh)
+		}
+	}
+	for _, candidates := range [][]*Shard{dedicated, general} {
+		sh, err := s.pick(candidates, checkCapacity)
+		if err != nil || sh != nil {
+			return sh, err
+		}
+	}
+	return nil, fmt.Errorf("%w for products %s", ErrNoCapacity, strings.Join(products, ","))
+}
+
+// pick applies the placement policy to candidates, returning nil when
+// all of them are full.
+func (s *Shards) pick(candidates []*Shard, checkCapacity bool) (*Shard, error) {
+	var best *Shard
+	bestCount := 0
+	for _, sh := range candidates {
+		n, err := sh.Count()
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", sh.Name, err)
+		}
+		if checkCapacity && sh.Capacity > 0 && n >= sh.Capacity {
+			continue
+		}
+		if s.placement == config.PlacementFirstFit {
+			return sh, nil
+		}
+		if best == nil || n < bestCount {
+			best, bestCount = sh, n
+		}
+	}
+	return best, nil
+}
+
+// SaveSnapshot places a new snapshot scraping products and writes it to
+// the chosen shard. An empty id generates one; a chosen id already on any
+// shard returns ErrSnapshotExists. The caller asks the shard's agent to
+// reload with Reload once it has released its own locks, since that is an
+// HTTP round-trip.
+func (s *Shards) SaveSnapshot(id string, products []string, clusterInfo interface{}, agentType string) (string, *Shard, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id != "" {
+		if _, err := s.Locate(id); err == nil {
+			return "", nil, fmt.Errorf("%w: %s", ErrSnapshotExists, id)
+		}
+	}
+	sh, err := s.Place(products)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err = sh.SaveSnapshot(id, clusterInfo, agentType)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, sh, nil
+}
+
+// RenderSnapshot returns the scrape config SaveSnapshot would write for
+// clusterInfo on the shard it would be placed on. Nothing is written, so
+// full shards are not skipped: a dry run renders even when a create
+// would be refused with ErrNoCapacity.
+func (s *Shards) RenderSnapshot(products []string, clusterInfo interface{}, agentType string, id string) ([]byte, error) {
+	sh, err := s.place(products, false)
+	if err != nil {
+		return nil, err
+	}
+	return sh.RenderSnapshot(clusterInfo, agentType, id)
+}
+
+// ListSnapshots returns the active snapshots of every shard, oldest
+// keep-alive first.
+func (s *Shards) ListSnapshots() ([]models.DisplaySnapshot, error) {
+	var out []models.DisplaySnapshot
+	for _, sh := range s.shards {
+		snapshots, err := sh.ListSnapshots()
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", sh.Name, err)
+		}
+		for i := range snapshots {
+			snapshots[i].Shard = sh.Name
+		}
+		out = append(out, snapshots...)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].TimeStamp.Before(out[j].TimeStamp)
+	})
+	return out, nil
+}
+
+// GetSnapshot returns id's snapshot along with the shard holding it.
+func (s *Shards) GetSnapshot(id string) (models.DisplaySnapshot, error) {
+	sh, err := s.Locate(id)
+	if err != nil {
+		return models.DisplaySnapshot{}, err
+	}
+	snapshot, err := sh.GetSnapshot(id)
+	snapshot.Shard = sh.Name
+	return snapshot, err
+}
+
+// DeleteSnapshot removes id from its shard and asks the agent to reload.
+func (s *Shards) DeleteSnapshot(id string) error {
+	sh, err := s.Locate(id)
+	if err != nil {
+		return err
+	}
+	if err := sh.DeleteSnapshot(id); err != nil {
+		return err
+	}
+	s.Reload(sh)
+	return nil
+}
+
+// PatchSnapshot refreshes id's keep-alive on its shard.
+func (s *Shards) PatchSnapshot(id string) error {
+	sh, err := s.Locate(id)
+	if err != nil {
+		return err
+	}
+	return sh.PatchSnapshot(id)
+}
+
+// PatchTargets edits id's file_sd target list on its shard.
+func (s *Shards) PatchTargets(id string, patch models.TargetsPatchRequest, maxTargets int) ([]string, error) {
+	sh, err := s.Locate(id)
+	if err != nil {
+		return nil, err
+	}
+	return sh.PatchTargets(id, patch, maxTargets)
+}
+
+// ReadSnapshot returns id's raw scrape config.
+func (s *Shards) ReadSnapshot(id string) ([]byte, error) {
+	sh, err := s.Locate(id)
+	if err != nil {
+		return nil, err
+	}
+	return sh.ReadSnapshot(id)
+}
+
+// SaveRequest keeps id's request and creator on its shard.
+func (s *Shards) SaveRequest(id, caller string, req *models.SnapshotRequest) error {
+	sh, err := s.Locate(id)
+	if err != nil {
+		return err
+	}
+	return sh.SaveRequest(id, caller, req)
+}
+
+// GetRequest returns the request id was created from.
+func (s *Shards) GetRequest(id string) (*models.SnapshotRequest, error) {
+	sh, err := s.Locate(id)
+	if err != nil {
+		return nil, err
+	}
+	return sh.GetRequest(id)
+}
+
+// GetStoredRequest returns id's request with its creator and creation
+// time.
+func (s *Shards) GetStoredRequest(id string) (*StoredRequest, error) {
+	sh, err := s.Locate(id)
+	if err != nil {
+		return nil, err
+	}
+	return sh.GetStoredRequest(id)
+}
+
+// TargetGroups returns id's file_sd target lists by scheme.
+func (s *Shards) TargetGroups(id string) (map[string][]models.TargetGroup, error) {
+	sh, err := s.Locate(id)
+	if err != nil {
+		return nil, err
+	}
+	return sh.TargetGroups(id)
+}
+
+// ReplaceTargetGroups overwrites id's file_sd target lists.
+func (s *Shards) ReplaceTargetGroups(id string, groups map[string][]models.TargetGroup) error {
+	sh, err := s.Locate(id)
+	if err != nil {
+		return err
+	}
+	return sh.ReplaceTargetGroups(id, groups)
+}
+
+// Reload asks sh's agent to re-read its scrape files. A failed reload
+// is logged and counted rather than failing the change that caused it:
+// the agent still picks the files up on its next config check.
+func (s *Shards) Reload(sh *Shard) {
+	if sh.ReloadURL == "" {
+		return
+	}
+	err := s.reload(sh.ReloadURL)
+	if err != nil {
+		logger.Warn("Failed to reload agent", "shard", sh.Name, "url", sh.ReloadURL, "error", err)
+		metrics.AgentReloads.WithLabelValues(sh.Name, "failure").Inc()
+		return
+	}
+	metrics.AgentReloads.WithLabelValues(sh.Name, "success").Inc()
+}
+
+func (s *Shards) reload(url string) error {
+	resp, err := s.client.Post(url, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("reload returned %s", resp.Status)
+	}
+	return nil
+}
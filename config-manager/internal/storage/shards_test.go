@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/config"
+)
+
+var staticCluster = map[string]interface{}{
+	"configs": []interface{}{
+		map[string]interface{}{"hostnames": []string{"node1"}, "type": "static", "port": 9100},
+	},
+	"credentials": map[string]interface{}{"username": "u", "password": "p"},
+}
+
+func newShard(t *testing.T, name string, capacity int, products ...string) *Shard {
+	t.Helper()
+	return &Shard{FileStorage: NewFileStorage(t.TempDir(), ""), Name: name, Capacity: capacity, Products: products}
+}
+
+func TestShardsPlacement(t *testing.T) {
+	kafka := newShard(t, "kafka", 1, "kafka")
+	a := newShard(t, "a", 2)
+	b := newShard(t, "b", 0)
+	shards := NewShards(config.PlacementLeastLoaded, kafka, a, b)
+
+	save := func(products ...string) *Shard {
+		t.Helper()
+		id, sh, err := shards.SaveSnapshot("", products, staticCluster, "vmagent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if located, err := shards.Locate(id); err != nil || located != sh {
+			t.Fatalf("Locate(%s) = %v, %v; want shard %s", id, located, err, sh.Name)
+		}
+		return sh
+	}
+
+	// Dedicated shards win, and overflow to the general ones when full.
+	if sh := save("kafka"); sh != kafka {
+		t.Errorf("kafka snapshot placed on %s", sh.Name)
+	}
+	if sh := save("kafka"); sh == kafka {
+		t.Error("kafka shard took a snapshot past its capacity")
+	}
+	// Least loaded alternates between the general shards.
+	first, second := save("couchbase"), save("couchbase")
+	if first == second || first == kafka || second == kafka {
+		t.Errorf("couchbase snapshots placed on %s and %s", first.Name, second.Name)
+	}
+
+	if _, err := shards.Locate("missing"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Locate(missing) = %v, want ErrSnapshotNotFound", err)
+	}
+	list, err := shards.ListSnapshots()
+	if err != nil || len(list) != 4 {
+		t.Fatalf("ListSnapshots = %d snapshots, %v; want 4", len(list), err)
+	}
+	for _, s := range list {
+		if s.Shard == "" {
+			t.Errorf("snapshot %s listed without its shard", s.Name)
+		}
+	}
+}
+
+func TestShardsFirstFitAndCapacity(t *testing.T) {
+	a := newShard(t, "a", 1)
+	b := newShard(t, "b", 1)
+	shards := NewShards(config.PlacementFirstFit, a, b)
+
+	for _, want := range []*Shard{a, b} {
+		if _, sh, err := shards.SaveSnapshot("", nil, staticCluster, "vmagent"); err != nil || sh != want {
+			t.Fatalf("placed on %v (%v), want %s", sh, err, want.Name)
+		}
+	}
+	if _, _, err := shards.SaveSnapshot("", nil, staticCluster, "vmagent"); !errors.Is(err, ErrNoCapacity) {
+		t.Fatalf("save with every shard full = %v, want ErrNoCapacity", err)
+	}
+	// A dry run writes nothing, so it still renders.
+	if content, err := shards.RenderSnapshot(nil, staticCluster, "vmagent", "dry-run"); err != nil || len(content) == 0 {
+		t.Fatalf("render with every shard full = %q, %v; want the scrape config", content, err)
+	}
+	if n, _ := a.Count(); n != 1 {
+		t.Errorf("render changed shard a to %d snapshots", n)
+	}
+}
+
+func TestShardsReload(t *testing.T) {
+	var reloads atomic.Int32
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/-/reload" {
+			reloads.Add(1)
+		}
+	}))
+	defer agent.Close()
+
+	sh := newShard(t, "a", 0)
+	sh.ReloadURL = agent.URL + "/-/reload"
+	shards := NewShards("", sh)
+
+	id, placed, err := shards.SaveSnapshot("", nil, staticCluster, "vmagent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Saving leaves the reload to the caller, outside its locks.
+	if got := reloads.Load(); got != 0 {
+		t.Fatalf("SaveSnapshot reloaded the agent %d times, want 0", got)
+	}
+	shards.Reload(placed)
+	if err := shards.PatchSnapshot(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := shards.DeleteSnapshot(id); err != nil {
+		t.Fatal(err)
+	}
+	if got := reloads.Load(); got != 2 {
+		t.Errorf("agent reloaded %d times, want 2 (create and delete)", got)
+	}
+}
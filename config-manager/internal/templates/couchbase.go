@@ -0,0 +1,117 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/gocb/v2"
+)
+
+// CouchbaseStore keeps templates as documents keyed by name in a
+// collection of the metadata bucket.
+type CouchbaseStore struct {
+	cluster    *gocb.Cluster
+	collection *gocb.Collection
+	keyspace   string
+	timeout    time.Duration
+}
+
+// NewCouchbaseStore uses the named collection in the bucket's scope,
+// creating it and its query index when they don't exist. The store owns
+// cluster and closes it on Close.
+func NewCouchbaseStore(cluster *gocb.Cluster, bucket *gocb.Bucket, scope, collection string, timeout time.Duration) (*CouchbaseStore, error) {
+	err := bucket.CollectionsV2().CreateCollection(scope, collection, nil, &gocb.CreateCollectionOptions{Timeout: timeout})
+	if err != nil && !errors.Is(err, gocb.ErrCollectionExists) {
+		return nil, fmt.Errorf("failed to create templates collection %q: %w", collection, err)
+	}
+
+	cs := &CouchbaseStore{
+		cluster:    cluster,
+		collection: bucket.Scope(scope).Collection(collection),
+		keyspace:   fmt.Sprintf("`%s`.`%s`.`%s`", bucket.Name(), scope, collection),
+		timeout:    timeout,
+	}
+
+	// Listing sorts on name. Without the index it fails, but templates
+	// can still be read and written by name, so this is only a warning.
+	_, err = cluster.Query(
+		"CREATE INDEX `idx_templates_name` IF NOT EXISTS ON "+cs.keyspace+"(name)",
+		&gocb.QueryOptions{Timeout: timeout},
+	)
+	if err != nil {
+		logger.Warn("Failed to create templates index; listing templates will fail until it exists", "keyspace", cs.keyspace, "error", err)
+	}
+	return cs, nil
+}
+
+// List queries every template, ordered by name.
+func (cs *CouchbaseStore) List() ([]Template, error) {
+	rows, err := cs.cluster.Query(
+		"SELECT t.* FROM "+cs.keyspace+" AS t WHERE t.name IS VALUED ORDER BY t.name",
+		&gocb.QueryOptions{Timeout: cs.timeout, Readonly: true},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templates: %w", err)
+	}
+	defer rows.Close()
+
+	out := []Template{}
+	for rows.Next() {
+		var t Template
+		if err := rows.Row(&t); err != nil {
+			return nil, fmt.Errorf("failed to decode template: %w", err)
+		}
+		out = append(out, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query templates: %w", err)
+	}
+	return out, nil
+}
+
+// Get fetches the template name.
+func (cs *CouchbaseStore) Get(name string) (*Template, error) {
+	result, err := cs.collection.Get(name, &gocb.GetOptions{Timeout: cs.timeout})
+	if err != nil {
+		if errors.Is(err, gocb.ErrDocumentNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		return nil, fmt.Errorf("failed to get template from Couchbase: %w", err)
+	}
+	var t Template
+	if err := result.Content(&t); err != nil {
+		return nil, fmt.Errorf("failed to decode template: %w", err)
+	}
+	return &t, nil
+}
+
+// Save upserts the template.
+func (cs *CouchbaseStore) Save(t *Template) error {
+	if _, err := cs.collection.Upsert(t.Name, t, &gocb.UpsertOptions{Timeout: cs.timeout}); err != nil {
+		return fmt.Errorf("failed to save template to Couchbase: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the template's document.
+func (cs *CouchbaseStore) Delete(name string) error {
+	if _, err := cs.collection.Remove(name, &gocb.RemoveOptions{Timeout: cs.timeout}); err != nil {
+		if errors.Is(err, gocb.ErrDocumentNotFound) {
+			return fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		return fmt.Errorf("failed to delete template from Couchbase: %w", err)
+	}
+	return nil
+}
+
+// Close closes the Couchbase connection
+func (cs *CouchbaseStore) Close() error {
+	return cs.cluster.Close(nil)
+}
+
+// Type returns the type of the template store
+func (cs *CouchbaseStore) Type() string {
+	return "couchbase"
+}
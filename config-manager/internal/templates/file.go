@@ -0,0 +1,121 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileStore keeps one JSON file per template in a directory.
+type FileStore struct {
+	directory string
+}
+
+// NewFileStore creates directory if needed.
+func NewFileStore(directory string) (*FileStore, error) {
+	if err := os.MkdirAll(directory, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create templates directory: %w", err)
+	}
+	return &FileStore{directory: directory}, nil
+}
+
+func (fs *FileStore) path(name string) string {
+	return filepath.Join(fs.directory, name+".json")
+}
+
+// valid keeps names that come from URLs inside the directory.
+func valid(name string) bool {
+	return name != "" && filepath.Base(name) == name
+}
+
+// List reads every template in the directory.
+func (fs *FileStore) List() ([]Template, error) {
+	entries, err := os.ReadDir(fs.directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read templates directory: %w", err)
+	}
+	out := []Template{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		t, err := fs.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Get reads the template name.
+func (fs *FileStore) Get(name string) (*Template, error) {
+	if !valid(name) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	content, err := os.ReadFile(fs.path(name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	var t Template
+	if err := json.Unmarshal(content, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+	return &t, nil
+}
+
+// Save writes the template atomically. Templates may hold the password
+// and TLS keys their creates use, so the file is only readable by the
+// service's user.
+func (fs *FileStore) Save(t *Template) error {
+	if !valid(t.Name) {
+		return fmt.Errorf("invalid template name %q", t.Name)
+	}
+	content, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode template: %w", err)
+	}
+	path := fs.path(t.Name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return fmt.Errorf("failed to write template: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write template: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the template's file.
+func (fs *FileStore) Delete(name string) error {
+	if !valid(name) {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	err := os.Remove(fs.path(name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	return nil
+}
+
+// Close is a no-op for file templates.
+func (fs *FileStore) Close() error {
+	return nil
+}
+
+// Type returns the type of the template store
+func (fs *FileStore) Type() string {
+	return "file"
+}
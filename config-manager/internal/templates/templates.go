@@ -0,0 +1,119 @@
+// Package templates keeps named snapshot requests that test setups
+// create snapshots from, so a lab's configs, presets and tags are defined
+// once instead of in every harness. A template's version goes up with
+// every update and is recorded on the snapshots made from it.
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+// ErrNotFound means there is no template with the name.
+var ErrNotFound = errors.New("template not found")
+
+// ErrDisabled is returned by Disabled.
+var ErrDisabled = errors.New("templates are not configured")
+
+// Template is a named snapshot request.
+type Template struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Version is 1 when the template is created and goes up by one with
+	// every update.
+	Version int `json:"version"`
+	// Request is what a create referencing the template starts from. Its
+	// credentials may be left out for the create to supply.
+	Request   *models.SnapshotRequest `json:"request"`
+	CreatedAt time.Time               `json:"created_at"`
+	UpdatedAt time.Time               `json:"updated_at"`
+	// CreatedBy and UpdatedBy name the API callers; both are empty when
+	// auth is disabled.
+	CreatedBy string `json:"created_by,omitempty"`
+	UpdatedBy string `json:"updated_by,omitempty"`
+}
+
+// Store persists templates.
+type Store interface {
+	// List returns every template, ordered by name.
+	List() ([]Template, error)
+	// Get returns ErrNotFound (wrapped) when there is no template name.
+	Get(name string) (*Template, error)
+	// Save writes t, replacing any template of the same name.
+	Save(t *Template) error
+	// Delete returns ErrNotFound (wrapped) when there is no template name.
+	Delete(name string) error
+	Close() error
+	Type() string
+}
+
+// Resolve returns the request t expands to with overrides, a JSON merge
+// patch (RFC 7386), applied: objects are merged key by key, null removes
+// a key and anything else, arrays included, replaces the template's
+// value.
+func Resolve(t *Template, overrides json.RawMessage) (*models.SnapshotRequest, error) {
+	base, err := json.Marshal(t.Request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode template %s: %w", t.Name, err)
+	}
+	if len(overrides) > 0 {
+		var doc, patch interface{}
+		if err := json.Unmarshal(base, &doc); err != nil {
+			return nil, fmt.Errorf("failed to decode template %s: %w", t.Name, err)
+		}
+		if err := json.Unmarshal(overrides, &patch); err != nil {
+			return nil, fmt.Errorf("invalid overrides: %w", err)
+		}
+		if _, ok := patch.(map[string]interface{}); !ok {
+			return nil, errors.New("overrides must be an object")
+		}
+		if base, err = json.Marshal(mergePatch(doc, patch)); err != nil {
+			return nil, fmt.Errorf("failed to apply overrides: %w", err)
+		}
+	}
+	var req models.SnapshotRequest
+	if err := json.Unmarshal(base, &req); err != nil {
+		return nil, fmt.Errorf("invalid overrides: %w", err)
+	}
+	return &req, nil
+}
+
+func mergePatch(doc, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]interface{})
+	if !ok {
+		d = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+			continue
+		}
+		d[k] = mergePatch(d[k], v)
+	}
+	return d
+}
+
+// Disabled is a Store without templates that refuses writes, for
+// callers that don't configure one.
+var Disabled Store = disabled{}
+
+type disabled struct{}
+
+func (disabled) List() ([]Template, error) { return []Template{}, nil }
+func (disabled) Get(name string) (*Template, error) {
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+func (disabled) Save(*Template) error { return ErrDisabled }
+func (disabled) Delete(name string) error {
+	return fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+func (disabled) Close() error { return nil }
+func (disabled) Type() string { return "disabled" }
@@ -0,0 +1,97 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/config-manager/internal/models"
+)
+
+func TestFileStore(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"lab-b", "lab-a"} {
+		tmpl := &Template{Name: name, Version: 1, Request: &models.SnapshotRequest{Label: name}}
+		if err := fs.Save(tmpl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if info, err := os.Stat(filepath.Join(fs.directory, "lab-a.json")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("template file = %v, %v; want mode 600", info, err)
+	}
+
+	list, err := fs.List()
+	if err != nil || len(list) != 2 || list[0].Name != "lab-a" || list[1].Request.Label != "lab-b" {
+		t.Fatalf("List = %+v, %v; want lab-a then lab-b", list, err)
+	}
+	if err := fs.Delete("lab-a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"lab-a", "../lab-b", ""} {
+		if _, err := fs.Get(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want ErrNotFound", name, err)
+		}
+	}
+	if err := fs.Delete("lab-a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tmpl := &Template{
+		Name: "lab-a-kv",
+		Request: &models.SnapshotRequest{
+			Configs:     []models.ConfigObject{{Hostnames: []string{"a"}, Port: 8091}},
+			Credentials: models.Credentials{Username: "u"},
+			Label:       "kv",
+			Tags:        map[string]string{"lab": "a", "owner": "perf"},
+			Presets:     []string{"cbagent"},
+		},
+	}
+	cases := []struct {
+		name      string
+		overrides string
+		want      func(*models.SnapshotRequest)
+	}{
+		{"none", "", func(*models.SnapshotRequest) {}},
+		{"merge", `{"credentials":{"password":"p"},"tags":{"owner":null,"build":"7.6"}}`, func(r *models.SnapshotRequest) {
+			r.Credentials.Password = "p"
+			r.Tags = map[string]string{"lab": "a", "build": "7.6"}
+		}},
+		{"replace list", `{"configs":[{"hostnames":["b"],"port":9100}],"presets":null}`, func(r *models.SnapshotRequest) {
+			r.Configs = []models.ConfigObject{{Hostnames: []string{"b"}, Port: 9100}}
+			r.Presets = nil
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Resolve(tmpl, json.RawMessage(tc.overrides))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := json.Marshal(tmpl.Request)
+			var want models.SnapshotRequest
+			json.Unmarshal(data, &want)
+			tc.want(&want)
+			if !reflect.DeepEqual(got, &want) {
+				t.Errorf("Resolve = %+v\nwant %+v", got, &want)
+			}
+		})
+	}
+
+	if tmpl.Request.Tags["owner"] != "perf" {
+		t.Error("Resolve modified the template")
+	}
+	for _, bad := range []string{`[]`, `{"configs":"a"}`, `{`} {
+		if _, err := Resolve(tmpl, json.RawMessage(bad)); err == nil {
+			t.Errorf("Resolve with overrides %s succeeded", bad)
+		}
+	}
+}
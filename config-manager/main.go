@@ -9,37 +9,35 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
-	"time"
 
 	"github.com/couchbase/config-manager/internal/api"
+	"github.com/couchbase/config-manager/internal/archive"
+	"github.com/couchbase/config-manager/internal/audit"
+	"github.com/couchbase/config-manager/internal/auth"
 	"github.com/couchbase/config-manager/internal/config"
 	"github.com/couchbase/config-manager/internal/logger"
 	"github.com/couchbase/config-manager/internal/manager"
 	"github.com/couchbase/config-manager/internal/metrics"
+	"github.com/couchbase/config-manager/internal/migrate"
+	"github.com/couchbase/config-manager/internal/overlap"
+	"github.com/couchbase/config-manager/internal/presets"
+	"github.com/couchbase/config-manager/internal/quota"
+	"github.com/couchbase/config-manager/internal/sd"
 	"github.com/couchbase/config-manager/internal/storage"
+	"github.com/couchbase/config-manager/internal/templates"
+	"gopkg.in/yaml.v3"
 )
 
 func main() {
-	var configPath string
-	// If config is not provided, use the defaults with any of the flag overrides
-	flag.StringVar(&configPath, "config", "", "Path to the configuration file")
-
-	// Parse all flags first to get any dot-notation overrides
-	flag.Parse()
-
-	// Collect any remaining arguments that might be dot-notation overrides
-	flagOverrides := make(map[string]string)
-	for _, arg := range flag.Args() {
-		if strings.Contains(arg, "=") {
-			parts := strings.SplitN(arg, "=", 2)
-			if len(parts) == 2 {
-				// Remove leading dashes if present
-				flagName := strings.TrimLeft(parts[0], "-")
-				flagOverrides[flagName] = parts[1]
-			}
-		}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
 	}
 
+	configPath, flagOverrides := parseArgs(flag.CommandLine, os.Args[1:])
+
 	// Load configuration with potential overrides first
 	if len(configPath) > 0 {
 		logger.GetLogger().Info("Loading configurations from file", "path", configPath)
@@ -60,6 +58,8 @@ func main() {
 		"server_host", cfg.Server.Host,
 		"agent_type", cfg.Agent.Type,
 		"agent_directory", cfg.Agent.Directory,
+		"agent_shards", len(cfg.Agent.Shards),
+		"agent_placement", cfg.Agent.Placement,
 		"logging_level", cfg.Logging.Level,
 		"manager_interval", cfg.Manager.Interval,
 		"manager_min_interval", cfg.Manager.MinInterval,
@@ -67,6 +67,9 @@ func main() {
 		"metadata_enabled", cfg.Metadata.Enabled,
 		"metadata_host", cfg.Metadata.Host,
 		"metadata_bucket", cfg.Metadata.Bucket,
+		"metadata_scope", cfg.Metadata.Scope,
+		"metadata_collection", cfg.Metadata.Collection,
+		"metadata_tls", cfg.Metadata.TLS,
 	)
 
 	// Validate agent type is vmagent
@@ -75,52 +78,132 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Validate if the base directory exists before initializing storage
-	if _, err := os.Stat(cfg.Agent.Directory); os.IsNotExist(err) {
-		if err := os.MkdirAll(cfg.Agent.Directory, 0755); err != nil {
-			logger.Error("Failed to create directory", "directory", cfg.Agent.Directory, "error", err)
-			os.Exit(1)
-		}
+	if err := overlap.ValidatePolicy(cfg.Overlap.Policy); err != nil {
+		logger.Error("Invalid overlap configuration", "error", err)
+		os.Exit(1)
 	}
 
-	fileStorage := storage.NewFileStorage(cfg.Agent.Directory)
-
-	interval := cfg.Manager.Interval
-	mininterval := cfg.Manager.MinInterval
-
-	// Validate manager interval
-	if interval > 30*time.Minute || interval < mininterval {
-		logger.Warn("Manager interval should be between 5 and 30 minutes", "interval", interval, "default", 5*time.Minute)
-		interval = mininterval // Default to 5 minutes if invalid
+	// Build the agent shards and make sure each directory exists before
+	// initializing storage
+	shards, err := storage.NewShardsFromConfig(cfg)
+	if err != nil {
+		logger.Error("Invalid agent configuration", "error", err)
+		os.Exit(1)
 	}
-
-	staleThreshold := cfg.Manager.StaleThreshold
-	// Validate manager stale threshold
-	if staleThreshold > 30*time.Minute || staleThreshold < 5*time.Minute {
-		logger.Warn("Manager stale threshold should be between 5 and 30 minutes", "stale_threshold", staleThreshold, "default", 5*time.Minute)
-		staleThreshold = 5 * time.Minute // Default to 5 minutes if invalid
+	for _, shard := range shards.List() {
+		if _, err := os.Stat(shard.Directory()); os.IsNotExist(err) {
+			if err := os.MkdirAll(shard.Directory(), 0755); err != nil {
+				logger.Error("Failed to create directory", "shard", shard.Name, "directory", shard.Directory(), "error", err)
+				os.Exit(1)
+			}
+		}
+		logger.Info("Agent shard configured", "shard", shard.Name, "directory", shard.Directory(), "capacity", shard.Capacity, "products", shard.Products, "reload_url", shard.ReloadURL)
 	}
 
+	// Validate manager interval and stale threshold
+	information := manager.Information{
+		Interval:       cfg.Manager.Interval,
+		MinInterval:    cfg.Manager.MinInterval,
+		StaleThreshold: cfg.Manager.StaleThreshold,
+	}.Validated()
+
 	// Initialize metadata storage
 	metadataStorage, err := storage.NewMetadataStorage(cfg)
 	if err != nil {
 		logger.Error("Failed to initialize metadata storage. Metadata will not be collected.", "error", err)
-		metadataStorage = storage.NewFileMetadataStorage(cfg.Agent.Directory)
+		metadataStorage = storage.Instrument(storage.NewFileMetadataStorage(cfg.Agent.Directory))
 		logger.Info("Falling back to file metadata storage", "directory", cfg.Agent.Directory)
 	} else {
 		logger.Info("Metadata storage initialized", "type", metadataStorage.Type())
 	}
+	defer metadataStorage.Close()
+
+	// Replay mutations journaled while the metadata store was unavailable.
+	stopReplay := make(chan struct{})
+	defer close(stopReplay)
+	if journal, ok := metadataStorage.(*storage.JournaledStorage); ok {
+		if cfg.Metadata.ReplayInterval <= 0 {
+			logger.Error("metadata.replay_interval must be positive", "replay_interval", cfg.Metadata.ReplayInterval)
+			os.Exit(1)
+		}
+		go journal.Run(cfg.Metadata.ReplayInterval, stopReplay)
+		logger.Info("Metadata journal enabled", "file", cfg.Metadata.JournalFile, "replay_interval", cfg.Metadata.ReplayInterval, "pending", journal.Depth())
+	}
 
 	// Initialize API handler
-	handler := api.NewHandler(fileStorage, metadataStorage, cfg.Agent.Type)
+	handler := api.NewHandler(shards, metadataStorage, cfg.Agent.Type)
 
-	// Setup HTTP server
-	mux := http.NewServeMux()
+	// Load custom-panel presets. A bad preset file is a configuration
+	// error, like bad auth settings, rather than something to skip.
+	presetRegistry, err := presets.Load(cfg.Presets.Directory)
+	if err != nil {
+		logger.Error("Failed to load presets", "directory", cfg.Presets.Directory, "error", err)
+		os.Exit(1)
+	}
+	handler.SetPresets(presetRegistry)
+	logger.Info("Presets loaded", "directory", cfg.Presets.Directory, "count", len(presetRegistry.List()))
+	handler.SetLimits(quota.FromConfig(cfg))
+	handler.SetIdempotencyWindow(cfg.Idempotency.Window)
+	handler.SetOverlapPolicy(cfg.Overlap.Policy)
+	handler.SetSDResolver(sd.NewResolver(cfg.SD.CacheTTL, cfg.SD.Timeout))
+	handler.SetReadiness(api.Readiness{
+		MetadataEnabled: cfg.Metadata.Enabled,
+		Heartbeat:       manager.Heartbeat,
+	})
+
+	// Initialize the audit log. Running without one would lose the record
+	// of who changed what, so failing to open it stops startup.
+	auditLog, err := audit.New(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize audit log", "error", err)
+		os.Exit(1)
+	}
+	defer auditLog.Close()
+	handler.SetAudit(auditLog)
+	logger.Info("Audit log initialized", "type", auditLog.Type())
+
+	// Initialize the archive of ended snapshots.
+	archives, err := archive.New(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize snapshot archive", "error", err)
+		os.Exit(1)
+	}
+	defer archives.Close()
+	handler.SetArchive(archives)
+	logger.Info("Snapshot archive initialized", "type", archives.Type())
+
+	// Initialize the snapshot template store.
+	templateStore, err := templates.New(cfg)
+	if err != nil {
+		logger.Error("Failed to initialize snapshot templates", "error", err)
+		os.Exit(1)
+	}
+	defer templateStore.Close()
+	handler.SetTemplates(templateStore)
+	logger.Info("Snapshot templates initialized", "type", templateStore.Type())
+
+	// Initialize authentication. A nil authenticator leaves every route open.
+	var authenticator auth.Authenticator
+	if cfg.Auth.Enabled {
+		static, err := auth.NewStatic(cfg.Auth)
+		if err != nil {
+			logger.Error("Invalid auth configuration", "error", err)
+			os.Exit(1)
+		}
+		authenticator = static
+		if len(cfg.Auth.Tokens) == 0 && len(cfg.Auth.Users) == 0 {
+			logger.Warn("API authentication is enabled but no tokens or users are configured; all protected routes will return 401")
+		}
+		logger.Info("API authentication enabled", "tokens", len(cfg.Auth.Tokens), "users", len(cfg.Auth.Users), "public_metrics", cfg.Auth.PublicMetrics)
+	} else {
+		logger.Warn("API authentication is disabled; every caller can create and delete snapshots")
+	}
 
-	// Register routes
-	mux.HandleFunc("/api/v1/snapshot", handler.CreateSnapshot)
-	mux.HandleFunc("/api/v1/snapshot/", handler.Manager)
-	mux.Handle("/metrics", metrics.Handler())
+	// Setup HTTP server
+	mux := api.NewRouter(handler, api.RouterOptions{
+		Authenticator: authenticator,
+		PublicMetrics: cfg.Auth.PublicMetrics,
+	})
 
 	// Create server
 	server := &http.Server{
@@ -140,27 +223,26 @@ func main() {
 	}()
 	logger.Debug(
 		"interval, mininterval and stale threshold",
-		"interval", interval.String(),
-		"mininterval", mininterval.String(),
-		"stale_threshold", staleThreshold.String(),
+		"interval", information.Interval.String(),
+		"mininterval", information.MinInterval.String(),
+		"stale_threshold", information.StaleThreshold.String(),
 	)
 
 	logger.Info("Config Manager REST Service Started")
 
 	go func() {
 
-		manager.StartManagerWithInterval(manager.Information{
-			Interval:       interval,
-			MinInterval:    mininterval,
-			StaleThreshold: staleThreshold,
-		}, cfg.Agent.Directory, cfg)
+		manager.StartManagerWithInterval(information, shards, metadataStorage, auditLog, archives)
 	}()
 	logger.Info("Manager Service Started")
 
-	// Wait for interrupt signal to gracefully shutdown the server
+	// Wait for interrupt signal to gracefully shutdown the server.
+	// SIGHUP reloads the settings that can change without a restart.
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := <-quit; sig == syscall.SIGHUP; sig = <-quit {
+		cfg = handleReload(configPath, flagOverrides, cfg, handler)
+	}
 
 	logger.Info("Shutting down server...")
 
@@ -172,3 +254,129 @@ func main() {
 
 	logger.Info("Server exited")
 }
+
+// parseArgs parses the -config flag and collects the remaining
+// section.field=value arguments as overrides.
+func parseArgs(fs *flag.FlagSet, args []string) (string, map[string]string) {
+	var configPath string
+	// If config is not provided, use the defaults with any of the flag overrides
+	fs.StringVar(&configPath, "config", "", "Path to the configuration file")
+
+	// Parse all flags first to get any dot-notation overrides
+	_ = fs.Parse(args)
+
+	// Collect any remaining arguments that might be dot-notation overrides
+	flagOverrides := make(map[string]string)
+	for _, arg := range fs.Args() {
+		if strings.Contains(arg, "=") {
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) == 2 {
+				// Remove leading dashes if present
+				flagName := strings.TrimLeft(parts[0], "-")
+				flagOverrides[flagName] = parts[1]
+			}
+		}
+	}
+	return configPath, flagOverrides
+}
+
+// runValidate implements `config-manager validate`: it loads the
+// configuration exactly as the service would, checks the parts that
+// would stop it from starting, and prints the effective configuration
+// with secrets redacted. It returns the process exit code.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath, flagOverrides := parseArgs(fs, args)
+
+	cfg, err := config.LoadConfig(configPath, flagOverrides)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		return 1
+	}
+
+	var problems []string
+	if strings.ToLower(cfg.Agent.Type) != "vmagent" {
+		problems = append(problems, fmt.Sprintf("agent.type: unsupported agent type %q (supported: vmagent)", cfg.Agent.Type))
+	}
+	if cfg.Auth.Enabled {
+		if _, err := auth.NewStatic(cfg.Auth); err != nil {
+			problems = append(problems, "auth: "+err.Error())
+		}
+	}
+	if _, err := cfg.AgentShards(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if cfg.Metadata.Enabled {
+		if err := storage.ValidateCouchbaseConfig(cfg); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if cfg.Metadata.JournalFile != "" && cfg.Metadata.ReplayInterval <= 0 {
+			problems = append(problems, "metadata: replay_interval must be positive")
+		}
+	}
+	if _, err := presets.Load(cfg.Presets.Directory); err != nil {
+		problems = append(problems, "presets: "+err.Error())
+	}
+	if err := overlap.ValidatePolicy(cfg.Overlap.Policy); err != nil {
+		problems = append(problems, "overlap: "+err.Error())
+	}
+	if cfg.SD.CacheTTL < 0 || cfg.SD.Timeout <= 0 {
+		problems = append(problems, "sd: cache_ttl can't be negative and timeout must be positive")
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg.Redacted()); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to render configuration:", err)
+		return 1
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, "invalid configuration:", p)
+		}
+		return 1
+	}
+	return 0
+}
+
+// runMigrate implements `config-manager migrate`: it upgrades every
+// metadata document below the current schema version in place. Documents
+// are also upgraded as they are read, so this is a backfill; it only
+// touches documents that still need migrating and is safe to re-run.
+// It returns the process exit code.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Report what would be migrated without writing")
+	configPath, flagOverrides := parseArgs(fs, args)
+
+	cfg, err := config.LoadConfig(configPath, flagOverrides)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		return 1
+	}
+	logger.InitLogger(cfg.Logging.Level)
+
+	cs, err := storage.NewCouchbaseStorage(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to connect to metadata storage:", err)
+		return 1
+	}
+	defer cs.Close()
+
+	res, err := cs.MigrateAll(*dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migration failed:", err)
+		return 1
+	}
+	verb := "migrated"
+	if *dryRun {
+		verb = "would migrate"
+	}
+	fmt.Printf("schema version %d: %d to migrate, %s %d, skipped %d, failed %d\n",
+		migrate.CurrentVersion, res.Scanned, verb, res.Migrated, res.Skipped, res.Failed)
+	if res.Failed > 0 {
+		return 1
+	}
+	return 0
+}
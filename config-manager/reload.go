@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/couchbase/config-manager/internal/api"
+	"github.com/couchbase/config-manager/internal/config"
+	"github.com/couchbase/config-manager/internal/logger"
+	"github.com/couchbase/config-manager/internal/manager"
+	"github.com/couchbase/config-manager/internal/metrics"
+	"github.com/couchbase/config-manager/internal/overlap"
+	"github.com/couchbase/config-manager/internal/presets"
+	"github.com/couchbase/config-manager/internal/quota"
+)
+
+// reloadConfig re-reads the configuration on SIGHUP and applies the
+// settings that are safe to change while serving: the log level, the
+// manager intervals, the presets, the admission limits, the idempotency
+// window and the overlap policy. Nothing is applied unless all of them
+// load, so a bad edit leaves the service exactly as it was.
+// Changes to any other section are reported as needing a restart.
+//
+// It returns the configuration now in effect: the reloadable sections
+// from the new file on top of the sections the process started with.
+func reloadConfig(configPath string, flagOverrides map[string]string, running *config.Config, handler *api.Handler) (*config.Config, error) {
+	next, err := config.LoadConfig(configPath, flagOverrides)
+	if err != nil {
+		return running, err
+	}
+	registry, err := presets.Load(next.Presets.Directory)
+	if err != nil {
+		return running, fmt.Errorf("failed to load presets: %w", err)
+	}
+	if err := overlap.ValidatePolicy(next.Overlap.Policy); err != nil {
+		return running, err
+	}
+
+	logger.SetLevel(next.Logging.Level)
+	information := manager.Information{
+		Interval:       next.Manager.Interval,
+		MinInterval:    next.Manager.MinInterval,
+		StaleThreshold: next.Manager.StaleThreshold,
+	}.Validated()
+	manager.SetInformation(information)
+	handler.SetPresets(registry)
+	handler.SetLimits(quota.FromConfig(next))
+	handler.SetIdempotencyWindow(next.Idempotency.Window)
+	handler.SetOverlapPolicy(next.Overlap.Policy)
+
+	for _, setting := range restartRequired(running, next) {
+		logger.Warn("Configuration change requires a restart to take effect", "setting", setting)
+	}
+
+	applied := *running
+	applied.Logging = next.Logging
+	applied.Manager = next.Manager
+	applied.Presets = next.Presets
+	applied.Limits = next.Limits
+	applied.Idempotency = next.Idempotency
+	applied.Overlap = next.Overlap
+
+	logger.Info("Configuration reloaded",
+		"logging_level", next.Logging.Level,
+		"manager_interval", information.Interval,
+		"manager_stale_threshold", information.StaleThreshold,
+		"presets", len(registry.List()),
+	)
+	return &applied, nil
+}
+
+// handleReload runs reloadConfig and records the result.
+func handleReload(configPath string, flagOverrides map[string]string, running *config.Config, handler *api.Handler) *config.Config {
+	logger.Info("Received SIGHUP, reloading configuration", "path", configPath)
+	applied, err := reloadConfig(configPath, flagOverrides, running, handler)
+	metrics.ReloadResult(err)
+	if err != nil {
+		logger.Error("Configuration reload failed; keeping the running configuration", "error", err)
+	}
+	return applied
+}
+
+// reloadable are the sections reloadConfig applies; a change to any
+// other section only takes effect after a restart.
+var reloadable = map[string]bool{
+	"logging":     true,
+	"manager":     true,
+	"presets":     true,
+	"limits":      true,
+	"idempotency": true,
+	"overlap":     true,
+}
+
+// restartRequired names the settings, as section.field, that differ
+// between a and b and are only read at startup. Every section that isn't
+// reloadable is compared, so a new one can't be missed here.
+func restartRequired(a, b *config.Config) []string {
+	var changed []string
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		section := yamlName(t.Field(i))
+		if reloadable[section] {
+			continue
+		}
+		sa, sb := va.Field(i), vb.Field(i)
+		if sa.Kind() != reflect.Struct {
+			if !reflect.DeepEqual(sa.Interface(), sb.Interface()) {
+				changed = append(changed, section)
+			}
+			continue
+		}
+		for j := 0; j < sa.NumField(); j++ {
+			if !reflect.DeepEqual(sa.Field(j).Interface(), sb.Field(j).Interface()) {
+				changed = append(changed, section+"."+yamlName(sa.Type().Field(j)))
+			}
+		}
+	}
+	return changed
+}
+
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	return name
+}